@@ -7,6 +7,25 @@ import (
 	"github.com/andydunstall/minc/pkg/token"
 )
 
+// registers maps each register to its name when accessed as a byte,
+// longword and quadword.
+var registers = map[string][3]string{
+	"AX":  {"%al", "%eax", "%rax"},
+	"BX":  {"%bl", "%ebx", "%rbx"},
+	"CX":  {"%cl", "%ecx", "%rcx"},
+	"DX":  {"%dl", "%edx", "%rdx"},
+	"DI":  {"%dil", "%edi", "%rdi"},
+	"SI":  {"%sil", "%esi", "%rsi"},
+	"R8":  {"%r8b", "%r8d", "%r8"},
+	"R9":  {"%r9b", "%r9d", "%r9"},
+	"R10": {"%r10b", "%r10d", "%r10"},
+	"R11": {"%r11b", "%r11d", "%r11"},
+	"R12": {"%r12b", "%r12d", "%r12"},
+	"R13": {"%r13b", "%r13d", "%r13"},
+	"R14": {"%r14b", "%r14d", "%r14"},
+	"R15": {"%r15b", "%r15d", "%r15"},
+}
+
 func Emit(n assembly.Node) string {
 	switch n.(type) {
 	case *assembly.File:
@@ -16,7 +35,7 @@ func Emit(n assembly.Node) string {
 	case assembly.Inst:
 		return emitInst(n.(assembly.Inst))
 	case assembly.Operand:
-		return emitOperand(n.(assembly.Operand))
+		return emitOperand(n.(assembly.Operand), assembly.Longword)
 	default:
 		panic("unsupported node type")
 	}
@@ -24,7 +43,28 @@ func Emit(n assembly.Node) string {
 
 func emitFile(f *assembly.File) string {
 	var s string
+	// Functions are in the text section, which is the default, so the
+	// section only needs switching back after a variable.
+	section := ".text"
 	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *assembly.FuncDecl:
+			if section != ".text" {
+				section = ".text"
+				s += "\t.text\n"
+			}
+		case *assembly.StaticVarDecl:
+			// Zero initialized variables go in .bss, so they don't take
+			// space in the object file.
+			varSection := ".data"
+			if decl.Init == "0" {
+				varSection = ".bss"
+			}
+			if section != varSection {
+				section = varSection
+				s += "\t" + section + "\n"
+			}
+		}
 		s += emitDecl(decl)
 	}
 	s += "\t.section .note.GNU-stack,\"\",@progbits\n"
@@ -35,6 +75,8 @@ func emitDecl(decl assembly.Decl) string {
 	switch decl.(type) {
 	case *assembly.FuncDecl:
 		return emitFuncDecl(decl.(*assembly.FuncDecl))
+	case *assembly.StaticVarDecl:
+		return emitStaticVarDecl(decl.(*assembly.StaticVarDecl))
 	default:
 		panic("unsupported decl type")
 	}
@@ -42,7 +84,9 @@ func emitDecl(decl assembly.Decl) string {
 
 func emitFuncDecl(decl *assembly.FuncDecl) string {
 	var s string
-	s += fmt.Sprintf("\t.global %s\n", decl.Name)
+	if decl.Global {
+		s += fmt.Sprintf("\t.global %s\n", decl.Name)
+	}
 	s += fmt.Sprintf("%s:\n", decl.Name)
 	s += "\tpushq %rbp\n"
 	s += "\tmovq %rsp, %rbp\n"
@@ -52,10 +96,48 @@ func emitFuncDecl(decl *assembly.FuncDecl) string {
 	return s
 }
 
+func emitStaticVarDecl(decl *assembly.StaticVarDecl) string {
+	var s string
+	if decl.Global {
+		s += fmt.Sprintf("\t.global %s\n", decl.Name)
+	}
+	s += fmt.Sprintf("\t.balign %d\n", decl.Type.Size())
+	s += fmt.Sprintf("%s:\n", decl.Name)
+	if decl.Init == "0" {
+		s += fmt.Sprintf("\t.zero %d\n", decl.Type.Size())
+		return s
+	}
+	switch decl.Type {
+	case assembly.Byte:
+		s += fmt.Sprintf("\t.byte %s\n", decl.Init)
+	case assembly.Longword:
+		s += fmt.Sprintf("\t.long %s\n", decl.Init)
+	default:
+		s += fmt.Sprintf("\t.quad %s\n", decl.Init)
+	}
+	return s
+}
+
 func emitInst(inst assembly.Inst) string {
 	switch v := inst.(type) {
 	case *assembly.MovInst:
 		return emitMovInst(v)
+	case *assembly.MovsxInst:
+		return fmt.Sprintf(
+			"\tmovs%s%s %s, %s\n",
+			emitSuffix(v.SrcType),
+			emitSuffix(v.DestType),
+			emitOperand(v.Src, v.SrcType),
+			emitOperand(v.Dest, v.DestType),
+		)
+	case *assembly.MovZeroExtendInst:
+		return fmt.Sprintf(
+			"\tmovz%s%s %s, %s\n",
+			emitSuffix(v.SrcType),
+			emitSuffix(v.DestType),
+			emitOperand(v.Src, v.SrcType),
+			emitOperand(v.Dest, v.DestType),
+		)
 	case *assembly.UnaryInst:
 		return emitUnaryInst(v)
 	case *assembly.BinaryInst:
@@ -63,23 +145,55 @@ func emitInst(inst assembly.Inst) string {
 	case *assembly.RetInst:
 		return "\tmovq %rbp, %rsp\n\tpopq %rbp\n\tret\n"
 	case *assembly.IdivInst:
-		return fmt.Sprintf("\tidiv %s\n", emitOperand(v.V))
+		return fmt.Sprintf("\tidiv%s %s\n", emitSuffix(v.Type), emitOperand(v.V, v.Type))
+	case *assembly.DivInst:
+		return fmt.Sprintf("\tdiv%s %s\n", emitSuffix(v.Type), emitOperand(v.V, v.Type))
+	case *assembly.ImulInst:
+		return fmt.Sprintf("\timul%s %s\n", emitSuffix(v.Type), emitOperand(v.V, v.Type))
+	case *assembly.MulInst:
+		return fmt.Sprintf("\tmul%s %s\n", emitSuffix(v.Type), emitOperand(v.V, v.Type))
+	case *assembly.ShiftInst:
+		return fmt.Sprintf(
+			"\t%s%s $%d, %s\n",
+			emitShiftOp(v.Op),
+			emitSuffix(v.Type),
+			v.N,
+			emitOperand(v.Dest, v.Type),
+		)
 	case *assembly.CDQInst:
+		if v.Type == assembly.Quadword {
+			return "\tcqo\n"
+		}
 		return "\tcdq\n"
 	case *assembly.AllocateStackInst:
 		return fmt.Sprintf("\tsubq $%d, %%rsp\n", v.N)
 	case *assembly.DeallocateStackInst:
 		return fmt.Sprintf("\taddq $%d, %%rsp\n", v.N)
 	case *assembly.PushInst:
-		return fmt.Sprintf("\tpushq %s\n", emitQuadOperand(v.V))
+		return fmt.Sprintf("\tpushq %s\n", emitOperand(v.V, assembly.Quadword))
 	case *assembly.CallInst:
 		return fmt.Sprintf("\tcall %s\n", v.Func)
+	case *assembly.TailCallInst:
+		return fmt.Sprintf("\tmovq %%rbp, %%rsp\n\tpopq %%rbp\n\tjmp %s\n", v.Func)
+	case *assembly.CallIndirectInst:
+		return fmt.Sprintf("\tcall *%s\n", emitOperand(v.V, assembly.Quadword))
+	case *assembly.LeaInst:
+		return fmt.Sprintf(
+			"\tleaq %s, %s\n",
+			emitOperand(v.Src, assembly.Quadword),
+			emitOperand(v.Dest, assembly.Quadword),
+		)
 	case *assembly.LabelInst:
 		return fmt.Sprintf(".L%s:\n", v.Name)
 	case *assembly.CmpInst:
-		return fmt.Sprintf("\tcmpl %s, %s\n", emitOperand(v.C), emitOperand(v.V))
+		return fmt.Sprintf(
+			"\tcmp%s %s, %s\n",
+			emitSuffix(v.Type),
+			emitOperand(v.C, v.Type),
+			emitOperand(v.V, v.Type),
+		)
 	case *assembly.SetCCInst:
-		return fmt.Sprintf("\tset%s %s\n", emitCondCode(v.C), emitOperand(v.V))
+		return fmt.Sprintf("\tset%s %s\n", emitCondCode(v.C), emitOperand(v.V, assembly.Byte))
 	case *assembly.JmpInst:
 		return fmt.Sprintf("\tjmp .L%s\n", v.Label)
 	case *assembly.JmpCCInst:
@@ -92,54 +206,48 @@ func emitInst(inst assembly.Inst) string {
 
 func emitMovInst(inst *assembly.MovInst) string {
 	return fmt.Sprintf(
-		"\tmovl %s, %s\n",
-		emitOperand(inst.L),
-		emitOperand(inst.R),
+		"\tmov%s %s, %s\n",
+		emitSuffix(inst.Type),
+		emitOperand(inst.L, inst.Type),
+		emitOperand(inst.R, inst.Type),
 	)
 }
 
 func emitUnaryInst(inst *assembly.UnaryInst) string {
 	return fmt.Sprintf(
-		"\t%s %s\n",
+		"\t%s%s %s\n",
 		emitUnaryOperator(inst.Op),
-		emitOperand(inst.V),
+		emitSuffix(inst.Type),
+		emitOperand(inst.V, inst.Type),
 	)
 }
 
 func emitBinaryInst(inst *assembly.BinaryInst) string {
 	return fmt.Sprintf(
-		"\t%s %s, %s\n",
+		"\t%s%s %s, %s\n",
 		emitBinaryOperator(inst.Op),
-		emitOperand(inst.Src),
-		emitOperand(inst.Dest),
+		emitSuffix(inst.Type),
+		emitOperand(inst.Src, inst.Type),
+		emitOperand(inst.Dest, inst.Type),
 	)
 }
 
-func emitOperand(op assembly.Operand) string {
+// emitOperand emits the operand when accessed with the size of t.
+func emitOperand(op assembly.Operand, t assembly.Type) string {
 	switch v := op.(type) {
 	case *assembly.RegisterOperand:
-		if v.Reg == "AX" {
-			return "%eax"
-		} else if v.Reg == "CX" {
-			return "%ecx"
-		} else if v.Reg == "DX" {
-			return "%edx"
-		} else if v.Reg == "DI" {
-			return "%edi"
-		} else if v.Reg == "SI" {
-			return "%esi"
-		} else if v.Reg == "R8" {
-			return "%r8d"
-		} else if v.Reg == "R9" {
-			return "%r9d"
-		} else if v.Reg == "R10" {
-			return "%r10d"
-		} else if v.Reg == "R11" {
-			return "%r11d"
-		} else {
+		names, ok := registers[v.Reg]
+		if !ok {
 			panic("unsupported register: " + v.Reg)
 		}
-
+		switch t {
+		case assembly.Byte:
+			return names[0]
+		case assembly.Longword:
+			return names[1]
+		default:
+			return names[2]
+		}
 	case *assembly.ImmOperand:
 		return "$" + v.V
 	case *assembly.PseudoOperand:
@@ -147,44 +255,23 @@ func emitOperand(op assembly.Operand) string {
 		panic("pseudo operand")
 	case *assembly.StackOperand:
 		return fmt.Sprintf("%d(%%rbp)", v.Offset)
+	case *assembly.DataOperand:
+		return v.Name + "(%rip)"
 	default:
 		panic("unsupported operand type")
 	}
 }
 
-func emitQuadOperand(op assembly.Operand) string {
-	switch v := op.(type) {
-	case *assembly.RegisterOperand:
-		if v.Reg == "AX" {
-			return "%rax"
-		} else if v.Reg == "CX" {
-			return "%rcx"
-		} else if v.Reg == "DX" {
-			return "%rdx"
-		} else if v.Reg == "DI" {
-			return "%rdi"
-		} else if v.Reg == "SI" {
-			return "%rsi"
-		} else if v.Reg == "R8" {
-			return "%r8"
-		} else if v.Reg == "R9" {
-			return "%r9"
-		} else if v.Reg == "R10" {
-			return "%r10"
-		} else if v.Reg == "R11" {
-			return "%r11"
-		} else {
-			panic("unsupported register: " + v.Reg)
-		}
-	case *assembly.ImmOperand:
-		return "$" + v.V
-	case *assembly.PseudoOperand:
-		// The IR pass should remove all pseudo operands.
-		panic("pseudo operand")
-	case *assembly.StackOperand:
-		return fmt.Sprintf("-%d(%%rbp)", v.Offset)
+func emitSuffix(t assembly.Type) string {
+	switch t {
+	case assembly.Byte:
+		return "b"
+	case assembly.Longword:
+		return "l"
+	case assembly.Quadword:
+		return "q"
 	default:
-		panic("unsupported operand type")
+		panic("unknown type")
 	}
 }
 
@@ -202,17 +289,38 @@ func emitCondCode(cc assembly.CondCode) string {
 		return "l"
 	case assembly.CondCodeLE:
 		return "le"
+	case assembly.CondCodeA:
+		return "a"
+	case assembly.CondCodeAE:
+		return "ae"
+	case assembly.CondCodeB:
+		return "b"
+	case assembly.CondCodeBE:
+		return "be"
 	default:
 		panic("unknown cond code")
 	}
 }
 
+func emitShiftOp(op assembly.ShiftOp) string {
+	switch op {
+	case assembly.ShiftLeft:
+		return "shl"
+	case assembly.ShiftRightArith:
+		return "sar"
+	case assembly.ShiftRightLogical:
+		return "shr"
+	default:
+		panic("unknown shift op")
+	}
+}
+
 func emitUnaryOperator(op token.Token) string {
 	switch op {
 	case token.TILDE:
-		return "notl"
+		return "not"
 	case token.SUB:
-		return "negl"
+		return "neg"
 	default:
 		panic("unsupported unary operator: " + op.String())
 	}
@@ -221,11 +329,13 @@ func emitUnaryOperator(op token.Token) string {
 func emitBinaryOperator(op token.Token) string {
 	switch op {
 	case token.ADD:
-		return "addl"
+		return "add"
 	case token.SUB:
-		return "subl"
+		return "sub"
 	case token.MUL:
-		return "imull"
+		return "imul"
+	case token.AND:
+		return "and"
 	default:
 		panic("unsupported binary operator: " + op.String())
 	}
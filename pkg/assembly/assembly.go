@@ -11,8 +11,36 @@ const (
 	CondCodeGE
 	CondCodeL
 	CondCodeLE
+	// Unsigned comparisons.
+	CondCodeA
+	CondCodeAE
+	CondCodeB
+	CondCodeBE
 )
 
+// Type is the size of the operands of an instruction.
+type Type int
+
+const (
+	Byte Type = iota + 1
+	Longword
+	Quadword
+)
+
+// Size returns the size of the type in bytes.
+func (t Type) Size() int32 {
+	switch t {
+	case Byte:
+		return 1
+	case Longword:
+		return 4
+	case Quadword:
+		return 8
+	default:
+		panic("unknown type")
+	}
+}
+
 type Node interface {
 	node()
 }
@@ -52,6 +80,14 @@ type RegisterOperand struct {
 func (n *RegisterOperand) node()        {}
 func (n *RegisterOperand) operandNode() {}
 
+// DataOperand is a RIP-relative reference to a symbol.
+type DataOperand struct {
+	Name string
+}
+
+func (n *DataOperand) node()        {}
+func (n *DataOperand) operandNode() {}
+
 // Declarations.
 
 type Decl interface {
@@ -60,13 +96,26 @@ type Decl interface {
 }
 
 type FuncDecl struct {
-	Name  string
-	Insts []Inst
+	Name   string
+	Global bool
+	Insts  []Inst
 }
 
 func (n *FuncDecl) node()     {}
 func (n *FuncDecl) declNode() {}
 
+// StaticVarDecl defines a variable with static storage duration. The
+// variable is aligned to the size of its type.
+type StaticVarDecl struct {
+	Name   string
+	Global bool
+	Type   Type
+	Init   string
+}
+
+func (n *StaticVarDecl) node()     {}
+func (n *StaticVarDecl) declNode() {}
+
 // Instructions.
 
 type Inst interface {
@@ -75,21 +124,47 @@ type Inst interface {
 }
 
 type MovInst struct {
-	L Operand
-	R Operand
+	Type Type
+	L    Operand
+	R    Operand
 }
 
 func (n *MovInst) node()     {}
 func (n *MovInst) instNode() {}
 
+// MovsxInst moves Src to Dest, sign extending it from SrcType to
+// DestType.
+type MovsxInst struct {
+	SrcType  Type
+	DestType Type
+	Src      Operand
+	Dest     Operand
+}
+
+func (n *MovsxInst) node()     {}
+func (n *MovsxInst) instNode() {}
+
+// MovZeroExtendInst moves Src to Dest, zero extending it from SrcType to
+// DestType.
+type MovZeroExtendInst struct {
+	SrcType  Type
+	DestType Type
+	Src      Operand
+	Dest     Operand
+}
+
+func (n *MovZeroExtendInst) node()     {}
+func (n *MovZeroExtendInst) instNode() {}
+
 type RetInst struct{}
 
 func (n *RetInst) node()     {}
 func (n *RetInst) instNode() {}
 
 type UnaryInst struct {
-	Op token.Token
-	V  Operand
+	Op   token.Token
+	Type Type
+	V    Operand
 }
 
 func (n *UnaryInst) node()     {}
@@ -97,6 +172,7 @@ func (n *UnaryInst) instNode() {}
 
 type BinaryInst struct {
 	Op   token.Token
+	Type Type
 	Src  Operand
 	Dest Operand
 }
@@ -105,13 +181,69 @@ func (n *BinaryInst) node()     {}
 func (n *BinaryInst) instNode() {}
 
 type IdivInst struct {
-	V Operand
+	Type Type
+	V    Operand
 }
 
 func (n *IdivInst) node()     {}
 func (n *IdivInst) instNode() {}
 
-type CDQInst struct{}
+// DivInst is unsigned division.
+type DivInst struct {
+	Type Type
+	V    Operand
+}
+
+func (n *DivInst) node()     {}
+func (n *DivInst) instNode() {}
+
+// ImulInst is signed multiplication of AX by V, with the low half of the
+// result in AX and the high half in DX.
+type ImulInst struct {
+	Type Type
+	V    Operand
+}
+
+func (n *ImulInst) node()     {}
+func (n *ImulInst) instNode() {}
+
+// MulInst is unsigned multiplication of AX by V, with the low half of the
+// result in AX and the high half in DX.
+type MulInst struct {
+	Type Type
+	V    Operand
+}
+
+func (n *MulInst) node()     {}
+func (n *MulInst) instNode() {}
+
+// ShiftOp is the direction and kind of a shift.
+type ShiftOp int
+
+const (
+	ShiftLeft ShiftOp = iota + 1
+	// ShiftRightArith copies the sign bit into the vacated bits.
+	ShiftRightArith
+	// ShiftRightLogical fills the vacated bits with zeros.
+	ShiftRightLogical
+)
+
+// ShiftInst shifts Dest by N bits.
+type ShiftInst struct {
+	Op   ShiftOp
+	Type Type
+	N    int
+	Dest Operand
+}
+
+func (n *ShiftInst) node()     {}
+func (n *ShiftInst) instNode() {}
+
+// CDQInst sign extends AX into DX (cdq for a longword and cqo for a
+// quadword).
+type CDQInst struct {
+	Type Type
+}
 
 func (n *CDQInst) node()     {}
 func (n *CDQInst) instNode() {}
@@ -140,8 +272,9 @@ func (n *JmpCCInst) node()     {}
 func (n *JmpCCInst) instNode() {}
 
 type CmpInst struct {
-	C Operand
-	V Operand
+	Type Type
+	C    Operand
+	V    Operand
 }
 
 func (n *CmpInst) node()     {}
@@ -177,11 +310,42 @@ func (n *DeallocateStackInst) instNode() {}
 
 type CallInst struct {
 	Func string
+	// RegArgs is the number of arguments passed in registers, which the
+	// call reads.
+	RegArgs int
 }
 
 func (n *CallInst) node()     {}
 func (n *CallInst) instNode() {}
 
+// TailCallInst removes the current frame then jumps to the function, which
+// returns directly to the caller.
+type TailCallInst struct {
+	Func    string
+	RegArgs int
+}
+
+func (n *TailCallInst) node()     {}
+func (n *TailCallInst) instNode() {}
+
+// CallIndirectInst calls the function whose address is in V.
+type CallIndirectInst struct {
+	V       Operand
+	RegArgs int
+}
+
+func (n *CallIndirectInst) node()     {}
+func (n *CallIndirectInst) instNode() {}
+
+// LeaInst loads the address of Src into Dest.
+type LeaInst struct {
+	Src  Operand
+	Dest Operand
+}
+
+func (n *LeaInst) node()     {}
+func (n *LeaInst) instNode() {}
+
 type File struct {
 	Decls []Decl
 }
@@ -1,6 +1,9 @@
 package assembly
 
 import (
+	"math"
+	"strconv"
+
 	"github.com/andydunstall/minc/pkg/token"
 )
 
@@ -18,8 +21,9 @@ func newFixer(debug bool) *fixer {
 
 func (f *fixer) fix(root *File) {
 	for _, decl := range root.Decls {
-		fn := decl.(*FuncDecl)
-		fn.Insts = f.fixInsts(fn.Insts)
+		if fn, ok := decl.(*FuncDecl); ok {
+			fn.Insts = f.fixInsts(fn.Insts)
+		}
 	}
 }
 
@@ -34,28 +38,86 @@ func (f *fixer) fixInsts(insts []Inst) []Inst {
 	for _, inst := range insts {
 		switch v := inst.(type) {
 		case *MovInst:
-			if _, ok := v.L.(*StackOperand); !ok {
-				break
+			if isLargeImm(v.L, v.Type) && isMemory(v.R) {
+				// Mov can only move a 64-bit immediate to a register.
+
+				updatedInsts = append(updatedInsts, &MovInst{
+					Type: v.Type,
+					L:    v.L,
+					R:    reg("R10"),
+				})
+				updatedInsts = append(updatedInsts, &MovInst{
+					Type: v.Type,
+					L:    reg("R10"),
+					R:    v.R,
+				})
+
+				continue
 			}
-			if _, ok := v.R.(*StackOperand); !ok {
+
+			if !isMemory(v.L) || !isMemory(v.R) {
 				break
 			}
 
 			// Mov can't move a value from one memory address to another.
 
 			updatedInsts = append(updatedInsts, &MovInst{
-				L: v.L,
-				R: &RegisterOperand{
-					Reg: "R10",
-				},
+				Type: v.Type,
+				L:    v.L,
+				R:    reg("R10"),
 			})
 			updatedInsts = append(updatedInsts, &MovInst{
-				L: &RegisterOperand{
-					Reg: "R10",
-				},
-				R: v.R,
+				Type: v.Type,
+				L:    reg("R10"),
+				R:    v.R,
 			})
 
+			continue
+		case *MovsxInst:
+			updatedInsts = append(updatedInsts, f.fixExtend(v.SrcType, v.DestType, v.Src, v.Dest, func(src, dest Operand) Inst {
+				return &MovsxInst{
+					SrcType:  v.SrcType,
+					DestType: v.DestType,
+					Src:      src,
+					Dest:     dest,
+				}
+			})...)
+			continue
+		case *MovZeroExtendInst:
+			if v.SrcType == Longword {
+				// Moving a longword to a register zeros the upper bytes, so
+				// there is no instruction to zero extend a longword.
+
+				if _, ok := v.Dest.(*RegisterOperand); ok {
+					updatedInsts = append(updatedInsts, &MovInst{
+						Type: Longword,
+						L:    v.Src,
+						R:    v.Dest,
+					})
+					continue
+				}
+
+				updatedInsts = append(updatedInsts, &MovInst{
+					Type: Longword,
+					L:    v.Src,
+					R:    reg("R11"),
+				})
+				updatedInsts = append(updatedInsts, &MovInst{
+					Type: Quadword,
+					L:    reg("R11"),
+					R:    v.Dest,
+				})
+				continue
+			}
+
+			updatedInsts = append(updatedInsts, f.fixExtend(v.SrcType, v.DestType, v.Src, v.Dest, func(src, dest Operand) Inst {
+				return &MovZeroExtendInst{
+					SrcType:  v.SrcType,
+					DestType: v.DestType,
+					Src:      src,
+					Dest:     dest,
+				}
+			})...)
 			continue
 		case *IdivInst:
 			if _, ok := v.V.(*ImmOperand); !ok {
@@ -65,110 +127,205 @@ func (f *fixer) fixInsts(insts []Inst) []Inst {
 			// Idiv can't operate on constants.
 
 			updatedInsts = append(updatedInsts, &MovInst{
-				L: v.V,
-				R: &RegisterOperand{
-					Reg: "R10",
-				},
+				Type: v.Type,
+				L:    v.V,
+				R:    reg("R10"),
 			})
 			updatedInsts = append(updatedInsts, &IdivInst{
-				V: &RegisterOperand{
-					Reg: "R10",
-				},
+				Type: v.Type,
+				V:    reg("R10"),
+			})
+
+			continue
+		case *ImulInst:
+			if _, ok := v.V.(*ImmOperand); !ok {
+				break
+			}
+
+			// Imul with one operand can't operate on constants.
+
+			updatedInsts = append(updatedInsts, &MovInst{
+				Type: v.Type,
+				L:    v.V,
+				R:    reg("R10"),
+			})
+			updatedInsts = append(updatedInsts, &ImulInst{
+				Type: v.Type,
+				V:    reg("R10"),
+			})
+
+			continue
+		case *MulInst:
+			if _, ok := v.V.(*ImmOperand); !ok {
+				break
+			}
+
+			// Mul can't operate on constants.
+
+			updatedInsts = append(updatedInsts, &MovInst{
+				Type: v.Type,
+				L:    v.V,
+				R:    reg("R10"),
+			})
+			updatedInsts = append(updatedInsts, &MulInst{
+				Type: v.Type,
+				V:    reg("R10"),
+			})
+
+			continue
+		case *DivInst:
+			if _, ok := v.V.(*ImmOperand); !ok {
+				break
+			}
+
+			// Div can't operate on constants.
+
+			updatedInsts = append(updatedInsts, &MovInst{
+				Type: v.Type,
+				L:    v.V,
+				R:    reg("R10"),
+			})
+			updatedInsts = append(updatedInsts, &DivInst{
+				Type: v.Type,
+				V:    reg("R10"),
 			})
 
 			continue
 		case *BinaryInst:
 			switch v.Op {
-			case token.ADD, token.SUB:
-				if _, ok := v.Src.(*StackOperand); !ok {
-					break
-				}
-				if _, ok := v.Dest.(*StackOperand); !ok {
+			case token.ADD, token.SUB, token.AND:
+				if !isLargeImm(v.Src, v.Type) && (!isMemory(v.Src) || !isMemory(v.Dest)) {
 					break
 				}
 
-				// Add/Sub can't use memory addresses for both operands.
+				// Add/Sub/And can't use memory addresses for both operands,
+				// or a 64-bit immediate.
 
 				updatedInsts = append(updatedInsts, &MovInst{
-					L: v.Src,
-					R: &RegisterOperand{
-						Reg: "R10",
-					},
+					Type: v.Type,
+					L:    v.Src,
+					R:    reg("R10"),
 				})
 				updatedInsts = append(updatedInsts, &BinaryInst{
-					Op: v.Op,
-					Src: &RegisterOperand{
-						Reg: "R10",
-					},
+					Op:   v.Op,
+					Type: v.Type,
+					Src:  reg("R10"),
 					Dest: v.Dest,
 				})
 
 				continue
 			case token.MUL:
-				if _, ok := v.Dest.(*StackOperand); !ok {
-					break
+				src := v.Src
+				if isLargeImm(src, v.Type) {
+					// Mult can't use a 64-bit immediate.
+
+					updatedInsts = append(updatedInsts, &MovInst{
+						Type: v.Type,
+						L:    src,
+						R:    reg("R10"),
+					})
+					src = reg("R10")
+				}
+
+				if !isMemory(v.Dest) {
+					updatedInsts = append(updatedInsts, &BinaryInst{
+						Op:   v.Op,
+						Type: v.Type,
+						Src:  src,
+						Dest: v.Dest,
+					})
+					continue
 				}
 
 				// Destination of Mult can't be in memory.
 
 				updatedInsts = append(updatedInsts, &MovInst{
-					L: v.Dest,
-					R: &RegisterOperand{
-						Reg: "R11",
-					},
+					Type: v.Type,
+					L:    v.Dest,
+					R:    reg("R11"),
 				})
 				updatedInsts = append(updatedInsts, &BinaryInst{
-					Op:  v.Op,
-					Src: v.Src,
-					Dest: &RegisterOperand{
-						Reg: "R11",
-					},
+					Op:   v.Op,
+					Type: v.Type,
+					Src:  src,
+					Dest: reg("R11"),
 				})
 				updatedInsts = append(updatedInsts, &MovInst{
-					L: &RegisterOperand{
-						Reg: "R11",
-					},
-					R: v.Dest,
+					Type: v.Type,
+					L:    reg("R11"),
+					R:    v.Dest,
 				})
 
 				continue
 			}
 		case *CmpInst:
-			_, ok1 := v.C.(*StackOperand)
-			_, ok2 := v.V.(*StackOperand)
-			if ok1 && ok2 {
+			c := v.C
+			if isLargeImm(c, v.Type) || (isMemory(c) && isMemory(v.V)) {
 				updatedInsts = append(updatedInsts, &MovInst{
-					L: v.C,
-					R: &RegisterOperand{
-						Reg: "R10",
-					},
-				})
-				updatedInsts = append(updatedInsts, &CmpInst{
-					C: &RegisterOperand{
-						Reg: "R10",
-					},
-					V: v.V,
+					Type: v.Type,
+					L:    c,
+					R:    reg("R10"),
 				})
-
-				continue
+				c = reg("R10")
 			}
 
 			if _, ok := v.V.(*ImmOperand); ok {
 				updatedInsts = append(updatedInsts, &MovInst{
-					L: v.V,
-					R: &RegisterOperand{
-						Reg: "R11",
-					},
+					Type: v.Type,
+					L:    v.V,
+					R:    reg("R11"),
 				})
 				updatedInsts = append(updatedInsts, &CmpInst{
-					C: v.C,
-					V: &RegisterOperand{
-						Reg: "R11",
-					},
+					Type: v.Type,
+					C:    c,
+					V:    reg("R11"),
 				})
 
 				continue
 			}
+
+			updatedInsts = append(updatedInsts, &CmpInst{
+				Type: v.Type,
+				C:    c,
+				V:    v.V,
+			})
+
+			continue
+		case *LeaInst:
+			if !isMemory(v.Dest) {
+				break
+			}
+
+			// Destination of Lea can't be in memory.
+
+			updatedInsts = append(updatedInsts, &LeaInst{
+				Src:  v.Src,
+				Dest: reg("R11"),
+			})
+			updatedInsts = append(updatedInsts, &MovInst{
+				Type: Quadword,
+				L:    reg("R11"),
+				R:    v.Dest,
+			})
+
+			continue
+		case *PushInst:
+			if !isLargeImm(v.V, Quadword) {
+				break
+			}
+
+			// Push can't use a 64-bit immediate.
+
+			updatedInsts = append(updatedInsts, &MovInst{
+				Type: Quadword,
+				L:    v.V,
+				R:    reg("R10"),
+			})
+			updatedInsts = append(updatedInsts, &PushInst{
+				V: reg("R10"),
+			})
+
+			continue
 		}
 
 		updatedInsts = append(updatedInsts, inst)
@@ -177,76 +334,228 @@ func (f *fixer) fixInsts(insts []Inst) []Inst {
 	return updatedInsts
 }
 
-func (f *fixer) replacePseudos(insts []Inst) ([]Inst, int32) {
-	// TODO(andydunstall): Add support for AST walking instead of checking
-	// each instruction.
+// fixExtend fixes the operands of a sign or zero extension. The source
+// can't be a constant and the destination must be a register.
+func (f *fixer) fixExtend(srcType Type, destType Type, src Operand, dest Operand, extend func(src, dest Operand) Inst) []Inst {
+	var insts []Inst
+
+	if _, ok := src.(*ImmOperand); ok {
+		insts = append(insts, &MovInst{
+			Type: srcType,
+			L:    src,
+			R:    reg("R10"),
+		})
+		src = reg("R10")
+	}
 
-	var lastOffset int32
-	offsets := make(map[string]int32)
+	if _, ok := dest.(*RegisterOperand); ok {
+		return append(insts, extend(src, dest))
+	}
 
-	replace := func(op Operand) Operand {
-		pseudo, ok := op.(*PseudoOperand)
-		if !ok {
-			return op
-		}
+	return append(insts,
+		extend(src, reg("R11")),
+		&MovInst{
+			Type: destType,
+			L:    reg("R11"),
+			R:    dest,
+		},
+	)
+}
 
-		if off, ok := offsets[pseudo.V]; ok {
-			return &StackOperand{
-				Offset: off,
+func (f *fixer) replacePseudos(insts []Inst) ([]Inst, int32) {
+	// Find the size of each pseudo from the instructions that use it, then
+	// assign each a stack slot in the order they are first used.
+
+	var names []string
+	sizes := make(map[string]int32)
+	for _, inst := range insts {
+		mapOperands(inst, func(op Operand, t Type) Operand {
+			pseudo, ok := op.(*PseudoOperand)
+			if !ok {
+				return op
 			}
-		} else {
-			lastOffset -= 4
-			offsets[pseudo.V] = lastOffset
-			return &StackOperand{
-				Offset: lastOffset,
+			size, ok := sizes[pseudo.V]
+			if !ok {
+				names = append(names, pseudo.V)
 			}
-		}
+			sizes[pseudo.V] = max(size, t.Size())
+			return op
+		})
+	}
+
+	var lastOffset int32
+	offsets := make(map[string]int32)
+	for _, name := range names {
+		size := sizes[name]
+		// Align each slot to its size.
+		lastOffset -= size
+		lastOffset -= ((lastOffset % size) + size) % size
+		offsets[name] = lastOffset
 	}
 
 	var updatedInsts []Inst
 	for _, inst := range insts {
-		switch v := inst.(type) {
-		case *MovInst:
-			inst = &MovInst{
-				L: replace(v.L),
-				R: replace(v.R),
+		updatedInsts = append(updatedInsts, mapOperands(inst, func(op Operand, _ Type) Operand {
+			pseudo, ok := op.(*PseudoOperand)
+			if !ok {
+				return op
 			}
-		case *UnaryInst:
-			inst = &UnaryInst{
-				Op: v.Op,
-				V:  replace(v.V),
-			}
-		case *BinaryInst:
-			inst = &BinaryInst{
-				Op:   v.Op,
-				Src:  replace(v.Src),
-				Dest: replace(v.Dest),
-			}
-		case *IdivInst:
-			inst = &IdivInst{
-				V: replace(v.V),
-			}
-		case *CmpInst:
-			inst = &CmpInst{
-				C: replace(v.C),
-				V: replace(v.V),
-			}
-		case *SetCCInst:
-			inst = &SetCCInst{
-				C: v.C,
-				V: replace(v.V),
-			}
-		case *PushInst:
-			inst = &PushInst{
-				V: replace(v.V),
+			return &StackOperand{
+				Offset: offsets[pseudo.V],
 			}
-		}
-		updatedInsts = append(updatedInsts, inst)
+		}))
 	}
 
 	return updatedInsts, lastOffset
 }
 
+// mapOperands returns a copy of the instruction with each operand replaced
+// by the result of fn, which is passed the operand and the size it is
+// accessed with.
+func mapOperands(inst Inst, fn func(op Operand, t Type) Operand) Inst {
+	switch v := inst.(type) {
+	case *MovInst:
+		return &MovInst{
+			Type: v.Type,
+			L:    fn(v.L, v.Type),
+			R:    fn(v.R, v.Type),
+		}
+	case *MovsxInst:
+		return &MovsxInst{
+			SrcType:  v.SrcType,
+			DestType: v.DestType,
+			Src:      fn(v.Src, v.SrcType),
+			Dest:     fn(v.Dest, v.DestType),
+		}
+	case *MovZeroExtendInst:
+		return &MovZeroExtendInst{
+			SrcType:  v.SrcType,
+			DestType: v.DestType,
+			Src:      fn(v.Src, v.SrcType),
+			Dest:     fn(v.Dest, v.DestType),
+		}
+	case *UnaryInst:
+		return &UnaryInst{
+			Op:   v.Op,
+			Type: v.Type,
+			V:    fn(v.V, v.Type),
+		}
+	case *BinaryInst:
+		return &BinaryInst{
+			Op:   v.Op,
+			Type: v.Type,
+			Src:  fn(v.Src, v.Type),
+			Dest: fn(v.Dest, v.Type),
+		}
+	case *IdivInst:
+		return &IdivInst{
+			Type: v.Type,
+			V:    fn(v.V, v.Type),
+		}
+	case *DivInst:
+		return &DivInst{
+			Type: v.Type,
+			V:    fn(v.V, v.Type),
+		}
+	case *ImulInst:
+		return &ImulInst{
+			Type: v.Type,
+			V:    fn(v.V, v.Type),
+		}
+	case *MulInst:
+		return &MulInst{
+			Type: v.Type,
+			V:    fn(v.V, v.Type),
+		}
+	case *ShiftInst:
+		return &ShiftInst{
+			Op:   v.Op,
+			Type: v.Type,
+			N:    v.N,
+			Dest: fn(v.Dest, v.Type),
+		}
+	case *CmpInst:
+		return &CmpInst{
+			Type: v.Type,
+			C:    fn(v.C, v.Type),
+			V:    fn(v.V, v.Type),
+		}
+	case *SetCCInst:
+		return &SetCCInst{
+			C: v.C,
+			V: fn(v.V, Byte),
+		}
+	case *PushInst:
+		return &PushInst{
+			V: fn(v.V, Quadword),
+		}
+	case *LeaInst:
+		return &LeaInst{
+			Src:  fn(v.Src, Quadword),
+			Dest: fn(v.Dest, Quadword),
+		}
+	case *CallIndirectInst:
+		return &CallIndirectInst{
+			V:       fn(v.V, Quadword),
+			RegArgs: v.RegArgs,
+		}
+	default:
+		return inst
+	}
+}
+
+func reg(name string) *RegisterOperand {
+	return &RegisterOperand{
+		Reg: name,
+	}
+}
+
+func isMemory(op Operand) bool {
+	switch op.(type) {
+	case *StackOperand, *DataOperand:
+		return true
+	default:
+		return false
+	}
+}
+
+// isLargeImm returns whether the operand is a quadword immediate that
+// doesn't fit in the 32-bit immediate most instructions support.
+func isLargeImm(op Operand, t Type) bool {
+	imm, ok := op.(*ImmOperand)
+	if !ok || t != Quadword {
+		return false
+	}
+	v, err := strconv.ParseInt(imm.V, 10, 64)
+	if err != nil {
+		// Only unsigned values above the int64 range fail to parse.
+		return true
+	}
+	return v < math.MinInt32 || v > math.MaxInt32
+}
+
+// truncateImm returns the immediate truncated to the size of t.
+func truncateImm(imm *ImmOperand, t Type) *ImmOperand {
+	v, err := strconv.ParseInt(imm.V, 10, 64)
+	if err != nil {
+		u, err := strconv.ParseUint(imm.V, 10, 64)
+		if err != nil {
+			panic("invalid immediate: " + imm.V)
+		}
+		v = int64(u)
+	}
+
+	switch t {
+	case Byte:
+		v = int64(int8(v))
+	case Longword:
+		v = int64(int32(v))
+	}
+	return &ImmOperand{
+		V: strconv.FormatInt(v, 10),
+	}
+}
+
 func roundUpToNextMultipleOf16(n int32) int32 {
 	remainder := n % 16
 	if remainder == 0 {
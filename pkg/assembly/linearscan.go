@@ -0,0 +1,738 @@
+package assembly
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+)
+
+// AllocateRegistersLinear assigns the pseudos in each function to
+// registers with a linear scan over their live intervals (Wimmer and
+// Mössenböck, Optimized Interval Splitting in a Linear Scan Register
+// Allocator). It's faster than AllocateRegisters, as it doesn't build an
+// interference graph, though the code it generates has more moves.
+//
+// Each instruction has two positions: its operands are read at the first
+// and written at the second. A pseudo's live interval is the positions it
+// holds a value, which may have holes where it's dead. The intervals are
+// allocated in order of their start, and each is assigned a register
+// that's free for the whole interval where possible. Otherwise the
+// interval is split, so it's in a register for only part of its lifetime:
+//
+//   - If a register is free for the start of the interval, the interval
+//     is split where the register is next needed.
+//   - Otherwise the interval in the register used again furthest in the
+//     future is spilled, whether that's another interval or this one. The
+//     spilled part is in the pseudo's stack slot until its next use.
+//
+// Intervals are split at the lowest loop depth between where the split is
+// needed and where it's needed by, so the moves to and from the stack slot
+// are outside loops where possible. After allocation, moves are added where
+// a pseudo is in different places at each end of a split, or of a jump.
+//
+// The registers the code uses directly have fixed intervals, which the
+// pseudos can't overlap, as in AllocateRegisters. It returns whether any
+// pseudos were assigned registers.
+func AllocateRegistersLinear(root *File) bool {
+	labels := fileLabels(root)
+	changed := false
+	for _, decl := range root.Decls {
+		fn, ok := decl.(*FuncDecl)
+		if !ok {
+			continue
+		}
+		if scanFunc(fn, computeLiveness(fn.Insts), labels) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// lrange is the positions from start up to but not including end.
+type lrange struct {
+	start, end int
+}
+
+// interval is the positions a pseudo is live, or part of them where it's
+// split, which are all in the same place.
+type interval struct {
+	id     int
+	ranges []lrange
+	// uses contains the positions the pseudo is read or written.
+	uses []int
+	// reg is the index of the interval's register in allocatable, or -1
+	// if it's in the pseudo's stack slot.
+	reg int
+}
+
+func (it *interval) start() int {
+	return it.ranges[0].start
+}
+
+func (it *interval) end() int {
+	return it.ranges[len(it.ranges)-1].end
+}
+
+func (it *interval) covers(pos int) bool {
+	i := sort.Search(len(it.ranges), func(i int) bool {
+		return it.ranges[i].end > pos
+	})
+	return i < len(it.ranges) && it.ranges[i].start <= pos
+}
+
+// nextUse returns the first use at or after pos, or math.MaxInt if there
+// is none.
+func (it *interval) nextUse(pos int) int {
+	for _, use := range it.uses {
+		if use >= pos {
+			return use
+		}
+	}
+	return math.MaxInt
+}
+
+// splitAt splits the interval at pos, keeping the positions before pos
+// and returning the rest as a new interval.
+func (it *interval) splitAt(pos int) *interval {
+	child := &interval{
+		id:  it.id,
+		reg: -1,
+	}
+	var ranges []lrange
+	for _, r := range it.ranges {
+		switch {
+		case r.end <= pos:
+			ranges = append(ranges, r)
+		case r.start >= pos:
+			child.ranges = append(child.ranges, r)
+		default:
+			ranges = append(ranges, lrange{r.start, pos})
+			child.ranges = append(child.ranges, lrange{pos, r.end})
+		}
+	}
+	it.ranges = ranges
+
+	var uses []int
+	for _, use := range it.uses {
+		if use < pos {
+			uses = append(uses, use)
+		} else {
+			child.uses = append(child.uses, use)
+		}
+	}
+	it.uses = uses
+	return child
+}
+
+// intersection returns the first position in both a and b, or
+// math.MaxInt if there is none.
+func intersection(a, b []lrange) int {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		start := max(a[i].start, b[j].start)
+		if start < min(a[i].end, b[j].end) {
+			return start
+		}
+		if a[i].end < b[j].end {
+			i++
+		} else {
+			j++
+		}
+	}
+	return math.MaxInt
+}
+
+// unhandled is the intervals not yet allocated, ordered by start.
+type unhandled []*interval
+
+func (h unhandled) Len() int { return len(h) }
+func (h unhandled) Less(i, j int) bool {
+	if h[i].start() != h[j].start() {
+		return h[i].start() < h[j].start()
+	}
+	return h[i].id < h[j].id
+}
+func (h unhandled) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *unhandled) Push(x any)   { *h = append(*h, x.(*interval)) }
+func (h *unhandled) Pop() any {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
+
+// scanner allocates the live intervals of a function.
+type scanner struct {
+	live   *liveness
+	depths []int
+
+	// fixed contains the positions each allocatable register is used
+	// directly.
+	fixed [][]lrange
+	// hints contains the registers each pseudo is copied to or from,
+	// which it's assigned if free so the copy can be removed.
+	hints [][]int
+	// pieces contains the intervals each pseudo is split into.
+	pieces [][]*interval
+
+	unhandled unhandled
+	active    []*interval
+	inactive  []*interval
+}
+
+// scanFunc allocates registers for the function, returning whether any
+// pseudos were assigned registers. labels contains the labels used by the
+// file, so new labels don't clash.
+func scanFunc(fn *FuncDecl, live *liveness, labels map[string]bool) bool {
+	s := &scanner{
+		live:   live,
+		depths: live.loopDepths(),
+		fixed:  make([][]lrange, len(allocatable)),
+		hints:  make([][]int, live.numLocations()),
+		pieces: make([][]*interval, live.numLocations()),
+	}
+	s.buildIntervals()
+	s.scan()
+
+	allocated := false
+	for _, pieces := range s.pieces {
+		for _, it := range pieces {
+			if it.reg >= 0 {
+				allocated = true
+			}
+		}
+	}
+	if !allocated {
+		return false
+	}
+	fn.Insts = saveCalleeSaved(removeSelfMoves(s.rewrite(labels)))
+	return true
+}
+
+// buildIntervals computes the live interval of each location from the
+// liveness, walking each block in reverse. An instruction's operands are
+// read at position 2i and written at 2i+1.
+func (s *scanner) buildIntervals() {
+	live := s.live
+	// The ranges and uses are found in reverse, so are reversed once all
+	// blocks are done.
+	ranges := make([][]lrange, live.numLocations())
+	uses := make([][]int, live.numLocations())
+	addRange := func(id, start, end int) {
+		rs := ranges[id]
+		if len(rs) > 0 && rs[len(rs)-1].start <= end {
+			rs[len(rs)-1].start = min(rs[len(rs)-1].start, start)
+			return
+		}
+		ranges[id] = append(rs, lrange{start, end})
+	}
+
+	for b := len(live.blocks) - 1; b >= 0; b-- {
+		start, end := 2*live.blocks[b].start, 2*live.blocks[b].end
+		out := live.out[b].clone()
+		for _, id := range out.elems() {
+			addRange(id, start, end)
+		}
+		live.walk(b, out, func(i int) {
+			for _, id := range live.defs[i] {
+				if out.has(id) {
+					ranges[id][len(ranges[id])-1].start = 2*i + 1
+				} else {
+					// A location that's written but not read afterwards
+					// still needs a register to write to.
+					addRange(id, 2*i+1, 2*i+2)
+				}
+				uses[id] = append(uses[id], 2*i+1)
+			}
+			for _, id := range live.uses[i] {
+				addRange(id, start, 2*i+1)
+				uses[id] = append(uses[id], 2*i)
+			}
+
+			if mov, ok := live.insts[i].(*MovInst); ok {
+				s.addHint(mov.L, mov.R)
+				s.addHint(mov.R, mov.L)
+			}
+		})
+	}
+
+	for id := range ranges {
+		if len(ranges[id]) == 0 {
+			continue
+		}
+		slices.Reverse(ranges[id])
+		slices.Reverse(uses[id])
+		if live.isRegister(id) {
+			s.fixed[id] = ranges[id]
+			continue
+		}
+		it := &interval{
+			id:     id,
+			ranges: ranges[id],
+			uses:   dedupe(uses[id]),
+			reg:    -1,
+		}
+		s.pieces[id] = []*interval{it}
+		heap.Push(&s.unhandled, it)
+	}
+}
+
+// addHint records the register r as a hint for the pseudo p, if they are a
+// pseudo and an allocatable register.
+func (s *scanner) addHint(p, r Operand) {
+	pseudo, ok := p.(*PseudoOperand)
+	if !ok {
+		return
+	}
+	ids := s.live.locations([]Operand{r})
+	if len(ids) == 0 || !s.live.isRegister(ids[0]) {
+		return
+	}
+	id := s.live.ids[pseudo.V]
+	s.hints[id] = append(s.hints[id], ids[0])
+}
+
+func (s *scanner) scan() {
+	for s.unhandled.Len() > 0 {
+		cur := heap.Pop(&s.unhandled).(*interval)
+		pos := cur.start()
+
+		var active []*interval
+		for _, it := range s.active {
+			switch {
+			case it.end() <= pos:
+			case !it.covers(pos):
+				s.inactive = append(s.inactive, it)
+			default:
+				active = append(active, it)
+			}
+		}
+		var inactive []*interval
+		for _, it := range s.inactive {
+			switch {
+			case it.end() <= pos:
+			case it.covers(pos):
+				active = append(active, it)
+			default:
+				inactive = append(inactive, it)
+			}
+		}
+		s.active, s.inactive = active, inactive
+
+		if !s.allocateFree(cur) {
+			s.allocateBlocked(cur)
+		}
+		if cur.reg >= 0 {
+			s.active = append(s.active, cur)
+		}
+	}
+}
+
+// allocateFree assigns the interval a register that's free for at least
+// the start of it, splitting the interval where the register is next
+// needed. It returns false if no register is free.
+//
+// A register is free until the first instruction that uses it, as the
+// interval can only be split between instructions.
+func (s *scanner) allocateFree(cur *interval) bool {
+	freeUntil := make([]int, len(allocatable))
+	for r := range freeUntil {
+		freeUntil[r] = evenFloor(intersection(s.fixed[r], cur.ranges))
+	}
+	for _, it := range s.active {
+		freeUntil[it.reg] = 0
+	}
+	for _, it := range s.inactive {
+		freeUntil[it.reg] = min(freeUntil[it.reg], evenFloor(intersection(it.ranges, cur.ranges)))
+	}
+
+	reg := -1
+	for _, r := range s.hints[cur.id] {
+		if freeUntil[r] >= cur.end() {
+			reg = r
+			break
+		}
+	}
+	if reg == -1 {
+		reg = 0
+		for r := range freeUntil {
+			if freeUntil[r] > freeUntil[reg] {
+				reg = r
+			}
+		}
+	}
+
+	if freeUntil[reg] <= cur.start() {
+		return false
+	}
+	cur.reg = reg
+	if freeUntil[reg] < cur.end() {
+		s.split(cur, cur.start(), freeUntil[reg])
+	}
+	return true
+}
+
+// allocateBlocked assigns the interval the register whose intervals are
+// used furthest in the future, spilling them, or spills the interval if
+// it's used later than all of them.
+func (s *scanner) allocateBlocked(cur *interval) {
+	pos := cur.start()
+	nextUse := make([]int, len(allocatable))
+	blocked := make([]int, len(allocatable))
+	for r := range nextUse {
+		blocked[r] = evenFloor(intersection(s.fixed[r], cur.ranges))
+		nextUse[r] = blocked[r]
+	}
+	for _, it := range s.active {
+		nextUse[it.reg] = min(nextUse[it.reg], evenFloor(it.nextUse(pos)))
+	}
+	for _, it := range s.inactive {
+		if intersection(it.ranges, cur.ranges) != math.MaxInt {
+			nextUse[it.reg] = min(nextUse[it.reg], evenFloor(it.nextUse(pos)))
+		}
+	}
+
+	reg := 0
+	for r := range nextUse {
+		if nextUse[r] > nextUse[reg] {
+			reg = r
+		}
+	}
+
+	if nextUse[reg] <= pos || nextUse[reg] < cur.nextUse(pos) {
+		s.spill(cur)
+		return
+	}
+
+	cur.reg = reg
+	if blocked[reg] < cur.end() {
+		s.split(cur, pos, blocked[reg])
+	}
+
+	// Spill the intervals in the register that overlap this one.
+	var active []*interval
+	for _, it := range s.active {
+		if it.reg != reg {
+			active = append(active, it)
+			continue
+		}
+		s.spill(s.splitOff(it, evenFloor(pos)))
+	}
+	s.active = active
+	var inactive []*interval
+	for _, it := range s.inactive {
+		at := intersection(it.ranges, cur.ranges)
+		if it.reg != reg || at == math.MaxInt {
+			inactive = append(inactive, it)
+			continue
+		}
+		if rest := s.splitOff(it, evenFloor(at)); rest != it {
+			s.spill(rest)
+			inactive = append(inactive, it)
+		} else {
+			s.spill(it)
+		}
+	}
+	s.inactive = inactive
+}
+
+// spill puts the interval in the pseudo's stack slot until its next use in
+// a later instruction, where it's split so the rest can be allocated a
+// register.
+func (s *scanner) spill(it *interval) {
+	it.reg = -1
+	from := evenFloor(it.start())
+	use := it.nextUse(from + 2)
+	if use == math.MaxInt {
+		return
+	}
+	s.split(it, it.start(), evenFloor(use))
+}
+
+// split splits the interval between the positions after and by, where
+// both are even or after is the start of the interval, and adds the rest
+// to the unhandled intervals.
+func (s *scanner) split(it *interval, after int, by int) {
+	rest := it.splitAt(s.splitPos(after, by))
+	if len(rest.ranges) == 0 {
+		return
+	}
+	s.pieces[it.id] = append(s.pieces[it.id], rest)
+	heap.Push(&s.unhandled, rest)
+}
+
+// splitOff splits the interval at pos and returns the rest, or returns the
+// interval itself if it starts at or after pos.
+func (s *scanner) splitOff(it *interval, pos int) *interval {
+	if pos <= it.start() {
+		return it
+	}
+	rest := it.splitAt(pos)
+	s.pieces[it.id] = append(s.pieces[it.id], rest)
+	return rest
+}
+
+// splitPos returns the position to split an interval between the
+// instructions after the position after, up to the position by. It picks
+// the latest position with the lowest loop depth, so moves added at the
+// split are outside loops where possible.
+func (s *scanner) splitPos(after int, by int) int {
+	best := by
+	for pos := by - 2; pos > after; pos -= 2 {
+		if s.depths[pos/2] < s.depths[best/2] {
+			best = pos
+		}
+	}
+	return best
+}
+
+// location returns where the pseudo is at the position, and whether it's
+// live there.
+func (s *scanner) location(id int, pos int) (Operand, bool) {
+	for _, it := range s.pieces[id] {
+		if it.covers(pos) {
+			if it.reg >= 0 {
+				return reg(allocatable[it.reg]), true
+			}
+			return &PseudoOperand{V: s.live.pseudo(id)}, true
+		}
+	}
+	return &PseudoOperand{V: s.live.pseudo(id)}, false
+}
+
+// parallelMove is a move of a pseudo between the places it's at each end of
+// a split or jump.
+type parallelMove struct {
+	src, dest Operand
+	t         Type
+	// slot is the pseudo's stack slot.
+	slot Operand
+}
+
+// rewrite replaces the pseudos in each instruction with where they are at
+// the instruction, and adds the moves where a pseudo is in different
+// places either side of a split or jump.
+func (s *scanner) rewrite(labels map[string]bool) []Inst {
+	live := s.live
+	types := pseudoTypes(live)
+
+	// splits contains the pieces that start at each position, other than
+	// the first piece of each pseudo.
+	splits := make(map[int][]*interval)
+	for _, pieces := range s.pieces {
+		for _, it := range pieces[min(1, len(pieces)):] {
+			splits[it.start()] = append(splits[it.start()], it)
+		}
+	}
+
+	addMove := func(moves []parallelMove, id int, from, to int) []parallelMove {
+		src, ok := s.location(id, from)
+		if !ok {
+			return moves
+		}
+		dest, ok := s.location(id, to)
+		if !ok || sameLocation(src, dest) {
+			return moves
+		}
+		return append(moves, parallelMove{
+			src:  src,
+			dest: dest,
+			t:    types[id],
+			slot: &PseudoOperand{V: live.pseudo(id)},
+		})
+	}
+	// resolve returns the moves needed for the edge from instruction i to
+	// j. Where j starts a block, the pseudos live on entry to it may be in
+	// different places in each predecessor. Otherwise they only differ
+	// where a pseudo is split before j.
+	resolve := func(i, j int) []Inst {
+		var moves []parallelMove
+		if live.isBlockStart(j) {
+			for _, id := range live.in[live.blockOf[j]].elems() {
+				if !live.isRegister(id) {
+					moves = addMove(moves, id, 2*i+1, 2*j)
+				}
+			}
+		} else {
+			for _, it := range splits[2*j] {
+				moves = addMove(moves, it.id, 2*i+1, 2*j)
+			}
+		}
+		return sequentialize(moves)
+	}
+
+	var insts []Inst
+	var edges []Inst
+	for i, inst := range live.insts {
+		inst = mapOperands(inst, func(op Operand, _ Type) Operand {
+			pseudo, ok := op.(*PseudoOperand)
+			if !ok {
+				return op
+			}
+			id := live.ids[pseudo.V]
+			if loc, ok := s.location(id, 2*i); ok {
+				return loc
+			}
+			loc, _ := s.location(id, 2*i+1)
+			return loc
+		})
+
+		var after []Inst
+		seen := make(map[int]bool)
+		for _, j := range live.succs[i] {
+			if seen[j] {
+				continue
+			}
+			seen[j] = true
+			moves := resolve(i, j)
+			if len(moves) == 0 {
+				continue
+			}
+			switch inst := inst.(type) {
+			case *JmpInst:
+				insts = append(insts, moves...)
+			case *JmpCCInst:
+				switch {
+				case live.succs[i][0] == i+1:
+					// Both edges go to the next instruction, so the
+					// moves can go before the jump, as they don't change
+					// the flags.
+					insts = append(insts, moves...)
+				case j == i+1:
+					after = moves
+				default:
+					// The moves only apply when the jump is taken, so the
+					// jump goes via a new block that does the moves.
+					label := newLabel(labels, "resolve")
+					edges = append(edges, &LabelInst{Name: label})
+					edges = append(edges, moves...)
+					edges = append(edges, &JmpInst{Label: inst.Label})
+					inst.Label = label
+				}
+			default:
+				after = moves
+			}
+		}
+		insts = append(insts, inst)
+		insts = append(insts, after...)
+	}
+	return append(insts, edges...)
+}
+
+// sequentialize orders the moves so none overwrites the source of another
+// before it's read. Where the moves form a cycle, which can only happen
+// between registers, one register is copied to its pseudo's stack slot
+// first.
+func sequentialize(moves []parallelMove) []Inst {
+	var insts []Inst
+	for len(moves) > 0 {
+		progress := false
+		for i, m := range moves {
+			if isSource(moves, i, m.dest) {
+				continue
+			}
+			insts = append(insts, &MovInst{Type: m.t, L: m.src, R: m.dest})
+			moves = append(moves[:i], moves[i+1:]...)
+			progress = true
+			break
+		}
+		if progress {
+			continue
+		}
+
+		// Every destination is the source of another move. Each pseudo
+		// has one move, so its stack slot isn't the source of any other.
+		for i, m := range moves {
+			if sameLocation(m.src, moves[0].dest) {
+				insts = append(insts, &MovInst{Type: m.t, L: m.src, R: m.slot})
+				moves[i].src = m.slot
+				break
+			}
+		}
+	}
+	return insts
+}
+
+// isSource returns whether op is the source of a move other than the move
+// at index skip.
+func isSource(moves []parallelMove, skip int, op Operand) bool {
+	for i, m := range moves {
+		if i != skip && sameLocation(m.src, op) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameLocation(a, b Operand) bool {
+	if a, ok := a.(*RegisterOperand); ok {
+		b, ok := b.(*RegisterOperand)
+		return ok && a.Reg == b.Reg
+	}
+	return sameOperand(a, b)
+}
+
+// pseudoTypes returns the largest size each pseudo is accessed with.
+func pseudoTypes(live *liveness) []Type {
+	types := make([]Type, live.numLocations())
+	for _, inst := range live.insts {
+		mapOperands(inst, func(op Operand, t Type) Operand {
+			if pseudo, ok := op.(*PseudoOperand); ok {
+				id := live.ids[pseudo.V]
+				if types[id] == 0 || t.Size() > types[id].Size() {
+					types[id] = t
+				}
+			}
+			return op
+		})
+	}
+	return types
+}
+
+// fileLabels returns the labels used by the functions in the file.
+func fileLabels(root *File) map[string]bool {
+	labels := make(map[string]bool)
+	for _, decl := range root.Decls {
+		if fn, ok := decl.(*FuncDecl); ok {
+			for _, inst := range fn.Insts {
+				if label, ok := inst.(*LabelInst); ok {
+					labels[label.Name] = true
+				}
+			}
+		}
+	}
+	return labels
+}
+
+// newLabel returns a label starting with name that isn't in labels, and
+// adds it.
+func newLabel(labels map[string]bool, name string) string {
+	for i := 1; ; i++ {
+		label := fmt.Sprintf("%s.%d", name, i)
+		if !labels[label] {
+			labels[label] = true
+			return label
+		}
+	}
+}
+
+// evenFloor returns the position of the start of the instruction
+// containing pos.
+func evenFloor(pos int) int {
+	if pos == math.MaxInt {
+		return pos
+	}
+	return pos &^ 1
+}
+
+func dedupe(s []int) []int {
+	var deduped []int
+	for _, v := range s {
+		if len(deduped) == 0 || deduped[len(deduped)-1] != v {
+			deduped = append(deduped, v)
+		}
+	}
+	return deduped
+}
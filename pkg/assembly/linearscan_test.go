@@ -0,0 +1,151 @@
+package assembly
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/token"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocateRegistersLinear(t *testing.T) {
+	x := &PseudoOperand{V: "x"}
+	y := &PseudoOperand{V: "y"}
+	saved := &PseudoOperand{V: "saved.BX"}
+	f := &File{
+		Decls: []Decl{&FuncDecl{
+			Name: "main",
+			Insts: []Inst{
+				&MovInst{Type: Longword, L: reg("DI"), R: x},
+				&MovInst{Type: Longword, L: x, R: reg("DI")},
+				&CallInst{Func: "f", RegArgs: 1},
+				&MovInst{Type: Longword, L: reg("AX"), R: y},
+				&BinaryInst{Op: token.ADD, Type: Longword, Src: x, Dest: y},
+				&MovInst{Type: Longword, L: y, R: reg("AX")},
+				&RetInst{},
+			},
+		}},
+	}
+	assert.True(t, AllocateRegistersLinear(f))
+	// x is live across the call, so is in a callee saved register, and y
+	// is in AX, which it's copied from and to.
+	assert.Equal(t, []Inst{
+		&MovInst{Type: Quadword, L: reg("BX"), R: saved},
+		&MovInst{Type: Longword, L: reg("DI"), R: reg("BX")},
+		&MovInst{Type: Longword, L: reg("BX"), R: reg("DI")},
+		&CallInst{Func: "f", RegArgs: 1},
+		&BinaryInst{Op: token.ADD, Type: Longword, Src: reg("BX"), Dest: reg("AX")},
+		&MovInst{Type: Quadword, L: saved, R: reg("BX")},
+		&RetInst{},
+	}, f.Decls[0].(*FuncDecl).Insts)
+}
+
+func TestAllocateRegistersLinearSpill(t *testing.T) {
+	// 13 pseudos are live at once along with AX, which leaves 11 registers,
+	// so at least 2 are spilled for part of their lifetime.
+	var insts []Inst
+	for i := range 13 {
+		insts = append(insts, &MovInst{
+			Type: Longword,
+			L:    &ImmOperand{V: strconv.Itoa(i)},
+			R:    &PseudoOperand{V: fmt.Sprint("p", i)},
+		})
+	}
+	insts = append(insts, &MovInst{Type: Longword, L: &ImmOperand{V: "0"}, R: reg("AX")})
+	for i := range 13 {
+		insts = append(insts, &BinaryInst{
+			Op:   token.ADD,
+			Type: Longword,
+			Src:  &PseudoOperand{V: fmt.Sprint("p", i)},
+			Dest: reg("AX"),
+		})
+	}
+	insts = append(insts, &RetInst{})
+
+	fn := &FuncDecl{Name: "main", Insts: insts}
+	assert.True(t, AllocateRegistersLinear(&File{Decls: []Decl{fn}}))
+
+	spilled := make(map[string]bool)
+	for _, inst := range fn.Insts {
+		mapOperands(inst, func(op Operand, _ Type) Operand {
+			if pseudo, ok := op.(*PseudoOperand); ok && pseudo.V[0] == 'p' {
+				spilled[pseudo.V] = true
+			}
+			if r, ok := op.(*RegisterOperand); ok {
+				assert.NotContains(t, []string{"R10", "R11"}, r.Reg)
+			}
+			return op
+		})
+	}
+	assert.GreaterOrEqual(t, len(spilled), 2)
+}
+
+func TestAllocateRegistersLinearSplit(t *testing.T) {
+	// q0 to q4 are live across the call so take the callee saved
+	// registers. x is in AX in the loop, then must be moved to a callee
+	// saved register for the call, so the interval of q4, which is used
+	// furthest in the future, is split and spilled.
+	x := &PseudoOperand{V: "x"}
+	q := func(i int) Operand {
+		return &PseudoOperand{V: fmt.Sprint("q", i)}
+	}
+	var insts []Inst
+	for i := range 5 {
+		insts = append(insts, &MovInst{Type: Longword, L: &ImmOperand{V: "1"}, R: q(i)})
+	}
+	insts = append(insts,
+		&MovInst{Type: Longword, L: &ImmOperand{V: "0"}, R: x},
+		&LabelInst{Name: "loop"},
+		&CmpInst{Type: Longword, C: &ImmOperand{V: "10"}, V: x},
+		&JmpCCInst{C: CondCodeGE, Label: "end"},
+		&BinaryInst{Op: token.ADD, Type: Longword, Src: &ImmOperand{V: "1"}, Dest: x},
+		&JmpInst{Label: "loop"},
+		&LabelInst{Name: "end"},
+		&CallInst{Func: "f"},
+		&MovInst{Type: Longword, L: x, R: reg("AX")},
+	)
+	for i := range 5 {
+		insts = append(insts, &BinaryInst{Op: token.ADD, Type: Longword, Src: q(i), Dest: reg("AX")})
+	}
+	insts = append(insts, &RetInst{})
+
+	fn := &FuncDecl{Name: "main", Insts: insts}
+	assert.True(t, AllocateRegistersLinear(&File{Decls: []Decl{fn}}))
+
+	regs := []string{"BX", "R12", "R13", "R14", "R15"}
+	var want []Inst
+	for _, r := range regs {
+		want = append(want, &MovInst{Type: Quadword, L: reg(r), R: &PseudoOperand{V: "saved." + r}})
+	}
+	for _, r := range regs {
+		want = append(want, &MovInst{Type: Longword, L: &ImmOperand{V: "1"}, R: reg(r)})
+	}
+	want = append(want,
+		&MovInst{Type: Longword, L: &ImmOperand{V: "0"}, R: reg("AX")},
+		&LabelInst{Name: "loop"},
+		&CmpInst{Type: Longword, C: &ImmOperand{V: "10"}, V: reg("AX")},
+		&JmpCCInst{C: CondCodeGE, Label: "end"},
+		&BinaryInst{Op: token.ADD, Type: Longword, Src: &ImmOperand{V: "1"}, Dest: reg("AX")},
+		&JmpInst{Label: "loop"},
+		&LabelInst{Name: "end"},
+		// The moves at the split, q4 to its stack slot then x to R15.
+		&MovInst{Type: Longword, L: reg("R15"), R: q(4)},
+		&MovInst{Type: Longword, L: reg("AX"), R: reg("R15")},
+		&CallInst{Func: "f"},
+		&MovInst{Type: Longword, L: reg("R15"), R: reg("AX")},
+	)
+	for _, r := range regs[:4] {
+		want = append(want, &BinaryInst{Op: token.ADD, Type: Longword, Src: reg(r), Dest: reg("AX")})
+	}
+	want = append(want,
+		// q4 is loaded into a register before it's used.
+		&MovInst{Type: Longword, L: q(4), R: reg("CX")},
+		&BinaryInst{Op: token.ADD, Type: Longword, Src: reg("CX"), Dest: reg("AX")},
+	)
+	for _, r := range regs {
+		want = append(want, &MovInst{Type: Quadword, L: &PseudoOperand{V: "saved." + r}, R: reg(r)})
+	}
+	want = append(want, &RetInst{})
+	assert.Equal(t, want, fn.Insts)
+}
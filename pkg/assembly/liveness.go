@@ -0,0 +1,333 @@
+package assembly
+
+import "math/bits"
+
+// allocatable contains the registers the register allocator assigns to
+// pseudos, in order of preference. R10 and R11 are left for Fix to use as
+// scratch registers. The caller saved registers come first, as the callee
+// saved registers must be saved on entry and restored on return.
+var allocatable = []string{
+	"AX", "CX", "DX", "SI", "DI", "R8", "R9",
+	"BX", "R12", "R13", "R14", "R15",
+}
+
+// callerSaved contains the registers a call may change. The System V ABI
+// requires the callee to preserve the others.
+var callerSaved = []string{"AX", "CX", "DX", "SI", "DI", "R8", "R9", "R10", "R11"}
+
+// calleeSaved contains the allocatable registers a function must restore
+// before it returns if it changes them.
+var calleeSaved = map[string]bool{
+	"BX":  true,
+	"R12": true,
+	"R13": true,
+	"R14": true,
+	"R15": true,
+}
+
+// liveness is the locations live on entry to and exit from each basic
+// block in a function, where a location is a pseudo or an allocatable
+// register.
+//
+// Each location has an ID. The allocatable registers are the first IDs, in
+// the order of allocatable, followed by the pseudos in the order they're
+// first used.
+type liveness struct {
+	insts []Inst
+
+	// pseudos contains the name of each pseudo, indexed by its ID less the
+	// number of registers.
+	pseudos []string
+	ids     map[string]int
+
+	// defs and uses contain the locations each instruction writes and
+	// reads.
+	defs [][]int
+	uses [][]int
+	// succs contains the indices of the instructions that may run after
+	// each instruction.
+	succs [][]int
+
+	// blocks contains the basic blocks in order, and blockOf the index of
+	// the block containing each instruction.
+	blocks  []block
+	blockOf []int
+
+	// in and out contain the locations live on entry to and exit from each
+	// block.
+	in  []bitset
+	out []bitset
+}
+
+// block is the instructions from index start up to but not including end.
+type block struct {
+	start, end int
+}
+
+func computeLiveness(insts []Inst) *liveness {
+	l := &liveness{
+		insts:   insts,
+		ids:     make(map[string]int),
+		defs:    make([][]int, len(insts)),
+		uses:    make([][]int, len(insts)),
+		succs:   make([][]int, len(insts)),
+		blockOf: make([]int, len(insts)),
+	}
+
+	labels := make(map[string]int)
+	for i, inst := range insts {
+		if label, ok := inst.(*LabelInst); ok {
+			labels[label.Name] = i
+		}
+	}
+	for i, inst := range insts {
+		defs, uses := defsUses(inst)
+		l.defs[i] = l.locations(defs)
+		l.uses[i] = l.locations(uses)
+
+		switch inst := inst.(type) {
+		case *RetInst, *TailCallInst:
+		case *JmpInst:
+			l.succs[i] = []int{labels[inst.Label]}
+		case *JmpCCInst:
+			l.succs[i] = []int{labels[inst.Label]}
+			if i+1 < len(insts) {
+				l.succs[i] = append(l.succs[i], i+1)
+			}
+		default:
+			if i+1 < len(insts) {
+				l.succs[i] = []int{i + 1}
+			}
+		}
+	}
+
+	// A block starts at each label, and after each jump or return.
+	for i, inst := range insts {
+		_, isLabel := inst.(*LabelInst)
+		if i == 0 || isLabel || len(l.succs[i-1]) != 1 || l.succs[i-1][0] != i {
+			l.blocks = append(l.blocks, block{start: i})
+		}
+		l.blocks[len(l.blocks)-1].end = i + 1
+		l.blockOf[i] = len(l.blocks) - 1
+	}
+
+	l.in = make([]bitset, len(l.blocks))
+	l.out = make([]bitset, len(l.blocks))
+	for b := range l.blocks {
+		l.in[b] = newBitset(l.numLocations())
+		l.out[b] = newBitset(l.numLocations())
+	}
+
+	// Iterate backwards until nothing changes, where the locations live
+	// on entry to an instruction are those it reads, and those live after
+	// it that it doesn't write.
+	for changed := true; changed; {
+		changed = false
+		for b := len(l.blocks) - 1; b >= 0; b-- {
+			last := l.blocks[b].end - 1
+			for _, succ := range l.succs[last] {
+				l.out[b].union(l.in[l.blockOf[succ]])
+			}
+			in := l.out[b].clone()
+			l.walk(b, in, func(int) {})
+			if !in.equal(l.in[b]) {
+				l.in[b] = in
+				changed = true
+			}
+		}
+	}
+	return l
+}
+
+// walk visits the instructions in the block in reverse, where live is the
+// locations live on exit from the block. Each instruction is visited with
+// live containing the locations live after it, then live is updated to
+// those live before it.
+func (l *liveness) walk(b int, live bitset, visit func(i int)) {
+	for i := l.blocks[b].end - 1; i >= l.blocks[b].start; i-- {
+		visit(i)
+		for _, def := range l.defs[i] {
+			live.remove(def)
+		}
+		for _, use := range l.uses[i] {
+			live.add(use)
+		}
+	}
+}
+
+// isBlockStart returns whether the instruction at index i starts a block.
+func (l *liveness) isBlockStart(i int) bool {
+	return l.blocks[l.blockOf[i]].start == i
+}
+
+func (l *liveness) numLocations() int {
+	return len(allocatable) + len(l.pseudos)
+}
+
+func (l *liveness) isRegister(id int) bool {
+	return id < len(allocatable)
+}
+
+// pseudo returns the name of the pseudo with the ID.
+func (l *liveness) pseudo(id int) string {
+	return l.pseudos[id-len(allocatable)]
+}
+
+// locations returns the IDs of the operands that are locations, adding
+// any pseudos not seen before.
+func (l *liveness) locations(ops []Operand) []int {
+	var ids []int
+	for _, op := range ops {
+		switch op := op.(type) {
+		case *PseudoOperand:
+			id, ok := l.ids[op.V]
+			if !ok {
+				id = l.numLocations()
+				l.ids[op.V] = id
+				l.pseudos = append(l.pseudos, op.V)
+			}
+			ids = append(ids, id)
+		case *RegisterOperand:
+			for id, name := range allocatable {
+				if name == op.Reg {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+	return ids
+}
+
+// loopDepths returns the number of loops containing each instruction,
+// where a loop is the instructions from a label to a jump back to it.
+func (l *liveness) loopDepths() []int {
+	depths := make([]int, len(l.insts))
+	for i, succs := range l.succs {
+		for _, succ := range succs {
+			if succ > i {
+				continue
+			}
+			for j := succ; j <= i; j++ {
+				depths[j]++
+			}
+		}
+	}
+	return depths
+}
+
+// defsUses returns the operands the instruction writes and reads, including
+// the registers it writes and reads implicitly. An operand that's updated,
+// or only partly written, is both written and read.
+func defsUses(inst Inst) ([]Operand, []Operand) {
+	switch v := inst.(type) {
+	case *MovInst:
+		return []Operand{v.R}, []Operand{v.L}
+	case *MovsxInst:
+		return []Operand{v.Dest}, []Operand{v.Src}
+	case *MovZeroExtendInst:
+		return []Operand{v.Dest}, []Operand{v.Src}
+	case *LeaInst:
+		return []Operand{v.Dest}, []Operand{v.Src}
+	case *UnaryInst:
+		return []Operand{v.V}, []Operand{v.V}
+	case *BinaryInst:
+		return []Operand{v.Dest}, []Operand{v.Src, v.Dest}
+	case *ShiftInst:
+		return []Operand{v.Dest}, []Operand{v.Dest}
+	case *SetCCInst:
+		// Only the low byte is set.
+		return []Operand{v.V}, []Operand{v.V}
+	case *CmpInst:
+		return nil, []Operand{v.C, v.V}
+	case *PushInst:
+		return nil, []Operand{v.V}
+	case *CDQInst:
+		return []Operand{reg("DX")}, []Operand{reg("AX")}
+	case *IdivInst:
+		return []Operand{reg("AX"), reg("DX")}, []Operand{v.V, reg("AX"), reg("DX")}
+	case *DivInst:
+		return []Operand{reg("AX"), reg("DX")}, []Operand{v.V, reg("AX"), reg("DX")}
+	case *ImulInst:
+		return []Operand{reg("AX"), reg("DX")}, []Operand{v.V, reg("AX")}
+	case *MulInst:
+		return []Operand{reg("AX"), reg("DX")}, []Operand{v.V, reg("AX")}
+	case *CallInst:
+		return callClobbers(), argRegs(v.RegArgs)
+	case *CallIndirectInst:
+		return callClobbers(), append(argRegs(v.RegArgs), v.V)
+	case *TailCallInst:
+		return nil, argRegs(v.RegArgs)
+	case *RetInst:
+		return nil, []Operand{reg("AX")}
+	default:
+		return nil, nil
+	}
+}
+
+func callClobbers() []Operand {
+	var ops []Operand
+	for _, name := range callerSaved {
+		ops = append(ops, reg(name))
+	}
+	return ops
+}
+
+// argRegs returns the registers that pass the first n arguments.
+func argRegs(n int) []Operand {
+	var ops []Operand
+	for _, name := range paramPassingRegs[:n] {
+		ops = append(ops, reg(name))
+	}
+	return ops
+}
+
+// bitset is a set of small non-negative integers.
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (s bitset) has(i int) bool {
+	return s[i/64]&(1<<(i%64)) != 0
+}
+
+func (s bitset) add(i int) {
+	s[i/64] |= 1 << (i % 64)
+}
+
+func (s bitset) remove(i int) {
+	s[i/64] &^= 1 << (i % 64)
+}
+
+func (s bitset) union(o bitset) {
+	for i := range s {
+		s[i] |= o[i]
+	}
+}
+
+func (s bitset) equal(o bitset) bool {
+	for i := range s {
+		if s[i] != o[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s bitset) clone() bitset {
+	return append(bitset(nil), s...)
+}
+
+// elems returns the integers in the set in ascending order.
+func (s bitset) elems() []int {
+	var elems []int
+	for i, word := range s {
+		for word != 0 {
+			b := bits.TrailingZeros64(word)
+			elems = append(elems, i*64+b)
+			word &^= 1 << b
+		}
+	}
+	return elems
+}
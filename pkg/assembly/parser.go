@@ -1,6 +1,8 @@
 package assembly
 
 import (
+	"fmt"
+
 	"github.com/andydunstall/minc/pkg/ir"
 	"github.com/andydunstall/minc/pkg/token"
 )
@@ -14,10 +16,24 @@ func Parse(root ir.Node, debug bool) (n Node, err error) {
 }
 
 type parser struct {
+	// statics contains the names of the variables with static storage
+	// duration, which are addressed by name rather than on the stack.
+	statics map[string]bool
+
+	// labels contains the labels used by the functions parsed so far.
+	// Assembly labels are shared by the whole file, though IR labels are
+	// only unique within a function once optimized.
+	labels map[string]bool
+	// renamed maps each label in the current function that clashes with
+	// an earlier function to its new name.
+	renamed map[string]string
 }
 
 func newParser(debug bool) *parser {
-	return &parser{}
+	return &parser{
+		statics: make(map[string]bool),
+		labels:  make(map[string]bool),
+	}
 }
 
 func (p *parser) parse(n ir.Node) Node {
@@ -27,8 +43,18 @@ func (p *parser) parse(n ir.Node) Node {
 	case ir.Value:
 		return p.parseValue(v)
 	case *ir.File:
+		for _, decl := range v.Decls {
+			if decl, ok := decl.(*ir.StaticVarDecl); ok {
+				p.statics[decl.Name] = true
+			}
+		}
+
 		var decls []Decl
 		for _, decl := range v.Decls {
+			if decl, ok := decl.(*ir.StaticVarDecl); ok && decl.Init == nil {
+				// Defined in another file.
+				continue
+			}
 			decls = append(decls, p.parseDecl(decl))
 		}
 		return &File{
@@ -48,6 +74,11 @@ func (p *parser) parseValue(v ir.Value) Operand {
 			V: v.V,
 		}
 	case *ir.VarValue:
+		if p.statics[v.V] {
+			return &DataOperand{
+				Name: v.V,
+			}
+		}
 		return &PseudoOperand{
 			V: v.V,
 		}
@@ -62,12 +93,21 @@ func (p *parser) parseDecl(decl ir.Decl) Decl {
 	switch decl := decl.(type) {
 	case *ir.FuncDecl:
 		return p.parseFuncDecl(decl)
+	case *ir.StaticVarDecl:
+		return &StaticVarDecl{
+			Name:   decl.Name,
+			Global: decl.Global,
+			Type:   asmType(decl.Type),
+			Init:   decl.Init.V,
+		}
 	default:
 		panic("unsupported decl type")
 	}
 }
 
 func (p *parser) parseFuncDecl(decl *ir.FuncDecl) *FuncDecl {
+	p.renameLabels(decl)
+
 	var insts []Inst
 
 	for i := 0; i != 6; i++ {
@@ -76,21 +116,23 @@ func (p *parser) parseFuncDecl(decl *ir.FuncDecl) *FuncDecl {
 		}
 
 		insts = append(insts, &MovInst{
+			Type: asmType(decl.Params[i].Type),
 			L: &RegisterOperand{
 				Reg: paramPassingRegs[i],
 			},
 			R: &PseudoOperand{
-				V: decl.Params[i],
+				V: decl.Params[i].V,
 			},
 		})
 	}
 	for i := 6; i < len(decl.Params); i++ {
 		insts = append(insts, &MovInst{
+			Type: asmType(decl.Params[i].Type),
 			L: &StackOperand{
 				Offset: int32(16 + 8*(i-6)),
 			},
 			R: &PseudoOperand{
-				V: decl.Params[i],
+				V: decl.Params[i].V,
 			},
 		})
 	}
@@ -100,14 +142,43 @@ func (p *parser) parseFuncDecl(decl *ir.FuncDecl) *FuncDecl {
 	}
 
 	return &FuncDecl{
-		Name:  decl.Name,
-		Insts: insts,
+		Name:   decl.Name,
+		Global: decl.Global,
+		Insts:  insts,
+	}
+}
+
+// renameLabels renames the labels in the function that were used by an
+// earlier function, by adding the function name.
+func (p *parser) renameLabels(decl *ir.FuncDecl) {
+	p.renamed = make(map[string]string)
+	for _, inst := range decl.Insts {
+		label, ok := inst.(*ir.LabelInst)
+		if !ok {
+			continue
+		}
+		name := label.Name
+		if p.labels[name] {
+			name = label.Name + "." + decl.Name
+			for i := 1; p.labels[name]; i++ {
+				name = fmt.Sprintf("%s.%s.%d", label.Name, decl.Name, i)
+			}
+			p.renamed[label.Name] = name
+		}
+		p.labels[name] = true
+	}
+}
+
+func (p *parser) label(name string) string {
+	if renamed, ok := p.renamed[name]; ok {
+		return renamed
 	}
+	return name
 }
 
 // Instructions.
 
-func (p *parser) parseInst(inst ir.Inst) (insts []Inst) {
+func (p *parser) parseInst(inst ir.Inst) []Inst {
 	switch v := inst.(type) {
 	case *ir.RetInst:
 		return p.parseRetInst(v)
@@ -117,6 +188,12 @@ func (p *parser) parseInst(inst ir.Inst) (insts []Inst) {
 		return p.parseBinaryInst(v)
 	case *ir.CopyInst:
 		return p.parseCopyInst(v)
+	case *ir.SignExtendInst:
+		return p.parseSignExtendInst(v)
+	case *ir.ZeroExtendInst:
+		return p.parseZeroExtendInst(v)
+	case *ir.TruncateInst:
+		return p.parseTruncateInst(v)
 	case *ir.JumpInst:
 		return p.parseJumpInst(v)
 	case *ir.JumpIfZeroInst:
@@ -125,24 +202,36 @@ func (p *parser) parseInst(inst ir.Inst) (insts []Inst) {
 		return p.parseJumpIfNotZeroInst(v)
 	case *ir.CallInst:
 		return p.parseCallInst(v)
+	case *ir.TailCallInst:
+		return p.parseTailCallInst(v)
+	case *ir.CallIndirectInst:
+		return p.parseCallIndirectInst(v)
+	case *ir.GetAddressInst:
+		return []Inst{
+			&LeaInst{
+				Src: &DataOperand{
+					Name: v.Name,
+				},
+				Dest: p.parseValue(v.Dest),
+			},
+		}
 	case *ir.LabelInst:
 		return []Inst{
 			&LabelInst{
-				Name: v.Name,
+				Name: p.label(v.Name),
 			},
 		}
 	default:
 		panic("unsupported inst type")
 	}
-
-	return
 }
 
 func (p *parser) parseRetInst(inst *ir.RetInst) []Inst {
 	V := p.parseValue(inst.Value)
 	return []Inst{
 		&MovInst{
-			L: V,
+			Type: asmType(ir.TypeOf(inst.Value)),
+			L:    V,
 			R: &RegisterOperand{
 				Reg: "AX",
 			},
@@ -154,16 +243,19 @@ func (p *parser) parseRetInst(inst *ir.RetInst) []Inst {
 func (p *parser) parseUnaryInst(inst *ir.UnaryInst) []Inst {
 	src := p.parseValue(inst.Src)
 	dest := p.parseValue(inst.Dest)
+	t := asmType(ir.TypeOf(inst.Dest))
 
 	if inst.Op == token.NOT {
 		return []Inst{
 			&CmpInst{
+				Type: asmType(ir.TypeOf(inst.Src)),
 				C: &ImmOperand{
 					V: "0",
 				},
 				V: src,
 			},
 			&MovInst{
+				Type: t,
 				L: &ImmOperand{
 					V: "0",
 				},
@@ -178,12 +270,14 @@ func (p *parser) parseUnaryInst(inst *ir.UnaryInst) []Inst {
 
 	return []Inst{
 		&MovInst{
-			L: src,
-			R: dest,
+			Type: t,
+			L:    src,
+			R:    dest,
 		},
 		&UnaryInst{
-			Op: inst.Op,
-			V:  dest,
+			Op:   inst.Op,
+			Type: t,
+			V:    dest,
 		},
 	}
 }
@@ -193,75 +287,112 @@ func (p *parser) parseBinaryInst(inst *ir.BinaryInst) []Inst {
 	v2 := p.parseValue(inst.V2)
 	dest := p.parseValue(inst.Dest)
 
+	// The operands always have the same type, though the destination of a
+	// comparison is an int.
+	opType := ir.TypeOf(inst.V1)
+	t := asmType(opType)
+
 	switch inst.Op {
 	case token.QUO, token.REM:
 		reg := "AX"
 		if inst.Op == token.REM {
 			reg = "DX"
 		}
-		return []Inst{
+
+		insts := []Inst{
 			&MovInst{
-				L: v1,
+				Type: t,
+				L:    v1,
 				R: &RegisterOperand{
 					Reg: "AX",
 				},
 			},
-			&CDQInst{},
-			&IdivInst{
-				V: v2,
-			},
-			&MovInst{
-				L: &RegisterOperand{
-					Reg: reg,
+		}
+		if opType.Signed() {
+			insts = append(insts,
+				&CDQInst{
+					Type: t,
 				},
-				R: dest,
-			},
+				&IdivInst{
+					Type: t,
+					V:    v2,
+				},
+			)
+		} else {
+			insts = append(insts,
+				&MovInst{
+					Type: t,
+					L: &ImmOperand{
+						V: "0",
+					},
+					R: &RegisterOperand{
+						Reg: "DX",
+					},
+				},
+				&DivInst{
+					Type: t,
+					V:    v2,
+				},
+			)
 		}
+		return append(insts, &MovInst{
+			Type: t,
+			L: &RegisterOperand{
+				Reg: reg,
+			},
+			R: dest,
+		})
 	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
-		v1 := p.parseValue(inst.V1)
-		v2 := p.parseValue(inst.V2)
-		dest := p.parseValue(inst.Dest)
-
-		var code CondCode
-		switch inst.Op {
-		case token.EQL:
-			code = CondCodeE
-		case token.NEQ:
-			code = CondCodeNE
-		case token.LSS:
-			code = CondCodeL
-		case token.LEQ:
-			code = CondCodeLE
-		case token.GTR:
-			code = CondCodeG
-		case token.GEQ:
-			code = CondCodeGE
-		}
-
 		return []Inst{
 			&CmpInst{
-				C: v2,
-				V: v1,
+				Type: t,
+				C:    v2,
+				V:    v1,
 			},
 			&MovInst{
-				&ImmOperand{
+				Type: asmType(ir.TypeOf(inst.Dest)),
+				L: &ImmOperand{
 					V: "0",
 				},
-				dest,
+				R: dest,
 			},
 			&SetCCInst{
-				C: code,
+				C: condCode(inst.Op, opType.Signed()),
 				V: dest,
 			},
 		}
 	default:
+		if sameOperand(v2, dest) && !sameOperand(v1, dest) {
+			// Moving v1 to dest would overwrite v2 before it's used, so
+			// apply the operation to v2 in place instead.
+			if inst.Op == token.SUB {
+				// v1 - v2 is -v2 + v1.
+				return []Inst{
+					&UnaryInst{
+						Op:   token.SUB,
+						Type: t,
+						V:    dest,
+					},
+					&BinaryInst{
+						Op:   token.ADD,
+						Type: t,
+						Src:  v1,
+						Dest: dest,
+					},
+				}
+			}
+			v1, v2 = v2, v1
+		}
+
 		return []Inst{
 			&MovInst{
-				L: v1,
-				R: dest,
+				Type: t,
+				L:    v1,
+				R:    dest,
 			},
 			&BinaryInst{
 				Op:   inst.Op,
+				Type: t,
 				Src:  v2,
 				Dest: dest,
 			},
@@ -269,13 +400,69 @@ func (p *parser) parseBinaryInst(inst *ir.BinaryInst) []Inst {
 	}
 }
 
+// sameOperand returns whether the operands refer to the same variable.
+func sameOperand(a, b Operand) bool {
+	switch a := a.(type) {
+	case *PseudoOperand:
+		b, ok := b.(*PseudoOperand)
+		return ok && a.V == b.V
+	case *DataOperand:
+		b, ok := b.(*DataOperand)
+		return ok && a.Name == b.Name
+	default:
+		return false
+	}
+}
+
 func (p *parser) parseCopyInst(inst *ir.CopyInst) []Inst {
 	l := p.parseValue(inst.L)
 	r := p.parseValue(inst.R)
 	return []Inst{
 		&MovInst{
-			L: l,
-			R: r,
+			Type: asmType(ir.TypeOf(inst.R)),
+			L:    l,
+			R:    r,
+		},
+	}
+}
+
+func (p *parser) parseSignExtendInst(inst *ir.SignExtendInst) []Inst {
+	return []Inst{
+		&MovsxInst{
+			SrcType:  asmType(ir.TypeOf(inst.Src)),
+			DestType: asmType(ir.TypeOf(inst.Dest)),
+			Src:      p.parseValue(inst.Src),
+			Dest:     p.parseValue(inst.Dest),
+		},
+	}
+}
+
+func (p *parser) parseZeroExtendInst(inst *ir.ZeroExtendInst) []Inst {
+	return []Inst{
+		&MovZeroExtendInst{
+			SrcType:  asmType(ir.TypeOf(inst.Src)),
+			DestType: asmType(ir.TypeOf(inst.Dest)),
+			Src:      p.parseValue(inst.Src),
+			Dest:     p.parseValue(inst.Dest),
+		},
+	}
+}
+
+func (p *parser) parseTruncateInst(inst *ir.TruncateInst) []Inst {
+	t := asmType(ir.TypeOf(inst.Dest))
+
+	// Moving the low bytes of the source truncates it, though a constant
+	// must be truncated first to fit the smaller instruction.
+	src := p.parseValue(inst.Src)
+	if imm, ok := src.(*ImmOperand); ok {
+		src = truncateImm(imm, t)
+	}
+
+	return []Inst{
+		&MovInst{
+			Type: t,
+			L:    src,
+			R:    p.parseValue(inst.Dest),
 		},
 	}
 }
@@ -283,7 +470,7 @@ func (p *parser) parseCopyInst(inst *ir.CopyInst) []Inst {
 func (p *parser) parseJumpInst(inst *ir.JumpInst) []Inst {
 	return []Inst{
 		&JmpInst{
-			Label: inst.Label,
+			Label: p.label(inst.Label),
 		},
 	}
 }
@@ -291,6 +478,7 @@ func (p *parser) parseJumpInst(inst *ir.JumpInst) []Inst {
 func (p *parser) parseJumpIfZeroInst(inst *ir.JumpIfZeroInst) []Inst {
 	return []Inst{
 		&CmpInst{
+			Type: asmType(ir.TypeOf(inst.V)),
 			C: &ImmOperand{
 				V: "0",
 			},
@@ -298,7 +486,7 @@ func (p *parser) parseJumpIfZeroInst(inst *ir.JumpIfZeroInst) []Inst {
 		},
 		&JmpCCInst{
 			C:     CondCodeE,
-			Label: inst.Label,
+			Label: p.label(inst.Label),
 		},
 	}
 }
@@ -306,6 +494,7 @@ func (p *parser) parseJumpIfZeroInst(inst *ir.JumpIfZeroInst) []Inst {
 func (p *parser) parseJumpIfNotZeroInst(inst *ir.JumpIfNotZeroInst) []Inst {
 	return []Inst{
 		&CmpInst{
+			Type: asmType(ir.TypeOf(inst.V)),
 			C: &ImmOperand{
 				V: "0",
 			},
@@ -313,48 +502,114 @@ func (p *parser) parseJumpIfNotZeroInst(inst *ir.JumpIfNotZeroInst) []Inst {
 		},
 		&JmpCCInst{
 			C:     CondCodeNE,
-			Label: inst.Label,
+			Label: p.label(inst.Label),
 		},
 	}
 }
 
 func (p *parser) parseCallInst(inst *ir.CallInst) []Inst {
+	return p.parseCall(inst.Args, inst.Dest, []Inst{
+		&CallInst{
+			Func:    inst.Name,
+			RegArgs: regArgs(inst.Args),
+		},
+	})
+}
+
+func (p *parser) parseTailCallInst(inst *ir.TailCallInst) []Inst {
+	// Arguments on the stack would be removed with the frame.
+	if len(inst.Args) > len(paramPassingRegs) {
+		panic("tail call with stack arguments: " + inst.Name)
+	}
+
+	var insts []Inst
+	for i, arg := range inst.Args {
+		insts = append(insts, &MovInst{
+			Type: asmType(ir.TypeOf(arg)),
+			L:    p.parseValue(arg),
+			R: &RegisterOperand{
+				Reg: paramPassingRegs[i],
+			},
+		})
+	}
+	return append(insts, &TailCallInst{
+		Func:    inst.Name,
+		RegArgs: len(inst.Args),
+	})
+}
+
+func (p *parser) parseCallIndirectInst(inst *ir.CallIndirectInst) []Inst {
+	// Load the function pointer after the arguments are set up, as moving
+	// arguments to the stack may use other registers.
+	return p.parseCall(inst.Args, inst.Dest, []Inst{
+		&MovInst{
+			Type: Quadword,
+			L:    p.parseValue(inst.Func),
+			R: &RegisterOperand{
+				Reg: "R11",
+			},
+		},
+		&CallIndirectInst{
+			V: &RegisterOperand{
+				Reg: "R11",
+			},
+			RegArgs: regArgs(inst.Args),
+		},
+	})
+}
+
+// parseCall passes the arguments, calls the function using the call
+// instructions, then copies the result to dest.
+func (p *parser) parseCall(args []ir.Value, dest ir.Value, call []Inst) []Inst {
 	var insts []Inst
 
 	var padding int32
-	if len(inst.Args)%2 != 0 {
+	// Keep the stack 16 byte aligned when passing an odd number of
+	// arguments on the stack.
+	if len(args) > 6 && len(args)%2 != 0 {
 		padding = 8
-		// Padding.
 		insts = append(insts, &AllocateStackInst{
 			N: padding,
 		})
 	}
 
 	// Push args to registers.
-	n := len(inst.Args)
+	n := len(args)
 	if n > 6 {
 		n = 6
 	}
 	for i := 0; i < n; i++ {
 		insts = append(insts, &MovInst{
-			L: p.parseValue(inst.Args[i]),
+			Type: asmType(ir.TypeOf(args[i])),
+			L:    p.parseValue(args[i]),
 			R: &RegisterOperand{
 				Reg: paramPassingRegs[i],
 			},
 		})
 	}
 
-	// Push args to stack.
-	for i := 6; i < len(inst.Args); i++ {
-		v := p.parseValue(inst.Args[i])
+	// Push args to stack in reverse order.
+	for i := len(args) - 1; i >= 6; i-- {
+		t := asmType(ir.TypeOf(args[i]))
+		v := p.parseValue(args[i])
 		switch v := v.(type) {
 		case *ImmOperand, *RegisterOperand:
 			insts = append(insts, &PushInst{
 				V: v,
 			})
 		default:
+			if t == Quadword {
+				insts = append(insts, &PushInst{
+					V: v,
+				})
+				break
+			}
+
+			// Pushing a smaller operand from memory would read past the
+			// end of it, so move it to a register first.
 			insts = append(insts, &MovInst{
-				L: v,
+				Type: t,
+				L:    v,
 				R: &RegisterOperand{
 					Reg: "AX",
 				},
@@ -364,25 +619,80 @@ func (p *parser) parseCallInst(inst *ir.CallInst) []Inst {
 					Reg: "AX",
 				},
 			})
-
 		}
 	}
 
-	insts = append(insts, &CallInst{
-		inst.Name,
-	})
+	insts = append(insts, call...)
 
-	insts = append(insts, &DeallocateStackInst{
-		N: int32(len(inst.Args)*8) + padding,
-	})
+	var stackArgs int32
+	if len(args) > 6 {
+		stackArgs = int32(len(args) - 6)
+	}
+	// Only remove the stack arguments and padding if there are any.
+	if n := stackArgs*8 + padding; n > 0 {
+		insts = append(insts, &DeallocateStackInst{
+			N: n,
+		})
+	}
 
-	dest := p.parseValue(inst.Dest)
 	insts = append(insts, &MovInst{
+		Type: asmType(ir.TypeOf(dest)),
 		L: &RegisterOperand{
 			Reg: "AX",
 		},
-		R: dest,
+		R: p.parseValue(dest),
 	})
 
 	return insts
 }
+
+// regArgs returns the number of the arguments passed in registers.
+func regArgs(args []ir.Value) int {
+	return min(len(args), len(paramPassingRegs))
+}
+
+func condCode(op token.Token, signed bool) CondCode {
+	switch op {
+	case token.EQL:
+		return CondCodeE
+	case token.NEQ:
+		return CondCodeNE
+	}
+
+	if signed {
+		switch op {
+		case token.LSS:
+			return CondCodeL
+		case token.LEQ:
+			return CondCodeLE
+		case token.GTR:
+			return CondCodeG
+		case token.GEQ:
+			return CondCodeGE
+		}
+	} else {
+		switch op {
+		case token.LSS:
+			return CondCodeB
+		case token.LEQ:
+			return CondCodeBE
+		case token.GTR:
+			return CondCodeA
+		case token.GEQ:
+			return CondCodeAE
+		}
+	}
+	panic("unsupported comparison: " + op.String())
+}
+
+// asmType returns the assembly type with the same size as the IR type.
+func asmType(t ir.Type) Type {
+	switch t.Size() {
+	case 1:
+		return Byte
+	case 4:
+		return Longword
+	default:
+		return Quadword
+	}
+}
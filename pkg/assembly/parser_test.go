@@ -0,0 +1,52 @@
+package assembly
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRenamesClashingLabels(t *testing.T) {
+	// Passes add labels that are only unique within a function, but
+	// assembly labels are shared by the file.
+	irFile, err := ir.ParseText([]byte(`global fn f(i32 n) {
+	jz i32 n, block.1
+	ret i32 1
+block.1:
+	ret i32 0
+}
+
+global fn g(i32 n) {
+	jnz i32 n, block.1
+	jmp block.2
+block.1:
+	ret i32 1
+block.2:
+	ret i32 0
+}
+`), false)
+	require.NoError(t, err)
+
+	n, err := Parse(irFile, false)
+	require.NoError(t, err)
+
+	labels := func(decl Decl) []string {
+		var labels []string
+		for _, inst := range decl.(*FuncDecl).Insts {
+			switch inst := inst.(type) {
+			case *LabelInst:
+				labels = append(labels, inst.Name+":")
+			case *JmpInst:
+				labels = append(labels, inst.Label)
+			case *JmpCCInst:
+				labels = append(labels, inst.Label)
+			}
+		}
+		return labels
+	}
+	decls := n.(*File).Decls
+	assert.Equal(t, []string{"block.1", "block.1:"}, labels(decls[0]))
+	assert.Equal(t, []string{"block.1.g", "block.2", "block.1.g:", "block.2:"}, labels(decls[1]))
+}
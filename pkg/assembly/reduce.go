@@ -0,0 +1,468 @@
+package assembly
+
+import (
+	"math/bits"
+	"strconv"
+
+	"github.com/andydunstall/minc/pkg/token"
+)
+
+// ReduceStrength replaces multiplication, division and remainder by
+// constants with cheaper instructions:
+//
+//   - Multiplying by a power of two shifts left.
+//   - Dividing by a power of two shifts right, and the remainder masks the
+//     low bits. Signed operands are first biased so negative values round
+//     towards zero, as idiv does.
+//   - Dividing by any other constant multiplies by a 'magic number', an
+//     approximation of 2^N/d, and keeps the high half of the result
+//     (Hacker's Delight, chapter 10). The remainder is n - (n/d)*d.
+//
+// Division is slow on x86, taking tens of cycles, where these take a few.
+// Division by -1, 0 or 1, and byte operations, are left unchanged.
+//
+// It must run before Fix, as it matches the instructions Parse generates
+// for division. It returns whether any instructions were replaced.
+func ReduceStrength(root *File) bool {
+	changed := false
+	for _, decl := range root.Decls {
+		fn, ok := decl.(*FuncDecl)
+		if !ok {
+			continue
+		}
+
+		var insts []Inst
+		for i := 0; i < len(fn.Insts); i++ {
+			if reduced, n := reduceDiv(fn.Insts[i:]); n > 0 {
+				insts = append(insts, reduced...)
+				i += n - 1
+				changed = true
+				continue
+			}
+			if reduced, n := reduceMul(fn.Insts[i:]); n > 0 {
+				insts = append(insts, reduced...)
+				i += n - 1
+				changed = true
+				continue
+			}
+			insts = append(insts, fn.Insts[i])
+		}
+		fn.Insts = insts
+	}
+	return changed
+}
+
+// reduceMul replaces a multiplication by a power of two at the start of the
+// instructions with a shift. It returns the replacement and the number of
+// instructions replaced, or zero if there is no such multiplication.
+func reduceMul(insts []Inst) ([]Inst, int) {
+	mul, ok := insts[0].(*BinaryInst)
+	if ok && mul.Op == token.MUL {
+		if k, ok := powerOfTwo(mul.Src, mul.Type); ok {
+			return []Inst{&ShiftInst{
+				Op:   ShiftLeft,
+				Type: mul.Type,
+				N:    k,
+				Dest: mul.Dest,
+			}}, 1
+		}
+		return nil, 0
+	}
+
+	// Where the constant is the first operand, it's moved to the
+	// destination then multiplied by the other operand.
+	mov, ok := insts[0].(*MovInst)
+	if !ok || len(insts) < 2 {
+		return nil, 0
+	}
+	mul, ok = insts[1].(*BinaryInst)
+	if !ok || mul.Op != token.MUL || !sameOperand(mov.R, mul.Dest) || sameOperand(mul.Src, mul.Dest) {
+		return nil, 0
+	}
+	k, ok := powerOfTwo(mov.L, mov.Type)
+	if !ok {
+		return nil, 0
+	}
+	return []Inst{
+		&MovInst{
+			Type: mul.Type,
+			L:    mul.Src,
+			R:    mul.Dest,
+		},
+		&ShiftInst{
+			Op:   ShiftLeft,
+			Type: mul.Type,
+			N:    k,
+			Dest: mul.Dest,
+		},
+	}, 2
+}
+
+// reduceDiv replaces a division or remainder by a constant at the start of
+// the instructions. It returns the replacement and the number of
+// instructions replaced, or zero if there is no such division.
+func reduceDiv(insts []Inst) ([]Inst, int) {
+	// Parse divides with: move the dividend to AX, extend into DX, divide,
+	// then move the quotient from AX or the remainder from DX.
+	if len(insts) < 4 {
+		return nil, 0
+	}
+	load, ok := insts[0].(*MovInst)
+	if !ok || !isReg(load.R, "AX") || load.Type == Byte {
+		return nil, 0
+	}
+	store, ok := insts[3].(*MovInst)
+	if !ok || (!isReg(store.L, "AX") && !isReg(store.L, "DX")) {
+		return nil, 0
+	}
+	d := &divider{
+		t:    load.Type,
+		n:    load.L,
+		dest: store.R,
+		rem:  isReg(store.L, "DX"),
+	}
+
+	switch div := insts[2].(type) {
+	case *IdivInst:
+		if _, ok := insts[1].(*CDQInst); !ok {
+			return nil, 0
+		}
+		v, ok := immValue(div.V, d.t)
+		if !ok {
+			return nil, 0
+		}
+		divisor := signExtend(v, d.t)
+		if divisor >= -1 && divisor <= 1 {
+			// Division by -1 traps on overflow, which must be kept.
+			return nil, 0
+		}
+		return d.signed(divisor), 4
+	case *DivInst:
+		v, ok := immValue(div.V, d.t)
+		if !ok || v <= 1 {
+			return nil, 0
+		}
+		return d.unsigned(v), 4
+	default:
+		return nil, 0
+	}
+}
+
+// divider generates the instructions to divide n by a constant, storing
+// the quotient or remainder in dest. Only AX and DX are used, as Parse's
+// division did.
+type divider struct {
+	t    Type
+	n    Operand
+	dest Operand
+	// rem is whether to store the remainder rather than the quotient.
+	rem bool
+
+	insts []Inst
+}
+
+func (d *divider) signed(divisor int64) []Inst {
+	n := d.bits()
+	abs := uint64(divisor)
+	if divisor < 0 {
+		abs = -abs
+	}
+	abs &= d.mask()
+
+	if abs&(abs-1) == 0 {
+		k := bits.TrailingZeros64(abs)
+
+		// Add 2^k-1 to negative dividends so shifting rounds towards
+		// zero: the sign fills DX, then the top k bits are shifted down.
+		d.mov(d.n, reg("AX"))
+		d.mov(reg("AX"), reg("DX"))
+		d.shift(ShiftRightArith, n-1, reg("DX"))
+		d.shift(ShiftRightLogical, n-k, reg("DX"))
+		d.binary(token.ADD, reg("DX"), reg("AX"))
+
+		if d.rem {
+			// Clear the low bits to get the quotient times 2^k, then
+			// subtract from the dividend.
+			d.binary(token.AND, imm(-int64(abs), d.t), reg("AX"))
+			d.mov(d.n, reg("DX"))
+			d.binary(token.SUB, reg("AX"), reg("DX"))
+			d.mov(reg("DX"), d.dest)
+			return d.insts
+		}
+
+		d.shift(ShiftRightArith, k, reg("AX"))
+		if divisor < 0 {
+			d.emit(&UnaryInst{
+				Op:   token.SUB,
+				Type: d.t,
+				V:    reg("AX"),
+			})
+		}
+		d.mov(reg("AX"), d.dest)
+		return d.insts
+	}
+
+	magic, shift := signedMagic(divisor, n)
+	d.mov(imm(magic, d.t), reg("AX"))
+	d.emit(&ImulInst{
+		Type: d.t,
+		V:    d.n,
+	})
+	// The magic number doesn't fit in N bits with the sign of the divisor,
+	// so correct for its sign overflowing.
+	if divisor > 0 && magic < 0 {
+		d.binary(token.ADD, d.n, reg("DX"))
+	}
+	if divisor < 0 && magic > 0 {
+		d.binary(token.SUB, d.n, reg("DX"))
+	}
+	if shift > 0 {
+		d.shift(ShiftRightArith, shift, reg("DX"))
+	}
+	// Add one to a negative quotient to round towards zero.
+	d.mov(reg("DX"), reg("AX"))
+	d.shift(ShiftRightLogical, n-1, reg("AX"))
+	d.binary(token.ADD, reg("AX"), reg("DX"))
+
+	return d.store(divisor)
+}
+
+func (d *divider) unsigned(divisor uint64) []Inst {
+	if divisor&(divisor-1) == 0 {
+		k := bits.TrailingZeros64(divisor)
+		d.mov(d.n, reg("AX"))
+		if d.rem {
+			d.binary(token.AND, imm(int64(divisor-1), d.t), reg("AX"))
+		} else {
+			d.shift(ShiftRightLogical, k, reg("AX"))
+		}
+		d.mov(reg("AX"), d.dest)
+		return d.insts
+	}
+
+	magic, shift, add := unsignedMagic(divisor, d.bits())
+	d.mov(imm(int64(magic), d.t), reg("AX"))
+	d.emit(&MulInst{
+		Type: d.t,
+		V:    d.n,
+	})
+	if add {
+		// The magic number needs N+1 bits, so the top bit is added
+		// separately: (((n - hi) >> 1) + hi) >> (shift - 1).
+		d.mov(d.n, reg("AX"))
+		d.binary(token.SUB, reg("DX"), reg("AX"))
+		d.shift(ShiftRightLogical, 1, reg("AX"))
+		d.binary(token.ADD, reg("DX"), reg("AX"))
+		if shift > 1 {
+			d.shift(ShiftRightLogical, shift-1, reg("AX"))
+		}
+		d.mov(reg("AX"), reg("DX"))
+	} else if shift > 0 {
+		d.shift(ShiftRightLogical, shift, reg("DX"))
+	}
+
+	return d.store(int64(divisor))
+}
+
+// store stores the quotient in DX to dest, or the remainder if dividing by
+// divisor.
+func (d *divider) store(divisor int64) []Inst {
+	if d.rem {
+		d.binary(token.MUL, imm(divisor, d.t), reg("DX"))
+		d.mov(d.n, reg("AX"))
+		d.binary(token.SUB, reg("DX"), reg("AX"))
+		d.mov(reg("AX"), d.dest)
+		return d.insts
+	}
+	d.mov(reg("DX"), d.dest)
+	return d.insts
+}
+
+func (d *divider) mov(src, dest Operand) {
+	d.emit(&MovInst{
+		Type: d.t,
+		L:    src,
+		R:    dest,
+	})
+}
+
+func (d *divider) binary(op token.Token, src, dest Operand) {
+	d.emit(&BinaryInst{
+		Op:   op,
+		Type: d.t,
+		Src:  src,
+		Dest: dest,
+	})
+}
+
+func (d *divider) shift(op ShiftOp, n int, dest Operand) {
+	d.emit(&ShiftInst{
+		Op:   op,
+		Type: d.t,
+		N:    n,
+		Dest: dest,
+	})
+}
+
+func (d *divider) emit(inst Inst) {
+	d.insts = append(d.insts, inst)
+}
+
+func (d *divider) bits() int {
+	return int(d.t.Size()) * 8
+}
+
+func (d *divider) mask() uint64 {
+	return typeMask(d.t)
+}
+
+// signedMagic returns the magic number and shift to divide N-bit signed
+// integers by d, where |d| > 1. The quotient is the high half of n*magic,
+// corrected by n where magic has the wrong sign, shifted right by shift.
+//
+// This is the algorithm in Hacker's Delight figure 10-1, with the
+// arithmetic done modulo 2^N.
+func signedMagic(d int64, n int) (int64, int) {
+	mask := bitMask(n)
+	two := uint64(1) << (n - 1)
+
+	ad := uint64(d)
+	if d < 0 {
+		ad = -ad
+	}
+	ad &= mask
+	// The largest dividend, or one more for a negative divisor, less its
+	// remainder.
+	t := two + (uint64(d)&mask)>>(n-1)
+	anc := t - 1 - t%ad
+
+	p := n - 1
+	q1, r1 := two/anc, two%anc
+	q2, r2 := two/ad, two%ad
+	for {
+		p++
+		q1, r1 = (2*q1)&mask, 2*r1
+		if r1 >= anc {
+			q1++
+			r1 -= anc
+		}
+		q2, r2 = (2*q2)&mask, 2*r2
+		if r2 >= ad {
+			q2++
+			r2 -= ad
+		}
+		delta := ad - r2
+		if q1 > delta || (q1 == delta && r1 != 0) {
+			break
+		}
+	}
+
+	magic := (q2 + 1) & mask
+	if d < 0 {
+		magic = -magic & mask
+	}
+	return signExtendBits(magic, n), p - n
+}
+
+// unsignedMagic returns the magic number and shift to divide N-bit unsigned
+// integers by d, where d > 1. Where add is true, the magic number needs
+// N+1 bits, of which the top bit is not included.
+//
+// This is the algorithm in Hacker's Delight figure 10-2, with the
+// arithmetic done modulo 2^N.
+func unsignedMagic(d uint64, n int) (uint64, int, bool) {
+	mask := bitMask(n)
+	two := uint64(1) << (n - 1)
+
+	add := false
+	nc := (mask - (-d&mask)%d) & mask
+	p := n - 1
+	q1, r1 := two/nc, two%nc
+	q2, r2 := (two-1)/d, (two-1)%d
+	for {
+		p++
+		if r1 >= (nc-r1)&mask {
+			q1 = (2*q1 + 1) & mask
+			r1 = (2*r1 - nc) & mask
+		} else {
+			q1 = (2 * q1) & mask
+			r1 = (2 * r1) & mask
+		}
+		if r2+1 >= d-r2 {
+			if q2 >= two-1 {
+				add = true
+			}
+			q2 = (2*q2 + 1) & mask
+			r2 = (2*r2 + 1 - d) & mask
+		} else {
+			if q2 >= two {
+				add = true
+			}
+			q2 = (2 * q2) & mask
+			r2 = (2*r2 + 1) & mask
+		}
+		delta := d - 1 - r2
+		if p >= 2*n || q1 > delta || (q1 == delta && r1 != 0) {
+			break
+		}
+	}
+	return (q2 + 1) & mask, p - n, add
+}
+
+// powerOfTwo returns k where the operand is the constant 2^k and k > 0.
+func powerOfTwo(op Operand, t Type) (int, bool) {
+	v, ok := immValue(op, t)
+	if !ok || v <= 1 || v&(v-1) != 0 {
+		return 0, false
+	}
+	return bits.TrailingZeros64(v), true
+}
+
+// immValue returns the bits of the operand if it's a constant, truncated
+// to the size of t.
+func immValue(op Operand, t Type) (uint64, bool) {
+	i, ok := op.(*ImmOperand)
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(i.V, 10, 64)
+	if err != nil {
+		u, err := strconv.ParseUint(i.V, 10, 64)
+		if err != nil {
+			panic("invalid immediate: " + i.V)
+		}
+		v = int64(u)
+	}
+	return uint64(v) & typeMask(t), true
+}
+
+// imm returns the constant v truncated to the size of t.
+func imm(v int64, t Type) *ImmOperand {
+	return &ImmOperand{
+		V: strconv.FormatInt(signExtend(uint64(v), t), 10),
+	}
+}
+
+func signExtend(v uint64, t Type) int64 {
+	return signExtendBits(v, int(t.Size())*8)
+}
+
+func signExtendBits(v uint64, n int) int64 {
+	return int64(v<<(64-n)) >> (64 - n)
+}
+
+func typeMask(t Type) uint64 {
+	return bitMask(int(t.Size()) * 8)
+}
+
+func bitMask(n int) uint64 {
+	if n == 64 {
+		return ^uint64(0)
+	}
+	return uint64(1)<<n - 1
+}
+
+func isReg(op Operand, name string) bool {
+	r, ok := op.(*RegisterOperand)
+	return ok && r.Reg == name
+}
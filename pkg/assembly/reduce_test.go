@@ -0,0 +1,150 @@
+package assembly
+
+import (
+	"math"
+	"math/bits"
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/token"
+	"github.com/stretchr/testify/assert"
+)
+
+var dividends = []int64{
+	0, 1, 2, 3, 7, 100, 12345, 1 << 20, math.MaxInt32, math.MaxInt32 - 1,
+	math.MaxInt64, math.MaxInt64 - 1, 1<<32 + 17, 987654321987654321,
+}
+
+var divisors = []int64{
+	2, 3, 5, 6, 7, 10, 11, 25, 125, 641, 1000, 1 << 16, 1000000007,
+	math.MaxInt32, 1 << 31, 1<<32 + 1, 6700417, math.MaxInt64,
+}
+
+func TestSignedMagic(t *testing.T) {
+	for _, n := range []int{32, 64} {
+		for _, d := range signedValues(divisors, n) {
+			if d >= -1 && d <= 1 {
+				continue
+			}
+			magic, shift := signedMagic(d, n)
+			for _, v := range signedValues(dividends, n) {
+				// The sequence ReduceStrength generates.
+				q := mulHighSigned(v, magic, n)
+				if d > 0 && magic < 0 {
+					q = truncate(q+v, n)
+				}
+				if d < 0 && magic > 0 {
+					q = truncate(q-v, n)
+				}
+				q >>= shift
+				q = truncate(q+int64(uint64(q)&bitMask(n)>>(n-1)), n)
+
+				assert.Equal(t, v/d, q, "%d-bit %d / %d", n, v, d)
+			}
+		}
+	}
+}
+
+func TestUnsignedMagic(t *testing.T) {
+	for _, n := range []int{32, 64} {
+		mask := bitMask(n)
+		var ds []uint64
+		for _, d := range divisors {
+			ds = append(ds, uint64(d)&mask, -uint64(d)&mask)
+		}
+		for _, d := range ds {
+			if d <= 1 {
+				continue
+			}
+			magic, shift, add := unsignedMagic(d, n)
+			for _, v := range dividends {
+				for _, u := range []uint64{uint64(v) & mask, -uint64(v) & mask} {
+					// The sequence ReduceStrength generates.
+					hi, _ := bits.Mul64(u, magic)
+					if n == 32 {
+						hi = (u * magic) >> 32
+					}
+					q := hi
+					if add {
+						q = (((u-hi)&mask)>>1 + hi) >> (shift - 1)
+					} else {
+						q >>= shift
+					}
+
+					assert.Equal(t, u/d, q, "%d-bit %d / %d", n, u, d)
+				}
+			}
+		}
+	}
+}
+
+func TestReduceStrength(t *testing.T) {
+	x := &PseudoOperand{V: "x"}
+	y := &PseudoOperand{V: "y"}
+	f := &File{
+		Decls: []Decl{&FuncDecl{
+			Name: "main",
+			Insts: []Inst{
+				&MovInst{Type: Longword, L: x, R: reg("AX")},
+				&CDQInst{Type: Longword},
+				&IdivInst{Type: Longword, V: &ImmOperand{V: "8"}},
+				&MovInst{Type: Longword, L: reg("AX"), R: y},
+				&MovInst{Type: Longword, L: &ImmOperand{V: "4"}, R: y},
+				&BinaryInst{Op: token.MUL, Type: Longword, Src: x, Dest: y},
+				// Division by -1 may trap, so is kept.
+				&MovInst{Type: Longword, L: x, R: reg("AX")},
+				&CDQInst{Type: Longword},
+				&IdivInst{Type: Longword, V: &ImmOperand{V: "-1"}},
+				&MovInst{Type: Longword, L: reg("AX"), R: y},
+			},
+		}},
+	}
+	assert.True(t, ReduceStrength(f))
+	assert.Equal(t, []Inst{
+		&MovInst{Type: Longword, L: x, R: reg("AX")},
+		&MovInst{Type: Longword, L: reg("AX"), R: reg("DX")},
+		&ShiftInst{Op: ShiftRightArith, Type: Longword, N: 31, Dest: reg("DX")},
+		&ShiftInst{Op: ShiftRightLogical, Type: Longword, N: 29, Dest: reg("DX")},
+		&BinaryInst{Op: token.ADD, Type: Longword, Src: reg("DX"), Dest: reg("AX")},
+		&ShiftInst{Op: ShiftRightArith, Type: Longword, N: 3, Dest: reg("AX")},
+		&MovInst{Type: Longword, L: reg("AX"), R: y},
+		&MovInst{Type: Longword, L: x, R: y},
+		&ShiftInst{Op: ShiftLeft, Type: Longword, N: 2, Dest: y},
+		&MovInst{Type: Longword, L: x, R: reg("AX")},
+		&CDQInst{Type: Longword},
+		&IdivInst{Type: Longword, V: &ImmOperand{V: "-1"}},
+		&MovInst{Type: Longword, L: reg("AX"), R: y},
+	}, f.Decls[0].(*FuncDecl).Insts)
+}
+
+// signedValues returns the values and their negations, truncated to n
+// bits.
+func signedValues(values []int64, n int) []int64 {
+	var signed []int64
+	for _, v := range values {
+		signed = append(signed, truncate(v, n), truncate(-v, n))
+	}
+	signed = append(signed, minInt(n))
+	return signed
+}
+
+func mulHighSigned(a, b int64, n int) int64 {
+	if n == 32 {
+		return (a * b) >> 32
+	}
+	hi, _ := bits.Mul64(uint64(a), uint64(b))
+	if a < 0 {
+		hi -= uint64(b)
+	}
+	if b < 0 {
+		hi -= uint64(a)
+	}
+	return int64(hi)
+}
+
+func truncate(v int64, n int) int64 {
+	return signExtendBits(uint64(v)&bitMask(n), n)
+}
+
+func minInt(n int) int64 {
+	return -1 << (n - 1)
+}
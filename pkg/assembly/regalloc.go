@@ -0,0 +1,624 @@
+package assembly
+
+import (
+	"math"
+	"sort"
+)
+
+// AllocateRegisters assigns the pseudos in each function to registers,
+// rather than Fix giving each its own stack slot, using iterated register
+// coalescing (Appel, Modern Compiler Implementation, chapter 11).
+//
+// Two pseudos interfere if one is written while the other is live, so
+// they can't share a register. The registers the code uses directly are
+// pre-coloured nodes in the interference graph: AX and DX for division,
+// multiplication and the return value, the argument registers, and the
+// caller saved registers, which each call writes. A pseudo live across a
+// call therefore gets a callee saved register, which is saved on entry to
+// the function and restored before it returns.
+//
+// Pseudos that are copied to each other are coalesced into one node where
+// it won't make the graph harder to colour, so the copy can be removed.
+// Where the graph can't be coloured with the allocatable registers, the
+// pseudos with the lowest cost, the number of times they're used weighted
+// by loop depth, relative to their degree are spilled. A spilled pseudo is
+// left for Fix, which gives it a stack slot and loads and stores it
+// through R10 and R11, which are never allocated.
+//
+// The interference graph grows with the square of the number of pseudos,
+// so functions with more than maxColoringLocations locations, such as in
+// large generated sources, are allocated with AllocateRegistersLinear
+// instead.
+//
+// It must run after ReduceStrength, which matches the pseudos Parse
+// generates. It returns whether any pseudos were assigned registers.
+func AllocateRegisters(root *File) bool {
+	labels := fileLabels(root)
+	changed := false
+	for _, decl := range root.Decls {
+		fn, ok := decl.(*FuncDecl)
+		if !ok {
+			continue
+		}
+		live := computeLiveness(fn.Insts)
+		if live.numLocations() > maxColoringLocations {
+			if scanFunc(fn, live, labels) {
+				changed = true
+			}
+			continue
+		}
+		colors := newColoring(live).allocate()
+		if len(colors) > 0 {
+			fn.Insts = assignRegisters(fn.Insts, colors)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// maxColoringLocations is the most locations a function can have to be
+// allocated by graph colouring.
+const maxColoringLocations = 4096
+
+// The state of each node in the interference graph, which is in exactly
+// one of the sets of nodes in Appel's algorithm.
+type nodeState int
+
+const (
+	nodePrecolored nodeState = iota
+	nodeInitial
+	nodeSimplify
+	nodeFreeze
+	nodeSpill
+	nodeSpilled
+	nodeCoalesced
+	nodeColored
+	nodeSelected
+)
+
+// The state of each move, which is in exactly one of the sets of moves in
+// Appel's algorithm.
+type moveState int
+
+const (
+	moveWorklist moveState = iota
+	moveActive
+	moveCoalesced
+	moveConstrained
+	moveFrozen
+)
+
+type move struct {
+	src, dest int
+}
+
+// coloring colours the interference graph of a function, where the nodes
+// are the locations in the function's liveness.
+type coloring struct {
+	live *liveness
+	// k is the number of colours.
+	k int
+
+	state  []nodeState
+	adjSet []bitset
+	// adjList contains the neighbours of each pseudo. Registers have too
+	// many neighbours to track, so only adjSet is kept for them.
+	adjList [][]int
+	degree  []int
+	alias   []int
+	color   []int
+	cost    []float64
+
+	moves     []move
+	moveState []moveState
+	// moveList contains the moves each node is an operand of.
+	moveList [][]int
+
+	// The worklists may contain nodes and moves that have since changed
+	// state, which are skipped.
+	simplifyWorklist []int
+	freezeWorklist   []int
+	spillWorklist    []int
+	worklistMoves    []int
+	selectStack      []int
+}
+
+func newColoring(live *liveness) *coloring {
+	n := live.numLocations()
+	c := &coloring{
+		live:     live,
+		k:        len(allocatable),
+		state:    make([]nodeState, n),
+		adjSet:   make([]bitset, n),
+		adjList:  make([][]int, n),
+		degree:   make([]int, n),
+		alias:    make([]int, n),
+		color:    make([]int, n),
+		cost:     make([]float64, n),
+		moveList: make([][]int, n),
+	}
+	for id := range n {
+		c.adjSet[id] = newBitset(n)
+		c.alias[id] = id
+		c.color[id] = -1
+		if live.isRegister(id) {
+			c.state[id] = nodePrecolored
+			c.color[id] = id
+			c.degree[id] = math.MaxInt
+		} else {
+			c.state[id] = nodeInitial
+		}
+	}
+	return c
+}
+
+// allocate returns the register assigned to each pseudo that isn't spilled.
+func (c *coloring) allocate() map[string]string {
+	c.build()
+	c.makeWorklist()
+	for {
+		if id, ok := pop(&c.simplifyWorklist, c.state, nodeSimplify); ok {
+			c.simplify(id)
+		} else if m, ok := pop(&c.worklistMoves, c.moveState, moveWorklist); ok {
+			c.coalesce(m)
+		} else if id, ok := pop(&c.freezeWorklist, c.state, nodeFreeze); ok {
+			c.freeze(id)
+		} else if len(c.spillWorklist) > 0 {
+			c.selectSpill()
+		} else {
+			break
+		}
+	}
+	c.assignColors()
+
+	colors := make(map[string]string)
+	for id := len(allocatable); id < len(c.state); id++ {
+		if c.color[id] >= 0 {
+			colors[c.live.pseudo(id)] = allocatable[c.color[id]]
+		}
+	}
+	return colors
+}
+
+// build adds an edge between each location an instruction writes and each
+// location live after it, and records the moves between locations.
+func (c *coloring) build() {
+	live := c.live
+	depths := live.loopDepths()
+	for b := range live.blocks {
+		out := live.out[b].clone()
+		live.walk(b, out, func(i int) {
+			c.buildInst(i, depths[i], out)
+		})
+	}
+
+	// The locations live on entry to the function, such as the argument
+	// registers, hold their values at the same time.
+	if len(live.blocks) > 0 {
+		entry := live.in[0].elems()
+		for _, u := range entry {
+			for _, v := range entry {
+				c.addEdge(u, v)
+			}
+		}
+	}
+}
+
+// buildInst adds the edges and moves of the instruction at index i, where
+// out is the locations live after it.
+func (c *coloring) buildInst(i int, depth int, out bitset) {
+	live := c.live
+	for _, id := range append(live.defs[i], live.uses[i]...) {
+		c.cost[id] += math.Pow(10, float64(depth))
+	}
+
+	out = out.clone()
+	if m, ok := c.move(i, live.insts[i]); ok {
+		// The source and destination of a move hold the same value so may
+		// share a register, unless the destination is written again while
+		// the source is live.
+		out.remove(m.src)
+		id := len(c.moves)
+		c.moves = append(c.moves, m)
+		c.moveState = append(c.moveState, moveWorklist)
+		c.moveList[m.src] = append(c.moveList[m.src], id)
+		c.moveList[m.dest] = append(c.moveList[m.dest], id)
+		c.worklistMoves = append(c.worklistMoves, id)
+	}
+	for _, def := range live.defs[i] {
+		out.add(def)
+	}
+	for _, def := range live.defs[i] {
+		for _, id := range out.elems() {
+			c.addEdge(def, id)
+		}
+	}
+}
+
+// move returns the locations of the instruction at index i if it copies
+// one location to another.
+func (c *coloring) move(i int, inst Inst) (move, bool) {
+	if _, ok := inst.(*MovInst); !ok {
+		return move{}, false
+	}
+	defs, uses := c.live.defs[i], c.live.uses[i]
+	if len(defs) != 1 || len(uses) != 1 || defs[0] == uses[0] {
+		return move{}, false
+	}
+	return move{src: uses[0], dest: defs[0]}, true
+}
+
+func (c *coloring) addEdge(u, v int) {
+	if u == v || c.adjSet[u].has(v) {
+		return
+	}
+	c.adjSet[u].add(v)
+	c.adjSet[v].add(u)
+	if c.state[u] != nodePrecolored {
+		c.adjList[u] = append(c.adjList[u], v)
+		c.degree[u]++
+	}
+	if c.state[v] != nodePrecolored {
+		c.adjList[v] = append(c.adjList[v], u)
+		c.degree[v]++
+	}
+}
+
+func (c *coloring) makeWorklist() {
+	for id, state := range c.state {
+		if state != nodeInitial {
+			continue
+		}
+		switch {
+		case c.degree[id] >= c.k:
+			c.setState(id, nodeSpill)
+		case c.moveRelated(id):
+			c.setState(id, nodeFreeze)
+		default:
+			c.setState(id, nodeSimplify)
+		}
+	}
+}
+
+// setState moves the node to the set for the state, adding it to the
+// worklist for the state if there is one.
+func (c *coloring) setState(id int, state nodeState) {
+	if c.state[id] == nodeSpill {
+		c.spillWorklist = remove(c.spillWorklist, id)
+	}
+	c.state[id] = state
+	switch state {
+	case nodeSimplify:
+		c.simplifyWorklist = append(c.simplifyWorklist, id)
+	case nodeFreeze:
+		c.freezeWorklist = append(c.freezeWorklist, id)
+	case nodeSpill:
+		c.spillWorklist = append(c.spillWorklist, id)
+	case nodeSelected:
+		c.selectStack = append(c.selectStack, id)
+	}
+}
+
+// adjacent returns the neighbours of the node still in the graph.
+func (c *coloring) adjacent(id int) []int {
+	var adj []int
+	for _, n := range c.adjList[id] {
+		if c.state[n] != nodeSelected && c.state[n] != nodeCoalesced {
+			adj = append(adj, n)
+		}
+	}
+	return adj
+}
+
+// nodeMoves returns the moves of the node that may still be coalesced.
+func (c *coloring) nodeMoves(id int) []int {
+	var moves []int
+	for _, m := range c.moveList[id] {
+		if c.moveState[m] == moveActive || c.moveState[m] == moveWorklist {
+			moves = append(moves, m)
+		}
+	}
+	return moves
+}
+
+func (c *coloring) moveRelated(id int) bool {
+	return len(c.nodeMoves(id)) > 0
+}
+
+// simplify removes a node of low degree from the graph, as it can always
+// be coloured once its neighbours are.
+func (c *coloring) simplify(id int) {
+	c.setState(id, nodeSelected)
+	for _, n := range c.adjacent(id) {
+		c.decrementDegree(n)
+	}
+}
+
+func (c *coloring) decrementDegree(id int) {
+	if c.state[id] == nodePrecolored {
+		return
+	}
+	d := c.degree[id]
+	c.degree[id]--
+	if d != c.k {
+		return
+	}
+	// The node now has low degree, so its moves and its neighbours' moves
+	// may now be coalesced.
+	c.enableMoves(append(c.adjacent(id), id))
+	if c.state[id] != nodeSpill {
+		return
+	}
+	if c.moveRelated(id) {
+		c.setState(id, nodeFreeze)
+	} else {
+		c.setState(id, nodeSimplify)
+	}
+}
+
+func (c *coloring) enableMoves(ids []int) {
+	for _, id := range ids {
+		for _, m := range c.nodeMoves(id) {
+			if c.moveState[m] == moveActive {
+				c.moveState[m] = moveWorklist
+				c.worklistMoves = append(c.worklistMoves, m)
+			}
+		}
+	}
+}
+
+// coalesce combines the operands of the move into one node if they don't
+// interfere, and the result won't make the graph harder to colour.
+func (c *coloring) coalesce(m int) {
+	x, y := c.getAlias(c.moves[m].src), c.getAlias(c.moves[m].dest)
+	u, v := x, y
+	if c.state[y] == nodePrecolored {
+		u, v = y, x
+	}
+
+	// A register is coalesced with George's test, as its neighbours
+	// aren't tracked, and pseudos with Briggs' test.
+	safe := false
+	if c.state[u] == nodePrecolored {
+		safe = c.george(u, v)
+	} else {
+		safe = c.briggs(u, v)
+	}
+
+	switch {
+	case u == v:
+		c.moveState[m] = moveCoalesced
+		c.addWorklist(u)
+	case c.state[v] == nodePrecolored || c.adjSet[u].has(v):
+		c.moveState[m] = moveConstrained
+		c.addWorklist(u)
+		c.addWorklist(v)
+	case safe:
+		c.moveState[m] = moveCoalesced
+		c.combine(u, v)
+		c.addWorklist(u)
+	default:
+		c.moveState[m] = moveActive
+	}
+}
+
+func (c *coloring) addWorklist(id int) {
+	if c.state[id] == nodeFreeze && !c.moveRelated(id) && c.degree[id] < c.k {
+		c.setState(id, nodeSimplify)
+	}
+}
+
+// george returns whether each neighbour of v either has low degree or
+// already interferes with the register u, so coalescing v into u doesn't
+// make the graph harder to colour.
+func (c *coloring) george(u, v int) bool {
+	for _, t := range c.adjacent(v) {
+		if c.degree[t] >= c.k && c.state[t] != nodePrecolored && !c.adjSet[t].has(u) {
+			return false
+		}
+	}
+	return true
+}
+
+// briggs returns whether the node combining u and v would have fewer than
+// k neighbours of high degree, so could still be simplified.
+func (c *coloring) briggs(u, v int) bool {
+	seen := make(map[int]bool)
+	high := 0
+	for _, t := range append(c.adjacent(u), c.adjacent(v)...) {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		if c.degree[t] >= c.k {
+			high++
+		}
+	}
+	return high < c.k
+}
+
+func (c *coloring) getAlias(id int) int {
+	for c.state[id] == nodeCoalesced {
+		id = c.alias[id]
+	}
+	return id
+}
+
+func (c *coloring) combine(u, v int) {
+	c.setState(v, nodeCoalesced)
+	c.alias[v] = u
+	c.moveList[u] = append(c.moveList[u], c.moveList[v]...)
+	c.cost[u] += c.cost[v]
+	c.enableMoves([]int{v})
+	for _, t := range c.adjacent(v) {
+		c.addEdge(t, u)
+		c.decrementDegree(t)
+	}
+	if c.degree[u] >= c.k && c.state[u] == nodeFreeze {
+		c.setState(u, nodeSpill)
+	}
+}
+
+// freeze gives up coalescing the moves of a node of low degree, so it can
+// be simplified.
+func (c *coloring) freeze(id int) {
+	c.setState(id, nodeSimplify)
+	c.freezeMoves(id)
+}
+
+func (c *coloring) freezeMoves(u int) {
+	for _, m := range c.nodeMoves(u) {
+		x, y := c.getAlias(c.moves[m].src), c.getAlias(c.moves[m].dest)
+		v := y
+		if y == c.getAlias(u) {
+			v = x
+		}
+		c.moveState[m] = moveFrozen
+		if c.state[v] == nodeFreeze && !c.moveRelated(v) && c.degree[v] < c.k {
+			c.setState(v, nodeSimplify)
+		}
+	}
+}
+
+// selectSpill removes the node that is cheapest to spill from the graph,
+// in the hope it's coloured anyway. Otherwise it's spilled when colours
+// are assigned.
+func (c *coloring) selectSpill() {
+	best := -1
+	for _, id := range c.spillWorklist {
+		if best == -1 || c.cost[id]/float64(c.degree[id]) < c.cost[best]/float64(c.degree[best]) {
+			best = id
+		}
+	}
+	c.setState(best, nodeSimplify)
+	c.freezeMoves(best)
+}
+
+// assignColors colours the nodes in the reverse of the order they were
+// removed from the graph, so each node's neighbours that are already
+// coloured are those it had when removed.
+func (c *coloring) assignColors() {
+	for len(c.selectStack) > 0 {
+		id := c.selectStack[len(c.selectStack)-1]
+		c.selectStack = c.selectStack[:len(c.selectStack)-1]
+
+		used := make([]bool, c.k)
+		for _, n := range c.adjList[id] {
+			n = c.getAlias(n)
+			if c.state[n] == nodeColored || c.state[n] == nodePrecolored {
+				used[c.color[n]] = true
+			}
+		}
+		c.state[id] = nodeSpilled
+		for color, used := range used {
+			if !used {
+				c.state[id] = nodeColored
+				c.color[id] = color
+				break
+			}
+		}
+	}
+	for id, state := range c.state {
+		if state == nodeCoalesced {
+			c.color[id] = c.color[c.getAlias(id)]
+		}
+	}
+}
+
+// assignRegisters replaces the pseudos with their registers.
+func assignRegisters(insts []Inst, colors map[string]string) []Inst {
+	var updated []Inst
+	for _, inst := range insts {
+		updated = append(updated, mapOperands(inst, func(op Operand, _ Type) Operand {
+			if pseudo, ok := op.(*PseudoOperand); ok {
+				if r, ok := colors[pseudo.V]; ok {
+					return reg(r)
+				}
+			}
+			return op
+		}))
+	}
+	return saveCalleeSaved(removeSelfMoves(updated))
+}
+
+// removeSelfMoves removes the moves from a register to itself, which are
+// left where a copy's operands were assigned the same register.
+func removeSelfMoves(insts []Inst) []Inst {
+	var updated []Inst
+	for _, inst := range insts {
+		if mov, ok := inst.(*MovInst); ok {
+			l, lok := mov.L.(*RegisterOperand)
+			r, rok := mov.R.(*RegisterOperand)
+			if lok && rok && l.Reg == r.Reg {
+				continue
+			}
+		}
+		updated = append(updated, inst)
+	}
+	return updated
+}
+
+// saveCalleeSaved saves the callee saved registers the instructions use to
+// pseudos on entry, and restores them before each return and tail call.
+// The pseudos are given stack slots by Fix.
+func saveCalleeSaved(insts []Inst) []Inst {
+	saved := make(map[string]bool)
+	for _, inst := range insts {
+		mapOperands(inst, func(op Operand, _ Type) Operand {
+			if r, ok := op.(*RegisterOperand); ok && calleeSaved[r.Reg] {
+				saved[r.Reg] = true
+			}
+			return op
+		})
+	}
+	var regs []string
+	for r := range saved {
+		regs = append(regs, r)
+	}
+	sort.Strings(regs)
+
+	var updated []Inst
+	for _, r := range regs {
+		updated = append(updated, &MovInst{
+			Type: Quadword,
+			L:    reg(r),
+			R:    &PseudoOperand{V: "saved." + r},
+		})
+	}
+	for _, inst := range insts {
+		switch inst.(type) {
+		case *RetInst, *TailCallInst:
+			for _, r := range regs {
+				updated = append(updated, &MovInst{
+					Type: Quadword,
+					L:    &PseudoOperand{V: "saved." + r},
+					R:    reg(r),
+				})
+			}
+		}
+		updated = append(updated, inst)
+	}
+	return updated
+}
+
+// pop removes and returns the last item in the worklist that is still in
+// the state of the worklist, discarding any others.
+func pop[S comparable](worklist *[]int, states []S, state S) (int, bool) {
+	for len(*worklist) > 0 {
+		id := (*worklist)[len(*worklist)-1]
+		*worklist = (*worklist)[:len(*worklist)-1]
+		if states[id] == state {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func remove(s []int, v int) []int {
+	for i, e := range s {
+		if e == v {
+			return append(s[:i], s[i+1:]...)
+		}
+	}
+	return s
+}
@@ -0,0 +1,82 @@
+package assembly
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/token"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocateRegisters(t *testing.T) {
+	x := &PseudoOperand{V: "x"}
+	y := &PseudoOperand{V: "y"}
+	saved := &PseudoOperand{V: "saved.BX"}
+	f := &File{
+		Decls: []Decl{&FuncDecl{
+			Name: "main",
+			Insts: []Inst{
+				&MovInst{Type: Longword, L: reg("DI"), R: x},
+				&MovInst{Type: Longword, L: x, R: reg("DI")},
+				&CallInst{Func: "f", RegArgs: 1},
+				&MovInst{Type: Longword, L: reg("AX"), R: y},
+				&BinaryInst{Op: token.ADD, Type: Longword, Src: x, Dest: y},
+				&MovInst{Type: Longword, L: y, R: reg("AX")},
+				&RetInst{},
+			},
+		}},
+	}
+	assert.True(t, AllocateRegisters(f))
+	// x is live across the call, so is in a callee saved register, and y
+	// is coalesced with AX.
+	assert.Equal(t, []Inst{
+		&MovInst{Type: Quadword, L: reg("BX"), R: saved},
+		&MovInst{Type: Longword, L: reg("DI"), R: reg("BX")},
+		&MovInst{Type: Longword, L: reg("BX"), R: reg("DI")},
+		&CallInst{Func: "f", RegArgs: 1},
+		&BinaryInst{Op: token.ADD, Type: Longword, Src: reg("BX"), Dest: reg("AX")},
+		&MovInst{Type: Quadword, L: saved, R: reg("BX")},
+		&RetInst{},
+	}, f.Decls[0].(*FuncDecl).Insts)
+}
+
+func TestAllocateRegistersSpill(t *testing.T) {
+	// 13 pseudos are live at once along with AX, which leaves 11 registers,
+	// so 2 must be spilled.
+	var insts []Inst
+	for i := range 13 {
+		insts = append(insts, &MovInst{
+			Type: Longword,
+			L:    &ImmOperand{V: strconv.Itoa(i)},
+			R:    &PseudoOperand{V: fmt.Sprint("p", i)},
+		})
+	}
+	insts = append(insts, &MovInst{Type: Longword, L: &ImmOperand{V: "0"}, R: reg("AX")})
+	for i := range 13 {
+		insts = append(insts, &BinaryInst{
+			Op:   token.ADD,
+			Type: Longword,
+			Src:  &PseudoOperand{V: fmt.Sprint("p", i)},
+			Dest: reg("AX"),
+		})
+	}
+	insts = append(insts, &RetInst{})
+
+	fn := &FuncDecl{Name: "main", Insts: insts}
+	assert.True(t, AllocateRegisters(&File{Decls: []Decl{fn}}))
+
+	spilled := make(map[string]bool)
+	for _, inst := range fn.Insts {
+		mapOperands(inst, func(op Operand, _ Type) Operand {
+			if pseudo, ok := op.(*PseudoOperand); ok && pseudo.V[0] == 'p' {
+				spilled[pseudo.V] = true
+			}
+			if r, ok := op.(*RegisterOperand); ok {
+				assert.NotContains(t, []string{"R10", "R11"}, r.Reg)
+			}
+			return op
+		})
+	}
+	assert.Len(t, spilled, 2)
+}
@@ -16,6 +16,8 @@ type Expr interface {
 type UnaryExpr struct {
 	Op   token.Token
 	Expr Expr
+
+	Type Type
 }
 
 func (n *UnaryExpr) node()     {}
@@ -25,6 +27,8 @@ type BinaryExpr struct {
 	Op token.Token
 	L  Expr
 	R  Expr
+
+	Type Type
 }
 
 func (n *BinaryExpr) node()     {}
@@ -32,6 +36,8 @@ func (n *BinaryExpr) exprNode() {}
 
 type VarExpr struct {
 	Name string
+
+	Type Type
 }
 
 func (n *VarExpr) node()     {}
@@ -40,14 +46,18 @@ func (n *VarExpr) exprNode() {}
 type AssignExpr struct {
 	L Expr
 	R Expr
+
+	Type Type
 }
 
 func (n *AssignExpr) node()     {}
 func (n *AssignExpr) exprNode() {}
 
 type CallExpr struct {
-	Func string
+	Func Expr
 	Args []Expr
+
+	Type Type
 }
 
 func (n *CallExpr) node()     {}
@@ -56,11 +66,73 @@ func (n *CallExpr) exprNode() {}
 type BasicLitExpr struct {
 	Kind  token.Token
 	Value string
+
+	Type Type
 }
 
 func (n *BasicLitExpr) node()     {}
 func (n *BasicLitExpr) exprNode() {}
 
+// AddrOfExpr takes the address of a function.
+type AddrOfExpr struct {
+	Expr Expr
+
+	Type Type
+}
+
+func (n *AddrOfExpr) node()     {}
+func (n *AddrOfExpr) exprNode() {}
+
+// CastExpr converts Expr to type T. As well as explicit casts, the type
+// checker adds casts wherever C performs an implicit conversion.
+type CastExpr struct {
+	T    Type
+	Expr Expr
+}
+
+func (n *CastExpr) node()     {}
+func (n *CastExpr) exprNode() {}
+
+// SizeofExpr is the size of the type of Expr. Expr is not evaluated.
+type SizeofExpr struct {
+	Expr Expr
+}
+
+func (n *SizeofExpr) node()     {}
+func (n *SizeofExpr) exprNode() {}
+
+type SizeofTypeExpr struct {
+	T Type
+}
+
+func (n *SizeofTypeExpr) node()     {}
+func (n *SizeofTypeExpr) exprNode() {}
+
+// TypeOf returns the type of the expression assigned by the type checker,
+// or nil if the expression hasn't been type checked.
+func TypeOf(expr Expr) Type {
+	switch expr := expr.(type) {
+	case *UnaryExpr:
+		return expr.Type
+	case *BinaryExpr:
+		return expr.Type
+	case *VarExpr:
+		return expr.Type
+	case *AssignExpr:
+		return expr.Type
+	case *CallExpr:
+		return expr.Type
+	case *BasicLitExpr:
+		return expr.Type
+	case *AddrOfExpr:
+		return expr.Type
+	case *CastExpr:
+		return expr.T
+	default:
+		return nil
+	}
+}
+
 // Statements.
 
 type Stmt interface {
@@ -136,30 +208,73 @@ type Decl interface {
 	declNode()
 }
 
+// StorageClass is the storage class specifier of a declaration.
+type StorageClass int
+
+const (
+	StorageNone StorageClass = iota
+	StorageStatic
+	StorageExtern
+)
+
 type VarDecl struct {
 	Name string
-	Expr Expr
+	Type Type
+	// Expr is the initializer, or nil if the variable isn't initialized.
+	Expr    Expr
+	Storage StorageClass
+	// Used is whether the variable's value is read, which the validator
+	// sets for local variables. Assigning the variable isn't a use.
+	Used bool
 }
 
 func (n *VarDecl) node()     {}
 func (n *VarDecl) declNode() {}
 
-type FuncType struct {
-	// TODO(andydunstall): No types yet, only identifier names.
-	Params []string
-}
-
-func (n *FuncType) node() {}
-
 type FuncDecl struct {
 	Name string
 	Type *FuncType
-	Body *BlockStmt
+	// Body is nil if the function is declared but not defined.
+	Body    *BlockStmt
+	Storage StorageClass
+	// Attrs contains the names of the function's attributes, such as
+	// "inline" for '@inline'.
+	Attrs []string
+	// Used is whether the function is referenced other than by itself,
+	// which the validator sets.
+	Used bool
 }
 
 func (n *FuncDecl) node()     {}
 func (n *FuncDecl) declNode() {}
 
+// TypedefDecl declares Name as an alias for Type. The parser resolves
+// typedef names as it goes, so later stages can ignore these declarations.
+type TypedefDecl struct {
+	Name string
+	Type Type
+}
+
+func (n *TypedefDecl) node()     {}
+func (n *TypedefDecl) declNode() {}
+
+type EnumConst struct {
+	Name string
+	// Value is the explicit value of the constant, or nil if the value
+	// follows on from the previous constant.
+	Value Expr
+}
+
+func (n *EnumConst) node() {}
+
+type EnumDecl struct {
+	Type   *EnumType
+	Consts []*EnumConst
+}
+
+func (n *EnumDecl) node()     {}
+func (n *EnumDecl) declNode() {}
+
 type File struct {
 	Decls []Decl
 }
@@ -18,18 +18,48 @@ type parser struct {
 
 	scanner *token.Scanner
 
+	// scopes contains the identifiers and enum tags declared in each
+	// enclosing scope, with the innermost scope last. The scanner can't
+	// distinguish typedef names from other identifiers, so the parser uses
+	// the scopes to classify identifiers as it reads them.
+	scopes []*scope
+
 	line   int
 	indent int
 	debug  bool
 }
 
+type scope struct {
+	// idents maps each identifier declared in the scope to its type if it
+	// is a typedef name, or nil if it is an ordinary identifier (which
+	// hides any typedef of the same name in an enclosing scope).
+	idents map[string]Type
+	tags   map[string]*EnumType
+}
+
+func newScope() *scope {
+	return &scope{
+		idents: make(map[string]Type),
+		tags:   make(map[string]*EnumType),
+	}
+}
+
 func newParser(scanner *token.Scanner, debug bool) *parser {
+	universe := newScope()
+	universe.idents["char"] = &CharType{}
+	universe.idents["uchar"] = &UCharType{}
+	universe.idents["int"] = &IntType{}
+	universe.idents["uint"] = &UIntType{}
+	universe.idents["long"] = &LongType{}
+	universe.idents["ulong"] = &ULongType{}
+
 	p := &parser{
 		scanner: scanner,
+		scopes:  []*scope{universe, newScope()},
 		line:    1,
 		debug:   debug,
 	}
-	p.tok, p.lit = p.scanner.Scan()
+	p.scan()
 	return p
 }
 
@@ -99,7 +129,7 @@ func (p *parser) parseBinaryExpr(l Expr, prec int) *BinaryExpr {
 	}
 }
 
-func (p *parser) parseCallExpr(name string) *CallExpr {
+func (p *parser) parseCallExpr(fn Expr) *CallExpr {
 	if p.debug {
 		defer un(trace(p, "CallExpr"))
 	}
@@ -117,7 +147,7 @@ func (p *parser) parseCallExpr(name string) *CallExpr {
 	p.expect(token.RPAREN)
 
 	return &CallExpr{
-		Func: name,
+		Func: fn,
 		Args: args,
 	}
 }
@@ -127,6 +157,18 @@ func (p *parser) parseFactor() Expr {
 		defer un(trace(p, "Factor"))
 	}
 
+	expr := p.parseOperand()
+	for p.tok == token.LPAREN {
+		expr = p.parseCallExpr(expr)
+	}
+	return expr
+}
+
+func (p *parser) parseOperand() Expr {
+	if p.debug {
+		defer un(trace(p, "Operand"))
+	}
+
 	switch p.tok {
 	case token.INT:
 		f := &BasicLitExpr{
@@ -136,34 +178,83 @@ func (p *parser) parseFactor() Expr {
 		p.next()
 		return f
 	case token.SUB, token.TILDE, token.NOT:
+		// Unary operators bind tighter than any binary operator, so
+		// -a - b is (-a) - b.
 		op := p.tok
 		p.next()
-		expr := p.parseExpr(0)
 		return &UnaryExpr{
 			Op:   op,
-			Expr: expr,
+			Expr: p.parseFactor(),
 		}
 	case token.LPAREN:
 		p.next()
+		if p.isTypeStart() {
+			return p.parseCastExpr()
+		}
 		expr := p.parseExpr(0)
 		p.expect(token.RPAREN)
 		return expr
 	case token.IDENT:
 		name := p.lit
 		p.next()
-
-		if p.tok == token.LPAREN {
-			return p.parseCallExpr(name)
-		} else {
-			return &VarExpr{
-				Name: name,
-			}
+		return &VarExpr{
+			Name: name,
+		}
+	case token.AND:
+		p.next()
+		return &AddrOfExpr{
+			Expr: p.parseFactor(),
 		}
+	case token.SIZEOF:
+		return p.parseSizeofExpr()
 	default:
 		panic("unknown: " + p.tok.String())
 	}
 }
 
+// parseCastExpr parses a cast following the opening parenthesis.
+func (p *parser) parseCastExpr() *CastExpr {
+	if p.debug {
+		defer un(trace(p, "CastExpr"))
+	}
+
+	t := p.parseType()
+	p.expect(token.RPAREN)
+	return &CastExpr{
+		T:    t,
+		Expr: p.parseFactor(),
+	}
+}
+
+func (p *parser) parseSizeofExpr() Expr {
+	if p.debug {
+		defer un(trace(p, "SizeofExpr"))
+	}
+
+	p.expect(token.SIZEOF)
+
+	if p.tok != token.LPAREN {
+		return &SizeofExpr{
+			Expr: p.parseFactor(),
+		}
+	}
+
+	p.next()
+	if p.isTypeStart() {
+		t := p.parseType()
+		p.expect(token.RPAREN)
+		return &SizeofTypeExpr{
+			T: t,
+		}
+	}
+
+	expr := p.parseExpr(0)
+	p.expect(token.RPAREN)
+	return &SizeofExpr{
+		Expr: expr,
+	}
+}
+
 // Statements.
 
 func (p *parser) parseStmt() (s Stmt) {
@@ -176,7 +267,7 @@ func (p *parser) parseStmt() (s Stmt) {
 		s = p.parseBlockStmt()
 	case token.RETURN:
 		s = p.parseReturnStmt()
-	case token.LET:
+	case token.LET, token.TYPEDEF, token.ENUM, token.STATIC, token.EXTERN:
 		s = p.parseDeclStmt()
 	case token.IF:
 		s = p.parseIfStmt()
@@ -198,10 +289,20 @@ func (p *parser) parseBlockStmt() *BlockStmt {
 	}
 
 	p.expect(token.LBRACE)
+	p.openScope()
+	return p.parseBlockBody()
+}
+
+// parseBlockBody parses the statements in a block up to and including the
+// closing brace, then closes the block's scope.
+func (p *parser) parseBlockBody() *BlockStmt {
 	var list []Stmt
 	for p.tok != token.RBRACE && p.tok != token.EOF {
 		list = append(list, p.parseStmt())
 	}
+	// Close the scope before consuming the closing brace so the token
+	// following the block is classified using the enclosing scope.
+	p.closeScope()
 	p.expect(token.RBRACE)
 	return &BlockStmt{
 		List: list,
@@ -314,15 +415,74 @@ func (p *parser) parseDecl() Decl {
 	}
 
 	switch p.tok {
+	case token.AT:
+		return p.parseAttrDecl()
+	case token.STATIC, token.EXTERN:
+		return p.parseStorageDecl()
 	case token.FN:
 		return p.parseFuncDecl()
 	case token.LET:
 		return p.parseVarDecl()
+	case token.TYPEDEF:
+		return p.parseTypedefDecl()
+	case token.ENUM:
+		return p.parseEnumDecl()
 	default:
 		panic("unsupported decl")
 	}
 }
 
+// parseStorageDecl parses a function or variable declaration with a
+// storage class specifier.
+func (p *parser) parseStorageDecl() Decl {
+	storage := StorageStatic
+	if p.tok == token.EXTERN {
+		storage = StorageExtern
+	}
+	p.next()
+
+	switch p.tok {
+	case token.FN:
+		decl := p.parseFuncDecl()
+		decl.Storage = storage
+		return decl
+	case token.LET:
+		decl := p.parseVarDecl()
+		decl.Storage = storage
+		return decl
+	default:
+		panic("expected function or variable declaration")
+	}
+}
+
+// parseAttrDecl parses a function declaration preceded by attributes, such
+// as '@inline'.
+func (p *parser) parseAttrDecl() Decl {
+	if p.debug {
+		defer un(trace(p, "AttrDecl"))
+	}
+
+	var attrs []string
+	for p.tok == token.AT {
+		p.next()
+		attrs = append(attrs, p.parseIdent())
+	}
+
+	var decl Decl
+	switch p.tok {
+	case token.STATIC, token.EXTERN:
+		decl = p.parseStorageDecl()
+	case token.FN:
+		decl = p.parseFuncDecl()
+	}
+	funcDecl, ok := decl.(*FuncDecl)
+	if !ok {
+		panic("attributes must precede a function declaration")
+	}
+	funcDecl.Attrs = attrs
+	return funcDecl
+}
+
 func (p *parser) parseFuncDecl() *FuncDecl {
 	if p.debug {
 		defer un(trace(p, "FuncDecl"))
@@ -330,21 +490,22 @@ func (p *parser) parseFuncDecl() *FuncDecl {
 
 	p.expect(token.FN)
 	funcName := p.parseIdent()
+	p.declare(funcName, nil)
 
 	var funcType FuncType
 
+	// The parameters share a scope with the function body.
+	p.openScope()
+
 	p.expect(token.LPAREN)
 	for p.tok != token.RPAREN {
-		paramType := p.lit
-		p.expect(token.IDENT)
-		if paramType != "int" {
-			panic("unsupported type: " + paramType)
-		}
-
-		name := p.lit
-		p.expect(token.IDENT)
+		paramType := p.parseType()
+		name := p.parseDeclName()
 
-		funcType.Params = append(funcType.Params, name)
+		funcType.Params = append(funcType.Params, &Param{
+			Name: name,
+			Type: paramType,
+		})
 
 		if p.tok != token.RPAREN {
 			p.expect(token.COMMA)
@@ -352,7 +513,30 @@ func (p *parser) parseFuncDecl() *FuncDecl {
 	}
 	p.expect(token.RPAREN)
 
-	body := p.parseBlockStmt()
+	if p.isTypeStart() {
+		funcType.Result = p.parseType()
+	} else {
+		funcType.Result = &IntType{}
+	}
+
+	// The parameters are declared after the result type, so a parameter
+	// that hides a typedef name doesn't hide it in the result type.
+	for _, param := range funcType.Params {
+		p.declare(param.Name, nil)
+	}
+
+	if p.tok == token.SEMICOLON {
+		// A declaration without a body.
+		p.next()
+		p.closeScope()
+		return &FuncDecl{
+			Name: funcName,
+			Type: &funcType,
+		}
+	}
+
+	p.expect(token.LBRACE)
+	body := p.parseBlockBody()
 	return &FuncDecl{
 		Name: funcName,
 		Type: &funcType,
@@ -366,25 +550,227 @@ func (p *parser) parseVarDecl() *VarDecl {
 	}
 
 	p.expect(token.LET)
-	name := p.lit
-	p.expect(token.IDENT)
-	p.expect(token.ASSIGN)
 
-	expr := p.parseExpr(0)
+	// The type is optional and defaults to int.
+	var t Type = &IntType{}
+	var name string
+	switch {
+	case p.tok == token.TYPENAME:
+		// A typedef name followed by '=' or ';' is the name of the
+		// variable, which hides the typedef, rather than its type.
+		typeName := p.lit
+		t = p.parseType()
+		if p.tok == token.ASSIGN || p.tok == token.SEMICOLON {
+			t = &IntType{}
+			name = typeName
+		} else {
+			name = p.parseDeclName()
+		}
+	case p.isTypeStart():
+		t = p.parseType()
+		name = p.parseDeclName()
+	default:
+		name = p.parseIdent()
+	}
+
+	p.declare(name, nil)
+
+	// The initializer is optional.
+	var expr Expr
+	if p.tok == token.ASSIGN {
+		p.next()
+		expr = p.parseExpr(0)
+	}
 	p.expect(token.SEMICOLON)
 
 	return &VarDecl{
 		Name: name,
+		Type: t,
 		Expr: expr,
 	}
 }
 
+func (p *parser) parseTypedefDecl() *TypedefDecl {
+	if p.debug {
+		defer un(trace(p, "TypedefDecl"))
+	}
+
+	p.expect(token.TYPEDEF)
+	t := p.parseType()
+
+	name := p.parseDeclName()
+
+	// Declare the typedef before consuming the semicolon, so the following
+	// token is classified with the new name in scope.
+	p.declare(name, t)
+	p.expect(token.SEMICOLON)
+
+	return &TypedefDecl{
+		Name: name,
+		Type: t,
+	}
+}
+
+func (p *parser) parseEnumDecl() *EnumDecl {
+	if p.debug {
+		defer un(trace(p, "EnumDecl"))
+	}
+
+	p.expect(token.ENUM)
+
+	var tag string
+	if p.tok != token.LBRACE {
+		tag = p.parseDeclName()
+	}
+
+	enumType := &EnumType{
+		Name: tag,
+	}
+	if tag != "" {
+		s := p.scopes[len(p.scopes)-1]
+		if _, ok := s.tags[tag]; ok {
+			panic("duplicate enum: " + tag)
+		}
+		s.tags[tag] = enumType
+	}
+
+	var consts []*EnumConst
+
+	p.expect(token.LBRACE)
+	for p.tok != token.RBRACE {
+		name := p.parseIdent()
+		p.declare(name, nil)
+
+		var value Expr
+		if p.tok == token.ASSIGN {
+			p.next()
+			value = p.parseExpr(0)
+		}
+
+		consts = append(consts, &EnumConst{
+			Name:  name,
+			Value: value,
+		})
+
+		if p.tok != token.RBRACE {
+			p.expect(token.COMMA)
+		}
+	}
+	p.expect(token.RBRACE)
+	p.expect(token.SEMICOLON)
+
+	return &EnumDecl{
+		Type:   enumType,
+		Consts: consts,
+	}
+}
+
+// Types.
+
+func (p *parser) parseType() Type {
+	if p.debug {
+		defer un(trace(p, "Type"))
+	}
+
+	switch p.tok {
+	case token.TYPENAME:
+		t := p.lookupType(p.lit)
+		p.next()
+		return t
+	case token.FN:
+		return p.parseFuncType()
+	case token.ENUM:
+		p.next()
+		tag := p.parseDeclName()
+		for i := len(p.scopes) - 1; i >= 0; i-- {
+			if t, ok := p.scopes[i].tags[tag]; ok {
+				return t
+			}
+		}
+		panic("undeclared enum: " + tag)
+	default:
+		panic("expected type: " + p.tok.String())
+	}
+}
+
+// parseFuncType parses a function pointer type, such as fn(int, int) long.
+func (p *parser) parseFuncType() *FuncType {
+	if p.debug {
+		defer un(trace(p, "FuncType"))
+	}
+
+	var funcType FuncType
+
+	p.expect(token.FN)
+	p.expect(token.LPAREN)
+	for p.tok != token.RPAREN {
+		funcType.Params = append(funcType.Params, &Param{
+			Type: p.parseType(),
+		})
+
+		if p.tok != token.RPAREN {
+			p.expect(token.COMMA)
+		}
+	}
+	p.expect(token.RPAREN)
+
+	if p.isTypeStart() {
+		funcType.Result = p.parseType()
+	} else {
+		funcType.Result = &IntType{}
+	}
+	return &funcType
+}
+
+func (p *parser) isTypeStart() bool {
+	return p.tok == token.TYPENAME || p.tok == token.ENUM || p.tok == token.FN
+}
+
+// parseDeclName parses the name in a declaration following a type. The
+// name may be a typedef name from an enclosing scope, which the declaration
+// then hides (or an enum tag, which has its own namespace).
+func (p *parser) parseDeclName() string {
+	name := p.lit
+	if p.tok != token.IDENT && p.tok != token.TYPENAME {
+		panic("unexpected token: " + p.tok.String())
+	}
+	p.next()
+	return name
+}
+
 func (p *parser) parseIdent() string {
 	ident := p.lit
 	p.expect(token.IDENT)
 	return ident
 }
 
+// Scopes.
+
+func (p *parser) openScope() {
+	p.scopes = append(p.scopes, newScope())
+}
+
+func (p *parser) closeScope() {
+	p.scopes = p.scopes[:len(p.scopes)-1]
+}
+
+// declare adds the identifier to the current scope. t is the aliased type
+// if the identifier is a typedef name, otherwise nil.
+func (p *parser) declare(name string, t Type) {
+	p.scopes[len(p.scopes)-1].idents[name] = t
+}
+
+// lookupType returns the type aliased by the name if the name is a typedef
+// name in scope, otherwise nil.
+func (p *parser) lookupType(name string) Type {
+	for i := len(p.scopes) - 1; i >= 0; i-- {
+		if t, ok := p.scopes[i].idents[name]; ok {
+			return t
+		}
+	}
+	return nil
+}
+
 func (p *parser) expect(tok token.Token) {
 	if p.tok != tok {
 		panic("unexpected token: " + p.tok.String())
@@ -405,7 +791,17 @@ func (p *parser) next() {
 		}
 	}
 
+	p.scan()
+}
+
+func (p *parser) scan() {
 	p.tok, p.lit = p.scanner.Scan()
+
+	// The scanner only knows about identifiers, so use the declarations in
+	// scope to classify typedef names.
+	if p.tok == token.IDENT && p.lookupType(p.lit) != nil {
+		p.tok = token.TYPENAME
+	}
 }
 
 func (p *parser) precedence(tok token.Token) int {
@@ -0,0 +1,103 @@
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ast"
+	"github.com/andydunstall/minc/pkg/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTypedefNameHidden(t *testing.T) {
+	tests := []struct {
+		Name string
+		Src  string
+		Want ast.Decl
+	}{
+		{
+			// The parameter hides the typedef in the body, but not in the
+			// result type.
+			Name: "param",
+			Src: `typedef long T;
+fn f(T T) T {
+	return T;
+}
+`,
+			Want: &ast.FuncDecl{
+				Name: "f",
+				Type: &ast.FuncType{
+					Params: []*ast.Param{
+						{Name: "T", Type: &ast.LongType{}},
+					},
+					Result: &ast.LongType{},
+				},
+				Body: &ast.BlockStmt{
+					List: []ast.Stmt{
+						&ast.ReturnStmt{
+							Result: &ast.VarExpr{Name: "T"},
+						},
+					},
+				},
+			},
+		},
+		{
+			// A typedef name followed by '=' is the name of a variable
+			// with the default type.
+			Name: "let",
+			Src: `typedef long T;
+fn f() {
+	let T = 4;
+	return T;
+}
+`,
+			Want: &ast.FuncDecl{
+				Name: "f",
+				Type: &ast.FuncType{
+					Result: &ast.IntType{},
+				},
+				Body: &ast.BlockStmt{
+					List: []ast.Stmt{
+						&ast.DeclStmt{
+							Decl: &ast.VarDecl{
+								Name: "T",
+								Type: &ast.IntType{},
+								Expr: &ast.BasicLitExpr{Kind: token.INT, Value: "4"},
+							},
+						},
+						&ast.ReturnStmt{
+							Result: &ast.VarExpr{Name: "T"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			file, err := ast.Parse(token.NewScanner([]byte(tt.Src)), false)
+			require.NoError(t, err)
+			require.Len(t, file.Decls, 2)
+			assert.Equal(t, tt.Want, file.Decls[1])
+		})
+	}
+}
+
+func TestParseUnaryPrecedence(t *testing.T) {
+	file, err := ast.Parse(token.NewScanner([]byte(`fn f() {
+	return -9223372036854775807 - 1;
+}
+`)), false)
+	require.NoError(t, err)
+
+	// -a - b is (-a) - b.
+	assert.Equal(t, &ast.BinaryExpr{
+		Op: token.SUB,
+		L: &ast.UnaryExpr{
+			Op:   token.SUB,
+			Expr: &ast.BasicLitExpr{Kind: token.INT, Value: "9223372036854775807"},
+		},
+		R: &ast.BasicLitExpr{Kind: token.INT, Value: "1"},
+	}, file.Decls[0].(*ast.FuncDecl).Body.List[0].(*ast.ReturnStmt).Result)
+}
@@ -0,0 +1,617 @@
+package ast
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/andydunstall/minc/pkg/token"
+)
+
+// symbol is a type checker symbol table entry, keyed by the unique name
+// assigned by the validator.
+type symbol struct {
+	t Type
+
+	// function is whether the symbol is a function rather than a variable.
+	function bool
+	// static is whether the symbol is a variable with static storage
+	// duration.
+	static bool
+	// global is whether a function or static variable has external
+	// linkage.
+	global bool
+	// defined is whether a function has a body, or a static variable has
+	// an initializer.
+	defined bool
+	// attrs contains the attributes of every declaration of a function.
+	attrs map[string]bool
+
+	// constant is whether the symbol is an enum constant, in which case
+	// value is the constant's value.
+	constant bool
+	value    int64
+}
+
+// typechecker assigns a type to each expression, and adds a cast wherever
+// a value is implicitly converted to another type.
+//
+// Since enum constants and sizeof expressions are known at compile time,
+// the type checker also replaces them with integer literals, so later
+// stages never see them.
+type typechecker struct {
+	symbols map[string]*symbol
+
+	// result is the result type of the function being checked.
+	result Type
+}
+
+func newTypechecker(debug bool) *typechecker {
+	return &typechecker{
+		symbols: make(map[string]*symbol),
+	}
+}
+
+func (c *typechecker) check(n Node) (Node, error) {
+	switch n := n.(type) {
+	case *File:
+		for _, decl := range n.Decls {
+			if decl, ok := decl.(*VarDecl); ok {
+				c.checkFileVarDecl(decl)
+				continue
+			}
+			c.checkDecl(decl)
+		}
+		return n, nil
+	default:
+		panic("unsupported node type")
+	}
+}
+
+// Expressions.
+
+func (c *typechecker) checkExpr(expr Expr) Expr {
+	switch expr := expr.(type) {
+	case *BasicLitExpr:
+		c.checkBasicLitExpr(expr)
+	case *VarExpr:
+		sym, ok := c.symbols[expr.Name]
+		if !ok {
+			panic("undeclared variable: " + expr.Name)
+		}
+		if sym.constant {
+			return intLit(sym.value, sym.t)
+		}
+		expr.Type = sym.t
+	case *AssignExpr:
+		expr.L = c.checkExpr(expr.L)
+		if !c.isVar(expr.L) {
+			panic("expected variable")
+		}
+		expr.Type = TypeOf(expr.L)
+		expr.R = convert(c.checkExpr(expr.R), expr.Type)
+	case *UnaryExpr:
+		expr.Expr = c.checkExpr(expr.Expr)
+		if expr.Op == token.NOT {
+			expr.Type = &IntType{}
+		} else {
+			if !IsInteger(TypeOf(expr.Expr)) {
+				panic("invalid operand: " + expr.Op.String())
+			}
+			expr.Type = promote(TypeOf(expr.Expr))
+			expr.Expr = convert(expr.Expr, expr.Type)
+		}
+	case *BinaryExpr:
+		expr.L = c.checkExpr(expr.L)
+		expr.R = c.checkExpr(expr.R)
+		if expr.Op == token.LAND || expr.Op == token.LOR {
+			// The operands are only compared with zero so don't need
+			// converting.
+			expr.Type = &IntType{}
+			break
+		}
+
+		var t Type
+		lt, rt := TypeOf(expr.L), TypeOf(expr.R)
+		switch {
+		case IsInteger(lt) && IsInteger(rt):
+			t = commonType(lt, rt)
+		case expr.Op != token.EQL && expr.Op != token.NEQ:
+			panic("invalid operands: " + expr.Op.String())
+		case !IsInteger(lt):
+			// Function pointers can only be compared for equality, with
+			// the other operand converted to the same type.
+			t = lt
+		default:
+			t = rt
+		}
+		expr.L = convert(expr.L, t)
+		expr.R = convert(expr.R, t)
+
+		switch expr.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			expr.Type = &IntType{}
+		default:
+			expr.Type = t
+		}
+	case *CallExpr:
+		expr.Func = c.checkExpr(expr.Func)
+		funcType, ok := TypeOf(expr.Func).(*FuncType)
+		if !ok {
+			panic("called object is not a function")
+		}
+		if len(expr.Args) != len(funcType.Params) {
+			panic("wrong number of arguments")
+		}
+		for i, arg := range expr.Args {
+			expr.Args[i] = convert(c.checkExpr(arg), funcType.Params[i].Type)
+		}
+		expr.Type = funcType.Result
+	case *AddrOfExpr:
+		expr.Expr = c.checkExpr(expr.Expr)
+		if !c.isFunc(expr.Expr) {
+			panic("cannot take address of a non-function")
+		}
+		expr.Type = TypeOf(expr.Expr)
+	case *CastExpr:
+		// Explicit casts may convert between any types, including
+		// function pointers and integers.
+		expr.Expr = c.checkExpr(expr.Expr)
+	case *SizeofExpr:
+		// The operand is checked to find its type but never evaluated.
+		t := TypeOf(c.checkExpr(expr.Expr))
+		return intLit(SizeOf(t), &ULongType{})
+	case *SizeofTypeExpr:
+		return intLit(SizeOf(expr.T), &ULongType{})
+	default:
+		panic("unsupported expr type")
+	}
+	return expr
+}
+
+// checkBasicLitExpr assigns an integer literal the first type from its
+// suffix that can represent its value, and strips the suffix.
+func (c *typechecker) checkBasicLitExpr(expr *BasicLitExpr) {
+	digits := strings.TrimRight(expr.Value, "uUlL")
+	suffix := strings.ToLower(expr.Value[len(digits):])
+
+	v, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil {
+		panic("invalid integer: " + expr.Value)
+	}
+
+	var candidates []Type
+	switch suffix {
+	case "":
+		candidates = []Type{&IntType{}, &LongType{}}
+	case "u":
+		candidates = []Type{&UIntType{}, &ULongType{}}
+	case "l":
+		candidates = []Type{&LongType{}}
+	case "ul", "lu":
+		candidates = []Type{&ULongType{}}
+	default:
+		panic("invalid integer suffix: " + expr.Value)
+	}
+
+	for _, t := range candidates {
+		if v <= maxValue(t) {
+			expr.Value = digits
+			expr.Type = t
+			return
+		}
+	}
+	panic("integer too large: " + expr.Value)
+}
+
+// evalConst evaluates a type checked constant expression. The result is
+// the value converted to the type of the expression, where unsigned long
+// values are stored as their two's complement bit pattern.
+func (c *typechecker) evalConst(expr Expr) int64 {
+	switch expr := expr.(type) {
+	case *BasicLitExpr:
+		if IsSigned(expr.Type) {
+			v, err := strconv.ParseInt(expr.Value, 10, 64)
+			if err != nil {
+				panic("invalid integer: " + expr.Value)
+			}
+			return v
+		}
+		v, err := strconv.ParseUint(expr.Value, 10, 64)
+		if err != nil {
+			panic("invalid integer: " + expr.Value)
+		}
+		return int64(v)
+	case *CastExpr:
+		return wrap(c.evalConst(expr.Expr), expr.T)
+	case *UnaryExpr:
+		v := c.evalConst(expr.Expr)
+		switch expr.Op {
+		case token.SUB:
+			return wrap(-v, expr.Type)
+		case token.TILDE:
+			return wrap(^v, expr.Type)
+		case token.NOT:
+			return boolToInt(v == 0)
+		}
+	case *BinaryExpr:
+		l := c.evalConst(expr.L)
+		r := c.evalConst(expr.R)
+		switch expr.Op {
+		case token.LAND:
+			return boolToInt(l != 0 && r != 0)
+		case token.LOR:
+			return boolToInt(l != 0 || r != 0)
+		}
+
+		// The operands have been converted to the same type.
+		t := TypeOf(expr.L)
+		signed := IsSigned(t)
+
+		switch expr.Op {
+		case token.ADD:
+			return wrap(l+r, t)
+		case token.SUB:
+			return wrap(l-r, t)
+		case token.MUL:
+			return wrap(l*r, t)
+		case token.QUO, token.REM:
+			if r == 0 {
+				panic("division by zero in constant expression")
+			}
+			switch {
+			case !signed && expr.Op == token.QUO:
+				return wrap(int64(uint64(l)/uint64(r)), t)
+			case !signed:
+				return wrap(int64(uint64(l)%uint64(r)), t)
+			case r == -1:
+				// Avoid the Go runtime trapping on overflow.
+				if expr.Op == token.QUO {
+					return wrap(-l, t)
+				}
+				return 0
+			case expr.Op == token.QUO:
+				return wrap(l/r, t)
+			default:
+				return wrap(l%r, t)
+			}
+		case token.EQL:
+			return boolToInt(l == r)
+		case token.NEQ:
+			return boolToInt(l != r)
+		case token.LSS:
+			return boolToInt(signed && l < r || !signed && uint64(l) < uint64(r))
+		case token.LEQ:
+			return boolToInt(signed && l <= r || !signed && uint64(l) <= uint64(r))
+		case token.GTR:
+			return boolToInt(signed && l > r || !signed && uint64(l) > uint64(r))
+		case token.GEQ:
+			return boolToInt(signed && l >= r || !signed && uint64(l) >= uint64(r))
+		}
+	}
+	panic("expected constant expression")
+}
+
+// Statements.
+
+func (c *typechecker) checkStmt(stmt Stmt) {
+	switch stmt := stmt.(type) {
+	case *DeclStmt:
+		c.checkDecl(stmt.Decl)
+	case *ReturnStmt:
+		stmt.Result = convert(c.checkExpr(stmt.Result), c.result)
+	case *ExprStmt:
+		stmt.E = c.checkExpr(stmt.E)
+	case *IfStmt:
+		stmt.Cond = c.checkExpr(stmt.Cond)
+		c.checkStmt(stmt.Then)
+		if stmt.Else != nil {
+			c.checkStmt(stmt.Else)
+		}
+	case *LoopStmt:
+		stmt.Cond = c.checkExpr(stmt.Cond)
+		c.checkStmt(stmt.Body)
+	case *BlockStmt:
+		for _, s := range stmt.List {
+			c.checkStmt(s)
+		}
+	}
+}
+
+// Declarations.
+
+func (c *typechecker) checkDecl(decl Decl) {
+	switch decl := decl.(type) {
+	case *FuncDecl:
+		c.checkFuncDecl(decl)
+	case *VarDecl:
+		c.checkLocalVarDecl(decl)
+	case *EnumDecl:
+		c.checkEnumDecl(decl)
+	}
+}
+
+func (c *typechecker) checkFuncDecl(decl *FuncDecl) {
+	defined := decl.Body != nil
+	global := decl.Storage != StorageStatic
+	attrs := make(map[string]bool)
+
+	if old, ok := c.symbols[decl.Name]; ok {
+		if !old.function || !SameType(old.t, decl.Type) {
+			panic("incompatible declarations: " + decl.Name)
+		}
+		if old.defined && defined {
+			panic("duplicate function definition: " + decl.Name)
+		}
+		if old.global && !global {
+			panic("static function declaration follows non-static: " + decl.Name)
+		}
+		// Later declarations keep the linkage of the first.
+		global = old.global
+		defined = defined || old.defined
+		attrs = old.attrs
+	}
+	checkAttrs(decl, attrs)
+
+	// Add the function before checking the body to support recursion.
+	c.symbols[decl.Name] = &symbol{
+		t:        decl.Type,
+		function: true,
+		global:   global,
+		defined:  defined,
+		attrs:    attrs,
+	}
+
+	if decl.Body == nil {
+		return
+	}
+
+	for _, param := range decl.Type.Params {
+		c.symbols[param.Name] = &symbol{
+			t: param.Type,
+		}
+	}
+
+	c.result = decl.Type.Result
+	c.checkStmt(decl.Body)
+	c.result = nil
+}
+
+// funcAttrs contains the supported function attributes.
+var funcAttrs = map[string]bool{
+	"inline":             true,
+	"noinline":           true,
+	"warn_unused_result": true,
+}
+
+// checkAttrs adds the attributes of the declaration to those of earlier
+// declarations of the function.
+func checkAttrs(decl *FuncDecl, attrs map[string]bool) {
+	for _, attr := range decl.Attrs {
+		if !funcAttrs[attr] {
+			panic("unknown attribute: " + attr)
+		}
+		attrs[attr] = true
+	}
+	if attrs["inline"] && attrs["noinline"] {
+		panic("conflicting attributes inline and noinline: " + decl.Name)
+	}
+}
+
+// checkFileVarDecl checks a variable declared at file scope, which has
+// static storage duration.
+func (c *typechecker) checkFileVarDecl(decl *VarDecl) {
+	defined := decl.Expr != nil
+	if defined {
+		decl.Expr = c.checkStaticInit(decl.Expr, decl.Type)
+	}
+	global := decl.Storage != StorageStatic
+
+	if old, ok := c.symbols[decl.Name]; ok {
+		if !old.static || !SameType(old.t, decl.Type) {
+			panic("incompatible declarations: " + decl.Name)
+		}
+		if old.defined && defined {
+			panic("duplicate variable definition: " + decl.Name)
+		}
+		if decl.Storage == StorageExtern {
+			global = old.global
+		} else if old.global != global {
+			panic("conflicting variable linkage: " + decl.Name)
+		}
+		defined = defined || old.defined
+	}
+
+	c.symbols[decl.Name] = &symbol{
+		t:       decl.Type,
+		static:  true,
+		global:  global,
+		defined: defined,
+	}
+}
+
+func (c *typechecker) checkLocalVarDecl(decl *VarDecl) {
+	switch decl.Storage {
+	case StorageExtern:
+		// The declaration refers to a global variable, which may be
+		// declared elsewhere.
+		if old, ok := c.symbols[decl.Name]; ok {
+			if !old.static || !SameType(old.t, decl.Type) {
+				panic("incompatible declarations: " + decl.Name)
+			}
+			return
+		}
+		c.symbols[decl.Name] = &symbol{
+			t:      decl.Type,
+			static: true,
+			global: true,
+		}
+	case StorageStatic:
+		// Static locals are initialized once, so the initializer must be
+		// constant.
+		if decl.Expr != nil {
+			decl.Expr = c.checkStaticInit(decl.Expr, decl.Type)
+		}
+		c.symbols[decl.Name] = &symbol{
+			t:       decl.Type,
+			static:  true,
+			defined: true,
+		}
+	default:
+		c.symbols[decl.Name] = &symbol{
+			t: decl.Type,
+		}
+		if decl.Expr != nil {
+			decl.Expr = convert(c.checkExpr(decl.Expr), decl.Type)
+		}
+	}
+}
+
+// checkStaticInit checks the initializer of a variable with static storage
+// duration, and replaces it with a literal of the variable's type.
+func (c *typechecker) checkStaticInit(expr Expr, t Type) Expr {
+	expr = convert(c.checkExpr(expr), t)
+	return intLit(c.evalConst(expr), t)
+}
+
+func (c *typechecker) checkEnumDecl(decl *EnumDecl) {
+	var next int64
+	for _, ec := range decl.Consts {
+		if ec.Value != nil {
+			ec.Value = c.checkExpr(ec.Value)
+			next = c.evalConst(ec.Value)
+			if !IsSigned(TypeOf(ec.Value)) && next < 0 {
+				panic("enum constant out of range: " + ec.Name)
+			}
+		}
+		if next != int64(int32(next)) {
+			panic("enum constant out of range: " + ec.Name)
+		}
+
+		// Enum constants have type int rather than the enum type.
+		c.symbols[ec.Name] = &symbol{
+			t:        &IntType{},
+			constant: true,
+			value:    next,
+		}
+		next++
+	}
+}
+
+// isVar returns whether the expression names a variable.
+func (c *typechecker) isVar(expr Expr) bool {
+	v, ok := expr.(*VarExpr)
+	return ok && !c.symbols[v.Name].function
+}
+
+// isFunc returns whether the expression names a function.
+func (c *typechecker) isFunc(expr Expr) bool {
+	v, ok := expr.(*VarExpr)
+	return ok && c.symbols[v.Name].function
+}
+
+// convert returns the expression implicitly converted to type t, adding a
+// cast if needed.
+func convert(expr Expr, t Type) Expr {
+	from := TypeOf(expr)
+	if SameType(from, t) {
+		return expr
+	}
+	// Integers convert implicitly to one another, but function pointers
+	// only convert from a null pointer constant.
+	if !IsInteger(from) || (!IsInteger(t) && !isNullPointer(expr)) {
+		panic("incompatible types in conversion")
+	}
+	return &CastExpr{
+		T:    t,
+		Expr: expr,
+	}
+}
+
+func isNullPointer(expr Expr) bool {
+	lit, ok := expr.(*BasicLitExpr)
+	return ok && lit.Value == "0"
+}
+
+// promote returns the type an operand of the given type is converted to
+// in arithmetic. Integer types smaller than int (and enums) are promoted
+// to int.
+func promote(t Type) Type {
+	switch t.(type) {
+	case *CharType, *UCharType, *EnumType:
+		return &IntType{}
+	default:
+		return t
+	}
+}
+
+// commonType returns the type both operands of a binary expression are
+// converted to (the "usual arithmetic conversions").
+func commonType(a, b Type) Type {
+	a = promote(a)
+	b = promote(b)
+	if SameType(a, b) {
+		return a
+	}
+	if SizeOf(a) == SizeOf(b) {
+		// Where the sizes match, the unsigned type wins.
+		if IsSigned(a) {
+			return b
+		}
+		return a
+	}
+	// Otherwise the larger type can represent every value of the smaller.
+	if SizeOf(a) > SizeOf(b) {
+		return a
+	}
+	return b
+}
+
+// wrap converts the value to the integer type t, discarding any bits that
+// don't fit.
+func wrap(v int64, t Type) int64 {
+	switch t.(type) {
+	case *CharType:
+		return int64(int8(v))
+	case *UCharType:
+		return int64(uint8(v))
+	case *IntType, *EnumType:
+		return int64(int32(v))
+	case *UIntType:
+		return int64(uint32(v))
+	default:
+		return v
+	}
+}
+
+func maxValue(t Type) uint64 {
+	switch t.(type) {
+	case *IntType:
+		return math.MaxInt32
+	case *UIntType:
+		return math.MaxUint32
+	case *LongType:
+		return math.MaxInt64
+	default:
+		return math.MaxUint64
+	}
+}
+
+func intLit(v int64, t Type) *BasicLitExpr {
+	value := strconv.FormatInt(v, 10)
+	if !IsSigned(t) {
+		value = strconv.FormatUint(uint64(v), 10)
+	}
+	return &BasicLitExpr{
+		Kind:  token.INT,
+		Value: value,
+		Type:  t,
+	}
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+package ast
+
+import "reflect"
+
+// Types.
+
+type Type interface {
+	Node
+	typeNode()
+}
+
+type CharType struct{}
+
+func (n *CharType) node()     {}
+func (n *CharType) typeNode() {}
+
+type UCharType struct{}
+
+func (n *UCharType) node()     {}
+func (n *UCharType) typeNode() {}
+
+type IntType struct{}
+
+func (n *IntType) node()     {}
+func (n *IntType) typeNode() {}
+
+type UIntType struct{}
+
+func (n *UIntType) node()     {}
+func (n *UIntType) typeNode() {}
+
+type LongType struct{}
+
+func (n *LongType) node()     {}
+func (n *LongType) typeNode() {}
+
+type ULongType struct{}
+
+func (n *ULongType) node()     {}
+func (n *ULongType) typeNode() {}
+
+// EnumType is the type of an enum declaration. Each declaration has a
+// distinct *EnumType, so two enum types are the same only if they are the
+// same pointer.
+type EnumType struct {
+	Name string
+}
+
+func (n *EnumType) node()     {}
+func (n *EnumType) typeNode() {}
+
+type Param struct {
+	// Name is empty when the function type isn't part of a function
+	// declaration.
+	Name string
+	Type Type
+	// Used is whether the parameter is read in the function body, which
+	// the validator sets.
+	Used bool
+}
+
+func (n *Param) node() {}
+
+// FuncType is the type of a function. As a value type, it is a pointer to
+// a function.
+type FuncType struct {
+	Params []*Param
+	Result Type
+}
+
+func (n *FuncType) node()     {}
+func (n *FuncType) typeNode() {}
+
+// SameType returns whether a and b are the same type.
+func SameType(a, b Type) bool {
+	switch a := a.(type) {
+	case *EnumType:
+		return a == b
+	case *FuncType:
+		b, ok := b.(*FuncType)
+		if !ok || len(a.Params) != len(b.Params) || !SameType(a.Result, b.Result) {
+			return false
+		}
+		for i := range a.Params {
+			if !SameType(a.Params[i].Type, b.Params[i].Type) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.TypeOf(a) == reflect.TypeOf(b)
+	}
+}
+
+// SizeOf returns the size of the type in bytes.
+func SizeOf(t Type) int64 {
+	switch t.(type) {
+	case *CharType, *UCharType:
+		return 1
+	case *IntType, *UIntType, *EnumType:
+		return 4
+	case *LongType, *ULongType, *FuncType:
+		return 8
+	default:
+		panic("unsupported type")
+	}
+}
+
+// IsInteger returns whether the type is an integer type (including enums).
+func IsInteger(t Type) bool {
+	_, ok := t.(*FuncType)
+	return !ok
+}
+
+// IsSigned returns whether the integer type is signed.
+func IsSigned(t Type) bool {
+	switch t.(type) {
+	case *CharType, *IntType, *LongType, *EnumType:
+		return true
+	default:
+		return false
+	}
+}
@@ -7,30 +7,55 @@ import (
 type varEntry struct {
 	name      string
 	fromScope bool
+	// hasLinkage is whether the identifier refers to the same entity when
+	// declared again, as with functions and global variables.
+	hasLinkage bool
 }
 
 // Validate performs semantic analysis on the AST:
 // - Verify variables are defined
-// - Map variables to a unique name
+// - Map variables to a unique name, except identifiers with linkage
 // - Add a label for each loop
+// - Mark which locals, parameters and functions are used
+// - Type check expressions (see typecheck.go)
 func Validate(root Node, debug bool) (Node, error) {
 	v := newValidator(debug)
-	return v.validate(root)
+	n, err := v.validate(root)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newTypechecker(debug)
+	return c.check(n)
 }
 
 type validator struct {
 	identifiers map[string]varEntry
 
 	varCounter int
-	// A loop count of 0 means not in a loop.
+	// loopCounter numbers the loops in the function, so each has a unique
+	// label.
 	loopCounter int
+	// loops contains the labels of the loops enclosing the statement being
+	// validated, innermost last.
+	loops []string
+
+	// fn is the name of the function being validated.
+	fn string
+	// used contains the updated names of the identifiers that are used.
+	used map[string]bool
+	// vars, params and funcs contain the declarations to mark as used
+	// once the whole file is validated.
+	vars   []*VarDecl
+	params []*Param
+	funcs  []*FuncDecl
 }
 
 func newValidator(debug bool) *validator {
 	return &validator{
 		identifiers: make(map[string]varEntry),
 		varCounter:  1,
-		loopCounter: 0,
+		used:        make(map[string]bool),
 	}
 }
 
@@ -39,8 +64,24 @@ func (v *validator) validate(n Node) (Node, error) {
 	case *File:
 		var decls []Decl
 		for _, decl := range n.Decls {
+			if decl, ok := decl.(*VarDecl); ok {
+				v.validateFileVarDecl(decl)
+				decls = append(decls, decl)
+				continue
+			}
 			decls = append(decls, v.validateDecl(decl))
 		}
+
+		for _, decl := range v.vars {
+			decl.Used = v.used[decl.Name]
+		}
+		for _, param := range v.params {
+			param.Used = v.used[param.Name]
+		}
+		for _, decl := range v.funcs {
+			decl.Used = v.used[decl.Name]
+		}
+
 		return &File{
 			Decls: decls,
 		}, nil
@@ -54,17 +95,18 @@ func (v *validator) validate(n Node) (Node, error) {
 func (v *validator) validateExpr(expr Expr) Expr {
 	switch expr := expr.(type) {
 	case *VarExpr:
-		e, ok := v.identifiers[expr.Name]
-		if !ok {
-			panic("undeclared variable: " + expr.Name)
+		v.resolve(expr)
+		// A recursive call doesn't count as a use of the function.
+		if expr.Name != v.fn {
+			v.used[expr.Name] = true
 		}
-		// Map the variable to its updated name.
-		expr.Name = e.name
 	case *AssignExpr:
-		if _, ok := expr.L.(*VarExpr); !ok {
+		l, ok := expr.L.(*VarExpr)
+		if !ok {
 			panic("expected variable")
 		}
-		expr.L = v.validateExpr(expr.L)
+		// Assigning the variable doesn't use its value.
+		v.resolve(l)
 		expr.R = v.validateExpr(expr.R)
 	case *UnaryExpr:
 		expr.Expr = v.validateExpr(expr.Expr)
@@ -72,20 +114,39 @@ func (v *validator) validateExpr(expr Expr) Expr {
 		expr.L = v.validateExpr(expr.L)
 		expr.R = v.validateExpr(expr.R)
 	case *CallExpr:
+		expr.Func = v.validateExpr(expr.Func)
 		var args []Expr
 		for _, arg := range expr.Args {
 			args = append(args, v.validateExpr(arg))
 		}
 		expr.Args = args
+	case *AddrOfExpr:
+		expr.Expr = v.validateExpr(expr.Expr)
+	case *CastExpr:
+		expr.Expr = v.validateExpr(expr.Expr)
+	case *SizeofExpr:
+		expr.Expr = v.validateExpr(expr.Expr)
 	}
 	return expr
 }
 
+// resolve maps the variable to its updated name.
+func (v *validator) resolve(expr *VarExpr) {
+	e, ok := v.identifiers[expr.Name]
+	if !ok {
+		panic("undeclared variable: " + expr.Name)
+	}
+	expr.Name = e.name
+}
+
 // Statements.
 
 func (v *validator) validateStmt(stmt Stmt) Stmt {
 	switch stmt := stmt.(type) {
 	case *DeclStmt:
+		if _, ok := stmt.Decl.(*FuncDecl); ok {
+			panic("function declaration in block")
+		}
 		stmt.Decl = v.validateDecl(stmt.Decl)
 	case *ReturnStmt:
 		stmt.Result = v.validateExpr(stmt.Result)
@@ -99,25 +160,17 @@ func (v *validator) validateStmt(stmt Stmt) Stmt {
 		}
 	case *LoopStmt:
 		// Add a unique label to each loop.
-
-		existingLoop := v.loopCounter
 		v.loopCounter++
+		stmt.Label = fmt.Sprintf("loop.%d", v.loopCounter)
 
+		v.loops = append(v.loops, stmt.Label)
 		stmt.Cond = v.validateExpr(stmt.Cond)
 		stmt.Body = v.validateBlockStmt(stmt.Body)
-
-		stmt.Label = v.loopLabel()
-		v.loopCounter = existingLoop
+		v.loops = v.loops[:len(v.loops)-1]
 	case *ContinueStmt:
-		if v.loopCounter == 0 {
-			panic("not in loop")
-		}
 		// Point to closing loop.
 		stmt.Label = v.loopLabel()
 	case *BreakStmt:
-		if v.loopCounter == 0 {
-			panic("not in loop")
-		}
 		// Point to closing loop.
 		stmt.Label = v.loopLabel()
 	case *BlockStmt:
@@ -155,11 +208,26 @@ func (v *validator) validateDecl(decl Decl) Decl {
 		v.validateFuncDecl(decl)
 	case *VarDecl:
 		v.validateVarDecl(decl)
+	case *EnumDecl:
+		v.validateEnumDecl(decl)
 	}
 	return decl
 }
 
 func (v *validator) validateFuncDecl(decl *FuncDecl) {
+	// Function names aren't renamed, since they must match the symbol
+	// name. Functions may be declared multiple times, which the type
+	// checker verifies are compatible.
+	e, ok := v.identifiers[decl.Name]
+	if ok && e.fromScope && !e.hasLinkage {
+		panic("duplicate declaration: " + decl.Name)
+	}
+	v.identifiers[decl.Name] = varEntry{
+		name:       decl.Name,
+		fromScope:  true,
+		hasLinkage: true,
+	}
+
 	// Functions create a new scope (including parameters). Therefore store
 	// the current variables, and create a new scope for the block. After the
 	// block, reset to the existing scope.
@@ -171,33 +239,73 @@ func (v *validator) validateFuncDecl(decl *FuncDecl) {
 		v.identifiers[k] = e
 	}
 
-	for i, param := range decl.Type.Params {
-		e, ok := v.identifiers[param]
+	for _, param := range decl.Type.Params {
+		e, ok := v.identifiers[param.Name]
 		if ok && e.fromScope {
-			panic("duplicate declaration: " + param)
+			panic("duplicate declaration: " + param.Name)
 		}
 
-		updatedName := v.nextVar(param)
+		updatedName := v.nextVar(param.Name)
 
-		v.identifiers[param] = varEntry{
+		v.identifiers[param.Name] = varEntry{
 			name:      updatedName,
 			fromScope: true,
 		}
 
-		decl.Type.Params[i] = updatedName
+		param.Name = updatedName
 	}
 
-	decl.Body = v.validateBlockStmt(decl.Body)
+	if decl.Body != nil {
+		v.fn = decl.Name
+		v.loopCounter = 0
+		decl.Body = v.validateBlockStmt(decl.Body)
+		v.fn = ""
+
+		v.params = append(v.params, decl.Type.Params...)
+	}
+	v.funcs = append(v.funcs, decl)
 
 	v.identifiers = existingVars
 }
 
+// validateFileVarDecl validates a variable declared at file scope. These
+// variables have linkage, so aren't renamed.
+func (v *validator) validateFileVarDecl(decl *VarDecl) {
+	e, ok := v.identifiers[decl.Name]
+	if ok && !e.hasLinkage {
+		panic("duplicate declaration: " + decl.Name)
+	}
+	v.identifiers[decl.Name] = varEntry{
+		name:       decl.Name,
+		fromScope:  true,
+		hasLinkage: true,
+	}
+
+	if decl.Expr != nil {
+		decl.Expr = v.validateExpr(decl.Expr)
+	}
+}
+
 func (v *validator) validateVarDecl(decl *VarDecl) {
 	e, ok := v.identifiers[decl.Name]
-	if ok && e.fromScope {
+	if ok && e.fromScope && !(e.hasLinkage && decl.Storage == StorageExtern) {
 		panic("duplicate declaration: " + decl.Name)
 	}
 
+	if decl.Storage == StorageExtern {
+		// A local extern declaration refers to the global variable, so
+		// keeps its name.
+		v.identifiers[decl.Name] = varEntry{
+			name:       decl.Name,
+			fromScope:  true,
+			hasLinkage: true,
+		}
+		if decl.Expr != nil {
+			panic("initializer on local extern variable: " + decl.Name)
+		}
+		return
+	}
+
 	updatedName := v.nextVar(decl.Name)
 	v.identifiers[decl.Name] = varEntry{
 		name:      updatedName,
@@ -205,15 +313,50 @@ func (v *validator) validateVarDecl(decl *VarDecl) {
 	}
 
 	decl.Name = updatedName
-	decl.Expr = v.validateExpr(decl.Expr)
+	if decl.Expr != nil {
+		decl.Expr = v.validateExpr(decl.Expr)
+	}
+	v.vars = append(v.vars, decl)
 }
 
+func (v *validator) validateEnumDecl(decl *EnumDecl) {
+	for _, c := range decl.Consts {
+		// The constant isn't in scope until after its own value.
+		if c.Value != nil {
+			c.Value = v.validateExpr(c.Value)
+		}
+
+		e, ok := v.identifiers[c.Name]
+		if ok && e.fromScope {
+			panic("duplicate declaration: " + c.Name)
+		}
+
+		updatedName := v.nextVar(c.Name)
+		v.identifiers[c.Name] = varEntry{
+			name:      updatedName,
+			fromScope: true,
+		}
+
+		c.Name = updatedName
+	}
+}
+
+// loopLabel returns the label of the innermost enclosing loop.
 func (v *validator) loopLabel() string {
-	return fmt.Sprintf("loop.%d", v.loopCounter)
+	if len(v.loops) == 0 {
+		panic("not in loop")
+	}
+	return v.loops[len(v.loops)-1]
 }
 
 func (v *validator) nextVar(name string) string {
-	n := fmt.Sprintf("%s.%d", name, v.varCounter)
+	format := "%s.%d"
+	if name == "tmp" {
+		// The IR names its temporaries 'tmp.N', so a variable named tmp
+		// needs a different suffix to not clash with them.
+		format = "%s.v%d"
+	}
+	n := fmt.Sprintf(format, name, v.varCounter)
 	v.varCounter++
 	return n
 }
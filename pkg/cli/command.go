@@ -13,15 +13,48 @@ func newCommand() *cobra.Command {
 		SilenceUsage: true,
 		Long: `Minc is a mini C compiler.
 
-The compiler currently only supports a single C file, with no includes.
+The compiler doesn't support includes, so declare functions and variables
+from other files with extern.
 
 Compile a C file with:
 
   $ minc compile ./program.c
 
-Which will output the x86 assembly to ./main.s (or specify the output file
+Which will output the x86 assembly to ./dump.s (or specify the output file
 with -o/--output).
 
+Each file is compiled as a separate translation unit. Compile multiple files
+and link them into an executable with:
+
+  $ minc compile ./main.c ./lib.c --link -o ./program
+
+Or assemble each file into an object file with -c/--object.
+
+Optimize with -O1 or -O2, or choose the optimization passes to run with
+--passes, and output the IR after a pass with --dump-after:
+
+  $ minc compile ./program.c --passes=fold,copyprop,dce --dump-after=dce
+
+Output the IR in SSA form once the IR passes have run with --dump-ssa.
+
+-O1 allocates registers with a fast linear scan, and -O2 with graph
+colouring. Choose the register allocator with --regalloc=linear or
+--regalloc=graph.
+
+Enable or disable warnings with -W, such as -Wunused-parameter or
+-Wno-unused-variable, or -Wall to enable every warning.
+
+Compile and run a program with:
+
+  $ minc run ./program.c
+
+Or interpret the program without an assembler with --interp.
+
+Output the control-flow graph of each function, or the call graph with
+--calls, in the Graphviz dot language with:
+
+  $ minc graph ./program.c
+
 `,
 		CompletionOptions: cobra.CompletionOptions{
 			DisableDefaultCmd: true,
@@ -30,6 +63,8 @@ with -o/--output).
 
 	cmd.AddCommand(
 		newCompileCommand(),
+		newRunCommand(),
+		newGraphCommand(),
 	)
 
 	return cmd
@@ -3,7 +3,9 @@ package cli
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"slices"
+	"sort"
 	"strings"
 
 	"github.com/andydunstall/minc/pkg/arch/x86"
@@ -18,7 +20,7 @@ import (
 
 func newCompileCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "compile path [flags]",
+		Use:   "compile path... [flags]",
 		Short: "compile a C program",
 		Long:  `...`,
 	}
@@ -46,6 +48,67 @@ func newCompileCommand() *cobra.Command {
 		fmt.Sprintf("compiler stage (%s)", strings.Join(stages, ", ")),
 	)
 
+	var optLevel int
+	cmd.Flags().IntVarP(
+		&optLevel,
+		"opt-level",
+		"O",
+		0,
+		fmt.Sprintf("optimization level (0 to %d)", len(compiler.OptLevels)-1),
+	)
+
+	var passNames []string
+	for _, pass := range compiler.Passes() {
+		passNames = append(passNames, pass.Name)
+	}
+
+	var passes []string
+	cmd.Flags().StringSliceVar(
+		&passes,
+		"passes",
+		nil,
+		fmt.Sprintf("comma separated optimization passes to run instead of an optimization level (%s)", strings.Join(passNames, ", ")),
+	)
+
+	var allocators []string
+	for name := range compiler.Allocators {
+		allocators = append(allocators, name)
+	}
+	sort.Strings(allocators)
+
+	var allocator string
+	cmd.Flags().StringVar(
+		&allocator,
+		"regalloc",
+		"",
+		fmt.Sprintf("register allocator to use instead of the optimization level's (%s)", strings.Join(allocators, ", ")),
+	)
+
+	var dumpAfter []string
+	cmd.Flags().StringSliceVar(
+		&dumpAfter,
+		"dump-after",
+		nil,
+		"comma separated passes to output the ir or assembly after",
+	)
+
+	var dumpSSA bool
+	cmd.Flags().BoolVar(
+		&dumpSSA,
+		"dump-ssa",
+		false,
+		"output the ir in ssa form, with phi nodes, after the ir passes",
+	)
+
+	var warningFlags []string
+	cmd.Flags().StringSliceVarP(
+		&warningFlags,
+		"warn",
+		"W",
+		nil,
+		warnUsage(),
+	)
+
 	var debug bool
 	cmd.Flags().BoolVarP(
 		&debug,
@@ -55,15 +118,74 @@ func newCompileCommand() *cobra.Command {
 		"whether to output debug logs and traces",
 	)
 
-	cmd.Run = func(_ *cobra.Command, args []string) {
+	var object bool
+	cmd.Flags().BoolVarP(
+		&object,
+		"object",
+		"c",
+		false,
+		"assemble each file into an object file",
+	)
+
+	var link bool
+	cmd.Flags().BoolVarP(
+		&link,
+		"link",
+		"l",
+		false,
+		"link the files into an executable",
+	)
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			exitError(fmt.Errorf("compile: missing path"))
 		}
-		if len(args) > 1 {
-			exitError(fmt.Errorf("compile: only one path is supported"))
+		if len(args) > 1 && cmd.Flags().Changed("output") && !link {
+			exitError(fmt.Errorf("compile: output path requires a single path unless linking"))
+		}
+		if stage != "" && (object || link) {
+			exitError(fmt.Errorf("compile: cannot assemble or link when stopping at a stage"))
+		}
+
+		if cmd.Flags().Changed("passes") && cmd.Flags().Changed("opt-level") {
+			exitError(fmt.Errorf("compile: cannot set both passes and an optimization level"))
+		}
+		if !cmd.Flags().Changed("passes") {
+			if optLevel < 0 || optLevel >= len(compiler.OptLevels) {
+				exitError(fmt.Errorf("compile: unsupported optimization level: %d", optLevel))
+			}
+			passes = compiler.OptLevels[optLevel]
+		}
+		if allocator != "" {
+			var err error
+			passes, err = compiler.WithAllocator(passes, allocator)
+			if err != nil {
+				exitError(fmt.Errorf("compile: %w", err))
+			}
+		}
+		pm, err := compiler.NewPassManager(passes, dumpAfter, dumpSSA, os.Stdout, debug, debug)
+		if err != nil {
+			exitError(fmt.Errorf("compile: %w", err))
+		}
+		warnings, err := compiler.EnabledWarnings(warningFlags)
+		if err != nil {
+			exitError(fmt.Errorf("compile: %w", err))
+		}
+
+		if !cmd.Flags().Changed("output") {
+			switch {
+			case link:
+				outputPath = "./a.out"
+			case object:
+				// As with cc, the object file is named after the source
+				// file, in the current directory.
+				outputPath = replaceExt(filepath.Base(args[0]), ".o")
+			case compiler.Stage(stage) == compiler.StageIR:
+				outputPath = replaceExt(filepath.Base(args[0]), ".ir")
+			}
 		}
 
-		if err := runCompile(args[0], outputPath, compiler.Stage(stage), debug); err != nil {
+		if err := runCompileFiles(args, outputPath, object, link, compiler.Stage(stage), pm, warnings, debug); err != nil {
 			exitError(fmt.Errorf("compile: %w", err))
 		}
 	}
@@ -71,14 +193,125 @@ func newCompileCommand() *cobra.Command {
 	return cmd
 }
 
-func runCompile(path string, outputPath string, stage compiler.Stage, debug bool) error {
+// runCompileFiles compiles each file as a separate translation unit.
+//
+// When linking, the files are assembled and linked into an executable at
+// outputPath. Otherwise each file is written to its own assembly file, or
+// object file if object is set, or IR file if stopping at the IR stage. With
+// a single file the output is written to outputPath, and with multiple files
+// the output is written next to each source file with a .s, .o or .ir
+// extension.
+//
+// The passes in pm optimize each file, and the enabled warnings are
+// reported for each file.
+func runCompileFiles(paths []string, outputPath string, object bool, link bool, stage compiler.Stage, pm *compiler.PassManager, warnings map[string]bool, debug bool) error {
 	if stage != "" && !slices.Contains(compiler.Stages, stage) {
 		return fmt.Errorf("unsupported stage: %s", stage)
 	}
 
+	var tmpDir string
+	if object || link {
+		dir, err := os.MkdirTemp("", "minc")
+		if err != nil {
+			return fmt.Errorf("temp dir: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		tmpDir = dir
+	}
+
+	var objects []string
+	for i, path := range paths {
+		output, err := runCompile(path, stage, pm, warnings, debug)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if stage != "" && stage != compiler.StageIR {
+			continue
+		}
+
+		if !object && !link {
+			// When stopping at the IR stage the output is the textual IR
+			// rather than assembly.
+			ext := ".s"
+			if stage == compiler.StageIR {
+				ext = ".ir"
+			}
+			outPath := outputPath
+			if len(paths) > 1 {
+				outPath = replaceExt(path, ext)
+			}
+			// An .ir file compiled to the IR stage would otherwise be
+			// replaced by its own output.
+			if sameFile(outPath, path) {
+				return fmt.Errorf("%s: output would overwrite the input", path)
+			}
+			if err := os.WriteFile(outPath, []byte(output), 0o666); err != nil {
+				return fmt.Errorf("write: %w", err)
+			}
+			continue
+		}
+
+		// Prefix the temporary files with the index as files in different
+		// directories may have the same name.
+		base := fmt.Sprintf("%d-%s", i, filepath.Base(path))
+		asmPath := filepath.Join(tmpDir, replaceExt(base, ".s"))
+		if err := os.WriteFile(asmPath, []byte(output), 0o666); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+
+		objPath := filepath.Join(tmpDir, replaceExt(base, ".o"))
+		if !link {
+			objPath = outputPath
+			if len(paths) > 1 {
+				objPath = replaceExt(path, ".o")
+			}
+		}
+		if err := compiler.Assemble(asmPath, objPath); err != nil {
+			return fmt.Errorf("assemble: %s: %w", path, err)
+		}
+		objects = append(objects, objPath)
+	}
+
+	if link {
+		if err := compiler.Link(objects, outputPath); err != nil {
+			return fmt.Errorf("link: %w", err)
+		}
+	}
+	return nil
+}
+
+// runCompile compiles the file at path and returns the x86 assembly, or the
+// textual IR if compilation stopped at the IR stage, or an empty string if
+// compilation stopped at another stage.
+func runCompile(path string, stage compiler.Stage, pm *compiler.PassManager, warnings map[string]bool, debug bool) (string, error) {
+	irFile, err := runFrontend(path, stage, warnings, debug)
+	if irFile == nil || err != nil {
+		return "", err
+	}
+	return runCompileIR(irFile, stage, pm, debug)
+}
+
+// runFrontend compiles the file at path to IR, or returns nil if
+// compilation stopped at an earlier stage.
+//
+// Files with a .ir extension contain textual IR, so compilation resumes from
+// the IR stage. Otherwise the enabled warnings are written to stderr.
+func runFrontend(path string, stage compiler.Stage, warnings map[string]bool, debug bool) (*ir.File, error) {
 	src, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("read: %s: %w", path, err)
+		return nil, fmt.Errorf("read: %s: %w", path, err)
+	}
+
+	if filepath.Ext(path) == ".ir" {
+		if stage != "" && stage != compiler.StageIR && stage != compiler.StageAssemble {
+			return nil, fmt.Errorf("cannot stop at stage %s with an ir file", stage)
+		}
+
+		irFile, err := ir.ParseText(src, debug)
+		if err != nil {
+			return nil, fmt.Errorf("parse ir: %w", err)
+		}
+		return irFile, nil
 	}
 
 	if stage == compiler.StageTokenize || debug {
@@ -101,7 +334,7 @@ func runCompile(path string, outputPath string, stage compiler.Stage, debug bool
 		}
 		fmt.Println("")
 		if stage == compiler.StageTokenize {
-			return nil
+			return nil, nil
 		}
 	}
 
@@ -113,7 +346,7 @@ func runCompile(path string, outputPath string, stage compiler.Stage, debug bool
 
 	fileAST, err := ast.Parse(scanner, debug)
 	if err != nil {
-		return fmt.Errorf("parse ast: %w", err)
+		return nil, fmt.Errorf("parse ast: %w", err)
 	}
 
 	if debug {
@@ -127,7 +360,7 @@ func runCompile(path string, outputPath string, stage compiler.Stage, debug bool
 
 		print.Print(fileAST)
 		if stage == compiler.StageParse {
-			return nil
+			return nil, nil
 		}
 
 		if debug {
@@ -137,8 +370,9 @@ func runCompile(path string, outputPath string, stage compiler.Stage, debug bool
 
 	validatedAST, err := ast.Validate(fileAST, debug)
 	if err != nil {
-		return fmt.Errorf("validate ast: %w", err)
+		return nil, fmt.Errorf("validate ast: %w", err)
 	}
+	printWarnings(path, compiler.CheckUnused(validatedAST), warnings)
 
 	if stage == compiler.StageValidate || debug {
 		if debug {
@@ -147,7 +381,7 @@ func runCompile(path string, outputPath string, stage compiler.Stage, debug bool
 
 		print.Print(validatedAST)
 		if stage == compiler.StageValidate {
-			return nil
+			return nil, nil
 		}
 
 		if debug {
@@ -157,28 +391,38 @@ func runCompile(path string, outputPath string, stage compiler.Stage, debug bool
 
 	irFile, err := ir.Parse(validatedAST, debug)
 	if err != nil {
-		return fmt.Errorf("parse ir: %w", err)
+		return nil, fmt.Errorf("parse ir: %w", err)
 	}
 
-	if stage == compiler.StageIR || debug {
-		if debug {
-			fmt.Println("ir:")
-		}
+	printWarnings(path, compiler.CheckUninitialized(irFile.(*ir.File)), warnings)
 
-		print.Print(irFile)
-		if stage == compiler.StageIR {
-			return nil
-		}
+	return irFile.(*ir.File), nil
+}
 
-		if debug {
-			fmt.Println("")
-		}
+// runCompileIR optimizes the IR file with the passes in pm, and compiles it
+// to x86 assembly. If stopping at the IR stage it returns the optimized IR
+// as text instead.
+func runCompileIR(irFile *ir.File, stage compiler.Stage, pm *compiler.PassManager, debug bool) (string, error) {
+	if err := pm.RunIR(irFile); err != nil {
+		return "", err
+	}
+
+	if debug {
+		fmt.Println("ir:")
+		fmt.Print(ir.Format(irFile))
+		fmt.Println("")
+	}
+	if stage == compiler.StageIR {
+		// Output the textual IR, which can be compiled again from an .ir
+		// file.
+		return ir.Format(irFile), nil
 	}
 
 	assem, err := assembly.Parse(irFile, debug)
 	if err != nil {
-		return fmt.Errorf("parse assembly: %w", err)
+		return "", fmt.Errorf("parse assembly: %w", err)
 	}
+	pm.RunAssembly(assem.(*assembly.File))
 
 	if stage == compiler.StageAssemble || debug {
 		if debug {
@@ -187,7 +431,7 @@ func runCompile(path string, outputPath string, stage compiler.Stage, debug bool
 
 		print.Print(assem)
 		if stage == compiler.StageAssemble {
-			return nil
+			return "", nil
 		}
 
 		if debug {
@@ -203,7 +447,7 @@ func runCompile(path string, outputPath string, stage compiler.Stage, debug bool
 
 		print.Print(assemFixed)
 		if stage == compiler.StageAssemble {
-			return nil
+			return "", nil
 		}
 
 		if debug {
@@ -220,9 +464,42 @@ func runCompile(path string, outputPath string, stage compiler.Stage, debug bool
 		fmt.Println("")
 	}
 
-	if err := os.WriteFile(outputPath, []byte(x86Assem), 0o666); err != nil {
-		return fmt.Errorf("write: %w", err)
+	return x86Assem, nil
+}
+
+// replaceExt returns the path with its extension replaced by ext.
+func replaceExt(path string, ext string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ext
+}
+
+// sameFile returns whether the paths name the same existing file.
+func sameFile(a string, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
+// printWarnings writes the enabled warnings to stderr.
+func printWarnings(path string, warnings []compiler.Warning, enabled map[string]bool) {
+	for _, warning := range warnings {
+		if enabled[warning.Flag] {
+			fmt.Fprintf(os.Stderr, "%s: warning: %s\n", path, warning)
+		}
 	}
+}
 
-	return nil
+// warnUsage returns the usage of the -W flag, listing the warnings.
+func warnUsage() string {
+	var names []string
+	for name := range compiler.Warnings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("comma separated warnings to enable, or disable with a no- prefix, such as -Wno-unused-variable (all, %s)", strings.Join(names, ", "))
 }
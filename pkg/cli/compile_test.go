@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileObjectOutputPath(t *testing.T) {
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("cc not found")
+	}
+
+	src, err := filepath.Abs("../../testdata/return.c")
+	require.NoError(t, err)
+	t.Chdir(t.TempDir())
+
+	// Without --output, the object file is named after the source file.
+	cmd := newCommand()
+	cmd.SetArgs([]string{"compile", "-c", src})
+	require.NoError(t, cmd.Execute())
+
+	_, err = os.Stat("return.o")
+	assert.NoError(t, err)
+	_, err = os.Stat("dump.s")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCompileIROutputPath(t *testing.T) {
+	src, err := filepath.Abs("../../testdata/return.c")
+	require.NoError(t, err)
+	t.Chdir(t.TempDir())
+
+	// Without --output, the IR is named after the source file.
+	cmd := newCommand()
+	cmd.SetArgs([]string{"compile", "-s", "ir", src})
+	require.NoError(t, cmd.Execute())
+
+	b, err := os.ReadFile("return.ir")
+	require.NoError(t, err)
+	_, err = ir.ParseText(b, false)
+	assert.NoError(t, err)
+
+	cmd = newCommand()
+	cmd.SetArgs([]string{"compile", "-s", "ir", "-o", "x.ir", src})
+	require.NoError(t, cmd.Execute())
+
+	b2, err := os.ReadFile("x.ir")
+	require.NoError(t, err)
+	assert.Equal(t, b, b2)
+	_, err = os.Stat("dump.s")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/andydunstall/minc/pkg/compiler"
+	"github.com/andydunstall/minc/pkg/ir/dot"
+	"github.com/spf13/cobra"
+)
+
+func newGraphCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "graph path [flags]",
+		Short: "output the control-flow or call graph of a C program",
+		Long: `Output the control-flow graph of each function in a C program's IR, in
+the Graphviz dot language.
+
+Each node is a basic block listing its instructions, and the edges of
+conditional jumps are labelled true or false. With --calls the call graph
+is output instead.
+
+Render the graph with Graphviz:
+
+  $ minc graph ./program.c -o ./program.dot
+  $ dot -Tsvg ./program.dot -o ./program.svg`,
+	}
+
+	var outputPath string
+	cmd.Flags().StringVarP(
+		&outputPath,
+		"output",
+		"o",
+		"",
+		"output path (default stdout)",
+	)
+
+	var calls bool
+	cmd.Flags().BoolVar(
+		&calls,
+		"calls",
+		false,
+		"output the call graph rather than the control-flow graphs",
+	)
+
+	var optLevel int
+	cmd.Flags().IntVarP(
+		&optLevel,
+		"opt-level",
+		"O",
+		0,
+		fmt.Sprintf("optimization level to graph the ir after (0 to %d)", len(compiler.OptLevels)-1),
+	)
+
+	cmd.Run = func(_ *cobra.Command, args []string) {
+		if len(args) != 1 {
+			exitError(fmt.Errorf("graph: expected a single path"))
+		}
+		if optLevel < 0 || optLevel >= len(compiler.OptLevels) {
+			exitError(fmt.Errorf("graph: unsupported optimization level: %d", optLevel))
+		}
+
+		if err := runGraph(args[0], outputPath, calls, optLevel); err != nil {
+			exitError(fmt.Errorf("graph: %w", err))
+		}
+	}
+
+	return cmd
+}
+
+func runGraph(path string, outputPath string, calls bool, optLevel int) error {
+	// Warnings are output when compiling, so aren't repeated here.
+	irFile, err := runFrontend(path, "", nil, false)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	pm, err := compiler.NewPassManager(compiler.OptLevels[optLevel], nil, false, os.Stdout, false, false)
+	if err != nil {
+		return err
+	}
+	if err := pm.RunIR(irFile); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	var buf bytes.Buffer
+	if calls {
+		dot.WriteCallGraph(&buf, irFile)
+	} else {
+		dot.WriteCFG(&buf, irFile)
+	}
+
+	if outputPath == "" {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	if err := os.WriteFile(outputPath, buf.Bytes(), 0o666); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
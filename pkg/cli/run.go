@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/andydunstall/minc/pkg/compiler"
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/spf13/cobra"
+)
+
+func newRunCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run path... [flags]",
+		Short: "compile and run a C program",
+		Long: `Compile and run a C program, exiting with the program's exit code.
+
+By default the program is compiled, assembled and linked with the system C
+compiler. With --interp the program's IR is interpreted instead, so no
+assembler is needed.`,
+	}
+
+	var interp bool
+	cmd.Flags().BoolVarP(
+		&interp,
+		"interp",
+		"i",
+		false,
+		"interpret the program's IR rather than compiling it",
+	)
+
+	var warningFlags []string
+	cmd.Flags().StringSliceVarP(
+		&warningFlags,
+		"warn",
+		"W",
+		nil,
+		warnUsage(),
+	)
+
+	var debug bool
+	cmd.Flags().BoolVarP(
+		&debug,
+		"debug",
+		"d",
+		false,
+		"whether to output debug logs and traces",
+	)
+
+	cmd.Run = func(_ *cobra.Command, args []string) {
+		if len(args) == 0 {
+			exitError(fmt.Errorf("run: missing path"))
+		}
+
+		warnings, err := compiler.EnabledWarnings(warningFlags)
+		if err != nil {
+			exitError(fmt.Errorf("run: %w", err))
+		}
+
+		var code int
+		if interp {
+			code, err = runInterp(args, warnings, debug)
+		} else {
+			code, err = runNative(args, warnings, debug)
+		}
+		if err != nil {
+			exitError(fmt.Errorf("run: %w", err))
+		}
+		os.Exit(code)
+	}
+
+	return cmd
+}
+
+func runInterp(paths []string, warnings map[string]bool, debug bool) (int, error) {
+	if len(paths) > 1 {
+		return 0, fmt.Errorf("interpreter only supports a single path")
+	}
+
+	irFile, err := runFrontend(paths[0], "", warnings, debug)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", paths[0], err)
+	}
+
+	result, err := ir.Interpret(irFile, "main", nil)
+	if err != nil {
+		return 0, fmt.Errorf("interpret: %w", err)
+	}
+	// Only the low byte of main's result is the exit code.
+	return int(uint8(result)), nil
+}
+
+func runNative(paths []string, warnings map[string]bool, debug bool) (int, error) {
+	dir, err := os.MkdirTemp("", "minc")
+	if err != nil {
+		return 0, fmt.Errorf("temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pm, err := compiler.NewPassManager(compiler.OptLevels[0], nil, false, os.Stdout, debug, debug)
+	if err != nil {
+		return 0, err
+	}
+
+	program := filepath.Join(dir, "program")
+	if err := runCompileFiles(paths, program, false, true, "", pm, warnings, debug); err != nil {
+		return 0, err
+	}
+
+	cmd := exec.Command(program)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+			return exitErr.ExitCode(), nil
+		}
+		return 0, fmt.Errorf("exec: %w", err)
+	}
+	return 0, nil
+}
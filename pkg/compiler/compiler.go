@@ -13,7 +13,8 @@ const (
 	// semantic analysis.
 	StageValidate Stage = "validate"
 
-	// StageIR parses the program into an IR.
+	// StageIR parses the program into an IR, and writes the textual IR
+	// (see ir.Format) to the output path.
 	StageIR Stage = "ir"
 
 	// StageAssemble parses the program into assembly.
@@ -1,13 +1,23 @@
 package compiler_test
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/andydunstall/minc/pkg/arch/x86"
 	"github.com/andydunstall/minc/pkg/assembly"
 	"github.com/andydunstall/minc/pkg/ast"
+	"github.com/andydunstall/minc/pkg/compiler"
 	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/opt"
+	"github.com/andydunstall/minc/pkg/ir/ssa"
 	"github.com/andydunstall/minc/pkg/token"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -81,7 +91,7 @@ main:
 	movl $0, -8(%rbp)
 	jmp .Lor_end.1
 .Lor_true.0:
-	movl $0, -8(%rbp)
+	movl $1, -8(%rbp)
 .Lor_end.1:
 	cmpl $3, -8(%rbp)
 	movl $0, -12(%rbp)
@@ -238,15 +248,11 @@ addTen:
 	movq %rsp, %rbp
 	subq $16, %rsp
 	movl %edi, -4(%rbp)
-	subq $8, %rsp
 	movl -4(%rbp), %edi
 	call addFive
-	addq $16, %rsp
 	movl %eax, -8(%rbp)
-	subq $8, %rsp
 	movl -8(%rbp), %edi
 	call addFive
-	addq $16, %rsp
 	movl %eax, -12(%rbp)
 	movl -12(%rbp), %eax
 	movq %rbp, %rsp
@@ -258,27 +264,21 @@ main:
 	movq %rsp, %rbp
 	subq $32, %rsp
 	call two
-	addq $0, %rsp
 	movl %eax, -4(%rbp)
 	call two
-	addq $0, %rsp
 	movl %eax, -8(%rbp)
 	movl -8(%rbp), %r10d
 	movl %r10d, -12(%rbp)
 	addl $1, -12(%rbp)
-	subq $8, %rsp
 	movl -12(%rbp), %edi
 	call addTen
-	addq $16, %rsp
 	movl %eax, -16(%rbp)
 	movl -4(%rbp), %r10d
 	movl %r10d, -20(%rbp)
 	movl -16(%rbp), %r10d
 	addl %r10d, -20(%rbp)
-	subq $8, %rsp
 	movl $5, %edi
 	call addTen
-	addq $16, %rsp
 	movl %eax, -24(%rbp)
 	movl -20(%rbp), %r10d
 	movl %r10d, -28(%rbp)
@@ -289,6 +289,349 @@ main:
 	popq %rbp
 	ret
 	.section .note.GNU-stack,"",@progbits
+`,
+		},
+		{
+			Name: "enums",
+			Path: "enums.c",
+			Want: `	.global size
+size:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $32, %rsp
+	movl %edi, -4(%rbp)
+	movslq -4(%rbp), %r11
+	movq %r11, -16(%rbp)
+	movq -16(%rbp), %r10
+	movq %r10, -24(%rbp)
+	movq -24(%rbp), %r11
+	imulq $4, %r11
+	movq %r11, -24(%rbp)
+	movl -24(%rbp), %r10d
+	movl %r10d, -28(%rbp)
+	movl -28(%rbp), %eax
+	movq %rbp, %rsp
+	popq %rbp
+	ret
+	.global main
+main:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $48, %rsp
+	movl $6, -4(%rbp)
+	movl -4(%rbp), %r10d
+	movl %r10d, -8(%rbp)
+	addl $4, -8(%rbp)
+	movl -8(%rbp), %r10d
+	movl %r10d, -12(%rbp)
+	movl -12(%rbp), %edi
+	call size
+	movl %eax, -16(%rbp)
+	movslq -16(%rbp), %r11
+	movq %r11, -24(%rbp)
+	movq -24(%rbp), %r10
+	movq %r10, -32(%rbp)
+	addq $4, -32(%rbp)
+	movl -32(%rbp), %r10d
+	movl %r10d, -36(%rbp)
+	movl -36(%rbp), %eax
+	movq %rbp, %rsp
+	popq %rbp
+	ret
+	.section .note.GNU-stack,"",@progbits
+`,
+		},
+		{
+			Name: "casts",
+			Path: "casts.c",
+			Want: `	.global widen
+widen:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $32, %rsp
+	movb %dil, -1(%rbp)
+	movl %esi, -8(%rbp)
+	movsbl -1(%rbp), %r11d
+	movl %r11d, -12(%rbp)
+	movl -12(%rbp), %r10d
+	movl %r10d, -16(%rbp)
+	movl -8(%rbp), %r10d
+	addl %r10d, -16(%rbp)
+	movl -16(%rbp), %r11d
+	movq %r11, -24(%rbp)
+	movq -24(%rbp), %rax
+	movq %rbp, %rsp
+	popq %rbp
+	ret
+	.global main
+main:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $80, %rsp
+	movl $3, %r10d
+	movslq %r10d, %r11
+	movq %r11, -8(%rbp)
+	movq $4294967296, %r10
+	movq %r10, -16(%rbp)
+	movq -16(%rbp), %r11
+	imulq -8(%rbp), %r11
+	movq %r11, -16(%rbp)
+	movq -16(%rbp), %r10
+	movq %r10, -24(%rbp)
+	movl $200, %r10d
+	movslq %r10d, %r11
+	movq %r11, -32(%rbp)
+	movq -24(%rbp), %r10
+	movq %r10, -40(%rbp)
+	movq -32(%rbp), %r10
+	addq %r10, -40(%rbp)
+	movb -40(%rbp), %r10b
+	movb %r10b, -41(%rbp)
+	movb -41(%rbp), %r10b
+	movb %r10b, -42(%rbp)
+	movb -42(%rbp), %r10b
+	movb %r10b, -43(%rbp)
+	movb -43(%rbp), %r10b
+	movb %r10b, -44(%rbp)
+	movb -44(%rbp), %dil
+	movl $60, %esi
+	call widen
+	movq %rax, -56(%rbp)
+	movq -56(%rbp), %r10
+	movq %r10, -64(%rbp)
+	movq -24(%rbp), %r10
+	subq %r10, -64(%rbp)
+	movl -64(%rbp), %r10d
+	movl %r10d, -68(%rbp)
+	movzbl -42(%rbp), %r11d
+	movl %r11d, -72(%rbp)
+	cmpl $100, -72(%rbp)
+	movl $0, -76(%rbp)
+	setg -76(%rbp)
+	movl -68(%rbp), %r10d
+	movl %r10d, -80(%rbp)
+	movl -76(%rbp), %r10d
+	addl %r10d, -80(%rbp)
+	movl -80(%rbp), %eax
+	movq %rbp, %rsp
+	popq %rbp
+	ret
+	.section .note.GNU-stack,"",@progbits
+`,
+		},
+		{
+			Name: "funcptrs",
+			Path: "funcptrs.c",
+			Want: `	.global double
+double:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $16, %rsp
+	movl %edi, -4(%rbp)
+	movl -4(%rbp), %r10d
+	movl %r10d, -8(%rbp)
+	movl -8(%rbp), %r11d
+	imull $2, %r11d
+	movl %r11d, -8(%rbp)
+	movl -8(%rbp), %eax
+	movq %rbp, %rsp
+	popq %rbp
+	ret
+	.global negate
+negate:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $16, %rsp
+	movl %edi, -4(%rbp)
+	movl -4(%rbp), %r10d
+	movl %r10d, -8(%rbp)
+	negl -8(%rbp)
+	movl -8(%rbp), %eax
+	movq %rbp, %rsp
+	popq %rbp
+	ret
+	.global apply
+apply:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $16, %rsp
+	movq %rdi, -8(%rbp)
+	movl %esi, -12(%rbp)
+	movl -12(%rbp), %edi
+	movq -8(%rbp), %r11
+	call *%r11
+	movl %eax, -16(%rbp)
+	movl -16(%rbp), %eax
+	movq %rbp, %rsp
+	popq %rbp
+	ret
+	.global main
+main:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $112, %rsp
+	leaq double(%rip), %r11
+	movq %r11, -8(%rbp)
+	movq -8(%rbp), %r10
+	movq %r10, -16(%rbp)
+	leaq negate(%rip), %r11
+	movq %r11, -24(%rbp)
+	movq -24(%rbp), %r10
+	movq %r10, -32(%rbp)
+	movl $0, %r10d
+	movslq %r10d, %r11
+	movq %r11, -40(%rbp)
+	movq -40(%rbp), %r10
+	movq %r10, -48(%rbp)
+	movq -32(%rbp), %r10
+	cmpq %r10, -16(%rbp)
+	movl $0, -52(%rbp)
+	sete -52(%rbp)
+	cmpl $0, -52(%rbp)
+	jne .Lor_true.8
+	movl $0, %r10d
+	movslq %r10d, %r11
+	movq %r11, -64(%rbp)
+	movq -64(%rbp), %r10
+	cmpq %r10, -48(%rbp)
+	movl $0, -68(%rbp)
+	setne -68(%rbp)
+	cmpl $0, -68(%rbp)
+	jne .Lor_true.8
+	movl $0, -72(%rbp)
+	jmp .Lor_end.9
+.Lor_true.8:
+	movl $1, -72(%rbp)
+.Lor_end.9:
+	cmpl $0, -72(%rbp)
+	je .Lelse.6
+	movl $1, %eax
+	movq %rbp, %rsp
+	popq %rbp
+	ret
+	jmp .Lif_end.7
+.Lelse.6:
+.Lif_end.7:
+	movq -16(%rbp), %rdi
+	movl $20, %esi
+	call apply
+	movl %eax, -76(%rbp)
+	movl $3, %edi
+	movq -32(%rbp), %r11
+	call *%r11
+	movl %eax, -80(%rbp)
+	movl -76(%rbp), %r10d
+	movl %r10d, -84(%rbp)
+	movl -80(%rbp), %r10d
+	addl %r10d, -84(%rbp)
+	movl $0, %r10d
+	movslq %r10d, %r11
+	movq %r11, -96(%rbp)
+	movq -96(%rbp), %r10
+	cmpq %r10, -48(%rbp)
+	movl $0, -100(%rbp)
+	sete -100(%rbp)
+	movl -84(%rbp), %r10d
+	movl %r10d, -104(%rbp)
+	movl -100(%rbp), %r10d
+	addl %r10d, -104(%rbp)
+	movl -104(%rbp), %eax
+	movq %rbp, %rsp
+	popq %rbp
+	ret
+	.section .note.GNU-stack,"",@progbits
+`,
+		},
+		{
+			Name: "linkage",
+			Path: "linkage.c",
+			Want: `	.global counter
+counter:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $16, %rsp
+	movl calls.2(%rip), %r10d
+	movl %r10d, -4(%rbp)
+	addl $1, -4(%rbp)
+	movl -4(%rbp), %r10d
+	movl %r10d, calls.2(%rip)
+	movl calls.2(%rip), %eax
+	movq %rbp, %rsp
+	popq %rbp
+	ret
+helper:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $16, %rsp
+	movl %edi, -4(%rbp)
+	movl total(%rip), %r10d
+	movl %r10d, -8(%rbp)
+	movl -4(%rbp), %r10d
+	addl %r10d, -8(%rbp)
+	movl -8(%rbp), %r10d
+	movl %r10d, total(%rip)
+	movl total(%rip), %eax
+	movq %rbp, %rsp
+	popq %rbp
+	ret
+	.global main
+main:
+	pushq %rbp
+	movq %rsp, %rbp
+	subq $64, %rsp
+	call counter
+	movl %eax, -4(%rbp)
+	call counter
+	movl %eax, -8(%rbp)
+	movl $4, %edi
+	call helper
+	movl %eax, -12(%rbp)
+	call counter
+	movl %eax, -16(%rbp)
+	movslq -16(%rbp), %r11
+	movq %r11, -24(%rbp)
+	movq -24(%rbp), %r10
+	movq %r10, -32(%rbp)
+	movq -32(%rbp), %r11
+	imulq scale(%rip), %r11
+	movq %r11, -32(%rbp)
+	movl -32(%rbp), %r10d
+	movl %r10d, -36(%rbp)
+	movl $1, %edi
+	call helper
+	movl %eax, -40(%rbp)
+	movl -36(%rbp), %r10d
+	movl %r10d, -44(%rbp)
+	movl -40(%rbp), %r10d
+	addl %r10d, -44(%rbp)
+	movsbl small(%rip), %r11d
+	movl %r11d, -48(%rbp)
+	movl -44(%rbp), %r10d
+	movl %r10d, -52(%rbp)
+	movl -48(%rbp), %r10d
+	addl %r10d, -52(%rbp)
+	movl -52(%rbp), %eax
+	movq %rbp, %rsp
+	popq %rbp
+	ret
+	.bss
+	.global total
+	.balign 4
+total:
+	.zero 4
+	.data
+	.balign 8
+scale:
+	.quad 3
+	.global small
+	.balign 1
+small:
+	.byte -2
+	.bss
+	.balign 4
+calls.2:
+	.zero 4
+	.section .note.GNU-stack,"",@progbits
 `,
 		},
 	}
@@ -300,7 +643,497 @@ main:
 	}
 }
 
+func TestIRRoundTrip(t *testing.T) {
+	paths, err := filepath.Glob("../../testdata/*.c")
+	require.NoError(t, err)
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			irFile := compileIR(path, t)
+			text := ir.Format(irFile)
+
+			parsed, err := ir.ParseText([]byte(text), false)
+			require.NoError(t, err)
+			assert.Equal(t, text, ir.Format(parsed))
+			assert.Equal(t, emitX86(irFile, t), emitX86(parsed, t))
+		})
+	}
+}
+
+func TestInlineAttrs(t *testing.T) {
+	text := ir.Format(compileIR("../../testdata/inline.c", t))
+	assert.Contains(t, text, "global @inline fn square(i32 n.1) {")
+	assert.Contains(t, text, "global @noinline fn addOne(i32 n.2) {")
+	// The attribute applies to every declaration of the function.
+	assert.Contains(t, text, "@inline fn clamp(")
+
+	irFile := compileIR("../../testdata/inline.c", t)
+	opt.InlineCalls(irFile)
+	text = ir.Format(irFile)
+	assert.Contains(t, text, "call i32 addOne(")
+	assert.Contains(t, text, "call i32 fact(")
+	assert.NotContains(t, text, "call i32 square(")
+	// clamp is static, so is removed once every call is inlined.
+	assert.NotContains(t, text, "fn clamp(")
+}
+
+func TestInterpret(t *testing.T) {
+	tests := []struct {
+		Path string
+		Want int64
+	}{
+		{Path: "return.c", Want: 10},
+		{Path: "unary.c", Want: 1},
+		{Path: "logical.c", Want: 0},
+		{Path: "binary.c", Want: 72},
+		{Path: "variables.c", Want: 8},
+		{Path: "conditional.c", Want: 7},
+		{Path: "loops.c", Want: 5},
+		{Path: "functions.c", Want: 30},
+		{Path: "enums.c", Want: 44},
+		{Path: "casts.c", Want: 5},
+		{Path: "funcptrs.c", Want: 38},
+		{Path: "linkage.c", Want: 12},
+		{Path: "inline.c", Want: 48},
+		{Path: "tailcall.c", Want: 208},
+		{Path: "division.c", Want: 51},
+		{Path: "uninitialized.c", Want: 5},
+		{Path: "unused.c", Want: 4},
+		{Path: "registers.c", Want: 60},
+		{Path: "twoloops.c", Want: 9},
+		{Path: "tmpvar.c", Want: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Path, func(t *testing.T) {
+			result, err := ir.Interpret(compileIR("../../testdata/"+tt.Path, t), "main", nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.Want, result)
+
+			// Optimizations must not change the result.
+			for level, passes := range compiler.OptLevels {
+				irFile := compileIR("../../testdata/"+tt.Path, t)
+				pm, err := compiler.NewPassManager(passes, nil, false, io.Discard, false, true)
+				require.NoError(t, err)
+				require.NoError(t, pm.RunIR(irFile))
+				result, err = ir.Interpret(irFile, "main", nil)
+				require.NoError(t, err)
+				assert.Equal(t, tt.Want, result, "-O%d", level)
+			}
+
+			// Nor must converting to SSA form and back.
+			irFile := compileIR("../../testdata/"+tt.Path, t)
+			statics := ir.StaticVars(irFile)
+			for _, decl := range irFile.Decls {
+				if decl, ok := decl.(*ir.FuncDecl); ok {
+					ssa.Build(decl, statics).Destruct()
+				}
+			}
+			require.NoError(t, ir.Verify(irFile))
+			optimize(irFile, t)
+			result, err = ir.Interpret(irFile, "main", nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.Want, result)
+		})
+	}
+}
+
+func TestCheckUninitialized(t *testing.T) {
+	var warnings []string
+	for _, warning := range compiler.CheckUninitialized(compileIR("../../testdata/uninitialized.c", t)) {
+		warnings = append(warnings, warning.String())
+	}
+	assert.Equal(t, []string{
+		"pick: 'x' may be used uninitialized [-Wmaybe-uninitialized]",
+		"sum: 'total' may be used uninitialized [-Wmaybe-uninitialized]",
+	}, warnings)
+
+	// Programs that assign every variable before reading it have no
+	// warnings.
+	paths, err := filepath.Glob("../../testdata/*.c")
+	require.NoError(t, err)
+	for _, path := range paths {
+		if filepath.Base(path) == "uninitialized.c" {
+			continue
+		}
+		assert.Empty(t, compiler.CheckUninitialized(compileIR(path, t)), path)
+	}
+}
+
+func TestCheckUnused(t *testing.T) {
+	src, err := os.ReadFile("../../testdata/unused.c")
+	require.NoError(t, err)
+	fileAST, err := ast.Parse(token.NewScanner(src), false)
+	require.NoError(t, err)
+	validatedAST, err := ast.Validate(fileAST, false)
+	require.NoError(t, err)
+
+	var warnings []string
+	for _, warning := range compiler.CheckUnused(validatedAST) {
+		warnings = append(warnings, warning.String())
+	}
+	assert.Equal(t, []string{
+		// A recursive call isn't a use.
+		"static function 'helper' defined but not used [-Wunused-function]",
+		"callback: unused parameter 'b' [-Wunused-parameter]",
+		// Assigning a variable isn't a use, but sizeof is.
+		"main: unused variable 'y' [-Wunused-variable]",
+		"main: unused variable 's' [-Wunused-variable]",
+		"main: statement has no effect [-Wunused-value]",
+		"main: ignoring result of 'compute', declared with attribute warn_unused_result [-Wunused-result]",
+		"main: ignoring result of 'compute', declared with attribute warn_unused_result [-Wunused-result]",
+	}, warnings)
+}
+
+func TestEnabledWarnings(t *testing.T) {
+	enabled, err := compiler.EnabledWarnings(nil)
+	require.NoError(t, err)
+	assert.True(t, enabled["unused-variable"])
+	assert.False(t, enabled["unused-parameter"])
+
+	enabled, err = compiler.EnabledWarnings([]string{"no-all", "unused-parameter", "unused-value", "no-unused-value"})
+	require.NoError(t, err)
+	for name := range compiler.Warnings {
+		assert.Equal(t, name == "unused-parameter", enabled[name], name)
+	}
+
+	_, err = compiler.EnabledWarnings([]string{"unknown"})
+	assert.EqualError(t, err, "unknown warning: unknown")
+}
+
+func TestInterpretTrap(t *testing.T) {
+	irFile, err := ir.ParseText([]byte(`global fn div(i32 a, i32 b) {
+	tmp.1 = div i32 a, b
+	ret i32 tmp.1
+}
+`), false)
+	require.NoError(t, err)
+
+	result, err := ir.Interpret(irFile, "div", []int64{-7, 2})
+	require.NoError(t, err)
+	assert.Equal(t, int64(-3), result)
+
+	_, err = ir.Interpret(irFile, "div", []int64{7, 0})
+	assert.EqualError(t, err, "division by zero")
+
+	_, err = ir.Interpret(irFile, "div", []int64{math.MinInt32, -1})
+	assert.EqualError(t, err, "division overflow")
+
+	// INT_MIN can only be written as an expression in the source.
+	path := filepath.Join(t.TempDir(), "overflow.c")
+	require.NoError(t, os.WriteFile(path, []byte(`fn div(int a, int b) {
+	return a / b;
+}
+
+fn main() {
+	return div(-2147483647 - 1, -1);
+}
+`), 0o644))
+	_, err = ir.Interpret(compileIR(path, t), "main", nil)
+	assert.EqualError(t, err, "division overflow")
+}
+
+func TestPassManager(t *testing.T) {
+	irFile, err := ir.ParseText([]byte(`global fn main() {
+	x = copy i32 2
+	tmp.1 = mul i32 x, 3
+	ret i32 tmp.1
+}
+`), false)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	pm, err := compiler.NewPassManager([]string{"copyprop", "fold", "dce"}, []string{"fold"}, false, &out, false, true)
+	require.NoError(t, err)
+	require.NoError(t, pm.RunIR(irFile))
+
+	assert.Equal(t, `# after fold
+global fn main() {
+	x = copy i32 2
+	tmp.1 = copy i32 6
+	ret i32 6
+}
+`, out.String())
+	assert.Equal(t, `global fn main() {
+	ret i32 6
+}
+`, ir.Format(irFile))
+}
+
+func TestPassManagerDumpSSA(t *testing.T) {
+	text := `global fn main(i32 n) {
+	x = copy i32 0
+loop.1:
+	jz i32 n, end.2
+	x = add i32 x, n
+	n = sub i32 n, 1
+	jmp loop.1
+end.2:
+	ret i32 x
+}
+`
+	irFile, err := ir.ParseText([]byte(text), false)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	pm, err := compiler.NewPassManager(nil, nil, true, &out, false, true)
+	require.NoError(t, err)
+	require.NoError(t, pm.RunIR(irFile))
+
+	assert.Equal(t, `# ssa
+global fn main(i32 n) {
+	x.1 = copy i32 0
+loop.1:
+	x.2 = phi i32 x.1, x.3
+	n.1 = phi i32 n, n.2
+	jz i32 n.1, end.2
+	x.3 = add i32 x.2, n.1
+	n.2 = sub i32 n.1, 1
+	jmp loop.1
+end.2:
+	ret i32 x.2
+}
+`, out.String())
+	// Only the dump is in SSA form.
+	assert.Equal(t, text, ir.Format(irFile))
+}
+
+func TestPassManagerErrors(t *testing.T) {
+	tests := []struct {
+		Passes    []string
+		DumpAfter []string
+		Err       string
+	}{
+		{Passes: []string{"fold", "unknown"}, Err: "unknown pass: unknown"},
+		{Passes: []string{"reduce", "dce"}, Err: "ir pass dce cannot run after assembly pass reduce"},
+		{Passes: []string{"fold"}, DumpAfter: []string{"dce"}, Err: "cannot dump after pass dce as it doesn't run"},
+	}
+
+	for _, tt := range tests {
+		_, err := compiler.NewPassManager(tt.Passes, tt.DumpAfter, false, io.Discard, false, true)
+		assert.EqualError(t, err, tt.Err)
+	}
+
+	// Every optimization level only uses registered passes.
+	for _, passes := range compiler.OptLevels {
+		_, err := compiler.NewPassManager(passes, nil, false, io.Discard, false, true)
+		assert.NoError(t, err)
+	}
+}
+
+func TestPassManagerVerify(t *testing.T) {
+	irFile, err := ir.ParseText([]byte(`global fn main() {
+	ret i32 tmp.1
+}
+`), false)
+	require.NoError(t, err)
+
+	// The IR is only verified when enabled.
+	pm, err := compiler.NewPassManager(nil, nil, false, io.Discard, false, false)
+	require.NoError(t, err)
+	assert.NoError(t, pm.RunIR(irFile))
+
+	pm, err = compiler.NewPassManager(nil, nil, false, io.Discard, false, true)
+	require.NoError(t, err)
+	assert.EqualError(t, pm.RunIR(irFile), `invalid ir before passes: main: "ret i32 tmp.1": tmp.1 is never defined`)
+}
+
+func TestLinkX86(t *testing.T) {
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("cc not found")
+	}
+
+	// Compile each file as a separate translation unit, then link them
+	// into a single program.
+	dir := t.TempDir()
+	var objects []string
+	for _, name := range []string{"main", "lib"} {
+		asmPath := filepath.Join(dir, name+".s")
+		objPath := filepath.Join(dir, name+".o")
+		src := compileX86("../../testdata/multi/"+name+".c", t)
+		require.NoError(t, os.WriteFile(asmPath, []byte(src), 0o666))
+		require.NoError(t, compiler.Assemble(asmPath, objPath))
+		objects = append(objects, objPath)
+	}
+
+	program := filepath.Join(dir, "program")
+	require.NoError(t, compiler.Link(objects, program))
+
+	err := exec.Command(program).Run()
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 30, exitErr.ExitCode())
+}
+
+func TestReduceStrengthX86(t *testing.T) {
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("cc not found")
+	}
+
+	// Folding makes the negative divisors constants.
+	irFile := compileIR("../../testdata/division.c", t)
+	optimize(irFile, t)
+	assem, err := assembly.Parse(irFile, false)
+	require.NoError(t, err)
+	assert.True(t, assembly.ReduceStrength(assem.(*assembly.File)))
+	src := x86.Emit(assembly.Fix(assem.(*assembly.File), false))
+	assert.NotContains(t, src, "div")
+
+	dir := t.TempDir()
+	asmPath := filepath.Join(dir, "division.s")
+	objPath := filepath.Join(dir, "division.o")
+	require.NoError(t, os.WriteFile(asmPath, []byte(src), 0o666))
+	require.NoError(t, compiler.Assemble(asmPath, objPath))
+
+	program := filepath.Join(dir, "program")
+	require.NoError(t, compiler.Link([]string{objPath}, program))
+
+	// The same result as dividing with idiv.
+	err = exec.Command(program).Run()
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 51, exitErr.ExitCode())
+}
+
+func TestBuilderX86(t *testing.T) {
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("cc not found")
+	}
+
+	// sum adds the numbers from 1 to n.
+	b := ir.NewBuilder()
+	n := b.Var("n", ir.Int32)
+	total := b.Var("total", ir.Int32)
+	b.Func("sum", false, n)
+	b.Copy(total, b.Const(0, ir.Int32))
+	loop := b.NewLabel("loop")
+	end := b.NewLabel("end")
+	b.Block(loop)
+	b.JumpIfZero(n, end)
+	b.Copy(total, b.Add(total, n))
+	b.Copy(n, b.Sub(n, b.Const(1, ir.Int32)))
+	b.Jump(loop)
+	b.Block(end)
+	b.Ret(total)
+
+	b.Func("main", true)
+	b.Ret(b.Call("sum", ir.Int32, b.Const(10, ir.Int32)))
+
+	irFile, err := b.File()
+	require.NoError(t, err)
+	src := emitX86(irFile, t)
+
+	dir := t.TempDir()
+	asmPath := filepath.Join(dir, "sum.s")
+	objPath := filepath.Join(dir, "sum.o")
+	require.NoError(t, os.WriteFile(asmPath, []byte(src), 0o666))
+	require.NoError(t, compiler.Assemble(asmPath, objPath))
+
+	program := filepath.Join(dir, "program")
+	require.NoError(t, compiler.Link([]string{objPath}, program))
+
+	err = exec.Command(program).Run()
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 55, exitErr.ExitCode())
+}
+
+func TestDuplicateLabelsX86(t *testing.T) {
+	// Labels need only be unique within a function in the IR, such as
+	// those added by inlining, though assembly labels are shared by the
+	// file.
+	irFile, err := ir.ParseText([]byte(`global fn f() {
+	jmp end
+end:
+	ret i32 1
+}
+
+global fn g() {
+	jmp end
+end:
+	ret i32 2
+}
+`), false)
+	require.NoError(t, err)
+
+	src := emitX86(irFile, t)
+	assert.Equal(t, 1, strings.Count(src, ".Lend:"))
+	assert.Contains(t, src, "\tjmp .Lend.g\n.Lend.g:\n")
+}
+
+func TestOptimizeX86(t *testing.T) {
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("cc not found")
+	}
+
+	tests := []struct {
+		Path string
+		Want int
+	}{
+		{Path: "functions.c", Want: 30},
+		{Path: "funcptrs.c", Want: 38},
+		{Path: "inline.c", Want: 48},
+		{Path: "tailcall.c", Want: 208},
+		{Path: "division.c", Want: 51},
+		{Path: "registers.c", Want: 60},
+		{Path: "twoloops.c", Want: 9},
+		{Path: "tmpvar.c", Want: 5},
+	}
+
+	for _, tt := range tests {
+		for level := 1; level < len(compiler.OptLevels); level++ {
+			// Each level must work with either register allocator.
+			for _, allocator := range []string{"graph", "linear"} {
+				t.Run(fmt.Sprintf("%s/O%d/%s", tt.Path, level, allocator), func(t *testing.T) {
+					passes, err := compiler.WithAllocator(compiler.OptLevels[level], allocator)
+					require.NoError(t, err)
+					pm, err := compiler.NewPassManager(passes, nil, false, io.Discard, false, true)
+					require.NoError(t, err)
+
+					irFile := compileIR("../../testdata/"+tt.Path, t)
+					require.NoError(t, pm.RunIR(irFile))
+					assem, err := assembly.Parse(irFile, false)
+					require.NoError(t, err)
+					pm.RunAssembly(assem.(*assembly.File))
+					src := x86.Emit(assembly.Fix(assem.(*assembly.File), false))
+
+					dir := t.TempDir()
+					asmPath := filepath.Join(dir, "program.s")
+					objPath := filepath.Join(dir, "program.o")
+					require.NoError(t, os.WriteFile(asmPath, []byte(src), 0o666))
+					require.NoError(t, compiler.Assemble(asmPath, objPath))
+
+					program := filepath.Join(dir, "program")
+					require.NoError(t, compiler.Link([]string{objPath}, program))
+
+					err = exec.Command(program).Run()
+					var exitErr *exec.ExitError
+					require.ErrorAs(t, err, &exitErr)
+					assert.Equal(t, tt.Want, exitErr.ExitCode())
+				})
+			}
+		}
+	}
+}
+
+func TestWithAllocator(t *testing.T) {
+	passes, err := compiler.WithAllocator([]string{"cleanup", "reduce", "linearscan"}, "graph")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cleanup", "reduce", "regalloc"}, passes)
+
+	passes, err = compiler.WithAllocator([]string{"cleanup"}, "linear")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cleanup", "linearscan"}, passes)
+
+	_, err = compiler.WithAllocator(nil, "unknown")
+	assert.EqualError(t, err, "unknown register allocator: unknown")
+}
+
 func compileX86(path string, t *testing.T) string {
+	return emitX86(compileIR(path, t), t)
+}
+
+func compileIR(path string, t *testing.T) *ir.File {
 	src, err := os.ReadFile(path)
 	require.NoError(t, err)
 
@@ -314,7 +1147,19 @@ func compileX86(path string, t *testing.T) string {
 
 	irFile, err := ir.Parse(validatedAST, false)
 	require.NoError(t, err)
+	require.NoError(t, ir.Verify(irFile.(*ir.File)))
+
+	return irFile.(*ir.File)
+}
+
+// optimize runs the -O2 IR passes.
+func optimize(irFile *ir.File, t *testing.T) {
+	pm, err := compiler.NewPassManager(compiler.OptLevels[2], nil, false, io.Discard, false, true)
+	require.NoError(t, err)
+	require.NoError(t, pm.RunIR(irFile))
+}
 
+func emitX86(irFile *ir.File, t *testing.T) string {
 	assem, err := assembly.Parse(irFile, false)
 	require.NoError(t, err)
 	assem = assembly.Fix(assem.(*assembly.File), false)
@@ -0,0 +1,33 @@
+package compiler
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Assemble assembles the x86 assembly at path into an object file at
+// outputPath, using the system C compiler.
+func Assemble(path string, outputPath string) error {
+	return run("cc", "-c", path, "-o", outputPath)
+}
+
+// Link links the object files at paths into an executable at outputPath,
+// using the system C compiler. Symbols declared extern in one object file
+// are resolved against the global symbols of the others.
+func Link(paths []string, outputPath string) error {
+	args := append([]string{"-o", outputPath}, paths...)
+	return run("cc", args...)
+}
+
+func run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return fmt.Errorf("%s: %w: %s", name, err, msg)
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package compiler
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/andydunstall/minc/pkg/assembly"
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/analysis"
+	"github.com/andydunstall/minc/pkg/ir/opt"
+	"github.com/andydunstall/minc/pkg/ir/ssa"
+	"github.com/andydunstall/minc/pkg/print"
+)
+
+// Pass is a transform of the program's IR or assembly.
+type Pass struct {
+	// Name identifies the pass in --passes.
+	Name string
+
+	// Description is a one line summary of the pass.
+	Description string
+
+	// Requires contains the analyses an IR pass uses, which it gets from
+	// the cache passed to RunIR. Using any other analysis panics.
+	Requires []analysis.Kind
+
+	// Invalidates contains the analyses that are no longer valid once the
+	// pass changes the program, which the pass manager drops from the
+	// cache.
+	Invalidates []analysis.Kind
+
+	// Exactly one of RunIR and RunAssembly is set. Each returns whether
+	// the pass changed the program.
+	//
+	// Assembly passes run on the assembly from assembly.Parse, before it's
+	// fixed.
+	RunIR       func(file *ir.File, analyses *analysis.Cache) bool
+	RunAssembly func(file *assembly.File) bool
+}
+
+var passes = make(map[string]*Pass)
+
+// RegisterPass adds the pass so it can be looked up by name.
+func RegisterPass(pass *Pass) {
+	if _, ok := passes[pass.Name]; ok {
+		panic("pass already registered: " + pass.Name)
+	}
+	if (pass.RunIR == nil) == (pass.RunAssembly == nil) {
+		panic("pass must run on either ir or assembly: " + pass.Name)
+	}
+	passes[pass.Name] = pass
+}
+
+// LookupPass returns the pass with the name, or nil if there is no such
+// pass.
+func LookupPass(name string) *Pass {
+	return passes[name]
+}
+
+// Passes returns the registered passes, sorted by name.
+func Passes() []*Pass {
+	var sorted []*Pass
+	for _, pass := range passes {
+		sorted = append(sorted, pass)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// OptLevels contains the passes run at each optimization level, -O0 to
+// -O2.
+var OptLevels = [][]string{
+	// -O0 doesn't optimize, so the output follows the source closely.
+	{},
+	// -O1 makes cheap local improvements.
+	{"cleanup", "reduce", "linearscan"},
+	// -O2 also inlines, removes redundant and loop-invariant
+	// computations, and eliminates tail calls.
+	{"inline", "cleanup", "gvn", "licm", "cleanup", "tailcall", "cleanup", "reduce", "regalloc"},
+}
+
+// Allocators maps the names of the register allocators to their passes.
+var Allocators = map[string]string{
+	"graph":  "regalloc",
+	"linear": "linearscan",
+}
+
+// WithAllocator returns the passes with the register allocator replaced by
+// the allocator with the name, or added at the end if there isn't one.
+func WithAllocator(passes []string, name string) ([]string, error) {
+	allocator, ok := Allocators[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown register allocator: %s", name)
+	}
+	allocators := slices.Collect(maps.Values(Allocators))
+	var updated []string
+	found := false
+	for _, pass := range passes {
+		if slices.Contains(allocators, pass) {
+			pass = allocator
+			found = true
+		}
+		updated = append(updated, pass)
+	}
+	if !found {
+		updated = append(updated, allocator)
+	}
+	return updated, nil
+}
+
+// PassManager runs a sequence of passes over a program.
+//
+// The IR passes share a cache of the analyses of each function, so a pass
+// reuses the analyses computed by an earlier pass unless a pass in between
+// changed the program and invalidated them. With debug, the manager reports
+// whether each pass changed the program, and so which analyses it
+// invalidated, to help order pipelines.
+//
+// With verify, the manager verifies the IR before the passes run and after
+// each pass, and checks the analyses still in the cache match the program,
+// so a pass that breaks the IR or doesn't invalidate an analysis it changed
+// fails immediately.
+type PassManager struct {
+	passes []*Pass
+
+	// dumpAfter contains the names of the passes to output the program
+	// after.
+	dumpAfter map[string]bool
+	// dumpSSA is whether to output the IR in SSA form after the IR passes.
+	dumpSSA bool
+
+	out    io.Writer
+	debug  bool
+	verify bool
+}
+
+// NewPassManager returns a pass manager that runs the named passes in
+// order.
+//
+// The program is written to out after each pass named in dumpAfter, in the
+// format of the ir or assemble stage. With dumpSSA, the IR is also written
+// in SSA form, with phi nodes, once the IR passes have run.
+func NewPassManager(names []string, dumpAfter []string, dumpSSA bool, out io.Writer, debug bool, verify bool) (*PassManager, error) {
+	m := &PassManager{
+		dumpAfter: make(map[string]bool),
+		dumpSSA:   dumpSSA,
+		out:       out,
+		debug:     debug,
+		verify:    verify,
+	}
+
+	asm := ""
+	for _, name := range names {
+		pass := LookupPass(name)
+		if pass == nil {
+			return nil, fmt.Errorf("unknown pass: %s", name)
+		}
+		// The IR passes all run before the IR is lowered to assembly.
+		if pass.RunAssembly != nil {
+			asm = name
+		} else if asm != "" {
+			return nil, fmt.Errorf("ir pass %s cannot run after assembly pass %s", name, asm)
+		}
+		m.passes = append(m.passes, pass)
+	}
+
+	for _, name := range dumpAfter {
+		if !containsPass(m.passes, name) {
+			return nil, fmt.Errorf("cannot dump after pass %s as it doesn't run", name)
+		}
+		m.dumpAfter[name] = true
+	}
+	return m, nil
+}
+
+// RunIR runs the IR passes over the file. It returns an error if the IR is
+// verified and found to be invalid, either before the passes run or after
+// one of them.
+func (m *PassManager) RunIR(file *ir.File) error {
+	if err := m.verifyIR(file, "before passes"); err != nil {
+		return err
+	}
+	analyses := analysis.NewCache()
+	for _, pass := range m.passes {
+		if pass.RunIR == nil {
+			continue
+		}
+		m.run(pass, func() bool { return runIR(pass, file, analyses) })
+		if m.dumpAfter[pass.Name] {
+			fmt.Fprintf(m.out, "# after %s\n", pass.Name)
+			fmt.Fprint(m.out, ir.Format(file))
+		}
+		if err := m.verifyIR(file, "after pass "+pass.Name); err != nil {
+			return err
+		}
+		if m.verify {
+			if err := analyses.Check(file); err != nil {
+				return fmt.Errorf("pass %s didn't invalidate analysis: %w", pass.Name, err)
+			}
+		}
+	}
+	if m.dumpSSA {
+		fmt.Fprint(m.out, "# ssa\n")
+		fmt.Fprint(m.out, ssa.FormatFile(file))
+	}
+	return nil
+}
+
+func (m *PassManager) verifyIR(file *ir.File, when string) error {
+	if !m.verify {
+		return nil
+	}
+	if err := ir.Verify(file); err != nil {
+		return fmt.Errorf("invalid ir %s: %w", when, err)
+	}
+	return nil
+}
+
+// RunAssembly runs the assembly passes over the file.
+func (m *PassManager) RunAssembly(file *assembly.File) {
+	for _, pass := range m.passes {
+		if pass.RunAssembly == nil {
+			continue
+		}
+		m.run(pass, func() bool { return pass.RunAssembly(file) })
+		if m.dumpAfter[pass.Name] {
+			fmt.Fprintf(m.out, "# after %s\n", pass.Name)
+			print.Fprint(m.out, file)
+		}
+	}
+}
+
+// runIR runs the IR pass with the analyses it requires, and drops the
+// analyses it invalidates if it changed the file.
+func runIR(pass *Pass, file *ir.File, analyses *analysis.Cache) bool {
+	changed := pass.RunIR(file, analyses.Restrict(pass.Requires))
+	if changed {
+		analyses.Invalidate(pass.Invalidates)
+	}
+	return changed
+}
+
+func (m *PassManager) run(pass *Pass, run func() bool) {
+	changed := run()
+	if !m.debug {
+		return
+	}
+
+	var invalidated []string
+	if changed {
+		for _, analysis := range pass.Invalidates {
+			invalidated = append(invalidated, string(analysis))
+		}
+	}
+	fmt.Fprintf(
+		m.out,
+		"pass %s: changed=%t invalidated=[%s]\n",
+		pass.Name, changed, strings.Join(invalidated, ", "),
+	)
+}
+
+func containsPass(passes []*Pass, name string) bool {
+	for _, pass := range passes {
+		if pass.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanup runs the passes that tidy up after other passes until they stop
+// changing the file. Each pass uses and invalidates the analyses as if it
+// were run on its own.
+func cleanup(file *ir.File, analyses *analysis.Cache) bool {
+	changed := false
+	for {
+		progress := false
+		for _, name := range []string{"fold", "copyprop", "dce"} {
+			if runIR(LookupPass(name), file, analyses) {
+				progress = true
+			}
+		}
+		if !progress {
+			return changed
+		}
+		changed = true
+	}
+}
+
+// withoutAnalyses adapts an IR pass that doesn't use the analysis cache.
+func withoutAnalyses(run func(file *ir.File) bool) func(*ir.File, *analysis.Cache) bool {
+	return func(file *ir.File, _ *analysis.Cache) bool {
+		return run(file)
+	}
+}
+
+// codeAnalyses are the analyses of the code within each function, which are
+// invalid after any change to the instructions.
+var codeAnalyses = []analysis.Kind{
+	analysis.CFG,
+	analysis.Liveness,
+	analysis.Dominators,
+	analysis.Loops,
+}
+
+func init() {
+	RegisterPass(&Pass{
+		Name:        "fold",
+		Description: "fold constant expressions and simplify identities",
+		Invalidates: codeAnalyses,
+		RunIR:       withoutAnalyses(opt.FoldConstants),
+	})
+	RegisterPass(&Pass{
+		Name:        "dce",
+		Description: "remove unreachable code and unused assignments",
+		Requires:    []analysis.Kind{analysis.CFG, analysis.Liveness},
+		Invalidates: codeAnalyses,
+		RunIR:       opt.EliminateDeadCode,
+	})
+	RegisterPass(&Pass{
+		Name:        "copyprop",
+		Description: "replace copied variables with their source",
+		Requires:    []analysis.Kind{analysis.CFG, analysis.Liveness},
+		// Removing a copy may leave an empty block, so the control-flow
+		// graph changes too.
+		Invalidates: codeAnalyses,
+		RunIR:       opt.PropagateCopies,
+	})
+	RegisterPass(&Pass{
+		Name:        "cleanup",
+		Description: "run fold, copyprop and dce until they stop changing the program",
+		// The passes it runs invalidate the analyses as they change the
+		// program, so the analyses left when it finishes are valid.
+		RunIR: cleanup,
+	})
+	RegisterPass(&Pass{
+		Name:        "gvn",
+		Description: "reuse the results of redundant computations",
+		Invalidates: codeAnalyses,
+		RunIR:       withoutAnalyses(opt.NumberValues),
+	})
+	RegisterPass(&Pass{
+		Name:        "licm",
+		Description: "hoist loop-invariant computations out of loops",
+		Requires:    []analysis.Kind{analysis.Loops},
+		Invalidates: codeAnalyses,
+		RunIR:       opt.HoistInvariants,
+	})
+	RegisterPass(&Pass{
+		Name:        "inline",
+		Description: "replace calls to small functions with the function body",
+		Invalidates: codeAnalyses,
+		RunIR:       withoutAnalyses(opt.InlineCalls),
+	})
+	RegisterPass(&Pass{
+		Name:        "tailcall",
+		Description: "turn returned calls into tail calls, and self tail calls into loops",
+		Invalidates: codeAnalyses,
+		RunIR:       withoutAnalyses(opt.EliminateTailCalls),
+	})
+	RegisterPass(&Pass{
+		Name:        "reduce",
+		Description: "replace multiplication and division by constants with cheaper instructions",
+		RunAssembly: assembly.ReduceStrength,
+	})
+	RegisterPass(&Pass{
+		Name:        "regalloc",
+		Description: "keep variables in registers rather than on the stack",
+		RunAssembly: assembly.AllocateRegisters,
+	})
+	RegisterPass(&Pass{
+		Name:        "linearscan",
+		Description: "keep variables in registers, allocated quickly with a linear scan",
+		RunAssembly: assembly.AllocateRegistersLinear,
+	})
+}
@@ -0,0 +1,250 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andydunstall/minc/pkg/ast"
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/cfg"
+)
+
+// Warning is a likely bug in the program that doesn't stop it compiling.
+type Warning struct {
+	// Func is the name of the function containing the problem, or empty
+	// if the problem is at file scope.
+	Func string
+
+	Message string
+
+	// Flag is the name of the -W flag that controls the warning, such as
+	// "maybe-uninitialized".
+	Flag string
+}
+
+func (w Warning) String() string {
+	if w.Func == "" {
+		return fmt.Sprintf("%s [-W%s]", w.Message, w.Flag)
+	}
+	return fmt.Sprintf("%s: %s [-W%s]", w.Func, w.Message, w.Flag)
+}
+
+// Warnings contains the name of each warning's -W flag, and whether the
+// warning is enabled by default.
+var Warnings = map[string]bool{
+	"maybe-uninitialized": true,
+	"unused-variable":     true,
+	// Parameters are often unused where a function must match a type,
+	// such as to be called through a function pointer.
+	"unused-parameter": false,
+	"unused-function":  true,
+	"unused-value":     true,
+	"unused-result":    true,
+}
+
+// EnabledWarnings returns the warnings enabled by the -W flags, which are
+// applied in order to the defaults. Each flag either names a warning to
+// enable it, or names it with a 'no-' prefix to disable it. 'all' and
+// 'no-all' enable or disable every warning.
+func EnabledWarnings(flags []string) (map[string]bool, error) {
+	enabled := make(map[string]bool)
+	for name, on := range Warnings {
+		enabled[name] = on
+	}
+	for _, flag := range flags {
+		name, disable := strings.CutPrefix(flag, "no-")
+		if name == "all" {
+			for name := range enabled {
+				enabled[name] = !disable
+			}
+			continue
+		}
+		if _, ok := Warnings[name]; !ok {
+			return nil, fmt.Errorf("unknown warning: %s", flag)
+		}
+		enabled[name] = !disable
+	}
+	return enabled, nil
+}
+
+// CheckUninitialized warns about local variables that may be read before
+// they're assigned, as the value read is indeterminate.
+//
+// A variable is read before it's assigned on some path if it's live on
+// entry to the function. The file must be the IR from ir.Parse, before any
+// passes, so the variables still match the source.
+func CheckUninitialized(file *ir.File) []Warning {
+	statics := ir.StaticVars(file)
+
+	var warnings []Warning
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ir.FuncDecl)
+		if !ok {
+			continue
+		}
+
+		g := cfg.New(decl.Insts)
+		live := cfg.ComputeLiveness(g).LiveOnEntry(g)
+		params := make(map[string]bool)
+		for _, param := range decl.Params {
+			params[param.V] = true
+		}
+
+		// Report each variable once, in the order they're first read.
+		reported := make(map[string]bool)
+		for _, inst := range decl.Insts {
+			for _, use := range ir.Uses(inst) {
+				v, ok := (*use).(*ir.VarValue)
+				if !ok || !live[v.V] || params[v.V] || statics[v.V] || reported[v.V] {
+					continue
+				}
+				reported[v.V] = true
+				warnings = append(warnings, Warning{
+					Func:    decl.Name,
+					Message: fmt.Sprintf("'%s' may be used uninitialized", sourceName(v.V)),
+					Flag:    "maybe-uninitialized",
+				})
+			}
+		}
+	}
+	return warnings
+}
+
+// sourceName returns the name of the variable in the source, without the
+// suffix ast.Validate adds to make each variable's name unique.
+func sourceName(v string) string {
+	name, _, _ := strings.Cut(v, ".")
+	return name
+}
+
+// CheckUnused warns about unused local variables, parameters and static
+// functions, expression statements that have no effect, and discarded
+// results of functions with the warn_unused_result attribute.
+//
+// The file must be the validated AST, where each declaration is marked
+// with whether it's used.
+func CheckUnused(root ast.Node) []Warning {
+	file := root.(*ast.File)
+
+	// A function is static if its first declaration is, and has the
+	// attributes of all its declarations.
+	static := make(map[string]bool)
+	attrs := make(map[string]map[string]bool)
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		if _, ok := attrs[decl.Name]; !ok {
+			static[decl.Name] = decl.Storage == ast.StorageStatic
+			attrs[decl.Name] = make(map[string]bool)
+		}
+		for _, attr := range decl.Attrs {
+			attrs[decl.Name][attr] = true
+		}
+	}
+
+	c := &unusedChecker{
+		attrs: attrs,
+	}
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ast.FuncDecl)
+		if !ok || decl.Body == nil {
+			continue
+		}
+
+		if static[decl.Name] && !decl.Used {
+			c.warnings = append(c.warnings, Warning{
+				Message: fmt.Sprintf("static function '%s' defined but not used", decl.Name),
+				Flag:    "unused-function",
+			})
+		}
+
+		c.fn = decl.Name
+		for _, param := range decl.Type.Params {
+			if !param.Used {
+				c.warnf("unused-parameter", "unused parameter '%s'", sourceName(param.Name))
+			}
+		}
+		c.checkStmt(decl.Body)
+	}
+	return c.warnings
+}
+
+type unusedChecker struct {
+	// attrs contains the attributes of each function.
+	attrs map[string]map[string]bool
+
+	// fn is the name of the function being checked.
+	fn       string
+	warnings []Warning
+}
+
+func (c *unusedChecker) checkStmt(stmt ast.Stmt) {
+	switch stmt := stmt.(type) {
+	case *ast.DeclStmt:
+		// Extern declarations refer to global variables, which may be
+		// used elsewhere.
+		if decl, ok := stmt.Decl.(*ast.VarDecl); ok && decl.Storage != ast.StorageExtern && !decl.Used {
+			c.warnf("unused-variable", "unused variable '%s'", sourceName(decl.Name))
+		}
+	case *ast.ExprStmt:
+		if !hasEffect(stmt.E) {
+			c.warnf("unused-value", "statement has no effect")
+			break
+		}
+		if call, ok := stripCasts(stmt.E).(*ast.CallExpr); ok {
+			if f, ok := call.Func.(*ast.VarExpr); ok && c.attrs[f.Name]["warn_unused_result"] {
+				c.warnf("unused-result", "ignoring result of '%s', declared with attribute warn_unused_result", f.Name)
+			}
+		}
+	case *ast.IfStmt:
+		c.checkStmt(stmt.Then)
+		if stmt.Else != nil {
+			c.checkStmt(stmt.Else)
+		}
+	case *ast.LoopStmt:
+		c.checkStmt(stmt.Body)
+	case *ast.BlockStmt:
+		for _, s := range stmt.List {
+			c.checkStmt(s)
+		}
+	}
+}
+
+func (c *unusedChecker) warnf(flag string, format string, a ...any) {
+	c.warnings = append(c.warnings, Warning{
+		Func:    c.fn,
+		Message: fmt.Sprintf(format, a...),
+		Flag:    flag,
+	})
+}
+
+// hasEffect returns whether evaluating the expression does anything other
+// than compute its value, by assigning a variable or calling a function.
+func hasEffect(expr ast.Expr) bool {
+	switch expr := expr.(type) {
+	case *ast.AssignExpr, *ast.CallExpr:
+		return true
+	case *ast.UnaryExpr:
+		return hasEffect(expr.Expr)
+	case *ast.BinaryExpr:
+		return hasEffect(expr.L) || hasEffect(expr.R)
+	case *ast.CastExpr:
+		return hasEffect(expr.Expr)
+	default:
+		return false
+	}
+}
+
+// stripCasts returns the expression without any casts, including the
+// implicit conversions the type checker added.
+func stripCasts(expr ast.Expr) ast.Expr {
+	for {
+		cast, ok := expr.(*ast.CastExpr)
+		if !ok {
+			return expr
+		}
+		expr = cast.Expr
+	}
+}
@@ -0,0 +1,238 @@
+// Package analysis caches the analyses of IR functions, such as their
+// control-flow graphs, so passes can share them rather than each pass
+// computing them again.
+package analysis
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/cfg"
+	"github.com/andydunstall/minc/pkg/ir/ssa"
+)
+
+// Kind identifies an analysis of a function.
+type Kind string
+
+const (
+	// CFG is the control-flow graph of the function (see cfg.New).
+	CFG Kind = "cfg"
+
+	// Liveness is the variables live on entry to and exit from each block
+	// of the control-flow graph (see cfg.ComputeLiveness).
+	Liveness Kind = "liveness"
+
+	// Dominators is the dominator tree of the control-flow graph (see
+	// ssa.Dominators).
+	Dominators Kind = "dominators"
+
+	// Loops is the natural loops in the control-flow graph (see
+	// ssa.FindLoops).
+	Loops Kind = "loops"
+)
+
+// dependents maps each analysis to the analyses computed from it, which are
+// invalid once it is.
+var dependents = map[Kind][]Kind{
+	CFG:        {Liveness, Dominators},
+	Dominators: {Loops},
+}
+
+// Cache computes the analyses of each function when they're first used,
+// and keeps them until they're invalidated.
+//
+// The analyses describe the function as it was when they were computed, so
+// once a pass changes a function it must not use them again, and the
+// analyses the change invalidates must be dropped with Invalidate before the
+// next pass runs.
+type Cache struct {
+	cfgs       map[*ir.FuncDecl]*cfg.Graph
+	liveness   map[*ir.FuncDecl]*cfg.Liveness
+	dominators map[*ir.FuncDecl]*ssa.DomTree
+	loops      map[*ir.FuncDecl][]*ssa.Loop
+
+	// restricted is whether only the analyses in allowed may be used.
+	restricted bool
+	allowed    []Kind
+}
+
+// NewCache returns an empty cache.
+func NewCache() *Cache {
+	return &Cache{
+		cfgs:       make(map[*ir.FuncDecl]*cfg.Graph),
+		liveness:   make(map[*ir.FuncDecl]*cfg.Liveness),
+		dominators: make(map[*ir.FuncDecl]*ssa.DomTree),
+		loops:      make(map[*ir.FuncDecl][]*ssa.Loop),
+	}
+}
+
+// CFG returns the control-flow graph of the function.
+func (c *Cache) CFG(decl *ir.FuncDecl) *cfg.Graph {
+	c.use(CFG)
+	return c.cfg(decl)
+}
+
+// Liveness returns the liveness of the variables in the function's
+// control-flow graph.
+func (c *Cache) Liveness(decl *ir.FuncDecl) *cfg.Liveness {
+	c.use(Liveness)
+	l, ok := c.liveness[decl]
+	if !ok {
+		l = cfg.ComputeLiveness(c.cfg(decl))
+		c.liveness[decl] = l
+	}
+	return l
+}
+
+// Dominators returns the dominator tree of the function's control-flow
+// graph.
+func (c *Cache) Dominators(decl *ir.FuncDecl) *ssa.DomTree {
+	c.use(Dominators)
+	return c.dom(decl)
+}
+
+// Loops returns the natural loops in the function's control-flow graph.
+func (c *Cache) Loops(decl *ir.FuncDecl) []*ssa.Loop {
+	c.use(Loops)
+	loops, ok := c.loops[decl]
+	if !ok {
+		loops = ssa.FindLoops(c.cfg(decl), c.dom(decl))
+		c.loops[decl] = loops
+	}
+	return loops
+}
+
+// Restrict returns a view of the cache that only allows the listed
+// analyses to be used, so a pass that uses an analysis it doesn't declare
+// panics. The view shares the cached analyses.
+func (c *Cache) Restrict(kinds []Kind) *Cache {
+	view := *c
+	view.restricted = true
+	view.allowed = kinds
+	return &view
+}
+
+// Invalidate drops the analyses of every function, along with the analyses
+// computed from them.
+func (c *Cache) Invalidate(kinds []Kind) {
+	for _, kind := range kinds {
+		switch kind {
+		case CFG:
+			clear(c.cfgs)
+		case Liveness:
+			clear(c.liveness)
+		case Dominators:
+			clear(c.dominators)
+		case Loops:
+			clear(c.loops)
+		default:
+			panic("unknown analysis: " + string(kind))
+		}
+		c.Invalidate(dependents[kind])
+	}
+}
+
+// Check returns an error if a cached analysis of a function in the file no
+// longer matches the function, which means a pass changed the function
+// without invalidating the analysis.
+func (c *Cache) Check(file *ir.File) error {
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ir.FuncDecl)
+		if !ok {
+			continue
+		}
+
+		g, ok := c.cfgs[decl]
+		if !ok {
+			continue
+		}
+		if !sameGraph(g, cfg.New(decl.Insts)) {
+			return fmt.Errorf("%s: stale %s", decl.Name, CFG)
+		}
+		if l, ok := c.liveness[decl]; ok {
+			fresh := cfg.ComputeLiveness(g)
+			if !sameSets(l.In, fresh.In) || !sameSets(l.Out, fresh.Out) {
+				return fmt.Errorf("%s: stale %s", decl.Name, Liveness)
+			}
+		}
+		if dom, ok := c.dominators[decl]; ok {
+			if !maps.Equal(dom.IDom, ssa.Dominators(g).IDom) {
+				return fmt.Errorf("%s: stale %s", decl.Name, Dominators)
+			}
+			if loops, ok := c.loops[decl]; ok && !sameLoops(loops, ssa.FindLoops(g, dom)) {
+				return fmt.Errorf("%s: stale %s", decl.Name, Loops)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Cache) use(kind Kind) {
+	if c.restricted && !slices.Contains(c.allowed, kind) {
+		panic(fmt.Sprintf("analysis %s used without being required", kind))
+	}
+}
+
+func (c *Cache) cfg(decl *ir.FuncDecl) *cfg.Graph {
+	g, ok := c.cfgs[decl]
+	if !ok {
+		g = cfg.New(decl.Insts)
+		c.cfgs[decl] = g
+	}
+	return g
+}
+
+func (c *Cache) dom(decl *ir.FuncDecl) *ssa.DomTree {
+	dom, ok := c.dominators[decl]
+	if !ok {
+		dom = ssa.Dominators(c.cfg(decl))
+		c.dominators[decl] = dom
+	}
+	return dom
+}
+
+// sameGraph returns whether the graphs have the same blocks, with the same
+// instructions and edges.
+func sameGraph(g1, g2 *cfg.Graph) bool {
+	if len(g1.Blocks) != len(g2.Blocks) {
+		return false
+	}
+	index := func(g *cfg.Graph) map[*cfg.Block]int {
+		index := map[*cfg.Block]int{g.Entry: -1, g.Exit: -2}
+		for i, b := range g.Blocks {
+			index[b] = i
+		}
+		return index
+	}
+	index1 := index(g1)
+	index2 := index(g2)
+
+	blocks1 := append([]*cfg.Block{g1.Entry}, g1.Blocks...)
+	blocks2 := append([]*cfg.Block{g2.Entry}, g2.Blocks...)
+	for i := range blocks1 {
+		b1, b2 := blocks1[i], blocks2[i]
+		if !slices.Equal(b1.Insts, b2.Insts) || len(b1.Succs) != len(b2.Succs) {
+			return false
+		}
+		for j := range b1.Succs {
+			if index1[b1.Succs[j]] != index2[b2.Succs[j]] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func sameSets(s1, s2 map[*cfg.Block]map[string]bool) bool {
+	return maps.EqualFunc(s1, s2, func(v1, v2 map[string]bool) bool {
+		return maps.Equal(v1, v2)
+	})
+}
+
+func sameLoops(loops1, loops2 []*ssa.Loop) bool {
+	return slices.EqualFunc(loops1, loops2, func(l1, l2 *ssa.Loop) bool {
+		return l1.Header == l2.Header && maps.Equal(l1.Blocks, l2.Blocks)
+	})
+}
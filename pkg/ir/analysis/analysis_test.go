@@ -0,0 +1,52 @@
+package analysis_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/analysis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	file, err := ir.ParseText([]byte(`global fn f(i32 n) {
+loop.1:
+	n = sub i32 n, 1
+	jnz i32 n, loop.1
+	ret i32 n
+}
+`), false)
+	require.NoError(t, err)
+	decl := file.Decls[0].(*ir.FuncDecl)
+
+	analyses := analysis.NewCache()
+	assert.Len(t, analyses.Loops(decl), 1)
+	assert.Same(t, analyses.CFG(decl), analyses.CFG(decl))
+	require.NoError(t, analyses.Check(file))
+
+	// Removing the back edge without invalidating the analyses leaves them
+	// stale.
+	decl.Insts = append(decl.Insts[:2], decl.Insts[3:]...)
+	assert.EqualError(t, analyses.Check(file), "f: stale cfg")
+
+	// Invalidating the CFG drops the analyses computed from it.
+	analyses.Invalidate([]analysis.Kind{analysis.CFG})
+	require.NoError(t, analyses.Check(file))
+	assert.Empty(t, analyses.Loops(decl))
+}
+
+func TestCacheRestrict(t *testing.T) {
+	file, err := ir.ParseText([]byte(`global fn f() {
+	ret i32 0
+}
+`), false)
+	require.NoError(t, err)
+	decl := file.Decls[0].(*ir.FuncDecl)
+
+	analyses := analysis.NewCache().Restrict([]analysis.Kind{analysis.CFG})
+	assert.NotNil(t, analyses.CFG(decl))
+	assert.PanicsWithValue(t, "analysis loops used without being required", func() {
+		analyses.Loops(decl)
+	})
+}
@@ -0,0 +1,284 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/andydunstall/minc/pkg/token"
+)
+
+// Builder builds an IR file programmatically, such as to compile another
+// language to minc's IR, which can then be optimized and compiled like IR
+// from ir.Parse.
+//
+// Instructions are added to the end of the current function, which is the
+// function most recently started with Func. Temporaries and labels are
+// named as ir.Parse names them, so are unique within the file. For example:
+//
+//	b := ir.NewBuilder()
+//	n := b.Var("n", ir.Int32)
+//	b.Func("fact", true, n)
+//	recurse := b.NewLabel("recurse")
+//	b.JumpIfNotZero(n, recurse)
+//	b.Ret(b.Const(1, ir.Int32))
+//	b.Block(recurse)
+//	sub := b.Sub(n, b.Const(1, ir.Int32))
+//	b.Ret(b.Mul(n, b.Call("fact", ir.Int32, sub)))
+//	file, err := b.File()
+//
+// The builder panics if an instruction is invalid, such as if its operands
+// have different types.
+type Builder struct {
+	names
+
+	decls   []Decl
+	statics []Decl
+	fn      *FuncDecl
+}
+
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// File returns the file built, or an error if it isn't valid (see Verify).
+func (b *Builder) File() (*File, error) {
+	file := &File{
+		Decls: append(append([]Decl(nil), b.decls...), b.statics...),
+	}
+	if err := Verify(file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Func starts a function, with external linkage if global is set.
+func (b *Builder) Func(name string, global bool, params ...*VarValue) *FuncDecl {
+	b.fn = &FuncDecl{
+		Name:   name,
+		Global: global,
+		Params: params,
+	}
+	b.decls = append(b.decls, b.fn)
+	return b.fn
+}
+
+// Static adds a variable with static storage duration, with external
+// linkage if global is set, and returns the variable.
+func (b *Builder) Static(name string, t Type, init int64, global bool) *VarValue {
+	b.statics = append(b.statics, &StaticVarDecl{
+		Name:   name,
+		Global: global,
+		Type:   t,
+		Init:   NewConst(init, t),
+	})
+	return b.Var(name, t)
+}
+
+// Var returns the named variable, such as a parameter. Unless declared
+// with Local, a variable must be assigned on every path before it's read.
+func (b *Builder) Var(name string, t Type) *VarValue {
+	return &VarValue{
+		V:    name,
+		Type: t,
+	}
+}
+
+// Local declares a local variable of the current function, which may be
+// read before it's assigned as in C, and returns the variable.
+func (b *Builder) Local(name string, t Type) *VarValue {
+	if b.fn == nil {
+		panic("local outside function")
+	}
+	v := b.Var(name, t)
+	b.fn.Locals = append(b.fn.Locals, v)
+	return v
+}
+
+// Temp returns a new temporary.
+func (b *Builder) Temp(t Type) *VarValue {
+	return &VarValue{
+		V:    b.nextVar(),
+		Type: t,
+	}
+}
+
+// Const returns a constant of type t with the value v converted to t.
+func (b *Builder) Const(v int64, t Type) *ConstValue {
+	return NewConst(v, t)
+}
+
+// NewLabel returns a new label name starting with name, to start a block
+// with Block.
+func (b *Builder) NewLabel(name string) string {
+	return b.nextLabel(name)
+}
+
+// Block starts a block with the label, which jumps may target.
+func (b *Builder) Block(label string) {
+	b.add(&LabelInst{
+		Name: label,
+	})
+}
+
+// Unary adds an instruction computing the unary operation (token.SUB,
+// token.TILDE or token.NOT) and returns its result.
+func (b *Builder) Unary(op token.Token, v Value) *VarValue {
+	if _, ok := unaryOps[op]; !ok {
+		panic("unsupported unary op: " + op.String())
+	}
+	t := TypeOf(v)
+	if op == token.NOT {
+		t = Int32
+	}
+	dest := b.Temp(t)
+	b.add(&UnaryInst{
+		Op:   op,
+		Src:  v,
+		Dest: dest,
+	})
+	return dest
+}
+
+// Neg adds an instruction computing -v and returns its result.
+func (b *Builder) Neg(v Value) *VarValue {
+	return b.Unary(token.SUB, v)
+}
+
+// Binary adds an instruction computing the binary operation and returns
+// its result. The operands must have the same type. Comparisons result in
+// an Int32 of 0 or 1, and arithmetic in the operands' type.
+func (b *Builder) Binary(op token.Token, v1, v2 Value) *VarValue {
+	if _, ok := binaryOps[op]; !ok {
+		panic("unsupported binary op: " + op.String())
+	}
+	t1, t2 := TypeOf(v1), TypeOf(v2)
+	if t1 != t2 {
+		panic(fmt.Sprintf("operand types differ: %s and %s", t1, t2))
+	}
+	t := t1
+	switch op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		t = Int32
+	}
+	dest := b.Temp(t)
+	b.add(&BinaryInst{
+		Op:   op,
+		V1:   v1,
+		V2:   v2,
+		Dest: dest,
+	})
+	return dest
+}
+
+func (b *Builder) Add(v1, v2 Value) *VarValue { return b.Binary(token.ADD, v1, v2) }
+func (b *Builder) Sub(v1, v2 Value) *VarValue { return b.Binary(token.SUB, v1, v2) }
+func (b *Builder) Mul(v1, v2 Value) *VarValue { return b.Binary(token.MUL, v1, v2) }
+func (b *Builder) Div(v1, v2 Value) *VarValue { return b.Binary(token.QUO, v1, v2) }
+func (b *Builder) Rem(v1, v2 Value) *VarValue { return b.Binary(token.REM, v1, v2) }
+
+func (b *Builder) Eq(v1, v2 Value) *VarValue { return b.Binary(token.EQL, v1, v2) }
+func (b *Builder) Ne(v1, v2 Value) *VarValue { return b.Binary(token.NEQ, v1, v2) }
+func (b *Builder) Lt(v1, v2 Value) *VarValue { return b.Binary(token.LSS, v1, v2) }
+func (b *Builder) Le(v1, v2 Value) *VarValue { return b.Binary(token.LEQ, v1, v2) }
+func (b *Builder) Gt(v1, v2 Value) *VarValue { return b.Binary(token.GTR, v1, v2) }
+func (b *Builder) Ge(v1, v2 Value) *VarValue { return b.Binary(token.GEQ, v1, v2) }
+
+// Convert returns v converted to type t, adding a sign extension, zero
+// extension or truncation if needed.
+func (b *Builder) Convert(v Value, t Type) Value {
+	if TypeOf(v) == t {
+		return v
+	}
+	dest := b.Temp(t)
+	b.add(conversion(v, dest))
+	return dest
+}
+
+// Copy adds an instruction assigning v to the variable, which must have
+// the same type.
+func (b *Builder) Copy(dest *VarValue, v Value) {
+	if TypeOf(v) != dest.Type {
+		panic(fmt.Sprintf("cannot copy %s to %s", TypeOf(v), dest.Type))
+	}
+	b.add(&CopyInst{
+		L: v,
+		R: dest,
+	})
+}
+
+// Jump adds an unconditional jump to the label.
+func (b *Builder) Jump(label string) {
+	b.add(&JumpInst{
+		Label: label,
+	})
+}
+
+// JumpIfZero adds a jump to the label taken if v is zero.
+func (b *Builder) JumpIfZero(v Value, label string) {
+	b.add(&JumpIfZeroInst{
+		V:     v,
+		Label: label,
+	})
+}
+
+// JumpIfNotZero adds a jump to the label taken if v isn't zero.
+func (b *Builder) JumpIfNotZero(v Value, label string) {
+	b.add(&JumpIfNotZeroInst{
+		V:     v,
+		Label: label,
+	})
+}
+
+// Branch adds a jump to the then label if cond isn't zero, or the els
+// label otherwise.
+func (b *Builder) Branch(cond Value, then string, els string) {
+	b.JumpIfNotZero(cond, then)
+	b.Jump(els)
+}
+
+// Call adds a call to the named function and returns its result.
+func (b *Builder) Call(name string, result Type, args ...Value) *VarValue {
+	dest := b.Temp(result)
+	b.add(&CallInst{
+		Name: name,
+		Args: args,
+		Dest: dest,
+	})
+	return dest
+}
+
+// CallIndirect adds a call to the function whose address is f (see
+// Address) and returns its result.
+func (b *Builder) CallIndirect(f Value, result Type, args ...Value) *VarValue {
+	dest := b.Temp(result)
+	b.add(&CallIndirectInst{
+		Func: f,
+		Args: args,
+		Dest: dest,
+	})
+	return dest
+}
+
+// Address adds an instruction computing the address of the named function
+// and returns it.
+func (b *Builder) Address(name string) *VarValue {
+	dest := b.Temp(Uint64)
+	b.add(&GetAddressInst{
+		Name: name,
+		Dest: dest,
+	})
+	return dest
+}
+
+// Ret adds a return of v.
+func (b *Builder) Ret(v Value) {
+	b.add(&RetInst{
+		Value: v,
+	})
+}
+
+func (b *Builder) add(inst Inst) {
+	if b.fn == nil {
+		panic("instruction outside function")
+	}
+	b.fn.Insts = append(b.fn.Insts, inst)
+}
@@ -0,0 +1,113 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilder(t *testing.T) {
+	b := ir.NewBuilder()
+	total := b.Static("total", ir.Int64, 0, false)
+
+	// fact returns n!, and adds it to total.
+	n := b.Var("n", ir.Int32)
+	b.Func("fact", true, n)
+	recurse := b.NewLabel("recurse")
+	b.JumpIfNotZero(n, recurse)
+	b.Ret(b.Const(1, ir.Int32))
+	b.Block(recurse)
+	result := b.Mul(n, b.Call("fact", ir.Int32, b.Sub(n, b.Const(1, ir.Int32))))
+	b.Copy(total, b.Add(total, b.Convert(result, ir.Int64)))
+	b.Ret(result)
+
+	b.Func("main", true)
+	then := b.NewLabel("then")
+	els := b.NewLabel("else")
+	f := b.Address("fact")
+	b.Branch(b.Lt(b.CallIndirect(f, ir.Int32, b.Const(5, ir.Int32)), b.Const(200, ir.Int32)), then, els)
+	b.Block(then)
+	b.Ret(b.Convert(total, ir.Int32))
+	b.Block(els)
+	b.Ret(b.Neg(b.Const(1, ir.Int32)))
+
+	file, err := b.File()
+	require.NoError(t, err)
+	assert.Equal(t, `global fn fact(i32 n) {
+	jnz i32 n, recurse.0
+	ret i32 1
+recurse.0:
+	tmp.1 = sub i32 n, 1
+	tmp.2 = call i32 fact(i32 tmp.1)
+	tmp.3 = mul i32 n, tmp.2
+	tmp.4 = sext i32 tmp.3 to i64
+	tmp.5 = add i64 total, tmp.4
+	total = copy i64 tmp.5
+	ret i32 tmp.3
+}
+
+global fn main() {
+	tmp.8 = addr fact
+	tmp.9 = call i32 *tmp.8(i32 5)
+	tmp.10 = lt i32 tmp.9, 200
+	jnz i32 tmp.10, then.6
+	jmp else.7
+then.6:
+	tmp.11 = trunc i64 total to i32
+	ret i32 tmp.11
+else.7:
+	tmp.12 = neg i32 1
+	ret i32 tmp.12
+}
+
+var i64 total = 0
+`, ir.Format(file))
+
+	// 5! + 4! + 3! + 2! + 1!
+	v, err := ir.Interpret(file, "main", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(153), v)
+}
+
+func TestBuilderErrors(t *testing.T) {
+	b := ir.NewBuilder()
+	assert.PanicsWithValue(t, "instruction outside function", func() {
+		b.Ret(b.Const(0, ir.Int32))
+	})
+	assert.PanicsWithValue(t, "local outside function", func() {
+		b.Local("x", ir.Int32)
+	})
+
+	b.Func("main", true)
+	assert.PanicsWithValue(t, "operand types differ: i32 and i64", func() {
+		b.Add(b.Const(1, ir.Int32), b.Const(1, ir.Int64))
+	})
+
+	// The function doesn't return.
+	b.Add(b.Const(1, ir.Int32), b.Const(2, ir.Int32))
+	_, err := b.File()
+	assert.EqualError(t, err, `main: "tmp.0 = add i32 1, 2": function doesn't end in a return, jump or tail call`)
+}
+
+func TestBuilderLocals(t *testing.T) {
+	b := ir.NewBuilder()
+	b.Func("main", true)
+	b.Ret(b.Local("x", ir.Int32))
+	file, err := b.File()
+	require.NoError(t, err)
+	assert.Equal(t, `global fn main() {
+	local i32 x
+	ret i32 x
+}
+`, ir.Format(file))
+
+	// Any other variable must be assigned before it's read, whatever its
+	// name.
+	b = ir.NewBuilder()
+	b.Func("main", true)
+	b.Ret(b.Var("x", ir.Int32))
+	_, err = b.File()
+	assert.EqualError(t, err, `main: "ret i32 x": x is never defined`)
+}
@@ -0,0 +1,387 @@
+// Package cfg splits IR functions into a control-flow graph of basic
+// blocks.
+package cfg
+
+import (
+	"fmt"
+
+	"github.com/andydunstall/minc/pkg/ir"
+)
+
+// Block is a basic block: a sequence of instructions that is only entered
+// at the start and only left at the end.
+type Block struct {
+	// ID is the index of the block in the graph's block list when the
+	// graph was built. The entry and exit blocks have IDs -1 and -2.
+	ID int
+
+	// Insts contains the block's instructions, including the LabelInst
+	// that starts the block (if any) and the jump or return that ends it
+	// (if any).
+	Insts []ir.Inst
+
+	Preds []*Block
+	Succs []*Block
+}
+
+// Label returns the name of the label that starts the block, or an empty
+// string if the block has no label.
+func (b *Block) Label() string {
+	if len(b.Insts) == 0 {
+		return ""
+	}
+	if label, ok := b.Insts[0].(*ir.LabelInst); ok {
+		return label.Name
+	}
+	return ""
+}
+
+// Terminator returns the jump or return that ends the block, or nil if the
+// block falls through to the next block.
+func (b *Block) Terminator() ir.Inst {
+	if len(b.Insts) == 0 {
+		return nil
+	}
+	if inst := b.Insts[len(b.Insts)-1]; ir.IsTerminator(inst) {
+		return inst
+	}
+	return nil
+}
+
+// Graph is the control-flow graph of a function.
+type Graph struct {
+	// Entry and Exit are empty blocks before the first block and after
+	// every return.
+	Entry *Block
+	Exit  *Block
+
+	// Blocks contains the function's blocks in their original order,
+	// excluding Entry and Exit.
+	Blocks []*Block
+}
+
+// New builds the control-flow graph of the instructions. It panics if a label
+// is defined more than once, or a jump's label isn't defined.
+func New(insts []ir.Inst) *Graph {
+	g := &Graph{
+		Entry: &Block{ID: -1},
+		Exit:  &Block{ID: -2},
+	}
+
+	// Start a new block at each label and after each jump or return.
+	var current *Block
+	for _, inst := range insts {
+		if _, ok := inst.(*ir.LabelInst); ok || current == nil {
+			current = &Block{
+				ID: len(g.Blocks),
+			}
+			g.Blocks = append(g.Blocks, current)
+		}
+		current.Insts = append(current.Insts, inst)
+		if current.Terminator() != nil {
+			current = nil
+		}
+	}
+
+	labels := make(map[string]*Block)
+	for _, b := range g.Blocks {
+		if label := b.Label(); label != "" {
+			if _, ok := labels[label]; ok {
+				panic("duplicate label: " + label)
+			}
+			labels[label] = b
+		}
+	}
+	target := func(label string) *Block {
+		b, ok := labels[label]
+		if !ok {
+			panic("undefined label: " + label)
+		}
+		return b
+	}
+
+	if len(g.Blocks) == 0 {
+		addEdge(g.Entry, g.Exit)
+		return g
+	}
+	addEdge(g.Entry, g.Blocks[0])
+
+	for i, b := range g.Blocks {
+		next := g.Exit
+		if i+1 < len(g.Blocks) {
+			next = g.Blocks[i+1]
+		}
+
+		switch inst := b.Terminator().(type) {
+		case *ir.RetInst, *ir.TailCallInst:
+			addEdge(b, g.Exit)
+		case *ir.JumpInst:
+			addEdge(b, target(inst.Label))
+		case *ir.JumpIfZeroInst:
+			addEdge(b, target(inst.Label))
+			addEdge(b, next)
+		case *ir.JumpIfNotZeroInst:
+			addEdge(b, target(inst.Label))
+			addEdge(b, next)
+		default:
+			addEdge(b, next)
+		}
+	}
+
+	return g
+}
+
+// ReversePostOrder returns the blocks reachable from the entry block in
+// reverse post-order, so each block comes before its successors (except
+// along back edges). Entry and Exit are excluded.
+func (g *Graph) ReversePostOrder() []*Block {
+	post := g.PostOrder()
+	rpo := make([]*Block, 0, len(post))
+	for i := len(post) - 1; i >= 0; i-- {
+		rpo = append(rpo, post[i])
+	}
+	return rpo
+}
+
+// PostOrder returns the blocks reachable from the entry block in
+// post-order, so each block comes after its successors (except along back
+// edges). Entry and Exit are excluded.
+func (g *Graph) PostOrder() []*Block {
+	var order []*Block
+	visited := make(map[*Block]bool)
+
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		visited[b] = true
+		for _, succ := range b.Succs {
+			if !visited[succ] {
+				visit(succ)
+			}
+		}
+		if b != g.Entry && b != g.Exit {
+			order = append(order, b)
+		}
+	}
+	visit(g.Entry)
+
+	return order
+}
+
+// Reachable returns the set of blocks reachable from the entry block.
+func (g *Graph) Reachable() map[*Block]bool {
+	reachable := make(map[*Block]bool)
+	for _, b := range g.PostOrder() {
+		reachable[b] = true
+	}
+	return reachable
+}
+
+// RemoveBlock removes the block and its edges from the graph.
+func (g *Graph) RemoveBlock(b *Block) {
+	for _, pred := range b.Preds {
+		pred.Succs = removeBlock(pred.Succs, b)
+	}
+	for _, succ := range b.Succs {
+		succ.Preds = removeBlock(succ.Preds, b)
+	}
+	b.Preds = nil
+	b.Succs = nil
+	g.Blocks = removeBlock(g.Blocks, b)
+}
+
+// SplitEdge inserts a new empty block on the edge between from and to, and
+// returns the new block. This gives somewhere to add instructions that only
+// run when taking that edge.
+//
+// If from jumps to to, the jump is changed to target the new block, which is
+// given a label.
+func (g *Graph) SplitEdge(from, to *Block) *Block {
+	id := g.nextID()
+	b := &Block{
+		ID: id,
+	}
+
+	if fallthroughSucc(from) == to {
+		// Keep the new block directly after from so from still falls
+		// through to it.
+		var blocks []*Block
+		for _, block := range g.Blocks {
+			blocks = append(blocks, block)
+			if block == from {
+				blocks = append(blocks, b)
+			}
+		}
+		g.Blocks = blocks
+	} else {
+		label := fmt.Sprintf("block.%d", id)
+		b.Insts = []ir.Inst{&ir.LabelInst{
+			Name: label,
+		}}
+		switch inst := from.Terminator().(type) {
+		case *ir.JumpInst:
+			inst.Label = label
+		case *ir.JumpIfZeroInst:
+			inst.Label = label
+		case *ir.JumpIfNotZeroInst:
+			inst.Label = label
+		}
+		g.Blocks = append(g.Blocks, b)
+	}
+
+	replaceBlock(from.Succs, to, b)
+	replaceBlock(to.Preds, from, b)
+	b.Preds = []*Block{from}
+	b.Succs = []*Block{to}
+	return b
+}
+
+// MergeEdges inserts a new empty block that the edges from each block in
+// froms to to lead to instead, and returns the new block. The new block
+// takes the place of the first of froms in to's predecessors, and the others
+// are removed.
+//
+// Jumps to to from froms are changed to target the new block, which is
+// given a label.
+func (g *Graph) MergeEdges(froms []*Block, to *Block) *Block {
+	id := g.nextID()
+	label := fmt.Sprintf("block.%d", id)
+	b := &Block{
+		ID: id,
+		Insts: []ir.Inst{&ir.LabelInst{
+			Name: label,
+		}},
+		Succs: []*Block{to},
+	}
+
+	// Keep the new block directly before to so it falls through to it.
+	// Flatten adds a jump to any other block that fell through to to.
+	var blocks []*Block
+	for _, block := range g.Blocks {
+		if block == to {
+			blocks = append(blocks, b)
+		}
+		blocks = append(blocks, block)
+	}
+	g.Blocks = blocks
+
+	for _, from := range froms {
+		if fallthroughSucc(from) != to {
+			switch inst := from.Terminator().(type) {
+			case *ir.JumpInst:
+				inst.Label = label
+			case *ir.JumpIfZeroInst:
+				inst.Label = label
+			case *ir.JumpIfNotZeroInst:
+				inst.Label = label
+			}
+		}
+		replaceBlock(from.Succs, to, b)
+		b.Preds = append(b.Preds, from)
+	}
+
+	replaceBlock(to.Preds, froms[0], b)
+	for _, from := range froms[1:] {
+		to.Preds = removeBlock(to.Preds, from)
+	}
+	return b
+}
+
+// nextID returns an ID that no block in the graph has.
+func (g *Graph) nextID() int {
+	id := 0
+	for _, b := range g.Blocks {
+		if b.ID >= id {
+			id = b.ID + 1
+		}
+	}
+	return id
+}
+
+// Flatten returns the instructions of the blocks in order.
+//
+// Where a block falls through to a successor that is no longer the next
+// block, such as after blocks are reordered, a jump to the successor is
+// added.
+func (g *Graph) Flatten() []ir.Inst {
+	var insts []ir.Inst
+	for i, b := range g.Blocks {
+		insts = append(insts, b.Insts...)
+
+		next := fallthroughSucc(b)
+		if next == nil || next == g.Exit {
+			continue
+		}
+		if i+1 < len(g.Blocks) && g.Blocks[i+1] == next {
+			continue
+		}
+
+		label := next.Label()
+		if label == "" {
+			label = fmt.Sprintf("block.%d", next.ID)
+			next.Insts = append([]ir.Inst{&ir.LabelInst{
+				Name: label,
+			}}, next.Insts...)
+		}
+		insts = append(insts, &ir.JumpInst{
+			Label: label,
+		})
+	}
+	return insts
+}
+
+// fallthroughSucc returns the successor the block falls through to, or
+// nil if the block always jumps or returns.
+func fallthroughSucc(b *Block) *Block {
+	switch inst := b.Terminator().(type) {
+	case *ir.RetInst, *ir.TailCallInst, *ir.JumpInst:
+		return nil
+	case *ir.JumpIfZeroInst:
+		return otherSucc(b, inst.Label)
+	case *ir.JumpIfNotZeroInst:
+		return otherSucc(b, inst.Label)
+	default:
+		if len(b.Succs) == 0 {
+			return nil
+		}
+		return b.Succs[0]
+	}
+}
+
+// otherSucc returns the successor of a conditional jump that isn't the
+// jump's target.
+func otherSucc(b *Block, label string) *Block {
+	for _, succ := range b.Succs {
+		if succ.Label() != label {
+			return succ
+		}
+	}
+	// Both edges lead to the target.
+	if len(b.Succs) > 0 {
+		return b.Succs[0]
+	}
+	return nil
+}
+
+func addEdge(from, to *Block) {
+	from.Succs = append(from.Succs, to)
+	to.Preds = append(to.Preds, from)
+}
+
+func replaceBlock(blocks []*Block, old, new *Block) {
+	for i, b := range blocks {
+		if b == old {
+			blocks[i] = new
+			return
+		}
+	}
+}
+
+func removeBlock(blocks []*Block, b *Block) []*Block {
+	var updated []*Block
+	for _, block := range blocks {
+		if block != b {
+			updated = append(updated, block)
+		}
+	}
+	return updated
+}
@@ -0,0 +1,146 @@
+package cfg_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const loopIR = `global fn main(i32 n) {
+	i = copy i32 0
+continue.loop.1:
+	tmp.1 = lt i32 i, n
+	jz i32 tmp.1, break.loop.1
+	i = add i32 i, 1
+	jmp continue.loop.1
+break.loop.1:
+	ret i32 i
+}
+`
+
+func TestNew(t *testing.T) {
+	g := cfg.New(parseFunc(t, loopIR).Insts)
+
+	require.Len(t, g.Blocks, 4)
+	entry, header, body, exit := g.Blocks[0], g.Blocks[1], g.Blocks[2], g.Blocks[3]
+
+	assert.Equal(t, "", entry.Label())
+	assert.Equal(t, "continue.loop.1", header.Label())
+	assert.Equal(t, "break.loop.1", exit.Label())
+
+	assert.Equal(t, []*cfg.Block{g.Entry}, entry.Preds)
+	assert.Equal(t, []*cfg.Block{header}, entry.Succs)
+	assert.Equal(t, []*cfg.Block{entry, body}, header.Preds)
+	assert.Equal(t, []*cfg.Block{exit, body}, header.Succs)
+	assert.Equal(t, []*cfg.Block{header}, body.Succs)
+	assert.Equal(t, []*cfg.Block{g.Exit}, exit.Succs)
+
+	assert.Equal(t, []*cfg.Block{entry, header, body, exit}, g.ReversePostOrder())
+}
+
+func TestNewDuplicateLabel(t *testing.T) {
+	fn := parseFunc(t, `global fn main() {
+loop.1:
+	jmp loop.1
+loop.1:
+	ret i32 0
+}
+`)
+	assert.PanicsWithValue(t, "duplicate label: loop.1", func() {
+		cfg.New(fn.Insts)
+	})
+}
+
+func TestFlatten(t *testing.T) {
+	fn := parseFunc(t, loopIR)
+	g := cfg.New(fn.Insts)
+	assert.Equal(t, fn.Insts, g.Flatten())
+}
+
+func TestRemoveBlock(t *testing.T) {
+	g := cfg.New(parseFunc(t, `global fn main() {
+	x = copy i32 1
+	jmp end
+dead:
+	x = copy i32 2
+end:
+	ret i32 x
+}
+`).Insts)
+	require.Len(t, g.Blocks, 3)
+	first, dead, end := g.Blocks[0], g.Blocks[1], g.Blocks[2]
+
+	assert.False(t, g.Reachable()[dead])
+
+	g.RemoveBlock(dead)
+	assert.Equal(t, []*cfg.Block{first, end}, g.Blocks)
+	assert.Equal(t, []*cfg.Block{first}, end.Preds)
+}
+
+func TestFlattenAddsJump(t *testing.T) {
+	g := cfg.New(parseFunc(t, `global fn main() {
+	x = copy i32 1
+end:
+	ret i32 x
+}
+`).Insts)
+
+	// After reordering, the first block no longer falls through to its
+	// successor so needs a jump.
+	g.Blocks[0], g.Blocks[1] = g.Blocks[1], g.Blocks[0]
+	assert.Equal(t, `global fn main() {
+end:
+	ret i32 x
+	x = copy i32 1
+	jmp end
+}
+`, ir.Format(&ir.FuncDecl{
+		Name:   "main",
+		Global: true,
+		Insts:  g.Flatten(),
+	}))
+}
+
+func parseFunc(t *testing.T, src string) *ir.FuncDecl {
+	file, err := ir.ParseText([]byte(src), false)
+	require.NoError(t, err)
+	return file.Decls[0].(*ir.FuncDecl)
+}
+
+func TestComputeLiveness(t *testing.T) {
+	g := cfg.New(parseFunc(t, loopIR).Insts)
+	l := cfg.ComputeLiveness(g)
+	entry, header, body, exit := g.Blocks[0], g.Blocks[1], g.Blocks[2], g.Blocks[3]
+
+	assert.Equal(t, map[string]bool{"n": true}, l.In[entry])
+	assert.Equal(t, map[string]bool{"i": true, "n": true}, l.In[header])
+	assert.Equal(t, map[string]bool{"i": true, "n": true}, l.Out[body])
+	assert.Equal(t, map[string]bool{"i": true}, l.In[exit])
+	assert.Empty(t, l.Out[exit])
+	assert.Equal(t, map[string]bool{"n": true}, l.LiveOnEntry(g))
+
+	// The header is the label, comparison and conditional jump.
+	assert.Equal(t, []map[string]bool{
+		{"i": true, "n": true},
+		{"i": true, "n": true, "tmp.1": true},
+		{"i": true, "n": true},
+	}, l.LiveAfter(header))
+}
+
+func TestLiveOnEntry(t *testing.T) {
+	// x is only assigned on one path, so may be read before it's assigned.
+	g := cfg.New(parseFunc(t, `global fn main(i32 n) {
+	jz i32 n, end.1
+	x = copy i32 1
+	y = copy i32 2
+end.1:
+	y = copy i32 3
+	tmp.1 = add i32 x, y
+	ret i32 tmp.1
+}
+`).Insts)
+	assert.Equal(t, map[string]bool{"n": true, "x": true}, cfg.ComputeLiveness(g).LiveOnEntry(g))
+}
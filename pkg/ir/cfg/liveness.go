@@ -0,0 +1,103 @@
+package cfg
+
+import (
+	"github.com/andydunstall/minc/pkg/ir"
+)
+
+// Liveness contains the variables live on entry to and exit from each
+// block. A variable is live if its current value may be read later.
+type Liveness struct {
+	In  map[*Block]map[string]bool
+	Out map[*Block]map[string]bool
+}
+
+// ComputeLiveness computes the live variables of each block, by iterating
+// the backwards dataflow equations until they reach a fixed point.
+//
+// Only variables are tracked, so callers must treat static variables, which
+// may be read by other functions, as always live.
+func ComputeLiveness(g *Graph) *Liveness {
+	l := &Liveness{
+		In:  make(map[*Block]map[string]bool),
+		Out: make(map[*Block]map[string]bool),
+	}
+	blocks := append([]*Block{g.Entry, g.Exit}, g.Blocks...)
+	for _, b := range blocks {
+		l.In[b] = make(map[string]bool)
+		l.Out[b] = make(map[string]bool)
+	}
+
+	// Visiting blocks in post-order visits successors first, so the
+	// equations converge in few iterations.
+	order := g.PostOrder()
+	for changed := true; changed; {
+		changed = false
+		for _, b := range order {
+			out := make(map[string]bool)
+			for _, succ := range b.Succs {
+				for v := range l.In[succ] {
+					out[v] = true
+				}
+			}
+			in := LiveBefore(b.Insts, out)
+			if len(in) != len(l.In[b]) {
+				changed = true
+			}
+			l.In[b] = in
+			l.Out[b] = out
+		}
+	}
+	return l
+}
+
+// LiveBefore returns the variables live before the instructions, given the
+// variables live after them.
+func LiveBefore(insts []ir.Inst, liveOut map[string]bool) map[string]bool {
+	live := make(map[string]bool)
+	for v := range liveOut {
+		live[v] = true
+	}
+	for i := len(insts) - 1; i >= 0; i-- {
+		Transfer(insts[i], live)
+	}
+	return live
+}
+
+// Transfer updates the live variables after inst to those live before it.
+func Transfer(inst ir.Inst, live map[string]bool) {
+	if def := ir.Def(inst); def != nil {
+		delete(live, def.V)
+	}
+	for _, use := range ir.Uses(inst) {
+		if v, ok := (*use).(*ir.VarValue); ok {
+			live[v.V] = true
+		}
+	}
+}
+
+// LiveAfter returns the variables live after each instruction in the block,
+// such as to find assignments that are never read, or the variables that
+// interfere with each instruction's result.
+func (l *Liveness) LiveAfter(b *Block) []map[string]bool {
+	after := make([]map[string]bool, len(b.Insts))
+	live := make(map[string]bool)
+	for v := range l.Out[b] {
+		live[v] = true
+	}
+	for i := len(b.Insts) - 1; i >= 0; i-- {
+		after[i] = make(map[string]bool)
+		for v := range live {
+			after[i][v] = true
+		}
+		Transfer(b.Insts[i], live)
+	}
+	return after
+}
+
+// LiveOnEntry returns the variables live on entry to the function: those
+// that may be read on some path before they're assigned.
+func (l *Liveness) LiveOnEntry(g *Graph) map[string]bool {
+	// The entry block is empty, and falls through to the first block (or
+	// the exit block if the function is empty).
+	return l.In[g.Entry.Succs[0]]
+}
@@ -0,0 +1,95 @@
+package ir
+
+import (
+	"slices"
+)
+
+// Clone returns a copy of the file, so a pass can change the copy without
+// changing the file.
+//
+// Passes replace values rather than modifying them, so the copy shares its
+// values with the file.
+func Clone(file *File) *File {
+	clone := &File{}
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *FuncDecl:
+			clone.Decls = append(clone.Decls, CloneFuncDecl(decl))
+		case *StaticVarDecl:
+			c := *decl
+			clone.Decls = append(clone.Decls, &c)
+		default:
+			panic("unsupported decl type")
+		}
+	}
+	return clone
+}
+
+// CloneFuncDecl returns a copy of the function declaration and its
+// instructions.
+func CloneFuncDecl(decl *FuncDecl) *FuncDecl {
+	clone := *decl
+	clone.Params = slices.Clone(decl.Params)
+	clone.Locals = slices.Clone(decl.Locals)
+	clone.Insts = nil
+	for _, inst := range decl.Insts {
+		clone.Insts = append(clone.Insts, CloneInst(inst))
+	}
+	return &clone
+}
+
+// CloneInst returns a copy of the instruction.
+func CloneInst(inst Inst) Inst {
+	switch inst := inst.(type) {
+	case *RetInst:
+		c := *inst
+		return &c
+	case *UnaryInst:
+		c := *inst
+		return &c
+	case *BinaryInst:
+		c := *inst
+		return &c
+	case *SignExtendInst:
+		c := *inst
+		return &c
+	case *ZeroExtendInst:
+		c := *inst
+		return &c
+	case *TruncateInst:
+		c := *inst
+		return &c
+	case *CopyInst:
+		c := *inst
+		return &c
+	case *JumpInst:
+		c := *inst
+		return &c
+	case *JumpIfZeroInst:
+		c := *inst
+		return &c
+	case *JumpIfNotZeroInst:
+		c := *inst
+		return &c
+	case *CallInst:
+		c := *inst
+		c.Args = slices.Clone(inst.Args)
+		return &c
+	case *TailCallInst:
+		c := *inst
+		c.Args = slices.Clone(inst.Args)
+		return &c
+	case *CallIndirectInst:
+		c := *inst
+		c.Args = slices.Clone(inst.Args)
+		return &c
+	case *GetAddressInst:
+		c := *inst
+		return &c
+	case *LabelInst:
+		c := *inst
+		return &c
+	default:
+		panic("unsupported inst type")
+	}
+}
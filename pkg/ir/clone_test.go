@@ -0,0 +1,38 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClone(t *testing.T) {
+	text := `global fn main(i32 n) {
+	local i32 x
+	tmp.1 = call i32 f(i32 n)
+	x = add i32 tmp.1, 1
+	jz i32 x, end.1
+	tailcall f(i32 x)
+end.1:
+	ret i32 0
+}
+
+var i32 count = 0
+`
+	file, err := ir.ParseText([]byte(text), false)
+	require.NoError(t, err)
+
+	clone := ir.Clone(file)
+	assert.Equal(t, text, ir.Format(clone))
+
+	// Changing the copy leaves the file unchanged.
+	decl := clone.Decls[0].(*ir.FuncDecl)
+	decl.Insts[0].(*ir.CallInst).Args[0] = &ir.ConstValue{V: "1", Type: ir.Int32}
+	decl.Insts[3].(*ir.TailCallInst).Args[0] = &ir.ConstValue{V: "2", Type: ir.Int32}
+	decl.Insts[2].(*ir.JumpIfZeroInst).Label = "loop.1"
+	decl.Locals = append(decl.Locals[:0], &ir.VarValue{V: "y", Type: ir.Int64})
+	clone.Decls[1].(*ir.StaticVarDecl).Init = nil
+	assert.Equal(t, text, ir.Format(file))
+}
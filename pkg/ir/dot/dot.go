@@ -0,0 +1,163 @@
+// Package dot writes IR control-flow and call graphs in the Graphviz dot
+// language, so they can be rendered with:
+//
+//	$ dot -Tsvg graph.dot -o graph.svg
+package dot
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/cfg"
+)
+
+// WriteCFG writes the control-flow graph of each function in the file,
+// with each function in its own cluster.
+//
+// Each basic block is a node listing its instructions. The edges of a
+// conditional jump are labelled with whether the condition is true or
+// false, where jz and jnz jump on a condition of zero (false) and non-zero
+// (true).
+func WriteCFG(w io.Writer, file *ir.File) {
+	fmt.Fprintln(w, "digraph cfg {")
+	fmt.Fprintln(w, "\tnode [shape=box, fontname=monospace];")
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ir.FuncDecl)
+		if !ok {
+			continue
+		}
+		writeFunc(w, decl)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func writeFunc(w io.Writer, decl *ir.FuncDecl) {
+	g := cfg.New(decl.Insts)
+
+	id := func(b *cfg.Block) string {
+		switch b {
+		case g.Entry:
+			return quote(decl.Name + ".entry")
+		case g.Exit:
+			return quote(decl.Name + ".exit")
+		default:
+			return quote(fmt.Sprintf("%s.%d", decl.Name, b.ID))
+		}
+	}
+
+	fmt.Fprintf(w, "\tsubgraph %s {\n", quote("cluster_"+decl.Name))
+	fmt.Fprintf(w, "\t\tlabel=%s;\n", quote(decl.Name))
+	fmt.Fprintf(w, "\t\t%s [label=\"entry\", shape=oval];\n", id(g.Entry))
+	fmt.Fprintf(w, "\t\t%s [label=\"exit\", shape=oval];\n", id(g.Exit))
+	for _, b := range g.Blocks {
+		// Left justify each instruction with \l.
+		var label strings.Builder
+		for _, inst := range b.Insts {
+			label.WriteString(escape(ir.Format(inst)) + `\l`)
+		}
+		fmt.Fprintf(w, "\t\t%s [label=\"%s\"];\n", id(b), label.String())
+	}
+
+	blocks := append([]*cfg.Block{g.Entry}, g.Blocks...)
+	for _, b := range blocks {
+		for _, succ := range b.Succs {
+			if label := edgeLabel(b, succ); label != "" {
+				fmt.Fprintf(w, "\t\t%s -> %s [label=%s];\n", id(b), id(succ), quote(label))
+			} else {
+				fmt.Fprintf(w, "\t\t%s -> %s;\n", id(b), id(succ))
+			}
+		}
+	}
+	fmt.Fprintln(w, "\t}")
+}
+
+// edgeLabel returns "true" or "false" for the edges of a conditional jump,
+// or an empty string for other edges.
+func edgeLabel(from, to *cfg.Block) string {
+	var label string
+	var jumpIf bool
+	switch inst := from.Terminator().(type) {
+	case *ir.JumpIfZeroInst:
+		label, jumpIf = inst.Label, false
+	case *ir.JumpIfNotZeroInst:
+		label, jumpIf = inst.Label, true
+	default:
+		return ""
+	}
+
+	// If the target is also the next block, both edges are the same
+	// edge, which is taken whatever the condition.
+	if len(from.Succs) == 2 && from.Succs[0] == from.Succs[1] {
+		return ""
+	}
+	if to.Label() == label {
+		return fmt.Sprint(jumpIf)
+	}
+	return fmt.Sprint(!jumpIf)
+}
+
+// WriteCallGraph writes the functions in the file, with an edge from each
+// function to each function it calls. Functions that are declared in
+// another file are dashed, as are edges where every call is a tail call.
+//
+// Calls through function pointers aren't included, since the function
+// called isn't known.
+func WriteCallGraph(w io.Writer, file *ir.File) {
+	defined := make(map[string]bool)
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ir.FuncDecl); ok {
+			defined[decl.Name] = true
+		}
+	}
+
+	fmt.Fprintln(w, "digraph calls {")
+	fmt.Fprintln(w, "\tnode [shape=box, fontname=monospace];")
+
+	external := make(map[string]bool)
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ir.FuncDecl)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "\t%s;\n", quote(decl.Name))
+		for _, callee := range ir.Callees(decl) {
+			if !defined[callee] && !external[callee] {
+				external[callee] = true
+				fmt.Fprintf(w, "\t%s [style=dashed];\n", quote(callee))
+			}
+		}
+	}
+
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ir.FuncDecl)
+		if !ok {
+			continue
+		}
+		// Only dash the edge if every call to the function is a tail
+		// call.
+		calls := make(map[string]bool)
+		for _, inst := range decl.Insts {
+			if inst, ok := inst.(*ir.CallInst); ok {
+				calls[inst.Name] = true
+			}
+		}
+		for _, callee := range ir.Callees(decl) {
+			if !calls[callee] {
+				fmt.Fprintf(w, "\t%s -> %s [style=dashed];\n", quote(decl.Name), quote(callee))
+			} else {
+				fmt.Fprintf(w, "\t%s -> %s;\n", quote(decl.Name), quote(callee))
+			}
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+func quote(s string) string {
+	return `"` + escape(s) + `"`
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
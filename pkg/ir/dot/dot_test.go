@@ -0,0 +1,128 @@
+package dot_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/dot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const src = `global fn main(i32 n) {
+	jz i32 n, else.1
+	tmp.1 = call i32 f(i32 n)
+	ret i32 tmp.1
+else.1:
+	tailcall g(i32 n)
+}
+
+global fn f(i32 n) {
+	jnz i32 n, end.2
+	n = copy i32 1
+end.2:
+	tailcall f(i32 n)
+}
+`
+
+func TestWriteCFG(t *testing.T) {
+	file, err := ir.ParseText([]byte(src), false)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	dot.WriteCFG(&buf, file)
+	assert.Equal(t, `digraph cfg {
+	node [shape=box, fontname=monospace];
+	subgraph "cluster_main" {
+		label="main";
+		"main.entry" [label="entry", shape=oval];
+		"main.exit" [label="exit", shape=oval];
+		"main.0" [label="jz i32 n, else.1\l"];
+		"main.1" [label="tmp.1 = call i32 f(i32 n)\lret i32 tmp.1\l"];
+		"main.2" [label="else.1:\ltailcall g(i32 n)\l"];
+		"main.entry" -> "main.0";
+		"main.0" -> "main.2" [label="false"];
+		"main.0" -> "main.1" [label="true"];
+		"main.1" -> "main.exit";
+		"main.2" -> "main.exit";
+	}
+	subgraph "cluster_f" {
+		label="f";
+		"f.entry" [label="entry", shape=oval];
+		"f.exit" [label="exit", shape=oval];
+		"f.0" [label="jnz i32 n, end.2\l"];
+		"f.1" [label="n = copy i32 1\l"];
+		"f.2" [label="end.2:\ltailcall f(i32 n)\l"];
+		"f.entry" -> "f.0";
+		"f.0" -> "f.2" [label="true"];
+		"f.0" -> "f.1" [label="false"];
+		"f.1" -> "f.2";
+		"f.2" -> "f.exit";
+	}
+}
+`, buf.String())
+}
+
+func TestWriteCFGSequentialLoops(t *testing.T) {
+	file, err := ir.ParseText([]byte(`global fn main(i32 n) {
+continue.loop.1:
+	jz i32 n, break.loop.1
+	jmp continue.loop.1
+break.loop.1:
+continue.loop.2:
+	jz i32 n, break.loop.2
+	jmp continue.loop.2
+break.loop.2:
+	ret i32 n
+}
+`), false)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	dot.WriteCFG(&buf, file)
+	// Each loop jumps back to its own header.
+	assert.Equal(t, `digraph cfg {
+	node [shape=box, fontname=monospace];
+	subgraph "cluster_main" {
+		label="main";
+		"main.entry" [label="entry", shape=oval];
+		"main.exit" [label="exit", shape=oval];
+		"main.0" [label="continue.loop.1:\ljz i32 n, break.loop.1\l"];
+		"main.1" [label="jmp continue.loop.1\l"];
+		"main.2" [label="break.loop.1:\l"];
+		"main.3" [label="continue.loop.2:\ljz i32 n, break.loop.2\l"];
+		"main.4" [label="jmp continue.loop.2\l"];
+		"main.5" [label="break.loop.2:\lret i32 n\l"];
+		"main.entry" -> "main.0";
+		"main.0" -> "main.2" [label="false"];
+		"main.0" -> "main.1" [label="true"];
+		"main.1" -> "main.0";
+		"main.2" -> "main.3";
+		"main.3" -> "main.5" [label="false"];
+		"main.3" -> "main.4" [label="true"];
+		"main.4" -> "main.3";
+		"main.5" -> "main.exit";
+	}
+}
+`, buf.String())
+}
+
+func TestWriteCallGraph(t *testing.T) {
+	file, err := ir.ParseText([]byte(src), false)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	dot.WriteCallGraph(&buf, file)
+	// g isn't defined in the file, and is only tail called.
+	assert.Equal(t, `digraph calls {
+	node [shape=box, fontname=monospace];
+	"main";
+	"g" [style=dashed];
+	"f";
+	"main" -> "f";
+	"main" -> "g" [style=dashed];
+	"f" -> "f" [style=dashed];
+}
+`, buf.String())
+}
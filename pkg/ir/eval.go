@@ -0,0 +1,173 @@
+package ir
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/andydunstall/minc/pkg/token"
+)
+
+var (
+	ErrDivisionByZero = errors.New("division by zero")
+	// ErrDivisionOverflow is returned when dividing the minimum signed
+	// value by -1, which traps on x86.
+	ErrDivisionOverflow = errors.New("division overflow")
+)
+
+// Values are evaluated as int64s, holding the value converted to the
+// value's type. So signed values are sign extended and unsigned values zero
+// extended, except Uint64 which holds the two's complement bit pattern.
+
+// ConstInt returns the value of the constant.
+func ConstInt(c *ConstValue) int64 {
+	v, err := strconv.ParseInt(c.V, 10, 64)
+	if err != nil {
+		u, err := strconv.ParseUint(c.V, 10, 64)
+		if err != nil {
+			panic("invalid constant: " + c.V)
+		}
+		v = int64(u)
+	}
+	return Wrap(v, c.Type)
+}
+
+// NewConst returns a constant of type t with the value v converted to t.
+func NewConst(v int64, t Type) *ConstValue {
+	v = Wrap(v, t)
+	s := strconv.FormatInt(v, 10)
+	if !t.Signed() {
+		s = strconv.FormatUint(uint64(v), 10)
+	}
+	return &ConstValue{
+		V:    s,
+		Type: t,
+	}
+}
+
+// Wrap converts the value to type t, discarding any bits that don't fit.
+func Wrap(v int64, t Type) int64 {
+	switch t {
+	case Int8:
+		return int64(int8(v))
+	case Uint8:
+		return int64(uint8(v))
+	case Int32:
+		return int64(int32(v))
+	case Uint32:
+		return int64(uint32(v))
+	default:
+		return v
+	}
+}
+
+// EvalUnary returns the result of the unary operator. The result must be
+// wrapped to the destination type.
+func EvalUnary(op token.Token, v int64) int64 {
+	switch op {
+	case token.SUB:
+		return -v
+	case token.TILDE:
+		return ^v
+	case token.NOT:
+		return boolValue(v == 0)
+	default:
+		panic("unsupported unary operator: " + op.String())
+	}
+}
+
+// EvalBinary returns the result of the binary operator on operands of type
+// t. The result must be wrapped to the destination type.
+func EvalBinary(op token.Token, t Type, v1, v2 int64) (int64, error) {
+	signed := t.Signed()
+	switch op {
+	case token.ADD:
+		return v1 + v2, nil
+	case token.SUB:
+		return v1 - v2, nil
+	case token.MUL:
+		return v1 * v2, nil
+	case token.QUO, token.REM:
+		if v2 == 0 {
+			return 0, ErrDivisionByZero
+		}
+		if !signed {
+			// Smaller unsigned values are zero extended so are positive.
+			if op == token.QUO {
+				return int64(uint64(v1) / uint64(v2)), nil
+			}
+			return int64(uint64(v1) % uint64(v2)), nil
+		}
+		if v2 == -1 && v1 != 0 && Wrap(-v1, t) == v1 {
+			return 0, ErrDivisionOverflow
+		}
+		if op == token.QUO {
+			return v1 / v2, nil
+		}
+		return v1 % v2, nil
+	case token.EQL:
+		return boolValue(v1 == v2), nil
+	case token.NEQ:
+		return boolValue(v1 != v2), nil
+	case token.LSS:
+		return boolValue(signed && v1 < v2 || !signed && uint64(v1) < uint64(v2)), nil
+	case token.LEQ:
+		return boolValue(signed && v1 <= v2 || !signed && uint64(v1) <= uint64(v2)), nil
+	case token.GTR:
+		return boolValue(signed && v1 > v2 || !signed && uint64(v1) > uint64(v2)), nil
+	case token.GEQ:
+		return boolValue(signed && v1 >= v2 || !signed && uint64(v1) >= uint64(v2)), nil
+	default:
+		panic("unsupported binary operator: " + op.String())
+	}
+}
+
+// EvalConversion returns the result of a SignExtendInst, ZeroExtendInst or
+// TruncateInst on the value. The result must be wrapped to the destination
+// type.
+func EvalConversion(inst Inst, v int64) int64 {
+	switch inst := inst.(type) {
+	case *SignExtendInst:
+		return Wrap(v, signedType(TypeOf(inst.Src)))
+	case *ZeroExtendInst:
+		return Wrap(v, unsignedType(TypeOf(inst.Src)))
+	case *TruncateInst:
+		return v
+	default:
+		panic("unsupported inst type")
+	}
+}
+
+// signedType returns the signed type with the same size as t.
+func signedType(t Type) Type {
+	switch t {
+	case Uint8:
+		return Int8
+	case Uint32:
+		return Int32
+	case Uint64:
+		return Int64
+	default:
+		return t
+	}
+}
+
+// unsignedType returns the unsigned type with the same size as t.
+func unsignedType(t Type) Type {
+	switch t {
+	case Int8:
+		return Uint8
+	case Int32:
+		return Uint32
+	case Int64:
+		return Uint64
+	default:
+		return t
+	}
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,188 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andydunstall/minc/pkg/token"
+)
+
+// Format returns the textual form of the IR node, which ParseText parses
+// back into the same IR.
+//
+// Each instruction is written on its own line, with the type of its
+// operands after the operation name. Where the destination has a different
+// type to the operands, the destination type follows 'to'. The variables
+// declared in a function's source are listed with 'local' before its
+// instructions. For example:
+//
+//	global fn main(i32 x.1) {
+//		local i32 y.2
+//		y.2 = add i32 x.1, 5
+//		tmp.3 = lt i32 y.2, 10
+//		tmp.4 = sext i32 tmp.3 to i64
+//		ret i64 tmp.4
+//	}
+//
+//	global var i32 count = 0
+func Format(n Node) string {
+	switch n := n.(type) {
+	case *File:
+		var decls []string
+		for _, decl := range n.Decls {
+			decls = append(decls, Format(decl))
+		}
+		return strings.Join(decls, "\n")
+	case *FuncDecl:
+		return formatFuncDecl(n)
+	case *StaticVarDecl:
+		return formatStaticVarDecl(n)
+	case Inst:
+		return formatInst(n)
+	case Value:
+		return formatValue(n)
+	default:
+		panic("unsupported node type")
+	}
+}
+
+func formatFuncDecl(decl *FuncDecl) string {
+	var params []string
+	for _, param := range decl.Params {
+		params = append(params, param.Type.String()+" "+param.V)
+	}
+
+	var s string
+	if decl.Global {
+		s += "global "
+	}
+	switch decl.Inline {
+	case InlineAlways:
+		s += "@inline "
+	case InlineNever:
+		s += "@noinline "
+	}
+	s += fmt.Sprintf("fn %s(%s) {\n", decl.Name, strings.Join(params, ", "))
+	for _, local := range decl.Locals {
+		s += fmt.Sprintf("\tlocal %s %s\n", local.Type, local.V)
+	}
+	for _, inst := range decl.Insts {
+		if _, ok := inst.(*LabelInst); ok {
+			s += formatInst(inst) + "\n"
+			continue
+		}
+		s += "\t" + formatInst(inst) + "\n"
+	}
+	s += "}\n"
+	return s
+}
+
+func formatStaticVarDecl(decl *StaticVarDecl) string {
+	var s string
+	if decl.Global {
+		s += "global "
+	}
+	s += fmt.Sprintf("var %s %s", decl.Type, decl.Name)
+	if decl.Init != nil {
+		s += " = " + decl.Init.V
+	}
+	return s + "\n"
+}
+
+func formatInst(inst Inst) string {
+	switch v := inst.(type) {
+	case *RetInst:
+		return fmt.Sprintf("ret %s %s", TypeOf(v.Value), formatValue(v.Value))
+	case *UnaryInst:
+		return formatDef(v.Dest, unaryOps[v.Op], v.Src)
+	case *BinaryInst:
+		return formatDef(v.Dest, binaryOps[v.Op], v.V1, v.V2)
+	case *SignExtendInst:
+		return formatDef(v.Dest, "sext", v.Src)
+	case *ZeroExtendInst:
+		return formatDef(v.Dest, "zext", v.Src)
+	case *TruncateInst:
+		return formatDef(v.Dest, "trunc", v.Src)
+	case *CopyInst:
+		return formatDef(v.R, "copy", v.L)
+	case *JumpInst:
+		return "jmp " + v.Label
+	case *JumpIfZeroInst:
+		return fmt.Sprintf("jz %s %s, %s", TypeOf(v.V), formatValue(v.V), v.Label)
+	case *JumpIfNotZeroInst:
+		return fmt.Sprintf("jnz %s %s, %s", TypeOf(v.V), formatValue(v.V), v.Label)
+	case *CallInst:
+		return fmt.Sprintf(
+			"%s = call %s %s(%s)",
+			formatValue(v.Dest), TypeOf(v.Dest), v.Name, formatArgs(v.Args),
+		)
+	case *TailCallInst:
+		return fmt.Sprintf("tailcall %s(%s)", v.Name, formatArgs(v.Args))
+	case *CallIndirectInst:
+		return fmt.Sprintf(
+			"%s = call %s *%s(%s)",
+			formatValue(v.Dest), TypeOf(v.Dest), formatValue(v.Func), formatArgs(v.Args),
+		)
+	case *GetAddressInst:
+		return fmt.Sprintf("%s = addr %s", formatValue(v.Dest), v.Name)
+	case *LabelInst:
+		return v.Name + ":"
+	default:
+		panic("unsupported inst type")
+	}
+}
+
+// formatDef formats an instruction that assigns the result of the
+// operation to dest.
+func formatDef(dest Value, op string, operands ...Value) string {
+	var values []string
+	for _, v := range operands {
+		values = append(values, formatValue(v))
+	}
+
+	t := TypeOf(operands[0])
+	s := fmt.Sprintf("%s = %s %s %s", formatValue(dest), op, t, strings.Join(values, ", "))
+	if TypeOf(dest) != t {
+		s += " to " + TypeOf(dest).String()
+	}
+	return s
+}
+
+func formatArgs(args []Value) string {
+	var s []string
+	for _, arg := range args {
+		s = append(s, TypeOf(arg).String()+" "+formatValue(arg))
+	}
+	return strings.Join(s, ", ")
+}
+
+func formatValue(v Value) string {
+	switch v := v.(type) {
+	case *ConstValue:
+		return v.V
+	case *VarValue:
+		return v.V
+	default:
+		panic("unsupported value type")
+	}
+}
+
+var unaryOps = map[token.Token]string{
+	token.SUB:   "neg",
+	token.TILDE: "not",
+	token.NOT:   "lnot",
+}
+
+var binaryOps = map[token.Token]string{
+	token.ADD: "add",
+	token.SUB: "sub",
+	token.MUL: "mul",
+	token.QUO: "div",
+	token.REM: "rem",
+	token.EQL: "eq",
+	token.NEQ: "ne",
+	token.LSS: "lt",
+	token.LEQ: "le",
+	token.GTR: "gt",
+	token.GEQ: "ge",
+}
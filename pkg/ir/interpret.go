@@ -0,0 +1,244 @@
+package ir
+
+import (
+	"fmt"
+)
+
+// maxCallDepth is the maximum number of nested calls before the
+// interpreter reports a stack overflow.
+const maxCallDepth = 10000
+
+// funcAddrBase is the address of the first function. Function pointers
+// are assigned fake addresses so they can be stored in variables.
+const funcAddrBase = 0x1000
+
+// Interpret executes the function named entry with the given arguments,
+// and returns its result.
+//
+// Values have the wrap-around semantics of their type, matching the x86
+// backend. Division by zero and calls to functions that aren't defined in
+// the file are reported as errors.
+func Interpret(file *File, entry string, args []int64) (int64, error) {
+	in := newInterpreter(file)
+	return in.run(entry, args)
+}
+
+type interpreter struct {
+	funcs   map[string]*FuncDecl
+	labels  map[*FuncDecl]map[string]int
+	statics map[string]int64
+	// externs contains the variables declared but not defined in the file.
+	externs map[string]bool
+
+	// addrs maps each function to its address, and names maps each address
+	// back to the function.
+	addrs map[string]int64
+	names map[int64]string
+
+	depth int
+}
+
+func newInterpreter(file *File) *interpreter {
+	in := &interpreter{
+		funcs:   make(map[string]*FuncDecl),
+		labels:  make(map[*FuncDecl]map[string]int),
+		statics: make(map[string]int64),
+		externs: make(map[string]bool),
+		addrs:   make(map[string]int64),
+		names:   make(map[int64]string),
+	}
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *FuncDecl:
+			in.funcs[decl.Name] = decl
+
+			labels := make(map[string]int)
+			for i, inst := range decl.Insts {
+				if label, ok := inst.(*LabelInst); ok {
+					labels[label.Name] = i
+				}
+			}
+			in.labels[decl] = labels
+
+			addr := funcAddrBase + int64(len(in.addrs))*16
+			in.addrs[decl.Name] = addr
+			in.names[addr] = decl.Name
+		case *StaticVarDecl:
+			// Variables without an initializer are defined in another
+			// file.
+			if decl.Init == nil {
+				in.externs[decl.Name] = true
+				continue
+			}
+			in.statics[decl.Name] = ConstInt(decl.Init)
+		}
+	}
+	return in
+}
+
+// trap is panicked to stop the program on a runtime error.
+type trap struct {
+	msg string
+}
+
+func (in *interpreter) run(entry string, args []int64) (result int64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			t, ok := r.(trap)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s", t.msg)
+		}
+	}()
+
+	return in.call(entry, args), nil
+}
+
+// tailCall is a call that replaces the current function.
+type tailCall struct {
+	name string
+	args []int64
+}
+
+func (in *interpreter) call(name string, args []int64) int64 {
+	in.depth++
+	if in.depth > maxCallDepth {
+		in.trapf("stack overflow")
+	}
+	defer func() { in.depth-- }()
+
+	for {
+		result, tail := in.exec(name, args)
+		if tail == nil {
+			return result
+		}
+		// The tail call reuses the caller's frame, so doesn't add to the
+		// call depth.
+		name, args = tail.name, tail.args
+	}
+}
+
+// exec runs the function, and returns either its result or the tail call
+// it ends with.
+func (in *interpreter) exec(name string, args []int64) (int64, *tailCall) {
+	decl, ok := in.funcs[name]
+	if !ok {
+		in.trapf("undefined function: %s", name)
+	}
+	if len(args) != len(decl.Params) {
+		in.trapf("wrong number of arguments: %s", name)
+	}
+
+	locals := make(map[string]int64)
+	for i, param := range decl.Params {
+		locals[param.V] = Wrap(args[i], param.Type)
+	}
+
+	labels := in.labels[decl]
+	for pc := 0; pc < len(decl.Insts); pc++ {
+		switch inst := decl.Insts[pc].(type) {
+		case *RetInst:
+			return in.load(locals, inst.Value), nil
+		case *TailCallInst:
+			return 0, &tailCall{
+				name: inst.Name,
+				args: in.loadArgs(locals, inst.Args),
+			}
+		case *UnaryInst:
+			in.store(locals, inst.Dest, EvalUnary(inst.Op, in.load(locals, inst.Src)))
+		case *BinaryInst:
+			v1 := in.load(locals, inst.V1)
+			v2 := in.load(locals, inst.V2)
+			v, err := EvalBinary(inst.Op, TypeOf(inst.V1), v1, v2)
+			if err != nil {
+				in.trapf("%s", err)
+			}
+			in.store(locals, inst.Dest, v)
+		case *SignExtendInst:
+			in.store(locals, inst.Dest, EvalConversion(inst, in.load(locals, inst.Src)))
+		case *ZeroExtendInst:
+			in.store(locals, inst.Dest, EvalConversion(inst, in.load(locals, inst.Src)))
+		case *TruncateInst:
+			in.store(locals, inst.Dest, EvalConversion(inst, in.load(locals, inst.Src)))
+		case *CopyInst:
+			in.store(locals, inst.R, in.load(locals, inst.L))
+		case *JumpInst:
+			pc = labels[inst.Label]
+		case *JumpIfZeroInst:
+			if in.load(locals, inst.V) == 0 {
+				pc = labels[inst.Label]
+			}
+		case *JumpIfNotZeroInst:
+			if in.load(locals, inst.V) != 0 {
+				pc = labels[inst.Label]
+			}
+		case *CallInst:
+			in.store(locals, inst.Dest, in.call(inst.Name, in.loadArgs(locals, inst.Args)))
+		case *CallIndirectInst:
+			addr := in.load(locals, inst.Func)
+			fn, ok := in.names[addr]
+			if !ok {
+				in.trapf("call to invalid function pointer: %d", addr)
+			}
+			in.store(locals, inst.Dest, in.call(fn, in.loadArgs(locals, inst.Args)))
+		case *GetAddressInst:
+			addr, ok := in.addrs[inst.Name]
+			if !ok {
+				in.trapf("undefined function: %s", inst.Name)
+			}
+			in.store(locals, inst.Dest, addr)
+		case *LabelInst:
+		default:
+			panic("unsupported inst type")
+		}
+	}
+
+	// Falling off the end of a function returns zero, as main does in C.
+	return 0, nil
+}
+
+func (in *interpreter) load(locals map[string]int64, v Value) int64 {
+	switch v := v.(type) {
+	case *ConstValue:
+		return ConstInt(v)
+	case *VarValue:
+		if value, ok := in.statics[v.V]; ok {
+			return Wrap(value, v.Type)
+		}
+		if in.externs[v.V] {
+			in.trapf("undefined variable: %s", v.V)
+		}
+		// Uninitialized locals read as zero.
+		return Wrap(locals[v.V], v.Type)
+	default:
+		panic("unsupported value type")
+	}
+}
+
+func (in *interpreter) store(locals map[string]int64, dest Value, value int64) {
+	v := dest.(*VarValue)
+	value = Wrap(value, v.Type)
+	if _, ok := in.statics[v.V]; ok {
+		in.statics[v.V] = value
+		return
+	}
+	if in.externs[v.V] {
+		in.trapf("undefined variable: %s", v.V)
+	}
+	locals[v.V] = value
+}
+
+func (in *interpreter) loadArgs(locals map[string]int64, args []Value) []int64 {
+	var values []int64
+	for _, arg := range args {
+		values = append(values, in.load(locals, arg))
+	}
+	return values
+}
+
+func (in *interpreter) trapf(format string, a ...any) {
+	panic(trap{
+		msg: fmt.Sprintf(format, a...),
+	})
+}
@@ -1,11 +1,64 @@
 package ir
 
-import "github.com/andydunstall/minc/pkg/token"
+import (
+	"fmt"
+
+	"github.com/andydunstall/minc/pkg/token"
+)
 
 type Node interface {
 	node()
 }
 
+// Type is the type of a value. Every value is an integer of a fixed size.
+type Type int
+
+const (
+	Int8 Type = iota + 1
+	Uint8
+	Int32
+	Uint32
+	Int64
+	Uint64
+)
+
+// Size returns the size of the type in bytes.
+func (t Type) Size() int {
+	switch t {
+	case Int8, Uint8:
+		return 1
+	case Int32, Uint32:
+		return 4
+	case Int64, Uint64:
+		return 8
+	default:
+		panic(fmt.Sprintf("unknown type: %d", t))
+	}
+}
+
+func (t Type) Signed() bool {
+	return t == Int8 || t == Int32 || t == Int64
+}
+
+func (t Type) String() string {
+	switch t {
+	case Int8:
+		return "i8"
+	case Uint8:
+		return "u8"
+	case Int32:
+		return "i32"
+	case Uint32:
+		return "u32"
+	case Int64:
+		return "i64"
+	case Uint64:
+		return "u64"
+	default:
+		return fmt.Sprintf("Type(%d)", int(t))
+	}
+}
+
 // Values.
 
 type Value interface {
@@ -14,19 +67,33 @@ type Value interface {
 }
 
 type ConstValue struct {
-	V string
+	V    string
+	Type Type
 }
 
 func (n *ConstValue) node()      {}
 func (n *ConstValue) valueNode() {}
 
 type VarValue struct {
-	V string
+	V    string
+	Type Type
 }
 
 func (n *VarValue) node()      {}
 func (n *VarValue) valueNode() {}
 
+// TypeOf returns the type of the value.
+func TypeOf(v Value) Type {
+	switch v := v.(type) {
+	case *ConstValue:
+		return v.Type
+	case *VarValue:
+		return v.Type
+	default:
+		panic("unsupported value type")
+	}
+}
+
 // Declarations.
 
 type Decl interface {
@@ -34,15 +101,47 @@ type Decl interface {
 	declNode()
 }
 
+// InlineHint is whether a function should be inlined, from its '@inline'
+// or '@noinline' attribute.
+type InlineHint int
+
+const (
+	// InlineDefault leaves the inliner to decide from the function's size.
+	InlineDefault InlineHint = iota
+	InlineAlways
+	InlineNever
+)
+
 type FuncDecl struct {
-	Name   string
-	Params []string
+	Name string
+	// Global is whether the function has external linkage.
+	Global bool
+	Inline InlineHint
+	Params []*VarValue
+	// Locals are the variables declared in the function's source, which
+	// may be read before they're assigned as C allows. Every other variable
+	// that isn't a parameter or static is defined before it's read.
+	Locals []*VarValue
 	Insts  []Inst
 }
 
 func (n *FuncDecl) node()     {}
 func (n *FuncDecl) declNode() {}
 
+// StaticVarDecl is a variable with static storage duration.
+type StaticVarDecl struct {
+	Name string
+	// Global is whether the variable has external linkage.
+	Global bool
+	Type   Type
+	// Init is the initial value, or nil if the variable is declared but
+	// defined in another file.
+	Init *ConstValue
+}
+
+func (n *StaticVarDecl) node()     {}
+func (n *StaticVarDecl) declNode() {}
+
 // Instructions.
 
 type Inst interface {
@@ -76,6 +175,36 @@ type BinaryInst struct {
 func (n *BinaryInst) node()     {}
 func (n *BinaryInst) instNode() {}
 
+// SignExtendInst converts Src to the larger type of Dest, preserving its
+// signed value.
+type SignExtendInst struct {
+	Src  Value
+	Dest Value
+}
+
+func (n *SignExtendInst) node()     {}
+func (n *SignExtendInst) instNode() {}
+
+// ZeroExtendInst converts Src to the larger type of Dest, preserving its
+// unsigned value.
+type ZeroExtendInst struct {
+	Src  Value
+	Dest Value
+}
+
+func (n *ZeroExtendInst) node()     {}
+func (n *ZeroExtendInst) instNode() {}
+
+// TruncateInst converts Src to the smaller type of Dest, discarding the
+// high bits.
+type TruncateInst struct {
+	Src  Value
+	Dest Value
+}
+
+func (n *TruncateInst) node()     {}
+func (n *TruncateInst) instNode() {}
+
 type CopyInst struct {
 	L Value
 	R Value
@@ -116,6 +245,36 @@ type CallInst struct {
 func (n *CallInst) node()     {}
 func (n *CallInst) instNode() {}
 
+// TailCallInst calls the named function and returns its result. As nothing
+// runs after the call, the caller's frame is removed before the call so the
+// stack doesn't grow.
+type TailCallInst struct {
+	Name string
+	Args []Value
+}
+
+func (n *TailCallInst) node()     {}
+func (n *TailCallInst) instNode() {}
+
+// CallIndirectInst calls the function pointed to by Func.
+type CallIndirectInst struct {
+	Func Value
+	Args []Value
+	Dest Value
+}
+
+func (n *CallIndirectInst) node()     {}
+func (n *CallIndirectInst) instNode() {}
+
+// GetAddressInst stores the address of the named function in Dest.
+type GetAddressInst struct {
+	Name string
+	Dest Value
+}
+
+func (n *GetAddressInst) node()     {}
+func (n *GetAddressInst) instNode() {}
+
 type LabelInst struct {
 	Name string
 }
@@ -128,3 +287,15 @@ type File struct {
 }
 
 func (n *File) node() {}
+
+// StaticVars returns the names of the file's static variables. Unlike
+// locals, static variables may be read and written by other functions.
+func StaticVars(file *File) map[string]bool {
+	statics := make(map[string]bool)
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*StaticVarDecl); ok {
+			statics[decl.Name] = true
+		}
+	}
+	return statics
+}
@@ -0,0 +1,144 @@
+package ir
+
+// Uses returns pointers to the instruction's operands, so passes can both
+// read and replace them. The destination is not included.
+func Uses(inst Inst) []*Value {
+	switch inst := inst.(type) {
+	case *RetInst:
+		return []*Value{&inst.Value}
+	case *UnaryInst:
+		return []*Value{&inst.Src}
+	case *BinaryInst:
+		return []*Value{&inst.V1, &inst.V2}
+	case *SignExtendInst:
+		return []*Value{&inst.Src}
+	case *ZeroExtendInst:
+		return []*Value{&inst.Src}
+	case *TruncateInst:
+		return []*Value{&inst.Src}
+	case *CopyInst:
+		return []*Value{&inst.L}
+	case *JumpIfZeroInst:
+		return []*Value{&inst.V}
+	case *JumpIfNotZeroInst:
+		return []*Value{&inst.V}
+	case *CallInst:
+		var uses []*Value
+		for i := range inst.Args {
+			uses = append(uses, &inst.Args[i])
+		}
+		return uses
+	case *TailCallInst:
+		var uses []*Value
+		for i := range inst.Args {
+			uses = append(uses, &inst.Args[i])
+		}
+		return uses
+	case *CallIndirectInst:
+		uses := []*Value{&inst.Func}
+		for i := range inst.Args {
+			uses = append(uses, &inst.Args[i])
+		}
+		return uses
+	case *JumpInst, *GetAddressInst, *LabelInst:
+		return nil
+	default:
+		panic("unsupported inst type")
+	}
+}
+
+// Def returns the variable the instruction assigns to, or nil if the
+// instruction doesn't assign a variable.
+func Def(inst Inst) *VarValue {
+	dest, _ := dest(inst)
+	v, _ := dest.(*VarValue)
+	return v
+}
+
+// dest returns the instruction's destination operand, which unlike Def
+// includes destinations that aren't variables.
+func dest(inst Inst) (Value, bool) {
+	switch inst := inst.(type) {
+	case *UnaryInst:
+		return inst.Dest, true
+	case *BinaryInst:
+		return inst.Dest, true
+	case *SignExtendInst:
+		return inst.Dest, true
+	case *ZeroExtendInst:
+		return inst.Dest, true
+	case *TruncateInst:
+		return inst.Dest, true
+	case *CopyInst:
+		return inst.R, true
+	case *CallInst:
+		return inst.Dest, true
+	case *CallIndirectInst:
+		return inst.Dest, true
+	case *GetAddressInst:
+		return inst.Dest, true
+	default:
+		return nil, false
+	}
+}
+
+// SetDef replaces the variable the instruction assigns to. The instruction
+// must assign a variable.
+func SetDef(inst Inst, v Value) {
+	switch inst := inst.(type) {
+	case *UnaryInst:
+		inst.Dest = v
+	case *BinaryInst:
+		inst.Dest = v
+	case *SignExtendInst:
+		inst.Dest = v
+	case *ZeroExtendInst:
+		inst.Dest = v
+	case *TruncateInst:
+		inst.Dest = v
+	case *CopyInst:
+		inst.R = v
+	case *CallInst:
+		inst.Dest = v
+	case *CallIndirectInst:
+		inst.Dest = v
+	case *GetAddressInst:
+		inst.Dest = v
+	default:
+		panic("inst has no destination")
+	}
+}
+
+// IsTerminator returns whether the instruction ends a basic block, by
+// jumping or returning.
+func IsTerminator(inst Inst) bool {
+	switch inst.(type) {
+	case *JumpInst, *JumpIfZeroInst, *JumpIfNotZeroInst, *RetInst, *TailCallInst:
+		return true
+	default:
+		return false
+	}
+}
+
+// Callees returns the names of the functions the function calls directly,
+// including tail calls, in the order they're first called.
+func Callees(decl *FuncDecl) []string {
+	var callees []string
+	seen := make(map[string]bool)
+	for _, inst := range decl.Insts {
+		var name string
+		switch inst := inst.(type) {
+		case *CallInst:
+			name = inst.Name
+		case *TailCallInst:
+			name = inst.Name
+		default:
+			continue
+		}
+		if !seen[name] {
+			seen[name] = true
+			callees = append(callees, name)
+		}
+	}
+	return callees
+}
@@ -0,0 +1,290 @@
+package opt
+
+import (
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/analysis"
+	"github.com/andydunstall/minc/pkg/ir/cfg"
+)
+
+// PropagateCopies replaces uses of a variable that was copied from another
+// value with the value itself, so the copy can be removed by
+// EliminateDeadCode.
+//
+// Which copies hold at each instruction is found with reaching copies
+// analysis: reaching definitions restricted to copies, where a copy is also
+// killed by assigning its source, and only reaches an instruction if it
+// reaches along every path.
+//
+// It also coalesces a temporary that is only used to copy a result into a
+// variable, such as 'tmp.1 = add i32 x, 1' followed by 'y = copy i32 tmp.1',
+// by assigning the result to the variable directly.
+func PropagateCopies(file *ir.File, analyses *analysis.Cache) bool {
+	statics := ir.StaticVars(file)
+	changed := false
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ir.FuncDecl); ok {
+			// Coalesce first, as propagating the temporary into later
+			// instructions would keep it live.
+			g := analyses.CFG(decl)
+			if coalesceFunc(decl, g, analyses.Liveness(decl), statics) {
+				changed = true
+				g = cfg.New(decl.Insts)
+			}
+			if propagateFunc(decl, g, statics) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// copyKey identifies a copy by its destination and source, so the same copy
+// on different paths is treated as the same copy.
+type copyKey struct {
+	dest string
+	src  string
+}
+
+// copySet maps each copy that reaches a point to its source value.
+type copySet map[copyKey]ir.Value
+
+func (s copySet) clone() copySet {
+	c := make(copySet)
+	for k, v := range s {
+		c[k] = v
+	}
+	return c
+}
+
+// kill removes the copies to or from the variable.
+func (s copySet) kill(name string) {
+	for k := range s {
+		if k.dest == name || k.src == name {
+			delete(s, k)
+		}
+	}
+}
+
+// lookup returns the value copied into the variable, or nil if no copy to
+// the variable reaches. Where the source was itself copied from another
+// value, that value is returned instead.
+func (s copySet) lookup(name string) ir.Value {
+	var src ir.Value
+	// Limit the chain to the number of copies in case of a cycle.
+	for i := 0; i < len(s); i++ {
+		v, ok := s.find(name)
+		if !ok {
+			break
+		}
+		src = v
+		if v, ok := v.(*ir.VarValue); ok {
+			name = v.V
+		} else {
+			break
+		}
+	}
+	return src
+}
+
+func (s copySet) find(name string) (ir.Value, bool) {
+	for k, v := range s {
+		if k.dest == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// propagatable returns whether uses of the copy's destination can be
+// replaced by its source. Copies between types of a different signedness
+// can't be, as operations use the type of their operands.
+func propagatable(inst *ir.CopyInst) (copyKey, bool) {
+	dest, ok := inst.R.(*ir.VarValue)
+	if !ok || ir.TypeOf(inst.L) != dest.Type {
+		return copyKey{}, false
+	}
+	return copyKey{
+		dest: dest.V,
+		src:  ir.Format(inst.L),
+	}, true
+}
+
+// transferCopies updates the copies reaching after the instruction.
+func transferCopies(inst ir.Inst, copies copySet, statics map[string]bool) {
+	switch inst.(type) {
+	case *ir.CallInst, *ir.TailCallInst, *ir.CallIndirectInst:
+		// The called function may assign any static variable.
+		for name := range statics {
+			copies.kill(name)
+		}
+	}
+
+	def := ir.Def(inst)
+	if def == nil {
+		return
+	}
+	copies.kill(def.V)
+	if copy, ok := inst.(*ir.CopyInst); ok {
+		if key, ok := propagatable(copy); ok && key.dest != key.src {
+			copies[key] = copy.L
+		}
+	}
+}
+
+// reachingCopies returns the copies that reach the start of each block.
+func reachingCopies(g *cfg.Graph, statics map[string]bool) map[*cfg.Block]copySet {
+	// Start every block other than the entry with all copies, so the
+	// intersection at loop headers isn't limited by blocks that haven't been
+	// visited yet.
+	all := make(copySet)
+	for _, b := range g.Blocks {
+		for _, inst := range b.Insts {
+			if copy, ok := inst.(*ir.CopyInst); ok {
+				if key, ok := propagatable(copy); ok {
+					all[key] = copy.L
+				}
+			}
+		}
+	}
+
+	in := make(map[*cfg.Block]copySet)
+	out := make(map[*cfg.Block]copySet)
+	out[g.Entry] = make(copySet)
+	for _, b := range g.Blocks {
+		out[b] = all.clone()
+	}
+
+	order := g.ReversePostOrder()
+	for changed := true; changed; {
+		changed = false
+		for _, b := range order {
+			var copies copySet
+			for _, pred := range b.Preds {
+				if copies == nil {
+					copies = out[pred].clone()
+					continue
+				}
+				for k := range copies {
+					if _, ok := out[pred][k]; !ok {
+						delete(copies, k)
+					}
+				}
+			}
+			if copies == nil {
+				copies = make(copySet)
+			}
+			in[b] = copies.clone()
+
+			for _, inst := range b.Insts {
+				transferCopies(inst, copies, statics)
+			}
+			// The sets only shrink, so a change in size means the set
+			// changed.
+			if len(copies) != len(out[b]) {
+				changed = true
+			}
+			out[b] = copies
+		}
+	}
+	return in
+}
+
+func propagateFunc(decl *ir.FuncDecl, g *cfg.Graph, statics map[string]bool) bool {
+	in := reachingCopies(g, statics)
+
+	changed := false
+	for _, b := range g.Blocks {
+		copies, ok := in[b]
+		if !ok {
+			// The block is unreachable.
+			continue
+		}
+
+		var insts []ir.Inst
+		for _, inst := range b.Insts {
+			// Find the copies reaching the instruction before rewriting it,
+			// as the analysis was of the original instructions.
+			before := copies.clone()
+			transferCopies(inst, copies, statics)
+
+			if copy, ok := inst.(*ir.CopyInst); ok {
+				if key, ok := propagatable(copy); ok {
+					// The copy is redundant if the destination already holds
+					// the source, either from the same copy or the reverse.
+					_, same := before[key]
+					_, reverse := before[copyKey{dest: key.src, src: key.dest}]
+					if same || reverse || key.dest == key.src {
+						changed = true
+						continue
+					}
+				}
+			}
+
+			for _, use := range ir.Uses(inst) {
+				v, ok := (*use).(*ir.VarValue)
+				if !ok {
+					continue
+				}
+				if src := before.lookup(v.V); src != nil {
+					*use = src
+					changed = true
+				}
+			}
+			insts = append(insts, inst)
+		}
+		b.Insts = insts
+	}
+	decl.Insts = g.Flatten()
+
+	return changed
+}
+
+// coalesceFunc assigns results directly to the variable they're copied to,
+// where the result is a temporary that isn't used after the copy.
+func coalesceFunc(decl *ir.FuncDecl, g *cfg.Graph, liveness *cfg.Liveness, statics map[string]bool) bool {
+	changed := false
+	for _, b := range g.Blocks {
+		live := make(map[string]bool)
+		for v := range liveness.Out[b] {
+			live[v] = true
+		}
+
+		// Walk the block backwards, so live contains the variables live
+		// after each instruction.
+		var insts []ir.Inst
+		for i := len(b.Insts) - 1; i >= 0; i-- {
+			inst := b.Insts[i]
+			if copy, ok := inst.(*ir.CopyInst); ok && i > 0 {
+				if coalesce(b.Insts[i-1], copy, live, statics) {
+					// The previous instruction now assigns the copy's
+					// destination, so the variables live after it are
+					// unchanged.
+					changed = true
+					continue
+				}
+			}
+			cfg.Transfer(inst, live)
+			insts = append([]ir.Inst{inst}, insts...)
+		}
+		b.Insts = insts
+	}
+	decl.Insts = g.Flatten()
+
+	return changed
+}
+
+// coalesce replaces the destination of prev with the destination of the
+// copy that follows it, if the copy reads prev's result and the result isn't
+// used after the copy. Returns whether the copy can be removed.
+func coalesce(prev ir.Inst, copy *ir.CopyInst, liveAfter map[string]bool, statics map[string]bool) bool {
+	src, ok := copy.L.(*ir.VarValue)
+	if !ok || src.Type != ir.TypeOf(copy.R) || liveAfter[src.V] || statics[src.V] {
+		return false
+	}
+	if def := ir.Def(prev); def == nil || def.V != src.V {
+		return false
+	}
+
+	ir.SetDef(prev, copy.R)
+	return true
+}
@@ -0,0 +1,154 @@
+package opt_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/analysis"
+	"github.com/andydunstall/minc/pkg/ir/opt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPropagateCopies(t *testing.T) {
+	tests := []struct {
+		Name string
+		IR   string
+		Want string
+	}{
+		{
+			Name: "chain",
+			IR: `global fn main(i32 a) {
+	tmp.1 = copy i32 a
+	x.1 = copy i32 tmp.1
+	tmp.2 = copy i32 x.1
+	ret i32 tmp.2
+}
+`,
+			// The copies between temporaries are coalesced.
+			Want: `global fn main(i32 a) {
+	tmp.2 = copy i32 a
+	ret i32 a
+}
+`,
+		},
+		{
+			// A copy no longer holds once its source is assigned.
+			Name: "killed",
+			IR: `global fn main(i32 a) {
+	x.1 = copy i32 a
+	a = add i32 a, 1
+	ret i32 x.1
+}
+`,
+			Want: `global fn main(i32 a) {
+	x.1 = copy i32 a
+	a = add i32 a, 1
+	ret i32 x.1
+}
+`,
+		},
+		{
+			// A copy must reach along every path.
+			Name: "paths",
+			IR: `global fn main(i32 a) {
+	x.1 = copy i32 1
+	y.1 = copy i32 2
+	jz i32 a, end.1
+	x.1 = copy i32 3
+	y.1 = copy i32 2
+end.1:
+	tmp.1 = add i32 x.1, y.1
+	ret i32 tmp.1
+}
+`,
+			Want: `global fn main(i32 a) {
+	x.1 = copy i32 1
+	y.1 = copy i32 2
+	jz i32 a, end.1
+	x.1 = copy i32 3
+end.1:
+	tmp.1 = add i32 x.1, 2
+	ret i32 tmp.1
+}
+`,
+		},
+		{
+			// The source is reassigned on the path around the loop.
+			Name: "loop",
+			IR: `global fn main(i32 a) {
+	y.1 = copy i32 0
+loop.1:
+	x.1 = copy i32 y.1
+	jz i32 a, skip.1
+	y.1 = add i32 y.1, 1
+skip.1:
+	tmp.1 = add i32 x.1, 1
+	jnz i32 tmp.1, loop.1
+	ret i32 x.1
+}
+`,
+			Want: `global fn main(i32 a) {
+	y.1 = copy i32 0
+loop.1:
+	x.1 = copy i32 y.1
+	jz i32 a, skip.1
+	y.1 = add i32 y.1, 1
+skip.1:
+	tmp.1 = add i32 x.1, 1
+	jnz i32 tmp.1, loop.1
+	ret i32 x.1
+}
+`,
+		},
+		{
+			// Calls may assign static variables.
+			Name: "statics",
+			IR: `var i32 count = 0
+
+global fn main() {
+	x.1 = copy i32 count
+	tmp.1 = call i32 f()
+	ret i32 x.1
+}
+`,
+			Want: `var i32 count = 0
+
+global fn main() {
+	x.1 = copy i32 count
+	tmp.1 = call i32 f()
+	ret i32 x.1
+}
+`,
+		},
+		{
+			Name: "coalesce",
+			// x = x + 1; return x;
+			IR: `global fn main(i32 x.1) {
+	tmp.1 = add i32 x.1, 1
+	x.1 = copy i32 tmp.1
+	tmp.2 = call i32 f()
+	y.1 = copy i32 tmp.2
+	tmp.3 = add i32 x.1, y.1
+	ret i32 tmp.3
+}
+`,
+			Want: `global fn main(i32 x.1) {
+	x.1 = add i32 x.1, 1
+	y.1 = call i32 f()
+	tmp.3 = add i32 x.1, y.1
+	ret i32 tmp.3
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			file, err := ir.ParseText([]byte(tt.IR), false)
+			require.NoError(t, err)
+			opt.PropagateCopies(file, analysis.NewCache())
+			assert.Equal(t, tt.Want, ir.Format(file))
+		})
+	}
+}
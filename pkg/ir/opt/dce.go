@@ -0,0 +1,225 @@
+package opt
+
+import (
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/analysis"
+	"github.com/andydunstall/minc/pkg/ir/cfg"
+)
+
+// EliminateDeadCode removes instructions that can't affect the result of
+// the program.
+//
+// This removes blocks that can never be reached, such as code after a
+// return, jumps to the next instruction, and labels that nothing jumps to.
+// It also removes instructions whose result is never read, except calls,
+// which may have side effects.
+func EliminateDeadCode(file *ir.File, analyses *analysis.Cache) bool {
+	statics := ir.StaticVars(file)
+	changed := false
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ir.FuncDecl)
+		if !ok {
+			continue
+		}
+
+		// Each step only removes instructions, but may leave more to remove
+		// for the other steps, so repeat until the function stops getting
+		// shorter. The cached analyses are only used until the function
+		// first changes.
+		g := analyses.CFG(decl)
+		liveness := analyses.Liveness(decl)
+		for {
+			n := len(decl.Insts)
+			removeUnreachable(decl, g)
+			removeUselessInsts(decl, statics)
+			if len(decl.Insts) != n {
+				liveness = nil
+			}
+			removeDeadStores(decl, statics, g, liveness)
+			removeRedundantJumps(decl)
+			removeUnusedLabels(decl)
+			if len(decl.Insts) == n {
+				break
+			}
+			changed = true
+			g = cfg.New(decl.Insts)
+			liveness = nil
+		}
+	}
+	return changed
+}
+
+func removeUnreachable(decl *ir.FuncDecl, g *cfg.Graph) {
+	reachable := g.Reachable()
+	for _, b := range append([]*cfg.Block(nil), g.Blocks...) {
+		if !reachable[b] {
+			g.RemoveBlock(b)
+		}
+	}
+	decl.Insts = g.Flatten()
+}
+
+// removeUselessInsts removes instructions whose result never contributes
+// to the function's effects, even if it is read, such as a variable that is
+// only used to update itself in a loop.
+//
+// The useful instructions are found by starting from those with effects,
+// then marking every assignment to a variable they read, and so on.
+func removeUselessInsts(decl *ir.FuncDecl, statics map[string]bool) {
+	defs := make(map[string][]ir.Inst)
+	for _, inst := range decl.Insts {
+		if def := ir.Def(inst); def != nil {
+			defs[def.V] = append(defs[def.V], inst)
+		}
+	}
+
+	useful := make(map[ir.Inst]bool)
+	var worklist []ir.Inst
+	for _, inst := range decl.Insts {
+		if def := ir.Def(inst); def == nil || statics[def.V] || !isPure(inst) {
+			useful[inst] = true
+			worklist = append(worklist, inst)
+		}
+	}
+	for len(worklist) > 0 {
+		inst := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+
+		for _, use := range ir.Uses(inst) {
+			v, ok := (*use).(*ir.VarValue)
+			if !ok {
+				continue
+			}
+			for _, def := range defs[v.V] {
+				if !useful[def] {
+					useful[def] = true
+					worklist = append(worklist, def)
+				}
+			}
+			// Only visit the assignments of each variable once.
+			delete(defs, v.V)
+		}
+	}
+
+	var insts []ir.Inst
+	for _, inst := range decl.Insts {
+		if useful[inst] {
+			insts = append(insts, inst)
+		}
+	}
+	decl.Insts = insts
+}
+
+// removeDeadStores removes instructions that assign a variable that isn't
+// live afterwards. Removing an instruction may make the instructions that
+// computed its operands dead, so this repeats until there is nothing to
+// remove.
+//
+// If liveness isn't nil, it and g are the function's current control-flow
+// graph and liveness.
+func removeDeadStores(decl *ir.FuncDecl, statics map[string]bool, g *cfg.Graph, liveness *cfg.Liveness) {
+	for ; ; liveness = nil {
+		if liveness == nil {
+			g = cfg.New(decl.Insts)
+			liveness = cfg.ComputeLiveness(g)
+		}
+
+		removed := false
+		for _, b := range g.Blocks {
+			live := make(map[string]bool)
+			for v := range liveness.Out[b] {
+				live[v] = true
+			}
+
+			// Walk the block backwards, so live contains the variables
+			// live after each instruction.
+			var insts []ir.Inst
+			for i := len(b.Insts) - 1; i >= 0; i-- {
+				inst := b.Insts[i]
+				if def := ir.Def(inst); def != nil && !live[def.V] && !statics[def.V] && isPure(inst) {
+					removed = true
+					continue
+				}
+				cfg.Transfer(inst, live)
+				insts = append([]ir.Inst{inst}, insts...)
+			}
+			b.Insts = insts
+		}
+		decl.Insts = g.Flatten()
+
+		if !removed {
+			return
+		}
+	}
+}
+
+// isPure returns whether the instruction has no effect other than
+// assigning its destination.
+func isPure(inst ir.Inst) bool {
+	switch inst.(type) {
+	case *ir.CallInst, *ir.TailCallInst, *ir.CallIndirectInst:
+		return false
+	default:
+		return true
+	}
+}
+
+// removeRedundantJumps removes jumps to a label that immediately follows
+// the jump.
+func removeRedundantJumps(decl *ir.FuncDecl) {
+	var insts []ir.Inst
+	for i, inst := range decl.Insts {
+		var label string
+		switch inst := inst.(type) {
+		case *ir.JumpInst:
+			label = inst.Label
+		case *ir.JumpIfZeroInst:
+			label = inst.Label
+		case *ir.JumpIfNotZeroInst:
+			label = inst.Label
+		}
+		if label != "" && followedByLabel(decl.Insts[i+1:], label) {
+			continue
+		}
+		insts = append(insts, inst)
+	}
+	decl.Insts = insts
+}
+
+// followedByLabel returns whether the instructions start with the label,
+// possibly after other labels.
+func followedByLabel(insts []ir.Inst, label string) bool {
+	for _, inst := range insts {
+		l, ok := inst.(*ir.LabelInst)
+		if !ok {
+			return false
+		}
+		if l.Name == label {
+			return true
+		}
+	}
+	return false
+}
+
+func removeUnusedLabels(decl *ir.FuncDecl) {
+	targets := make(map[string]bool)
+	for _, inst := range decl.Insts {
+		switch inst := inst.(type) {
+		case *ir.JumpInst:
+			targets[inst.Label] = true
+		case *ir.JumpIfZeroInst:
+			targets[inst.Label] = true
+		case *ir.JumpIfNotZeroInst:
+			targets[inst.Label] = true
+		}
+	}
+
+	var insts []ir.Inst
+	for _, inst := range decl.Insts {
+		if label, ok := inst.(*ir.LabelInst); ok && !targets[label.Name] {
+			continue
+		}
+		insts = append(insts, inst)
+	}
+	decl.Insts = insts
+}
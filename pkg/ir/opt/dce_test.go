@@ -0,0 +1,122 @@
+package opt_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/analysis"
+	"github.com/andydunstall/minc/pkg/ir/opt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEliminateDeadCode(t *testing.T) {
+	tests := []struct {
+		Name string
+		IR   string
+		Want string
+	}{
+		{
+			Name: "unreachable",
+			// if (x) { return 1; } else { return 2; }
+			IR: `global fn main(i32 x) {
+	jz i32 x, else.1
+	ret i32 1
+	jmp end.1
+else.1:
+	ret i32 2
+	x = copy i32 3
+end.1:
+	ret i32 0
+}
+`,
+			Want: `global fn main(i32 x) {
+	jz i32 x, else.1
+	ret i32 1
+else.1:
+	ret i32 2
+}
+`,
+		},
+		{
+			Name: "unused results",
+			IR: `var i32 count = 0
+
+global fn main(i32 x) {
+	tmp.1 = add i32 x, 1
+	tmp.2 = mul i32 tmp.1, 2
+	tmp.3 = call i32 f()
+	count = copy i32 tmp.3
+	x = copy i32 5
+	x = copy i32 6
+	ret i32 x
+}
+`,
+			Want: `var i32 count = 0
+
+global fn main(i32 x) {
+	tmp.3 = call i32 f()
+	count = copy i32 tmp.3
+	x = copy i32 6
+	ret i32 x
+}
+`,
+		},
+		{
+			// Values read in a later iteration of a loop are live.
+			Name: "loop",
+			IR: `global fn main(i32 n) {
+	i = copy i32 0
+	sum = copy i32 0
+	unused = copy i32 0
+continue.loop.1:
+	tmp.1 = lt i32 i, n
+	jz i32 tmp.1, break.loop.1
+	sum = add i32 sum, i
+	unused = add i32 unused, i
+	i = add i32 i, 1
+	jmp continue.loop.1
+break.loop.1:
+	ret i32 sum
+}
+`,
+			Want: `global fn main(i32 n) {
+	i = copy i32 0
+	sum = copy i32 0
+continue.loop.1:
+	tmp.1 = lt i32 i, n
+	jz i32 tmp.1, break.loop.1
+	sum = add i32 sum, i
+	i = add i32 i, 1
+	jmp continue.loop.1
+break.loop.1:
+	ret i32 sum
+}
+`,
+		},
+		{
+			Name: "jumps",
+			IR: `global fn main(i32 x) {
+	jz i32 x, end.1
+	jmp end.1
+unused.1:
+end.1:
+	ret i32 x
+}
+`,
+			Want: `global fn main(i32 x) {
+	ret i32 x
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			file, err := ir.ParseText([]byte(tt.IR), false)
+			require.NoError(t, err)
+			opt.EliminateDeadCode(file, analysis.NewCache())
+			assert.Equal(t, tt.Want, ir.Format(file))
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package opt
+
+import (
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/token"
+)
+
+// FoldConstants evaluates operations on constants at compile time.
+//
+// Within each basic block, variables assigned a constant are replaced by
+// the constant where they are used, so whole expressions fold down to a
+// single value. Operations that would trap at runtime, such as division by
+// zero, are left in place.
+//
+// It also simplifies algebraic identities, such as x+0 and x*1, and replaces
+// conditional jumps on a constant with an unconditional jump, or removes
+// them if the jump is never taken.
+func FoldConstants(file *ir.File) bool {
+	statics := ir.StaticVars(file)
+	changed := false
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ir.FuncDecl); ok {
+			if foldFunc(decl, statics) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func foldFunc(decl *ir.FuncDecl, statics map[string]bool) bool {
+	changed := false
+
+	// consts maps each local variable known to hold a constant to its
+	// value. It is reset at each label, as the variable may have a different
+	// value on another path into the block.
+	consts := make(map[string]int64)
+
+	var insts []ir.Inst
+	for _, inst := range decl.Insts {
+		if _, ok := inst.(*ir.LabelInst); ok {
+			consts = make(map[string]int64)
+		}
+
+		for _, use := range ir.Uses(inst) {
+			v, ok := (*use).(*ir.VarValue)
+			if !ok {
+				continue
+			}
+			if c, ok := consts[v.V]; ok {
+				*use = ir.NewConst(c, v.Type)
+				changed = true
+			}
+		}
+
+		folded := foldInst(inst)
+		if copy, ok := folded.(*ir.CopyInst); ok && sameVar(copy.L, copy.R) {
+			// Simplifying an identity such as x = x + 0 leaves a copy to
+			// itself.
+			folded = nil
+		}
+		if folded != inst {
+			changed = true
+		}
+		if folded == nil {
+			continue
+		}
+		insts = append(insts, folded)
+
+		if dest := ir.Def(folded); dest != nil && !statics[dest.V] {
+			delete(consts, dest.V)
+			if copy, ok := folded.(*ir.CopyInst); ok {
+				if c, ok := copy.L.(*ir.ConstValue); ok {
+					consts[dest.V] = ir.Wrap(ir.ConstInt(c), dest.Type)
+				}
+			}
+		}
+	}
+	decl.Insts = insts
+
+	return changed
+}
+
+// foldInst returns the simplified instruction, the same instruction if it
+// can't be simplified, or nil if the instruction has no effect.
+func foldInst(inst ir.Inst) ir.Inst {
+	switch inst := inst.(type) {
+	case *ir.UnaryInst:
+		c, ok := inst.Src.(*ir.ConstValue)
+		if !ok {
+			return inst
+		}
+		return copyConst(ir.EvalUnary(inst.Op, ir.ConstInt(c)), inst.Dest)
+	case *ir.BinaryInst:
+		return foldBinary(inst)
+	case *ir.SignExtendInst:
+		if c, ok := inst.Src.(*ir.ConstValue); ok {
+			return copyConst(ir.EvalConversion(inst, ir.ConstInt(c)), inst.Dest)
+		}
+	case *ir.ZeroExtendInst:
+		if c, ok := inst.Src.(*ir.ConstValue); ok {
+			return copyConst(ir.EvalConversion(inst, ir.ConstInt(c)), inst.Dest)
+		}
+	case *ir.TruncateInst:
+		if c, ok := inst.Src.(*ir.ConstValue); ok {
+			return copyConst(ir.EvalConversion(inst, ir.ConstInt(c)), inst.Dest)
+		}
+	case *ir.JumpIfZeroInst:
+		if c, ok := inst.V.(*ir.ConstValue); ok {
+			if ir.ConstInt(c) == 0 {
+				return &ir.JumpInst{
+					Label: inst.Label,
+				}
+			}
+			return nil
+		}
+	case *ir.JumpIfNotZeroInst:
+		if c, ok := inst.V.(*ir.ConstValue); ok {
+			if ir.ConstInt(c) != 0 {
+				return &ir.JumpInst{
+					Label: inst.Label,
+				}
+			}
+			return nil
+		}
+	}
+	return inst
+}
+
+func foldBinary(inst *ir.BinaryInst) ir.Inst {
+	t := ir.TypeOf(inst.V1)
+	c1, ok1 := inst.V1.(*ir.ConstValue)
+	c2, ok2 := inst.V2.(*ir.ConstValue)
+	if ok1 && ok2 {
+		v, err := ir.EvalBinary(inst.Op, t, ir.ConstInt(c1), ir.ConstInt(c2))
+		if err != nil {
+			// Leave the operation to trap at runtime.
+			return inst
+		}
+		return copyConst(v, inst.Dest)
+	}
+
+	// The identities below only hold for arithmetic, where the result has
+	// the same type as the operands.
+	if ir.TypeOf(inst.Dest) != t {
+		return inst
+	}
+
+	isConst := func(c *ir.ConstValue, ok bool, v int64) bool {
+		return ok && ir.ConstInt(c) == v
+	}
+	switch inst.Op {
+	case token.ADD:
+		if isConst(c1, ok1, 0) {
+			return copyValue(inst.V2, inst.Dest)
+		}
+		if isConst(c2, ok2, 0) {
+			return copyValue(inst.V1, inst.Dest)
+		}
+	case token.SUB:
+		if isConst(c2, ok2, 0) {
+			return copyValue(inst.V1, inst.Dest)
+		}
+		if sameVar(inst.V1, inst.V2) {
+			return copyConst(0, inst.Dest)
+		}
+	case token.MUL:
+		if isConst(c1, ok1, 1) {
+			return copyValue(inst.V2, inst.Dest)
+		}
+		if isConst(c2, ok2, 1) {
+			return copyValue(inst.V1, inst.Dest)
+		}
+		if isConst(c1, ok1, 0) || isConst(c2, ok2, 0) {
+			return copyConst(0, inst.Dest)
+		}
+	case token.QUO:
+		if isConst(c2, ok2, 1) {
+			return copyValue(inst.V1, inst.Dest)
+		}
+	case token.REM:
+		if isConst(c2, ok2, 1) {
+			return copyConst(0, inst.Dest)
+		}
+	}
+	return inst
+}
+
+// sameVar returns whether both values are the same variable.
+func sameVar(v1, v2 ir.Value) bool {
+	var1, ok1 := v1.(*ir.VarValue)
+	var2, ok2 := v2.(*ir.VarValue)
+	return ok1 && ok2 && var1.V == var2.V
+}
+
+func copyConst(v int64, dest ir.Value) *ir.CopyInst {
+	return copyValue(ir.NewConst(v, ir.TypeOf(dest)), dest)
+}
+
+func copyValue(v ir.Value, dest ir.Value) *ir.CopyInst {
+	return &ir.CopyInst{
+		L: v,
+		R: dest,
+	}
+}
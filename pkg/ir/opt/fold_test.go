@@ -0,0 +1,169 @@
+package opt_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/opt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFoldConstants(t *testing.T) {
+	tests := []struct {
+		Name string
+		IR   string
+		Want string
+	}{
+		{
+			Name: "expression",
+			// return (2 + 5 - 1) * (9 % 5) * (9 / 3);
+			IR: `global fn main() {
+	tmp.1 = add i32 2, 5
+	tmp.2 = sub i32 tmp.1, 1
+	tmp.3 = rem i32 9, 5
+	tmp.4 = mul i32 tmp.2, tmp.3
+	tmp.5 = div i32 9, 3
+	tmp.6 = mul i32 tmp.4, tmp.5
+	ret i32 tmp.6
+}
+`,
+			Want: `global fn main() {
+	tmp.1 = copy i32 7
+	tmp.2 = copy i32 6
+	tmp.3 = copy i32 4
+	tmp.4 = copy i32 24
+	tmp.5 = copy i32 3
+	tmp.6 = copy i32 72
+	ret i32 72
+}
+`,
+		},
+		{
+			Name: "overflow",
+			IR: `global fn main() {
+	tmp.1 = add i32 2147483647, 1
+	tmp.2 = neg i32 -2147483648
+	tmp.3 = div u32 4294967295, 2
+	tmp.4 = lt u32 4294967295, 1 to i32
+	tmp.5 = trunc i64 4294967298 to i32
+	tmp.6 = sext i32 -1 to i64
+	tmp.7 = zext u8 255 to i32
+	ret i32 tmp.1
+}
+`,
+			Want: `global fn main() {
+	tmp.1 = copy i32 -2147483648
+	tmp.2 = copy i32 -2147483648
+	tmp.3 = copy u32 2147483647
+	tmp.4 = copy i32 0
+	tmp.5 = copy i32 2
+	tmp.6 = copy i64 -1
+	tmp.7 = copy i32 255
+	ret i32 -2147483648
+}
+`,
+		},
+		{
+			// Operations that trap at runtime are left in place.
+			Name: "trap",
+			IR: `global fn main() {
+	tmp.1 = div i32 1, 0
+	tmp.2 = rem i32 -2147483648, -1
+	ret i32 tmp.1
+}
+`,
+			Want: `global fn main() {
+	tmp.1 = div i32 1, 0
+	tmp.2 = rem i32 -2147483648, -1
+	ret i32 tmp.1
+}
+`,
+		},
+		{
+			Name: "identities",
+			IR: `global fn main(i32 x) {
+	tmp.1 = mul i32 x, 1
+	tmp.2 = add i32 0, tmp.1
+	tmp.3 = sub i32 tmp.2, tmp.2
+	tmp.4 = mul i32 x, 0
+	tmp.5 = div i32 x, 1
+	tmp.6 = rem i32 x, 1
+	x = add i32 x, 0
+	ret i32 tmp.5
+}
+`,
+			Want: `global fn main(i32 x) {
+	tmp.1 = copy i32 x
+	tmp.2 = copy i32 tmp.1
+	tmp.3 = copy i32 0
+	tmp.4 = copy i32 0
+	tmp.5 = copy i32 x
+	tmp.6 = copy i32 0
+	ret i32 tmp.5
+}
+`,
+		},
+		{
+			Name: "jumps",
+			IR: `global fn main(i32 x) {
+	tmp.1 = lt i32 1, 2
+	jz i32 tmp.1, else.1
+	jnz i32 tmp.1, end.1
+else.1:
+	jz i32 0, end.1
+end.1:
+	ret i32 x
+}
+`,
+			Want: `global fn main(i32 x) {
+	tmp.1 = copy i32 1
+	jmp end.1
+else.1:
+	jmp end.1
+end.1:
+	ret i32 x
+}
+`,
+		},
+		{
+			// Variables may have a different value on another path into
+			// the block, and static variables may be changed by calls.
+			Name: "blocks",
+			IR: `var i32 count = 0
+
+global fn main(i32 x) {
+	x = copy i32 1
+	count = copy i32 2
+	jz i32 x, end.1
+	tmp.1 = call i32 f()
+	tmp.2 = add i32 count, 1
+	x = copy i32 3
+end.1:
+	ret i32 x
+}
+`,
+			Want: `var i32 count = 0
+
+global fn main(i32 x) {
+	x = copy i32 1
+	count = copy i32 2
+	tmp.1 = call i32 f()
+	tmp.2 = add i32 count, 1
+	x = copy i32 3
+end.1:
+	ret i32 x
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			file, err := ir.ParseText([]byte(tt.IR), false)
+			require.NoError(t, err)
+			opt.FoldConstants(file)
+			assert.Equal(t, tt.Want, ir.Format(file))
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package opt
+
+import (
+	"fmt"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/cfg"
+	"github.com/andydunstall/minc/pkg/ir/ssa"
+	"github.com/andydunstall/minc/pkg/token"
+)
+
+// NumberValues removes computations of a value that was already computed,
+// reusing the earlier result instead.
+//
+// Each function is converted to SSA form, so a variable always holds the
+// same value, then numbered by walking the dominator tree. Within a block
+// this is local value numbering, and as the expressions computed in a block
+// are available in every block it dominates, they are also reused there.
+//
+// Only operations without side effects are reused, so calls are always kept,
+// and operations that read static variables aren't reused as a call may
+// have changed the variable.
+func NumberValues(file *ir.File) bool {
+	statics := ir.StaticVars(file)
+	changed := false
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ir.FuncDecl)
+		if !ok {
+			continue
+		}
+
+		// Number a copy, as building SSA form renames every variable even
+		// when no value ends up being reused.
+		clone := ir.CloneFuncDecl(decl)
+		f := ssa.Build(clone, statics)
+		if numberFunc(f, statics) {
+			f.Destruct()
+			decl.Insts = clone.Insts
+			changed = true
+		}
+	}
+	return changed
+}
+
+// exprKey identifies the value computed by an instruction.
+type exprKey struct {
+	inst     string
+	op       token.Token
+	t        ir.Type
+	destType ir.Type
+	v1       string
+	v2       string
+}
+
+type valueNumberer struct {
+	f       *ssa.Func
+	statics map[string]bool
+	// replace maps each variable to an earlier variable or constant with
+	// the same value.
+	replace map[string]ir.Value
+	changed bool
+}
+
+func numberFunc(f *ssa.Func, statics map[string]bool) bool {
+	n := &valueNumberer{
+		f:       f,
+		statics: statics,
+		replace: make(map[string]ir.Value),
+	}
+	n.visit(f.Graph.Entry, make(map[exprKey]*ir.VarValue))
+
+	// Phi arguments are read at the end of a predecessor, which may not
+	// have been visited when the phi's block was.
+	for _, b := range f.Graph.Blocks {
+		for _, phi := range f.Phis[b] {
+			for i := range phi.Args {
+				phi.Args[i] = n.resolve(phi.Args[i])
+			}
+		}
+	}
+	return n.changed
+}
+
+// visit numbers the instructions in the block, then the blocks it
+// dominates. available contains the expressions computed in the blocks that
+// dominate b.
+func (n *valueNumberer) visit(b *cfg.Block, available map[exprKey]*ir.VarValue) {
+	scope := make(map[exprKey]*ir.VarValue)
+	for k, v := range available {
+		scope[k] = v
+	}
+
+	var insts []ir.Inst
+	for _, inst := range b.Insts {
+		for _, use := range ir.Uses(inst) {
+			*use = n.resolve(*use)
+		}
+
+		def := ir.Def(inst)
+		if def == nil || n.statics[def.V] {
+			insts = append(insts, inst)
+			continue
+		}
+
+		if copy, ok := inst.(*ir.CopyInst); ok && ir.TypeOf(copy.L) == def.Type && !n.isStatic(copy.L) {
+			// The destination has the same value as the source, unless
+			// the source is a static variable a call may change.
+			n.replace[def.V] = copy.L
+			insts = append(insts, inst)
+			continue
+		}
+
+		key, ok := n.key(inst)
+		if !ok {
+			insts = append(insts, inst)
+			continue
+		}
+		if prev, ok := scope[key]; ok {
+			// As the function is in SSA form, every use of the variable is
+			// dominated by this instruction, so will be replaced by the
+			// earlier result.
+			n.replace[def.V] = prev
+			n.changed = true
+			continue
+		}
+		scope[key] = def
+		insts = append(insts, inst)
+	}
+	b.Insts = insts
+
+	for _, child := range n.f.Dom.Children[b] {
+		n.visit(child, scope)
+	}
+}
+
+// key returns the key of the value computed by the instruction, or false if
+// the instruction may compute a different value each time it runs.
+func (n *valueNumberer) key(inst ir.Inst) (exprKey, bool) {
+	var key exprKey
+	var operands []ir.Value
+	switch inst := inst.(type) {
+	case *ir.UnaryInst:
+		key.op = inst.Op
+		operands = []ir.Value{inst.Src}
+	case *ir.BinaryInst:
+		key.op = inst.Op
+		operands = []ir.Value{inst.V1, inst.V2}
+		if isCommutative(inst.Op) && ir.Format(inst.V1) > ir.Format(inst.V2) {
+			operands = []ir.Value{inst.V2, inst.V1}
+		}
+	case *ir.SignExtendInst:
+		operands = []ir.Value{inst.Src}
+	case *ir.ZeroExtendInst:
+		operands = []ir.Value{inst.Src}
+	case *ir.TruncateInst:
+		operands = []ir.Value{inst.Src}
+	default:
+		return exprKey{}, false
+	}
+
+	for _, v := range operands {
+		if n.isStatic(v) {
+			return exprKey{}, false
+		}
+	}
+
+	key.inst = fmt.Sprintf("%T", inst)
+	key.t = ir.TypeOf(operands[0])
+	key.destType = ir.Def(inst).Type
+	key.v1 = ir.Format(operands[0])
+	if len(operands) > 1 {
+		key.v2 = ir.Format(operands[1])
+	}
+	return key, true
+}
+
+func (n *valueNumberer) isStatic(v ir.Value) bool {
+	variable, ok := v.(*ir.VarValue)
+	return ok && n.statics[variable.V]
+}
+
+// resolve returns the earliest value known to equal v.
+func (n *valueNumberer) resolve(v ir.Value) ir.Value {
+	for {
+		variable, ok := v.(*ir.VarValue)
+		if !ok {
+			return v
+		}
+		r, ok := n.replace[variable.V]
+		if !ok {
+			return v
+		}
+		v = r
+	}
+}
+
+func isCommutative(op token.Token) bool {
+	switch op {
+	case token.ADD, token.MUL, token.EQL, token.NEQ:
+		return true
+	default:
+		return false
+	}
+}
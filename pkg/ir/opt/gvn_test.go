@@ -0,0 +1,150 @@
+package opt_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/opt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNumberValues(t *testing.T) {
+	tests := []struct {
+		Name string
+		IR   string
+		Want string
+	}{
+		{
+			Name: "local",
+			IR: `global fn main(i32 a, i32 b) {
+	tmp.1 = add i32 a, b
+	tmp.2 = add i32 b, a
+	tmp.3 = mul i32 tmp.1, tmp.2
+	ret i32 tmp.3
+}
+`,
+			Want: `global fn main(i32 a, i32 b) {
+	tmp.1.1 = add i32 a, b
+	tmp.3.1 = mul i32 tmp.1.1, tmp.1.1
+	ret i32 tmp.3.1
+}
+`,
+		},
+		{
+			// Expressions are reused in the blocks they dominate, but not
+			// in sibling blocks.
+			Name: "global",
+			IR: `global fn main(i32 a, i32 b) {
+	tmp.1 = sub i32 a, b
+	jz i32 a, else.1
+	tmp.2 = sub i32 a, b
+	tmp.3 = neg i32 b
+	ret i32 tmp.2
+else.1:
+	tmp.4 = neg i32 b
+	ret i32 tmp.4
+}
+`,
+			Want: `global fn main(i32 a, i32 b) {
+	tmp.1.1 = sub i32 a, b
+	jz i32 a, else.1
+	tmp.3.1 = neg i32 b
+	ret i32 tmp.1.1
+else.1:
+	tmp.4.1 = neg i32 b
+	ret i32 tmp.4.1
+}
+`,
+		},
+		{
+			// Calls may have side effects and may change static variables.
+			Name: "calls",
+			IR: `var i32 count = 0
+
+global fn main() {
+	tmp.1 = call i32 two()
+	tmp.2 = call i32 two()
+	tmp.3 = add i32 count, 1
+	tmp.4 = call i32 two()
+	tmp.5 = add i32 count, 1
+	tmp.6 = add i32 tmp.3, tmp.5
+	ret i32 tmp.6
+}
+`,
+			Want: `var i32 count = 0
+
+global fn main() {
+	tmp.1 = call i32 two()
+	tmp.2 = call i32 two()
+	tmp.3 = add i32 count, 1
+	tmp.4 = call i32 two()
+	tmp.5 = add i32 count, 1
+	tmp.6 = add i32 tmp.3, tmp.5
+	ret i32 tmp.6
+}
+`,
+		},
+		{
+			// A copy of a static variable isn't replaced by the variable,
+			// as a call may change it.
+			Name: "static copies",
+			IR: `var i32 g = 5
+
+global fn main() {
+	x = copy i32 g
+	tmp.1 = call i32 bump()
+	ret i32 x
+}
+`,
+			Want: `var i32 g = 5
+
+global fn main() {
+	x = copy i32 g
+	tmp.1 = call i32 bump()
+	ret i32 x
+}
+`,
+		},
+		{
+			// a + b is invariant in the loop, but not available on entry.
+			Name: "loop",
+			IR: `global fn main(i32 a, i32 b, i32 n) {
+	tmp.1 = add i32 a, b
+	sum = copy i32 0
+loop.1:
+	tmp.2 = add i32 a, b
+	sum = add i32 sum, tmp.2
+	n = sub i32 n, 1
+	jnz i32 n, loop.1
+	ret i32 sum
+}
+`,
+			Want: `global fn main(i32 a, i32 b, i32 n) {
+	tmp.1.1 = add i32 a, b
+	sum.1 = copy i32 0
+	sum.2 = copy i32 0
+	n.1 = copy i32 n
+loop.1:
+	sum.3 = add i32 sum.2, tmp.1.1
+	n.2 = sub i32 n.1, 1
+	jnz i32 n.2, block.3
+	ret i32 sum.3
+block.3:
+	sum.2 = copy i32 sum.3
+	n.1 = copy i32 n.2
+	jmp loop.1
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			file, err := ir.ParseText([]byte(tt.IR), false)
+			require.NoError(t, err)
+			opt.NumberValues(file)
+			assert.Equal(t, tt.Want, ir.Format(file))
+		})
+	}
+}
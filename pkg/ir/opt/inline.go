@@ -0,0 +1,341 @@
+package opt
+
+import (
+	"fmt"
+
+	"github.com/andydunstall/minc/pkg/ir"
+)
+
+// inlineThreshold is the maximum number of instructions in a function that
+// is inlined without an '@inline' attribute.
+const inlineThreshold = 16
+
+// InlineCalls replaces calls to small functions defined in the file with a
+// copy of the function's body, avoiding the cost of the call.
+//
+// Functions marked '@inline' are always inlined and functions marked
+// '@noinline' never are. Recursive functions are never inlined, as inlining
+// would never end.
+//
+// The variables and labels of the inlined body are renamed so they don't
+// clash with those in the caller, or with another copy of the same body.
+// Static functions that are no longer called or referenced once inlined are
+// removed.
+func InlineCalls(file *ir.File) bool {
+	funcs := make(map[string]*ir.FuncDecl)
+	// Inline the bodies as they were before this pass, so a body with calls
+	// that were inlined isn't inlined again within the same pass.
+	bodies := make(map[string][]ir.Inst)
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ir.FuncDecl); ok {
+			funcs[decl.Name] = decl
+			bodies[decl.Name] = decl.Insts
+		}
+	}
+	recursive := recursiveFuncs(funcs)
+	statics := ir.StaticVars(file)
+
+	changed := false
+	for _, decl := range file.Decls {
+		caller, ok := decl.(*ir.FuncDecl)
+		if !ok {
+			continue
+		}
+
+		in := newInliner(caller, statics)
+		var insts []ir.Inst
+		for _, inst := range caller.Insts {
+			call, ok := inst.(*ir.CallInst)
+			if !ok {
+				insts = append(insts, inst)
+				continue
+			}
+			callee, ok := funcs[call.Name]
+			if !ok || callee == caller || recursive[callee.Name] || !shouldInline(callee, bodies[callee.Name], call) {
+				insts = append(insts, inst)
+				continue
+			}
+			insts = append(insts, in.inline(callee, bodies[callee.Name], call)...)
+			changed = true
+		}
+		caller.Insts = insts
+	}
+
+	if changed {
+		removeUnusedFuncs(file)
+	}
+	return changed
+}
+
+// shouldInline returns whether the call should be replaced by the body of
+// the callee.
+func shouldInline(callee *ir.FuncDecl, body []ir.Inst, call *ir.CallInst) bool {
+	if callee.Inline == ir.InlineNever || len(call.Args) != len(callee.Params) {
+		return false
+	}
+	// The values are copied into the parameters and out of the returns, so
+	// must have the same size.
+	for i, param := range callee.Params {
+		if ir.TypeOf(call.Args[i]).Size() != param.Type.Size() {
+			return false
+		}
+	}
+	size := 0
+	for _, inst := range body {
+		if ret, ok := inst.(*ir.RetInst); ok && ir.TypeOf(ret.Value).Size() != ir.TypeOf(call.Dest).Size() {
+			return false
+		}
+		// The type returned by a tail call isn't known, so it can't be
+		// turned back into a call.
+		if _, ok := inst.(*ir.TailCallInst); ok {
+			return false
+		}
+		if _, ok := inst.(*ir.LabelInst); !ok {
+			size++
+		}
+	}
+	return callee.Inline == ir.InlineAlways || size <= inlineThreshold
+}
+
+// recursiveFuncs returns the functions that may call themselves, either
+// directly or through other functions.
+func recursiveFuncs(funcs map[string]*ir.FuncDecl) map[string]bool {
+	callees := make(map[string][]string)
+	for name, decl := range funcs {
+		callees[name] = ir.Callees(decl)
+	}
+
+	recursive := make(map[string]bool)
+	for name := range funcs {
+		visited := make(map[string]bool)
+		worklist := append([]string(nil), callees[name]...)
+		for len(worklist) > 0 {
+			callee := worklist[len(worklist)-1]
+			worklist = worklist[:len(worklist)-1]
+			if callee == name {
+				recursive[name] = true
+				break
+			}
+			if !visited[callee] {
+				visited[callee] = true
+				worklist = append(worklist, callees[callee]...)
+			}
+		}
+	}
+	return recursive
+}
+
+// removeUnusedFuncs removes static functions that are never called or have
+// their address taken.
+func removeUnusedFuncs(file *ir.File) {
+	used := make(map[string]bool)
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ir.FuncDecl)
+		if !ok {
+			continue
+		}
+		for _, inst := range decl.Insts {
+			switch inst := inst.(type) {
+			case *ir.CallInst:
+				if inst.Name != decl.Name {
+					used[inst.Name] = true
+				}
+			case *ir.TailCallInst:
+				if inst.Name != decl.Name {
+					used[inst.Name] = true
+				}
+			case *ir.GetAddressInst:
+				used[inst.Name] = true
+			}
+		}
+	}
+
+	var decls []ir.Decl
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ir.FuncDecl); ok && !decl.Global && !used[decl.Name] {
+			continue
+		}
+		decls = append(decls, decl)
+	}
+	file.Decls = decls
+}
+
+type inliner struct {
+	caller  *ir.FuncDecl
+	statics map[string]bool
+	// names contains the variables and labels used in the caller.
+	names map[string]bool
+	// site is the number of calls inlined into the caller.
+	site int
+}
+
+func newInliner(caller *ir.FuncDecl, statics map[string]bool) *inliner {
+	in := &inliner{
+		caller:  caller,
+		statics: statics,
+		names:   make(map[string]bool),
+	}
+	for _, param := range caller.Params {
+		in.names[param.V] = true
+	}
+	for _, local := range caller.Locals {
+		in.names[local.V] = true
+	}
+	for _, inst := range caller.Insts {
+		in.addNames(inst)
+	}
+	return in
+}
+
+// inline returns the instructions that replace the call: copies of the
+// arguments into the parameters, then the body of the callee, where each
+// return copies its value into the call's destination and jumps to the end.
+func (in *inliner) inline(callee *ir.FuncDecl, body []ir.Inst, call *ir.CallInst) []ir.Inst {
+	suffix := in.suffix(callee, body)
+	rename := func(v ir.Value) ir.Value {
+		if v, ok := v.(*ir.VarValue); ok && !in.statics[v.V] {
+			return &ir.VarValue{
+				V:    v.V + suffix,
+				Type: v.Type,
+			}
+		}
+		return v
+	}
+	label := func(name string) string {
+		return name + suffix
+	}
+	end := label("inline_end")
+
+	// The callee's locals may still be read before they're assigned.
+	for _, local := range callee.Locals {
+		in.caller.Locals = append(in.caller.Locals, rename(local).(*ir.VarValue))
+	}
+
+	var insts []ir.Inst
+	for i, param := range callee.Params {
+		insts = append(insts, &ir.CopyInst{
+			L: call.Args[i],
+			R: rename(param),
+		})
+	}
+	for _, inst := range body {
+		if ret, ok := inst.(*ir.RetInst); ok {
+			insts = append(insts, &ir.CopyInst{
+				L: rename(ret.Value),
+				R: call.Dest,
+			}, &ir.JumpInst{
+				Label: end,
+			})
+			continue
+		}
+		insts = append(insts, cloneInst(inst, rename, label))
+	}
+	if len(body) == 0 || !endsBlock(body[len(body)-1]) {
+		// Falling off the end of a function returns zero.
+		insts = append(insts, &ir.CopyInst{
+			L: ir.NewConst(0, ir.TypeOf(call.Dest)),
+			R: call.Dest,
+		})
+	}
+	insts = append(insts, &ir.LabelInst{
+		Name: end,
+	})
+
+	for _, local := range callee.Locals {
+		in.names[local.V+suffix] = true
+	}
+	for _, inst := range insts {
+		in.addNames(inst)
+	}
+	return insts
+}
+
+// suffix returns a suffix for the names in the inlined body, that makes
+// them different to every name in the caller.
+func (in *inliner) suffix(callee *ir.FuncDecl, body []ir.Inst) string {
+	for {
+		in.site++
+		suffix := fmt.Sprintf(".i%d", in.site)
+
+		clash := in.names["inline_end"+suffix]
+		for _, param := range callee.Params {
+			clash = clash || in.names[param.V+suffix]
+		}
+		for _, local := range callee.Locals {
+			clash = clash || in.names[local.V+suffix]
+		}
+		for _, inst := range body {
+			for _, name := range instNames(inst) {
+				clash = clash || in.names[name+suffix]
+			}
+		}
+		if !clash {
+			return suffix
+		}
+	}
+}
+
+func (in *inliner) addNames(inst ir.Inst) {
+	for _, name := range instNames(inst) {
+		in.names[name] = true
+	}
+}
+
+// instNames returns the variables and labels the instruction uses.
+func instNames(inst ir.Inst) []string {
+	var names []string
+	for _, use := range ir.Uses(inst) {
+		if v, ok := (*use).(*ir.VarValue); ok {
+			names = append(names, v.V)
+		}
+	}
+	if def := ir.Def(inst); def != nil {
+		names = append(names, def.V)
+	}
+	switch inst := inst.(type) {
+	case *ir.LabelInst:
+		names = append(names, inst.Name)
+	case *ir.JumpInst:
+		names = append(names, inst.Label)
+	case *ir.JumpIfZeroInst:
+		names = append(names, inst.Label)
+	case *ir.JumpIfNotZeroInst:
+		names = append(names, inst.Label)
+	}
+	return names
+}
+
+// endsBlock returns whether the instruction never falls through to the next
+// instruction.
+func endsBlock(inst ir.Inst) bool {
+	switch inst.(type) {
+	case *ir.RetInst, *ir.JumpInst:
+		return true
+	default:
+		return false
+	}
+}
+
+// cloneInst returns a copy of the instruction with its variables and labels
+// renamed.
+func cloneInst(inst ir.Inst, rename func(ir.Value) ir.Value, label func(string) string) ir.Inst {
+	clone := ir.CloneInst(inst)
+	switch clone := clone.(type) {
+	case *ir.JumpInst:
+		clone.Label = label(clone.Label)
+	case *ir.JumpIfZeroInst:
+		clone.Label = label(clone.Label)
+	case *ir.JumpIfNotZeroInst:
+		clone.Label = label(clone.Label)
+	case *ir.LabelInst:
+		clone.Name = label(clone.Name)
+	}
+
+	for _, use := range ir.Uses(clone) {
+		*use = rename(*use)
+	}
+	if def := ir.Def(clone); def != nil {
+		ir.SetDef(clone, rename(def))
+	}
+	return clone
+}
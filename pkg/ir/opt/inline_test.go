@@ -0,0 +1,82 @@
+package opt_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/opt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInlineCalls(t *testing.T) {
+	file, err := ir.ParseText([]byte(`fn abs(i32 n.1) {
+	tmp.1 = lt i32 n.1, 0
+	jz i32 tmp.1, end.2
+	tmp.3 = neg i32 n.1
+	ret i32 tmp.3
+end.2:
+	ret i32 n.1
+}
+
+global fn fact(i32 n.2) {
+	tmp.4 = sub i32 n.2, 1
+	tmp.5 = call i32 fact(i32 tmp.4)
+	ret i32 tmp.5
+}
+
+global @noinline fn one() {
+	ret i32 1
+}
+
+global fn main(i32 x.1) {
+	tmp.1 = call i32 abs(i32 x.1)
+	tmp.2 = call i32 abs(i32 tmp.1)
+	tmp.3 = call i32 fact(i32 tmp.2)
+	tmp.4 = call i32 one()
+	ret i32 tmp.3
+}
+`), false)
+	require.NoError(t, err)
+
+	assert.True(t, opt.InlineCalls(file))
+	// The static function abs is removed after being inlined, and the
+	// recursive and noinline functions are left alone.
+	assert.Equal(t, `global fn fact(i32 n.2) {
+	tmp.4 = sub i32 n.2, 1
+	tmp.5 = call i32 fact(i32 tmp.4)
+	ret i32 tmp.5
+}
+
+global @noinline fn one() {
+	ret i32 1
+}
+
+global fn main(i32 x.1) {
+	n.1.i1 = copy i32 x.1
+	tmp.1.i1 = lt i32 n.1.i1, 0
+	jz i32 tmp.1.i1, end.2.i1
+	tmp.3.i1 = neg i32 n.1.i1
+	tmp.1 = copy i32 tmp.3.i1
+	jmp inline_end.i1
+end.2.i1:
+	tmp.1 = copy i32 n.1.i1
+	jmp inline_end.i1
+inline_end.i1:
+	n.1.i2 = copy i32 tmp.1
+	tmp.1.i2 = lt i32 n.1.i2, 0
+	jz i32 tmp.1.i2, end.2.i2
+	tmp.3.i2 = neg i32 n.1.i2
+	tmp.2 = copy i32 tmp.3.i2
+	jmp inline_end.i2
+end.2.i2:
+	tmp.2 = copy i32 n.1.i2
+	jmp inline_end.i2
+inline_end.i2:
+	tmp.3 = call i32 fact(i32 tmp.2)
+	tmp.4 = call i32 one()
+	ret i32 tmp.3
+}
+`, ir.Format(file))
+
+}
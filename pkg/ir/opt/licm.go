@@ -0,0 +1,179 @@
+package opt
+
+import (
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/analysis"
+	"github.com/andydunstall/minc/pkg/ir/cfg"
+	"github.com/andydunstall/minc/pkg/ir/ssa"
+	"github.com/andydunstall/minc/pkg/token"
+)
+
+// HoistInvariants moves computations that give the same result on every
+// iteration of a loop out of the loop, so they run once before it.
+//
+// Each function is converted to SSA form, so an instruction is invariant if
+// its operands are constants, assigned outside the loop, or assigned by
+// other invariant instructions. Invariant instructions are moved to a
+// preheader: a new block that runs once before entering the loop.
+//
+// Instructions are moved even if they wouldn't run on every iteration, so
+// operations that may trap, such as division by a variable, are only moved
+// if they would always run before leaving the loop.
+func HoistInvariants(file *ir.File, analyses *analysis.Cache) bool {
+	statics := ir.StaticVars(file)
+	changed := false
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ir.FuncDecl)
+		// A function without loops has nothing to hoist.
+		if !ok || len(analyses.Loops(decl)) == 0 {
+			continue
+		}
+
+		// SSA form renames the variables, so hoist from a copy and only
+		// keep it if an instruction moved.
+		clone := ir.CloneFuncDecl(decl)
+		f := ssa.Build(clone, statics)
+		if hoistFunc(f, statics) {
+			f.Destruct()
+			decl.Insts = clone.Insts
+			changed = true
+		}
+	}
+	return changed
+}
+
+func hoistFunc(f *ssa.Func, statics map[string]bool) bool {
+	g := f.Graph
+
+	// Add the preheaders first, so the loops found afterwards include the
+	// preheaders of the loops nested inside them. Where the loop is entered
+	// from several blocks, or from a block that may go elsewhere, the
+	// edges into the loop are merged into a new block.
+	for _, loop := range ssa.FindLoops(g, f.Dom) {
+		if preds := outsidePreds(loop); len(preds) > 1 || len(preds) == 1 && len(preds[0].Succs) > 1 {
+			f.InsertPreheader(loop.Header, preds)
+		}
+	}
+	dom := ssa.Dominators(g)
+
+	changed := false
+	for _, loop := range ssa.FindLoops(g, dom) {
+		preds := outsidePreds(loop)
+		if len(preds) != 1 || len(preds[0].Succs) != 1 {
+			// The loop has no block that only runs before entering it.
+			continue
+		}
+		if hoistLoop(f, loop, preds[0], dom, statics) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// outsidePreds returns the predecessors of the loop header that are outside
+// the loop.
+func outsidePreds(loop *ssa.Loop) []*cfg.Block {
+	var preds []*cfg.Block
+	for _, pred := range loop.Header.Preds {
+		if !loop.Blocks[pred] {
+			preds = append(preds, pred)
+		}
+	}
+	return preds
+}
+
+func hoistLoop(f *ssa.Func, loop *ssa.Loop, preheader *cfg.Block, dom *ssa.DomTree, statics map[string]bool) bool {
+	// Find the variables assigned in the loop, which aren't invariant unless
+	// their instruction is hoisted.
+	defined := make(map[string]bool)
+	hasCall := false
+	for b := range loop.Blocks {
+		for _, phi := range f.Phis[b] {
+			defined[phi.Dest.V] = true
+		}
+		for _, inst := range b.Insts {
+			if def := ir.Def(inst); def != nil {
+				defined[def.V] = true
+			}
+			switch inst.(type) {
+			case *ir.CallInst, *ir.TailCallInst, *ir.CallIndirectInst:
+				hasCall = true
+			}
+		}
+	}
+
+	invariant := func(inst ir.Inst) bool {
+		def := ir.Def(inst)
+		if def == nil || statics[def.V] || !isPure(inst) {
+			return false
+		}
+		for _, use := range ir.Uses(inst) {
+			if v, ok := (*use).(*ir.VarValue); ok && (defined[v.V] || statics[v.V]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// alwaysRuns returns whether the block runs on every iteration before
+	// the loop can be left.
+	exits := loop.Exits()
+	alwaysRuns := func(b *cfg.Block) bool {
+		for _, exit := range exits {
+			if !dom.Dominates(b, exit) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var hoisted []ir.Inst
+	// Visit blocks in reverse post-order, so instructions are visited after
+	// the instructions assigning their operands.
+	for _, b := range f.Graph.ReversePostOrder() {
+		if !loop.Blocks[b] {
+			continue
+		}
+
+		var insts []ir.Inst
+		for _, inst := range b.Insts {
+			if !invariant(inst) || (mayTrap(inst) && (hasCall || !alwaysRuns(b))) {
+				insts = append(insts, inst)
+				continue
+			}
+			hoisted = append(hoisted, inst)
+			delete(defined, ir.Def(inst).V)
+		}
+		b.Insts = insts
+	}
+	if len(hoisted) == 0 {
+		return false
+	}
+
+	// The preheader only has one successor, so any terminator is a jump.
+	insts := preheader.Insts
+	var term []ir.Inst
+	if preheader.Terminator() != nil {
+		term = insts[len(insts)-1:]
+		insts = insts[:len(insts)-1]
+	}
+	insts = append(insts, hoisted...)
+	preheader.Insts = append(insts, term...)
+	return true
+}
+
+// mayTrap returns whether the instruction may trap at runtime. Division
+// traps if the divisor is zero, or if the divisor is -1 and the dividend is
+// the minimum signed value.
+func mayTrap(inst ir.Inst) bool {
+	binary, ok := inst.(*ir.BinaryInst)
+	if !ok || (binary.Op != token.QUO && binary.Op != token.REM) {
+		return false
+	}
+	c, ok := binary.V2.(*ir.ConstValue)
+	if !ok {
+		return true
+	}
+	v := ir.ConstInt(c)
+	return v == 0 || (v == -1 && ir.TypeOf(c).Signed())
+}
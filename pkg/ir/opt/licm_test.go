@@ -0,0 +1,173 @@
+package opt_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/analysis"
+	"github.com/andydunstall/minc/pkg/ir/opt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHoistInvariants(t *testing.T) {
+	tests := []struct {
+		Name string
+		IR   string
+		Want string
+	}{
+		{
+			// while (i < n) { sum = sum + a * b; i = i + 1; }
+			Name: "while",
+			IR: `global fn main(i32 a, i32 b, i32 n) {
+	i = copy i32 0
+	sum = copy i32 0
+continue.loop.1:
+	tmp.1 = lt i32 i, n
+	jz i32 tmp.1, break.loop.1
+	tmp.2 = mul i32 a, b
+	tmp.3 = add i32 tmp.2, 1
+	tmp.4 = div i32 tmp.3, 2
+	tmp.5 = div i32 sum, tmp.3
+	sum = add i32 sum, tmp.4
+	i = add i32 i, 1
+	jmp continue.loop.1
+break.loop.1:
+	ret i32 sum
+}
+`,
+			// The division by a variable is only run if the loop is entered
+			// so isn't hoisted.
+			Want: `global fn main(i32 a, i32 b, i32 n) {
+	i.1 = copy i32 0
+	sum.1 = copy i32 0
+	tmp.2.1 = mul i32 a, b
+	tmp.3.1 = add i32 tmp.2.1, 1
+	tmp.4.1 = div i32 tmp.3.1, 2
+	i.2 = copy i32 i.1
+	sum.2 = copy i32 sum.1
+continue.loop.1:
+	tmp.1.1 = lt i32 i.2, n
+	jz i32 tmp.1.1, break.loop.1
+	tmp.5.1 = div i32 sum.2, tmp.3.1
+	sum.3 = add i32 sum.2, tmp.4.1
+	i.3 = add i32 i.2, 1
+	i.2 = copy i32 i.3
+	sum.2 = copy i32 sum.3
+	jmp continue.loop.1
+break.loop.1:
+	ret i32 sum.2
+}
+`,
+		},
+		{
+			// do { n = n - 1; tmp = a / b; } while (n);
+			Name: "do while",
+			IR: `global fn main(i32 a, i32 b, i32 n) {
+start.loop.1:
+	n = sub i32 n, 1
+	tmp.1 = div i32 a, b
+	jnz i32 n, start.loop.1
+	ret i32 tmp.1
+}
+`,
+			// The division runs before the loop can be left, so it is
+			// hoisted into a new preheader.
+			Want: `global fn main(i32 a, i32 b, i32 n) {
+block.entry:
+	tmp.1.1 = div i32 a, b
+	n.1 = copy i32 n
+start.loop.1:
+	n.2 = sub i32 n.1, 1
+	jnz i32 n.2, block.3
+	ret i32 tmp.1.1
+block.3:
+	n.1 = copy i32 n.2
+	jmp start.loop.1
+}
+`,
+		},
+		{
+			// The loop is entered from two blocks, so their edges are merged
+			// into a preheader, which selects the value of sum from them.
+			Name: "multiple entries",
+			IR: `global fn main(i32 a, i32 b, i32 n) {
+	jz i32 a, else.1
+	sum = copy i32 1
+	jmp loop.2
+else.1:
+	sum = copy i32 2
+loop.2:
+	tmp.1 = mul i32 a, b
+	sum = add i32 sum, tmp.1
+	n = sub i32 n, 1
+	jnz i32 n, loop.2
+	ret i32 sum
+}
+`,
+			Want: `global fn main(i32 a, i32 b, i32 n) {
+	jz i32 a, else.1
+	sum.1 = copy i32 1
+	sum.5 = copy i32 sum.1
+	jmp block.5
+else.1:
+	sum.2 = copy i32 2
+	sum.5 = copy i32 sum.2
+block.5:
+	tmp.1.1 = mul i32 a, b
+	sum.3 = copy i32 sum.5
+	n.1 = copy i32 n
+loop.2:
+	sum.4 = add i32 sum.3, tmp.1.1
+	n.2 = sub i32 n.1, 1
+	jnz i32 n.2, block.6
+	ret i32 sum.4
+block.6:
+	sum.3 = copy i32 sum.4
+	n.1 = copy i32 n.2
+	jmp loop.2
+}
+`,
+		},
+		{
+			// Operations reading static variables or assigned by calls aren't
+			// invariant.
+			Name: "calls",
+			IR: `var i32 count = 0
+
+global fn main(i32 n) {
+	sum = copy i32 0
+loop.1:
+	tmp.1 = call i32 f()
+	tmp.2 = add i32 count, 1
+	sum = add i32 sum, tmp.2
+	n = sub i32 n, 1
+	jnz i32 n, loop.1
+	ret i32 sum
+}
+`,
+			Want: `var i32 count = 0
+
+global fn main(i32 n) {
+	sum = copy i32 0
+loop.1:
+	tmp.1 = call i32 f()
+	tmp.2 = add i32 count, 1
+	sum = add i32 sum, tmp.2
+	n = sub i32 n, 1
+	jnz i32 n, loop.1
+	ret i32 sum
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			file, err := ir.ParseText([]byte(tt.IR), false)
+			require.NoError(t, err)
+			opt.HoistInvariants(file, analysis.NewCache())
+			assert.Equal(t, tt.Want, ir.Format(file))
+		})
+	}
+}
@@ -0,0 +1,9 @@
+// Package opt contains optimization passes over the IR.
+//
+// Each pass rewrites the functions in the file in place and returns whether
+// it changed anything, so passes can be repeated until none make progress.
+//
+// Passes that use analyses of a function, such as its control-flow graph,
+// take them from an analysis.Cache, so they can reuse the analyses of an
+// earlier pass that didn't change the function.
+package opt
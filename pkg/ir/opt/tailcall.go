@@ -0,0 +1,174 @@
+package opt
+
+import (
+	"fmt"
+
+	"github.com/andydunstall/minc/pkg/ir"
+)
+
+// maxTailCallArgs is the number of arguments passed in registers. Arguments
+// passed on the stack are in the caller's frame, which a tail call removes.
+const maxTailCallArgs = 6
+
+// EliminateTailCalls replaces a call whose result is immediately returned,
+// such as 'return f(x)', with a tail call, which reuses the caller's frame
+// rather than adding a new one.
+//
+// A function that tail calls itself is turned into a loop instead, by
+// assigning the arguments to the parameters and jumping back to the start
+// of the function. Other tail calls are only made where all the arguments
+// are passed in registers.
+func EliminateTailCalls(file *ir.File) bool {
+	statics := ir.StaticVars(file)
+	changed := false
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ir.FuncDecl); ok && eliminateTailCallsFunc(decl, statics) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+func eliminateTailCallsFunc(decl *ir.FuncDecl, statics map[string]bool) bool {
+	names := make(map[string]bool)
+	for _, param := range decl.Params {
+		names[param.V] = true
+	}
+	for _, inst := range decl.Insts {
+		for _, name := range instNames(inst) {
+			names[name] = true
+		}
+	}
+	fresh := func(prefix string) string {
+		for i := 1; ; i++ {
+			name := fmt.Sprintf("%s.%d", prefix, i)
+			if !names[name] {
+				names[name] = true
+				return name
+			}
+		}
+	}
+
+	var start string
+	var insts []ir.Inst
+	changed := false
+	for i := 0; i < len(decl.Insts); i++ {
+		inst := decl.Insts[i]
+		call, ok := inst.(*ir.CallInst)
+		if !ok || !returnsResult(decl.Insts[i+1:], call) || statics[call.Dest.(*ir.VarValue).V] {
+			insts = append(insts, inst)
+			continue
+		}
+
+		switch {
+		case call.Name == decl.Name && loopable(decl, call):
+			if start == "" {
+				// Labels must be unique across the file, so include the
+				// function name.
+				start = fresh(decl.Name + ".start")
+			}
+			insts = append(insts, assignParams(decl, call.Args, fresh)...)
+			insts = append(insts, &ir.JumpInst{
+				Label: start,
+			})
+		case len(call.Args) <= maxTailCallArgs:
+			insts = append(insts, &ir.TailCallInst{
+				Name: call.Name,
+				Args: call.Args,
+			})
+		default:
+			insts = append(insts, inst)
+			continue
+		}
+		changed = true
+
+		// Drop the return that directly follows the call, though a return
+		// after a label may still be reached by a jump.
+		if _, ok := decl.Insts[i+1].(*ir.RetInst); ok {
+			i++
+		}
+	}
+	if !changed {
+		return false
+	}
+
+	if start != "" {
+		insts = append([]ir.Inst{&ir.LabelInst{
+			Name: start,
+		}}, insts...)
+	}
+	decl.Insts = insts
+	return true
+}
+
+// returnsResult returns whether the instructions following the call return
+// the call's result, skipping any labels in between.
+func returnsResult(next []ir.Inst, call *ir.CallInst) bool {
+	for _, inst := range next {
+		switch inst := inst.(type) {
+		case *ir.LabelInst:
+			continue
+		case *ir.RetInst:
+			v, ok := inst.Value.(*ir.VarValue)
+			return ok && *v == *call.Dest.(*ir.VarValue)
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// loopable returns whether a call from the function to itself can be
+// replaced by copying the arguments into the parameters.
+func loopable(decl *ir.FuncDecl, call *ir.CallInst) bool {
+	if len(call.Args) != len(decl.Params) {
+		return false
+	}
+	for i, param := range decl.Params {
+		if ir.TypeOf(call.Args[i]).Size() != param.Type.Size() {
+			return false
+		}
+	}
+	return true
+}
+
+// assignParams returns copies of the arguments into the parameters.
+//
+// An argument that reads a parameter that is assigned before it is first
+// copied to a temporary, so it isn't overwritten.
+func assignParams(decl *ir.FuncDecl, args []ir.Value, fresh func(string) string) []ir.Inst {
+	index := make(map[string]int)
+	for i, param := range decl.Params {
+		index[param.V] = i
+	}
+
+	var insts []ir.Inst
+	values := append([]ir.Value(nil), args...)
+	for i, arg := range args {
+		v, ok := arg.(*ir.VarValue)
+		if !ok {
+			continue
+		}
+		if j, ok := index[v.V]; ok && j < i {
+			tmp := &ir.VarValue{
+				V:    fresh("tmp.tc"),
+				Type: v.Type,
+			}
+			insts = append(insts, &ir.CopyInst{
+				L: arg,
+				R: tmp,
+			})
+			values[i] = tmp
+		}
+	}
+	for i, param := range decl.Params {
+		if v, ok := values[i].(*ir.VarValue); ok && v.V == param.V {
+			continue
+		}
+		insts = append(insts, &ir.CopyInst{
+			L: values[i],
+			R: param,
+		})
+	}
+	return insts
+}
@@ -0,0 +1,163 @@
+package opt_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/opt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEliminateTailCalls(t *testing.T) {
+	tests := []struct {
+		Name string
+		IR   string
+		Want string
+	}{
+		{
+			Name: "sibling",
+			IR: `global fn f(i32 n) {
+	tmp.1 = add i32 n, 1
+	tmp.2 = call i32 g(i32 tmp.1)
+	ret i32 tmp.2
+}
+`,
+			Want: `global fn f(i32 n) {
+	tmp.1 = add i32 n, 1
+	tailcall g(i32 tmp.1)
+}
+`,
+		},
+		{
+			Name: "self",
+			IR: `global fn swap(i32 a, i32 b, i32 n) {
+	jz i32 n, end.1
+	tmp.1 = sub i32 n, 1
+	tmp.2 = call i32 swap(i32 b, i32 a, i32 tmp.1)
+	ret i32 tmp.2
+end.1:
+	ret i32 a
+}
+`,
+			// b is assigned before it's read, so a copy of a is kept.
+			Want: `global fn swap(i32 a, i32 b, i32 n) {
+swap.start.1:
+	jz i32 n, end.1
+	tmp.1 = sub i32 n, 1
+	tmp.tc.1 = copy i32 a
+	a = copy i32 b
+	b = copy i32 tmp.tc.1
+	n = copy i32 tmp.1
+	jmp swap.start.1
+end.1:
+	ret i32 a
+}
+`,
+		},
+		{
+			Name: "after label",
+			IR: `global fn f(i32 n) {
+	jz i32 n, end.1
+	tmp.1 = call i32 g(i32 n)
+end.1:
+	ret i32 tmp.1
+}
+`,
+			// The return is still reached by the jump.
+			Want: `global fn f(i32 n) {
+	jz i32 n, end.1
+	tailcall g(i32 n)
+end.1:
+	ret i32 tmp.1
+}
+`,
+		},
+		{
+			Name: "result used",
+			IR: `global fn f(i32 n) {
+	tmp.1 = call i32 g(i32 n)
+	tmp.2 = add i32 tmp.1, 1
+	ret i32 tmp.2
+}
+`,
+		},
+		{
+			Name: "stack arguments",
+			IR: `global fn f(i32 n) {
+	tmp.1 = call i32 g(i32 n, i32 n, i32 n, i32 n, i32 n, i32 n, i32 n)
+	ret i32 tmp.1
+}
+`,
+		},
+		{
+			Name: "extended result",
+			IR: `global fn f(i32 n) {
+	tmp.1 = call i32 g(i32 n)
+	tmp.2 = sext i32 tmp.1 to i64
+	ret i64 tmp.2
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			file, err := ir.ParseText([]byte(tt.IR), false)
+			require.NoError(t, err)
+
+			want := tt.Want
+			if want == "" {
+				want = tt.IR
+			}
+			assert.Equal(t, tt.Want != "", opt.EliminateTailCalls(file))
+			assert.Equal(t, want, ir.Format(file))
+		})
+	}
+}
+
+func TestEliminateTailCallsDeepRecursion(t *testing.T) {
+	file, err := ir.ParseText([]byte(`global fn sum(i32 n, i32 acc) {
+	jz i32 n, end.1
+	tmp.1 = sub i32 n, 1
+	tmp.2 = add i32 acc, n
+	tmp.3 = call i32 sum(i32 tmp.1, i32 tmp.2)
+	ret i32 tmp.3
+end.1:
+	ret i32 acc
+}
+
+global fn isEven(i32 n) {
+	jz i32 n, end.2
+	tmp.4 = sub i32 n, 1
+	tmp.5 = call i32 isOdd(i32 tmp.4)
+	ret i32 tmp.5
+end.2:
+	ret i32 1
+}
+
+global fn isOdd(i32 n) {
+	jz i32 n, end.3
+	tmp.6 = sub i32 n, 1
+	tmp.7 = call i32 isEven(i32 tmp.6)
+	ret i32 tmp.7
+end.3:
+	ret i32 0
+}
+`), false)
+	require.NoError(t, err)
+
+	// Without tail calls the recursion overflows the stack.
+	_, err = ir.Interpret(file, "sum", []int64{100000, 0})
+	assert.Error(t, err)
+
+	assert.True(t, opt.EliminateTailCalls(file))
+
+	result, err := ir.Interpret(file, "sum", []int64{100000, 0})
+	require.NoError(t, err)
+	assert.Equal(t, int64(705082704), result)
+
+	result, err = ir.Interpret(file, "isEven", []int64{100001})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), result)
+}
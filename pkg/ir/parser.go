@@ -14,19 +14,71 @@ func Parse(root ast.Node, debug bool) (n Node, err error) {
 }
 
 type parser struct {
-	counter int
+	names
+
+	// funcs contains the names of the functions declared in the file, to
+	// distinguish function designators from variables. The value is
+	// whether the function has external linkage.
+	funcs map[string]bool
+	// inline contains the inline hint of each function, from the
+	// attributes of all of its declarations.
+	inline map[string]InlineHint
+
+	// statics contains the variables with static storage duration, in the
+	// order they are first declared.
+	statics     []*StaticVarDecl
+	staticNames map[string]*StaticVarDecl
+
+	// locals contains the local variables declared in the current
+	// function.
+	locals []*VarValue
 }
 
 func newParser(debug bool) *parser {
-	return &parser{}
+	return &parser{
+		funcs:       make(map[string]bool),
+		inline:      make(map[string]InlineHint),
+		staticNames: make(map[string]*StaticVarDecl),
+	}
 }
 
 func (p *parser) parse(n ast.Node) Node {
 	switch v := n.(type) {
 	case *ast.File:
+		// Declare every function and file scope variable first. The
+		// validator has checked the declarations of each name agree, so the
+		// linkage is taken from the first declaration.
+		for _, decl := range v.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if _, ok := p.funcs[decl.Name]; !ok {
+					p.funcs[decl.Name] = decl.Storage != ast.StorageStatic
+				}
+				for _, attr := range decl.Attrs {
+					switch attr {
+					case "inline":
+						p.inline[decl.Name] = InlineAlways
+					case "noinline":
+						p.inline[decl.Name] = InlineNever
+					}
+				}
+			case *ast.VarDecl:
+				p.declareStatic(decl)
+			}
+		}
+
 		var decls []Decl
 		for _, decl := range v.Decls {
-			decls = append(decls, p.parseFuncDecl(decl.(*ast.FuncDecl)))
+			// Only function definitions generate code, since typedefs and
+			// enums are resolved during validation.
+			if decl, ok := decl.(*ast.FuncDecl); ok && decl.Body != nil {
+				decls = append(decls, p.parseFuncDecl(decl))
+			}
+		}
+		// Static variables include those declared in function bodies, so
+		// are added after the functions.
+		for _, decl := range p.statics {
+			decls = append(decls, decl)
 		}
 		return &File{
 			Decls: decls,
@@ -52,6 +104,10 @@ func (p *parser) parseExpr(expr ast.Expr) (Value, []Inst) {
 		return p.parseCallExpr(expr)
 	case *ast.BasicLitExpr:
 		return p.parseBasicLitExpr(expr)
+	case *ast.CastExpr:
+		return p.parseCastExpr(expr)
+	case *ast.AddrOfExpr:
+		return p.parseExpr(expr.Expr)
 	default:
 		panic("unsupported expr type")
 	}
@@ -60,7 +116,8 @@ func (p *parser) parseExpr(expr ast.Expr) (Value, []Inst) {
 func (p *parser) parseUnaryExpr(e *ast.UnaryExpr) (Value, []Inst) {
 	src, insts := p.parseExpr(e.Expr)
 	dest := &VarValue{
-		V: p.nextVar(),
+		V:    p.nextVar(),
+		Type: irType(e.Type),
 	}
 	insts = append(insts, &UnaryInst{
 		Op:   e.Op,
@@ -91,14 +148,16 @@ func (p *parser) parseBinaryExpr(e *ast.BinaryExpr) (Value, []Inst) {
 			Label: falseLabel,
 		})
 
-		dest := p.nextVar()
+		dest := &VarValue{
+			V:    p.nextVar(),
+			Type: Int32,
+		}
 		insts = append(insts, &CopyInst{
 			L: &ConstValue{
-				V: "1",
-			},
-			R: &VarValue{
-				V: dest,
+				V:    "1",
+				Type: Int32,
 			},
+			R: dest,
 		})
 		insts = append(insts, &JumpInst{
 			Label: endLabel,
@@ -109,19 +168,16 @@ func (p *parser) parseBinaryExpr(e *ast.BinaryExpr) (Value, []Inst) {
 		})
 		insts = append(insts, &CopyInst{
 			L: &ConstValue{
-				V: "0",
-			},
-			R: &VarValue{
-				V: dest,
+				V:    "0",
+				Type: Int32,
 			},
+			R: dest,
 		})
 		insts = append(insts, &LabelInst{
 			Name: endLabel,
 		})
 
-		return &VarValue{
-			V: dest,
-		}, insts
+		return dest, insts
 	} else if e.Op == token.LOR {
 		trueLabel := p.nextLabel("or_true")
 		endLabel := p.nextLabel("or_end")
@@ -142,14 +198,16 @@ func (p *parser) parseBinaryExpr(e *ast.BinaryExpr) (Value, []Inst) {
 			Label: trueLabel,
 		})
 
-		dest := p.nextVar()
+		dest := &VarValue{
+			V:    p.nextVar(),
+			Type: Int32,
+		}
 		insts = append(insts, &CopyInst{
 			L: &ConstValue{
-				V: "0",
-			},
-			R: &VarValue{
-				V: dest,
+				V:    "0",
+				Type: Int32,
 			},
+			R: dest,
 		})
 		insts = append(insts, &JumpInst{
 			Label: endLabel,
@@ -160,25 +218,23 @@ func (p *parser) parseBinaryExpr(e *ast.BinaryExpr) (Value, []Inst) {
 		})
 		insts = append(insts, &CopyInst{
 			L: &ConstValue{
-				V: "0",
-			},
-			R: &VarValue{
-				V: dest,
+				V:    "1",
+				Type: Int32,
 			},
+			R: dest,
 		})
 		insts = append(insts, &LabelInst{
 			Name: endLabel,
 		})
 
-		return &VarValue{
-			V: dest,
-		}, insts
+		return dest, insts
 	}
 
 	v1, insts1 := p.parseExpr(e.L)
 	v2, insts2 := p.parseExpr(e.R)
 	dest := &VarValue{
-		V: p.nextVar(),
+		V:    p.nextVar(),
+		Type: irType(e.Type),
 	}
 
 	insts := append(insts1, insts2...)
@@ -192,8 +248,21 @@ func (p *parser) parseBinaryExpr(e *ast.BinaryExpr) (Value, []Inst) {
 }
 
 func (p *parser) parseVarExpr(e *ast.VarExpr) (Value, []Inst) {
+	if p.isFunc(e.Name) {
+		// A function designator evaluates to the function's address.
+		dest := &VarValue{
+			V:    p.nextVar(),
+			Type: Uint64,
+		}
+		return dest, []Inst{&GetAddressInst{
+			Name: e.Name,
+			Dest: dest,
+		}}
+	}
+
 	return &VarValue{
-		V: e.Name,
+		V:    e.Name,
+		Type: irType(e.Type),
 	}, nil
 }
 
@@ -201,7 +270,8 @@ func (p *parser) parseAssignExpr(e *ast.AssignExpr) (Value, []Inst) {
 	name := e.L.(*ast.VarExpr).Name
 	dest, insts := p.parseExpr(e.R)
 	v := &VarValue{
-		V: name,
+		V:    name,
+		Type: irType(e.Type),
 	}
 	insts = append(insts, &CopyInst{
 		L: dest,
@@ -212,7 +282,8 @@ func (p *parser) parseAssignExpr(e *ast.AssignExpr) (Value, []Inst) {
 
 func (p *parser) parseCallExpr(e *ast.CallExpr) (Value, []Inst) {
 	dest := &VarValue{
-		V: p.nextVar(),
+		V:    p.nextVar(),
+		Type: irType(e.Type),
 	}
 
 	var argInsts []Inst
@@ -224,8 +295,21 @@ func (p *parser) parseCallExpr(e *ast.CallExpr) (Value, []Inst) {
 		argVals = append(argVals, val)
 	}
 
-	insts := append(argInsts, &CallInst{
-		Name: e.Func,
+	// Call functions directly by name where possible, rather than loading
+	// the function's address.
+	if v, ok := e.Func.(*ast.VarExpr); ok && p.isFunc(v.Name) {
+		insts := append(argInsts, &CallInst{
+			Name: v.Name,
+			Args: argVals,
+			Dest: dest,
+		})
+		return dest, insts
+	}
+
+	f, insts := p.parseExpr(e.Func)
+	insts = append(insts, argInsts...)
+	insts = append(insts, &CallIndirectInst{
+		Func: f,
 		Args: argVals,
 		Dest: dest,
 	})
@@ -234,10 +318,56 @@ func (p *parser) parseCallExpr(e *ast.CallExpr) (Value, []Inst) {
 
 func (p *parser) parseBasicLitExpr(e *ast.BasicLitExpr) (Value, []Inst) {
 	return &ConstValue{
-		V: e.Value,
+		V:    e.Value,
+		Type: irType(e.Type),
 	}, nil
 }
 
+func (p *parser) parseCastExpr(e *ast.CastExpr) (Value, []Inst) {
+	src, insts := p.parseExpr(e.Expr)
+
+	srcType := TypeOf(src)
+	destType := irType(e.T)
+	if srcType == destType {
+		return src, insts
+	}
+
+	dest := &VarValue{
+		V:    p.nextVar(),
+		Type: destType,
+	}
+	return dest, append(insts, conversion(src, dest))
+}
+
+// conversion returns the instruction that converts src to the type of
+// dest, which must differ from the type of src.
+func conversion(src Value, dest *VarValue) Inst {
+	srcType := TypeOf(src)
+	switch {
+	case dest.Type.Size() == srcType.Size():
+		// Only the signedness changes so the bits are the same.
+		return &CopyInst{
+			L: src,
+			R: dest,
+		}
+	case dest.Type.Size() < srcType.Size():
+		return &TruncateInst{
+			Src:  src,
+			Dest: dest,
+		}
+	case srcType.Signed():
+		return &SignExtendInst{
+			Src:  src,
+			Dest: dest,
+		}
+	default:
+		return &ZeroExtendInst{
+			Src:  src,
+			Dest: dest,
+		}
+	}
+}
+
 // Statements.
 
 func (p *parser) parseStmt(stmt ast.Stmt) []Inst {
@@ -347,34 +477,137 @@ func (p *parser) parseLoopStmt(stmt *ast.LoopStmt) []Inst {
 func (p *parser) parseDecl(decl ast.Decl) []Inst {
 	switch decl := decl.(type) {
 	case *ast.VarDecl:
+		if decl.Storage != ast.StorageNone {
+			// Static and extern variables aren't stored on the stack, and
+			// static variables are only initialized once.
+			p.declareStatic(decl)
+			return nil
+		}
+		p.locals = append(p.locals, &VarValue{
+			V:    decl.Name,
+			Type: irType(decl.Type),
+		})
+		if decl.Expr == nil {
+			return nil
+		}
+
 		_, insts := p.parseExpr(&ast.AssignExpr{
 			L: &ast.VarExpr{
 				Name: decl.Name,
+				Type: decl.Type,
 			},
-			R: decl.Expr,
+			R:    decl.Expr,
+			Type: decl.Type,
 		})
 		return insts
+	case *ast.TypedefDecl, *ast.EnumDecl:
+		return nil
 	default:
 		panic("unsupported decl type")
 	}
 }
 
 func (p *parser) parseFuncDecl(decl *ast.FuncDecl) Decl {
+	var params []*VarValue
+	for _, param := range decl.Type.Params {
+		params = append(params, &VarValue{
+			V:    param.Name,
+			Type: irType(param.Type),
+		})
+	}
+
+	p.locals = nil
+	insts := p.parseBlockStmt(decl.Body)
+	// Falling off the end of a function returns zero, as main does in C.
+	if len(insts) == 0 || !IsTerminator(insts[len(insts)-1]) {
+		insts = append(insts, &RetInst{
+			Value: NewConst(0, irType(decl.Type.Result)),
+		})
+	}
+
 	return &FuncDecl{
 		Name:   decl.Name,
-		Params: decl.Type.Params,
-		Insts:  p.parseBlockStmt(decl.Body),
+		Global: p.funcs[decl.Name],
+		Inline: p.inline[decl.Name],
+		Params: params,
+		Locals: p.locals,
+		Insts:  insts,
 	}
 }
 
-func (p *parser) nextVar() string {
-	s := fmt.Sprintf("tmp.%d", p.counter)
-	p.counter++
+func (p *parser) isFunc(name string) bool {
+	_, ok := p.funcs[name]
+	return ok
+}
+
+// declareStatic adds a declaration of a variable with static storage
+// duration, either at file scope or a static or extern local.
+func (p *parser) declareStatic(decl *ast.VarDecl) {
+	v, ok := p.staticNames[decl.Name]
+	if !ok {
+		v = &StaticVarDecl{
+			Name:   decl.Name,
+			Global: decl.Storage != ast.StorageStatic,
+			Type:   irType(decl.Type),
+		}
+		p.statics = append(p.statics, v)
+		p.staticNames[decl.Name] = v
+	}
+
+	switch {
+	case decl.Expr != nil:
+		// The type checker replaces static initializers with literals.
+		v.Init = &ConstValue{
+			V:    decl.Expr.(*ast.BasicLitExpr).Value,
+			Type: v.Type,
+		}
+	case decl.Storage != ast.StorageExtern && v.Init == nil:
+		// A declaration without an initializer is a tentative definition,
+		// which is initialized to zero unless defined elsewhere in the
+		// file.
+		v.Init = &ConstValue{
+			V:    "0",
+			Type: v.Type,
+		}
+	}
+}
+
+// irType maps an AST type to the IR type with the same size and
+// signedness.
+func irType(t ast.Type) Type {
+	switch t.(type) {
+	case *ast.CharType:
+		return Int8
+	case *ast.UCharType:
+		return Uint8
+	case *ast.IntType, *ast.EnumType:
+		return Int32
+	case *ast.UIntType:
+		return Uint32
+	case *ast.LongType:
+		return Int64
+	case *ast.ULongType, *ast.FuncType:
+		// Function pointers are 64-bit addresses.
+		return Uint64
+	default:
+		panic("unsupported type")
+	}
+}
+
+// names generates the names of temporaries and labels, which are unique
+// within a file.
+type names struct {
+	counter int
+}
+
+func (n *names) nextVar() string {
+	s := fmt.Sprintf("tmp.%d", n.counter)
+	n.counter++
 	return s
 }
 
-func (p *parser) nextLabel(name string) string {
-	s := fmt.Sprintf("%s.%d", name, p.counter)
-	p.counter++
+func (n *names) nextLabel(name string) string {
+	s := fmt.Sprintf("%s.%d", name, n.counter)
+	n.counter++
 	return s
 }
@@ -0,0 +1,122 @@
+package ssa
+
+import (
+	"github.com/andydunstall/minc/pkg/ir/cfg"
+)
+
+// DomTree is the dominator tree of a control-flow graph. Block a dominates
+// block b if every path from the entry block to b passes through a.
+type DomTree struct {
+	// IDom maps each block to its immediate dominator, the closest block
+	// that strictly dominates it. The entry block has no immediate
+	// dominator.
+	IDom map[*cfg.Block]*cfg.Block
+	// Children maps each block to the blocks it immediately dominates.
+	Children map[*cfg.Block][]*cfg.Block
+
+	// order maps each block to its index in reverse post-order.
+	order map[*cfg.Block]int
+}
+
+// Dominators computes the dominator tree of the blocks reachable from the
+// entry block, using the iterative algorithm by Cooper, Harvey and Kennedy.
+func Dominators(g *cfg.Graph) *DomTree {
+	rpo := append([]*cfg.Block{g.Entry}, g.ReversePostOrder()...)
+
+	t := &DomTree{
+		IDom:     make(map[*cfg.Block]*cfg.Block),
+		Children: make(map[*cfg.Block][]*cfg.Block),
+		order:    make(map[*cfg.Block]int),
+	}
+	for i, b := range rpo {
+		t.order[b] = i
+	}
+
+	t.IDom[g.Entry] = g.Entry
+	for changed := true; changed; {
+		changed = false
+		for _, b := range rpo[1:] {
+			var idom *cfg.Block
+			for _, pred := range b.Preds {
+				if _, ok := t.IDom[pred]; !ok {
+					// The predecessor hasn't been processed yet, or is
+					// unreachable.
+					continue
+				}
+				if idom == nil {
+					idom = pred
+				} else {
+					idom = t.intersect(pred, idom)
+				}
+			}
+			if t.IDom[b] != idom {
+				t.IDom[b] = idom
+				changed = true
+			}
+		}
+	}
+	delete(t.IDom, g.Entry)
+
+	for _, b := range rpo[1:] {
+		idom := t.IDom[b]
+		t.Children[idom] = append(t.Children[idom], b)
+	}
+	return t
+}
+
+// Dominates returns whether block a dominates block b. Every block
+// dominates itself.
+func (t *DomTree) Dominates(a, b *cfg.Block) bool {
+	for b != nil {
+		if a == b {
+			return true
+		}
+		b = t.IDom[b]
+	}
+	return false
+}
+
+// intersect returns the closest common dominator of the two blocks.
+func (t *DomTree) intersect(b1, b2 *cfg.Block) *cfg.Block {
+	for b1 != b2 {
+		for t.order[b1] > t.order[b2] {
+			b1 = t.IDom[b1]
+		}
+		for t.order[b2] > t.order[b1] {
+			b2 = t.IDom[b2]
+		}
+	}
+	return b1
+}
+
+// Frontiers returns the dominance frontier of each block: the blocks where
+// its dominance ends, as they have a predecessor it dominates but aren't
+// strictly dominated by it. This is where a variable assigned in the block
+// may need a phi node.
+func Frontiers(g *cfg.Graph, t *DomTree) map[*cfg.Block][]*cfg.Block {
+	frontiers := make(map[*cfg.Block][]*cfg.Block)
+	for _, b := range g.ReversePostOrder() {
+		if len(b.Preds) < 2 {
+			continue
+		}
+		for _, pred := range b.Preds {
+			runner := pred
+			for runner != nil && runner != t.IDom[b] {
+				if !containsBlock(frontiers[runner], b) {
+					frontiers[runner] = append(frontiers[runner], b)
+				}
+				runner = t.IDom[runner]
+			}
+		}
+	}
+	return frontiers
+}
+
+func containsBlock(blocks []*cfg.Block, b *cfg.Block) bool {
+	for _, block := range blocks {
+		if block == b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package ssa
+
+import (
+	"github.com/andydunstall/minc/pkg/ir/cfg"
+)
+
+// Loop is a natural loop: a header block that dominates every block in the
+// loop, and the blocks that can reach a back edge to the header without
+// passing through the header.
+type Loop struct {
+	Header *cfg.Block
+	Blocks map[*cfg.Block]bool
+}
+
+// Exits returns the blocks in the loop with a successor outside the loop.
+func (l *Loop) Exits() []*cfg.Block {
+	var exits []*cfg.Block
+	for b := range l.Blocks {
+		for _, succ := range b.Succs {
+			if !l.Blocks[succ] {
+				exits = append(exits, b)
+				break
+			}
+		}
+	}
+	return exits
+}
+
+// FindLoops returns the natural loops in the graph, found from its back
+// edges: edges to a block that dominates the edge's source. Back edges to
+// the same header are merged into one loop.
+//
+// Loops are ordered so inner loops come before the loops containing them.
+func FindLoops(g *cfg.Graph, dom *DomTree) []*Loop {
+	var loops []*Loop
+	headers := make(map[*cfg.Block]*Loop)
+	for _, b := range g.ReversePostOrder() {
+		for _, succ := range b.Succs {
+			if !dom.Dominates(succ, b) {
+				continue
+			}
+
+			loop, ok := headers[succ]
+			if !ok {
+				loop = &Loop{
+					Header: succ,
+					Blocks: map[*cfg.Block]bool{succ: true},
+				}
+				headers[succ] = loop
+				loops = append(loops, loop)
+			}
+
+			// Walk backwards from the back edge until reaching the header.
+			worklist := []*cfg.Block{b}
+			for len(worklist) > 0 {
+				block := worklist[len(worklist)-1]
+				worklist = worklist[:len(worklist)-1]
+				if loop.Blocks[block] {
+					continue
+				}
+				loop.Blocks[block] = true
+				worklist = append(worklist, block.Preds...)
+			}
+		}
+	}
+
+	// A loop nested in another has fewer blocks, so sorting by size puts
+	// inner loops first.
+	for i := 1; i < len(loops); i++ {
+		for j := i; j > 0 && len(loops[j].Blocks) < len(loops[j-1].Blocks); j-- {
+			loops[j], loops[j-1] = loops[j-1], loops[j]
+		}
+	}
+	return loops
+}
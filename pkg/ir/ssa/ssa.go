@@ -0,0 +1,474 @@
+// Package ssa converts IR functions to and from static single assignment
+// form, where each variable is assigned exactly once.
+//
+// Where different assignments of a variable reach the same block, a phi node
+// at the start of the block selects the value from the predecessor the block
+// was entered from. As the IR has no phi instruction, the phi nodes are kept
+// alongside the control-flow graph, and Destruct replaces them with copies.
+package ssa
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/cfg"
+)
+
+// Phi assigns Dest the argument for the predecessor the block was entered
+// from, where Args[i] is the value from the block's i'th predecessor.
+type Phi struct {
+	Dest *ir.VarValue
+	Args []ir.Value
+
+	// v is the name of the variable before renaming.
+	v string
+}
+
+// Func is a function in SSA form.
+type Func struct {
+	Decl  *ir.FuncDecl
+	Graph *cfg.Graph
+	Phis  map[*cfg.Block][]*Phi
+	Dom   *DomTree
+
+	// names contains every variable name used in the function, so new
+	// names don't clash.
+	names map[string]bool
+}
+
+// Build converts the function to SSA form. The function's instructions are
+// modified in place, so the declaration must not be used until Destruct.
+//
+// Static variables may be assigned by other functions so aren't renamed.
+func Build(decl *ir.FuncDecl, statics map[string]bool) *Func {
+	insts := removeFallthroughJumps(decl.Insts)
+	g := cfg.New(insts)
+	if len(g.Blocks) > 0 && len(g.Blocks[0].Preds) > 1 {
+		// Phi nodes need a predecessor to copy into, so the first block
+		// can't be the target of a jump.
+		g = cfg.New(append([]ir.Inst{&ir.LabelInst{
+			Name: "block.entry",
+		}}, insts...))
+	}
+	reachable := g.Reachable()
+	for _, b := range append([]*cfg.Block(nil), g.Blocks...) {
+		if !reachable[b] {
+			g.RemoveBlock(b)
+		}
+	}
+
+	f := &Func{
+		Decl:  decl,
+		Graph: g,
+		Phis:  make(map[*cfg.Block][]*Phi),
+		Dom:   Dominators(g),
+		names: make(map[string]bool),
+	}
+
+	// Find the blocks that assign each variable.
+	defs := make(map[string][]*cfg.Block)
+	types := make(map[string]ir.Type)
+	for _, param := range decl.Params {
+		f.names[param.V] = true
+	}
+	for _, b := range g.Blocks {
+		for _, inst := range b.Insts {
+			for _, use := range ir.Uses(inst) {
+				if v, ok := (*use).(*ir.VarValue); ok {
+					f.names[v.V] = true
+				}
+			}
+			if def := ir.Def(inst); def != nil {
+				f.names[def.V] = true
+				if !statics[def.V] {
+					defs[def.V] = append(defs[def.V], b)
+					types[def.V] = def.Type
+				}
+			}
+		}
+	}
+
+	f.insertPhis(defs, types)
+
+	r := &renamer{
+		f:       f,
+		stacks:  make(map[string][]string),
+		counts:  make(map[string]int),
+		statics: statics,
+	}
+	// Parameters keep their names, as the value on entry.
+	for _, param := range decl.Params {
+		r.stacks[param.V] = []string{param.V}
+	}
+	r.rename(g.Entry)
+
+	return f
+}
+
+// Destruct converts the function out of SSA form, replacing each phi node
+// with copies at the end of its block's predecessors, and updates the
+// function's instructions.
+//
+// The copies for a predecessor happen in parallel, so are ordered to avoid
+// overwriting a variable another copy still needs to read. Where an edge
+// leaves a block with multiple successors, the edge is split so the copies
+// only run when taking that edge.
+func (f *Func) Destruct() {
+	for _, b := range append([]*cfg.Block(nil), f.Graph.Blocks...) {
+		phis := f.Phis[b]
+		if len(phis) == 0 {
+			continue
+		}
+		for i := range b.Preds {
+			pred := b.Preds[i]
+			if len(pred.Succs) > 1 {
+				pred = f.Graph.SplitEdge(pred, b)
+			}
+
+			var copies []*ir.CopyInst
+			for _, phi := range phis {
+				copies = append(copies, &ir.CopyInst{
+					L: phi.Args[i],
+					R: phi.Dest,
+				})
+			}
+			insertBeforeTerminator(pred, f.sequentialize(copies))
+		}
+	}
+
+	f.Phis = make(map[*cfg.Block][]*Phi)
+	f.Decl.Insts = f.Graph.Flatten()
+}
+
+// InsertPreheader adds a block that the edges from preds to the header
+// lead to instead, so it runs once before entering the loop, and returns the
+// block.
+//
+// Where the header's phi nodes take different values from preds, the
+// preheader gets a phi node selecting the value, which the header's phi
+// node then reads. The dominator tree must be recomputed afterwards.
+func (f *Func) InsertPreheader(header *cfg.Block, preds []*cfg.Block) *cfg.Block {
+	headerPreds := append([]*cfg.Block(nil), header.Preds...)
+	preheader := f.Graph.MergeEdges(preds, header)
+
+	counts := make(map[string]int)
+	for _, phi := range f.Phis[header] {
+		var outside []ir.Value
+		for _, pred := range preds {
+			outside = append(outside, phi.Args[indexOf(headerPreds, pred)])
+		}
+		v := outside[0]
+		for _, arg := range outside[1:] {
+			if ir.Format(arg) != ir.Format(v) {
+				dest := &ir.VarValue{
+					V:    f.newName(phi.v, counts),
+					Type: phi.Dest.Type,
+				}
+				f.Phis[preheader] = append(f.Phis[preheader], &Phi{
+					Dest: dest,
+					Args: outside,
+					v:    phi.v,
+				})
+				v = dest
+				break
+			}
+		}
+
+		// The preheader replaces the first of preds.
+		var args []ir.Value
+		for i, pred := range headerPreds {
+			switch {
+			case pred == preds[0]:
+				args = append(args, v)
+			case !slices.Contains(preds, pred):
+				args = append(args, phi.Args[i])
+			}
+		}
+		phi.Args = args
+	}
+	return preheader
+}
+
+// Format returns the textual form of the function, with phi nodes after
+// the label of their block. Phi arguments are in the order of the block's
+// predecessors.
+func Format(f *Func) string {
+	var s strings.Builder
+	s.WriteString(strings.SplitAfter(ir.Format(&ir.FuncDecl{
+		Name:   f.Decl.Name,
+		Global: f.Decl.Global,
+		Params: f.Decl.Params,
+	}), "\n")[0])
+
+	for _, b := range f.Graph.Blocks {
+		insts := b.Insts
+		if b.Label() != "" {
+			s.WriteString(ir.Format(insts[0]) + "\n")
+			insts = insts[1:]
+		}
+		for _, phi := range f.Phis[b] {
+			var args []string
+			for _, arg := range phi.Args {
+				args = append(args, ir.Format(arg))
+			}
+			fmt.Fprintf(&s, "\t%s = phi %s %s\n", phi.Dest.V, phi.Dest.Type, strings.Join(args, ", "))
+		}
+		for _, inst := range insts {
+			s.WriteString("\t" + ir.Format(inst) + "\n")
+		}
+	}
+	s.WriteString("}\n")
+	return s.String()
+}
+
+// FormatFile returns the textual form of the file with each function in SSA
+// form, without changing the file.
+func FormatFile(file *ir.File) string {
+	// Building SSA form modifies the function, so build it from a copy.
+	file = ir.Clone(file)
+	statics := ir.StaticVars(file)
+	var decls []string
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ir.FuncDecl); ok {
+			decls = append(decls, Format(Build(decl, statics)))
+		} else {
+			decls = append(decls, ir.Format(decl))
+		}
+	}
+	return strings.Join(decls, "\n")
+}
+
+// insertPhis adds phi nodes for each variable at the blocks in the iterated
+// dominance frontier of the blocks that assign it. Phi nodes are only added
+// where the variable is live, as otherwise the phi node would be unused.
+func (f *Func) insertPhis(defs map[string][]*cfg.Block, types map[string]ir.Type) {
+	frontiers := Frontiers(f.Graph, f.Dom)
+	liveness := cfg.ComputeLiveness(f.Graph)
+
+	// Visit variables in the order they're first assigned, so the order of
+	// phi nodes is deterministic.
+	var vars []string
+	seen := make(map[string]bool)
+	for _, b := range f.Graph.Blocks {
+		for _, inst := range b.Insts {
+			if def := ir.Def(inst); def != nil && !seen[def.V] && defs[def.V] != nil {
+				seen[def.V] = true
+				vars = append(vars, def.V)
+			}
+		}
+	}
+
+	for _, v := range vars {
+		hasPhi := make(map[*cfg.Block]bool)
+		worklist := append([]*cfg.Block(nil), defs[v]...)
+		for len(worklist) > 0 {
+			b := worklist[len(worklist)-1]
+			worklist = worklist[:len(worklist)-1]
+
+			for _, df := range frontiers[b] {
+				if hasPhi[df] || df == f.Graph.Exit || !liveness.In[df][v] {
+					continue
+				}
+				hasPhi[df] = true
+				f.Phis[df] = append(f.Phis[df], &Phi{
+					Dest: &ir.VarValue{
+						V:    v,
+						Type: types[v],
+					},
+					Args: make([]ir.Value, len(df.Preds)),
+					v:    v,
+				})
+				// The phi node is itself an assignment of the variable.
+				worklist = append(worklist, df)
+			}
+		}
+	}
+}
+
+// newName returns a variable name based on v that isn't used in the
+// function.
+func (f *Func) newName(v string, counts map[string]int) string {
+	for {
+		counts[v]++
+		name := fmt.Sprintf("%s.%d", v, counts[v])
+		if !f.names[name] {
+			f.names[name] = true
+			return name
+		}
+	}
+}
+
+// sequentialize orders parallel copies so each copy reads its source before
+// it is overwritten. Where copies form a cycle, such as swapping two
+// variables, a temporary breaks the cycle.
+func (f *Func) sequentialize(copies []*ir.CopyInst) []ir.Inst {
+	var pending []*ir.CopyInst
+	for _, copy := range copies {
+		if ir.Format(copy.L) != ir.Format(copy.R) {
+			pending = append(pending, copy)
+		}
+	}
+
+	var insts []ir.Inst
+	counts := make(map[string]int)
+	for len(pending) > 0 {
+		// Emit a copy whose destination no other pending copy reads.
+		emitted := false
+		for i, copy := range pending {
+			if !readsVar(pending, copy.R.(*ir.VarValue).V) {
+				insts = append(insts, copy)
+				pending = append(pending[:i], pending[i+1:]...)
+				emitted = true
+				break
+			}
+		}
+		if emitted {
+			continue
+		}
+
+		// Every destination is read by another copy, so the copies form
+		// a cycle. Save the first destination to a temporary and read the
+		// temporary instead.
+		dest := pending[0].R.(*ir.VarValue)
+		tmp := &ir.VarValue{
+			V:    f.newName("tmp.ssa", counts),
+			Type: dest.Type,
+		}
+		insts = append(insts, &ir.CopyInst{
+			L: dest,
+			R: tmp,
+		})
+		for _, copy := range pending {
+			if v, ok := copy.L.(*ir.VarValue); ok && v.V == dest.V {
+				copy.L = tmp
+			}
+		}
+	}
+	return insts
+}
+
+func readsVar(copies []*ir.CopyInst, name string) bool {
+	for _, copy := range copies {
+		if v, ok := copy.L.(*ir.VarValue); ok && v.V == name {
+			return true
+		}
+	}
+	return false
+}
+
+type renamer struct {
+	f *Func
+	// stacks maps each variable to the names of its assignments that
+	// dominate the current block, with the closest last.
+	stacks map[string][]string
+	counts map[string]int
+	// statics contains the static variables, which aren't renamed.
+	statics map[string]bool
+}
+
+func (r *renamer) rename(b *cfg.Block) {
+	var pushed []string
+	push := func(v string) string {
+		name := r.f.newName(v, r.counts)
+		r.stacks[v] = append(r.stacks[v], name)
+		pushed = append(pushed, v)
+		return name
+	}
+
+	for _, phi := range r.f.Phis[b] {
+		phi.Dest = &ir.VarValue{
+			V:    push(phi.v),
+			Type: phi.Dest.Type,
+		}
+	}
+	for _, inst := range b.Insts {
+		for _, use := range ir.Uses(inst) {
+			if v, ok := (*use).(*ir.VarValue); ok {
+				*use = r.current(v)
+			}
+		}
+		if def := ir.Def(inst); def != nil && !r.statics[def.V] {
+			ir.SetDef(inst, &ir.VarValue{
+				V:    push(def.V),
+				Type: def.Type,
+			})
+		}
+	}
+
+	for _, succ := range b.Succs {
+		i := indexOf(succ.Preds, b)
+		for _, phi := range r.f.Phis[succ] {
+			phi.Args[i] = r.current(&ir.VarValue{
+				V:    phi.v,
+				Type: phi.Dest.Type,
+			})
+		}
+	}
+
+	for _, child := range r.f.Dom.Children[b] {
+		r.rename(child)
+	}
+
+	for _, v := range pushed {
+		r.stacks[v] = r.stacks[v][:len(r.stacks[v])-1]
+	}
+}
+
+// current returns the closest assignment of the variable that dominates
+// the current point. If there is none the variable is used before being
+// assigned, so the original name is kept.
+func (r *renamer) current(v *ir.VarValue) ir.Value {
+	stack := r.stacks[v.V]
+	if len(stack) == 0 {
+		return v
+	}
+	return &ir.VarValue{
+		V:    stack[len(stack)-1],
+		Type: v.Type,
+	}
+}
+
+// removeFallthroughJumps removes conditional jumps to the label that
+// immediately follows, so a block never has two edges to the same
+// successor.
+func removeFallthroughJumps(insts []ir.Inst) []ir.Inst {
+	var updated []ir.Inst
+	for i, inst := range insts {
+		var label string
+		switch inst := inst.(type) {
+		case *ir.JumpIfZeroInst:
+			label = inst.Label
+		case *ir.JumpIfNotZeroInst:
+			label = inst.Label
+		}
+		if label != "" && i+1 < len(insts) {
+			if next, ok := insts[i+1].(*ir.LabelInst); ok && next.Name == label {
+				continue
+			}
+		}
+		updated = append(updated, inst)
+	}
+	return updated
+}
+
+func insertBeforeTerminator(b *cfg.Block, insts []ir.Inst) {
+	if b.Terminator() == nil {
+		b.Insts = append(b.Insts, insts...)
+		return
+	}
+	n := len(b.Insts) - 1
+	updated := append([]ir.Inst(nil), b.Insts[:n]...)
+	updated = append(updated, insts...)
+	b.Insts = append(updated, b.Insts[n])
+}
+
+func indexOf(blocks []*cfg.Block, b *cfg.Block) int {
+	for i, block := range blocks {
+		if block == b {
+			return i
+		}
+	}
+	panic("block not found")
+}
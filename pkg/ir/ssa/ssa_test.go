@@ -0,0 +1,195 @@
+package ssa_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/andydunstall/minc/pkg/ir/cfg"
+	"github.com/andydunstall/minc/pkg/ir/ssa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sumIR sums the numbers below n, with a branch in the loop body.
+const sumIR = `global fn main(i32 n) {
+	i = copy i32 0
+	sum = copy i32 0
+loop.1:
+	tmp.1 = lt i32 i, n
+	jz i32 tmp.1, end.1
+	tmp.2 = rem i32 i, 2
+	jz i32 tmp.2, next.1
+	sum = add i32 sum, i
+next.1:
+	i = add i32 i, 1
+	jmp loop.1
+end.1:
+	ret i32 sum
+}
+`
+
+func TestDominators(t *testing.T) {
+	g := cfg.New(parseFunc(t, sumIR).Insts)
+	require.Len(t, g.Blocks, 6)
+	entry, header, body, odd, next, end := g.Blocks[0], g.Blocks[1], g.Blocks[2], g.Blocks[3], g.Blocks[4], g.Blocks[5]
+
+	dom := ssa.Dominators(g)
+	assert.Equal(t, g.Entry, dom.IDom[entry])
+	assert.Equal(t, entry, dom.IDom[header])
+	assert.Equal(t, header, dom.IDom[body])
+	assert.Equal(t, body, dom.IDom[odd])
+	assert.Equal(t, body, dom.IDom[next])
+	assert.Equal(t, header, dom.IDom[end])
+	assert.True(t, dom.Dominates(header, next))
+	assert.False(t, dom.Dominates(odd, next))
+
+	frontiers := ssa.Frontiers(g, dom)
+	assert.Equal(t, []*cfg.Block{next}, frontiers[odd])
+	assert.Equal(t, []*cfg.Block{header}, frontiers[next])
+	assert.Equal(t, []*cfg.Block{header}, frontiers[body])
+	assert.Empty(t, frontiers[entry])
+}
+
+func TestFindLoops(t *testing.T) {
+	g := cfg.New(parseFunc(t, sumIR).Insts)
+	header, body, odd, next, end := g.Blocks[1], g.Blocks[2], g.Blocks[3], g.Blocks[4], g.Blocks[5]
+
+	loops := ssa.FindLoops(g, ssa.Dominators(g))
+	require.Len(t, loops, 1)
+	assert.Equal(t, header, loops[0].Header)
+	assert.Equal(t, map[*cfg.Block]bool{
+		header: true,
+		body:   true,
+		odd:    true,
+		next:   true,
+	}, loops[0].Blocks)
+	assert.Equal(t, []*cfg.Block{header}, loops[0].Exits())
+	assert.False(t, loops[0].Blocks[end])
+}
+
+func TestBuild(t *testing.T) {
+	f := ssa.Build(parseFunc(t, sumIR), nil)
+	assert.Equal(t, `global fn main(i32 n) {
+	i.1 = copy i32 0
+	sum.1 = copy i32 0
+loop.1:
+	i.2 = phi i32 i.1, i.3
+	sum.2 = phi i32 sum.1, sum.4
+	tmp.1.1 = lt i32 i.2, n
+	jz i32 tmp.1.1, end.1
+	tmp.2.1 = rem i32 i.2, 2
+	jz i32 tmp.2.1, next.1
+	sum.3 = add i32 sum.2, i.2
+next.1:
+	sum.4 = phi i32 sum.2, sum.3
+	i.3 = add i32 i.2, 1
+	jmp loop.1
+end.1:
+	ret i32 sum.2
+}
+`, ssa.Format(f))
+}
+
+func TestDestruct(t *testing.T) {
+	decl := parseFunc(t, sumIR)
+	ssa.Build(decl, nil).Destruct()
+
+	// The edge from the loop body to next.1 is split, so the copy into
+	// sum.4 only happens when the jump is taken.
+	assert.Equal(t, `global fn main(i32 n) {
+	i.1 = copy i32 0
+	sum.1 = copy i32 0
+	i.2 = copy i32 i.1
+	sum.2 = copy i32 sum.1
+loop.1:
+	tmp.1.1 = lt i32 i.2, n
+	jz i32 tmp.1.1, end.1
+	tmp.2.1 = rem i32 i.2, 2
+	jz i32 tmp.2.1, block.6
+	sum.3 = add i32 sum.2, i.2
+	sum.4 = copy i32 sum.3
+next.1:
+	i.3 = add i32 i.2, 1
+	i.2 = copy i32 i.3
+	sum.2 = copy i32 sum.4
+	jmp loop.1
+end.1:
+	ret i32 sum.2
+block.6:
+	sum.4 = copy i32 sum.2
+	jmp next.1
+}
+`, ir.Format(decl))
+
+	for n, want := range map[int64]int64{0: 0, 1: 0, 4: 4, 10: 25} {
+		result, err := ir.Interpret(&ir.File{Decls: []ir.Decl{decl}}, "main", []int64{n})
+		require.NoError(t, err)
+		assert.Equal(t, want, result)
+	}
+}
+
+func TestDestructSwap(t *testing.T) {
+	// Swapping a and b each iteration makes the phi nodes copy in a cycle.
+	decl := parseFunc(t, `global fn main(i32 n) {
+	a = copy i32 1
+	b = copy i32 2
+loop.1:
+	tmp.1 = copy i32 a
+	a = copy i32 b
+	b = copy i32 tmp.1
+	n = sub i32 n, 1
+	jnz i32 n, loop.1
+	tmp.2 = mul i32 a, 10
+	tmp.3 = add i32 tmp.2, b
+	ret i32 tmp.3
+}
+`)
+	f := ssa.Build(decl, nil)
+	// Propagate the copies, so the phi nodes read each other.
+	for _, b := range f.Graph.Blocks {
+		var insts []ir.Inst
+		for _, inst := range b.Insts {
+			if copy, ok := inst.(*ir.CopyInst); ok {
+				if _, ok := copy.L.(*ir.VarValue); ok {
+					replaceUses(f, copy.R.(*ir.VarValue).V, copy.L)
+					continue
+				}
+			}
+			insts = append(insts, inst)
+		}
+		b.Insts = insts
+	}
+	f.Destruct()
+
+	for n, want := range map[int64]int64{1: 21, 2: 12, 3: 21} {
+		result, err := ir.Interpret(&ir.File{Decls: []ir.Decl{decl}}, "main", []int64{n})
+		require.NoError(t, err)
+		assert.Equal(t, want, result)
+	}
+}
+
+func replaceUses(f *ssa.Func, name string, v ir.Value) {
+	replace := func(use *ir.Value) {
+		if u, ok := (*use).(*ir.VarValue); ok && u.V == name {
+			*use = v
+		}
+	}
+	for _, b := range f.Graph.Blocks {
+		for _, inst := range b.Insts {
+			for _, use := range ir.Uses(inst) {
+				replace(use)
+			}
+		}
+		for _, phi := range f.Phis[b] {
+			for i := range phi.Args {
+				replace(&phi.Args[i])
+			}
+		}
+	}
+}
+
+func parseFunc(t *testing.T, src string) *ir.FuncDecl {
+	file, err := ir.ParseText([]byte(src), false)
+	require.NoError(t, err)
+	return file.Decls[0].(*ir.FuncDecl)
+}
@@ -0,0 +1,481 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/andydunstall/minc/pkg/token"
+)
+
+// ParseText parses the textual IR written by Format. Comments start with
+// '#' and run to the end of the line.
+//
+// It returns an error describing the first problem found if the text isn't
+// valid IR.
+func ParseText(src []byte, debug bool) (file *File, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			textErr, ok := r.(textError)
+			if !ok {
+				panic(r)
+			}
+			err = textErr
+		}
+	}()
+
+	p := newTextParser(src)
+	return p.parseFile(), nil
+}
+
+// textError is a problem in the text passed to ParseText. The parser panics
+// with a textError when it finds a problem, which ParseText recovers and
+// returns.
+type textError struct {
+	msg string
+}
+
+func (e textError) Error() string {
+	return e.msg
+}
+
+type textParser struct {
+	lines [][]string
+	// line is the index of the current line.
+	line int
+	// pos is the index of the current word in the line.
+	pos int
+}
+
+func newTextParser(src []byte) *textParser {
+	var lines [][]string
+	for _, line := range strings.Split(string(src), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		lines = append(lines, splitWords(line))
+	}
+	return &textParser{
+		lines: lines,
+		line:  -1,
+	}
+}
+
+// splitWords splits the line into names, numbers and punctuation.
+func splitWords(line string) []string {
+	var words []string
+	var word strings.Builder
+	for _, r := range line {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-' {
+			word.WriteRune(r)
+			continue
+		}
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+		if !unicode.IsSpace(r) {
+			words = append(words, string(r))
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+func (p *textParser) parseFile() *File {
+	var decls []Decl
+	for p.nextLine() {
+		decls = append(decls, p.parseDecl())
+	}
+	return &File{
+		Decls: decls,
+	}
+}
+
+// Declarations.
+
+func (p *textParser) parseDecl() Decl {
+	global := false
+	if p.peek() == "global" {
+		global = true
+		p.next()
+	}
+
+	inline := InlineDefault
+	for p.peek() == "@" {
+		p.next()
+		switch attr := p.next(); attr {
+		case "inline":
+			inline = InlineAlways
+		case "noinline":
+			inline = InlineNever
+		default:
+			p.errorf("unknown attribute: %s", attr)
+		}
+	}
+
+	switch p.next() {
+	case "fn":
+		decl := p.parseFuncDecl()
+		decl.Global = global
+		decl.Inline = inline
+		return decl
+	case "var":
+		if inline != InlineDefault {
+			p.errorf("attributes must precede a function declaration")
+		}
+		decl := p.parseStaticVarDecl()
+		decl.Global = global
+		return decl
+	default:
+		p.errorf("expected declaration")
+		return nil
+	}
+}
+
+func (p *textParser) parseFuncDecl() *FuncDecl {
+	decl := &FuncDecl{
+		Name: p.next(),
+	}
+
+	p.expect("(")
+	for p.peek() != ")" {
+		t := p.parseType()
+		decl.Params = append(decl.Params, &VarValue{
+			V:    p.next(),
+			Type: t,
+		})
+		if p.peek() != ")" {
+			p.expect(",")
+		}
+	}
+	p.expect(")")
+	p.expect("{")
+	p.expectEnd()
+
+	for {
+		if !p.nextLine() {
+			p.errorf("expected '}'")
+		}
+		if p.peek() == "}" {
+			p.next()
+			p.expectEnd()
+			return decl
+		}
+		if p.isLocal() {
+			p.next()
+			t := p.parseType()
+			decl.Locals = append(decl.Locals, &VarValue{
+				V:    p.next(),
+				Type: t,
+			})
+			p.expectEnd()
+			continue
+		}
+		decl.Insts = append(decl.Insts, p.parseInst())
+	}
+}
+
+func (p *textParser) parseStaticVarDecl() *StaticVarDecl {
+	decl := &StaticVarDecl{
+		Type: p.parseType(),
+		Name: p.next(),
+	}
+	if p.peek() == "=" {
+		p.next()
+		decl.Init = &ConstValue{
+			V:    p.next(),
+			Type: decl.Type,
+		}
+	}
+	p.expectEnd()
+	return decl
+}
+
+// isLocal returns whether the current line declares a local variable,
+// rather than assigning a variable named 'local'.
+func (p *textParser) isLocal() bool {
+	words := p.lines[p.line]
+	return words[0] == "local" && len(words) == 3
+}
+
+// Instructions.
+
+func (p *textParser) parseInst() Inst {
+	words := p.lines[p.line]
+	if len(words) == 2 && words[1] == ":" {
+		p.pos = 2
+		return &LabelInst{
+			Name: words[0],
+		}
+	}
+
+	var inst Inst
+	if len(words) > 1 && words[1] == "=" {
+		// Check for an assignment first, as a global variable may have the
+		// same name as an operation.
+		dest := p.next()
+		p.next()
+		inst = p.parseDef(dest)
+		p.expectEnd()
+		return inst
+	}
+
+	switch op := p.next(); op {
+	case "ret":
+		inst = &RetInst{
+			Value: p.parseTypedValue(),
+		}
+	case "jmp":
+		inst = &JumpInst{
+			Label: p.next(),
+		}
+	case "tailcall":
+		inst = &TailCallInst{
+			Name: p.next(),
+			Args: p.parseArgs(),
+		}
+	case "jz", "jnz":
+		v := p.parseTypedValue()
+		p.expect(",")
+		if op == "jz" {
+			inst = &JumpIfZeroInst{
+				V:     v,
+				Label: p.next(),
+			}
+		} else {
+			inst = &JumpIfNotZeroInst{
+				V:     v,
+				Label: p.next(),
+			}
+		}
+	default:
+		p.errorf("unknown instruction: %s", op)
+	}
+	p.expectEnd()
+	return inst
+}
+
+// parseDef parses an instruction that assigns to the variable dest.
+func (p *textParser) parseDef(dest string) Inst {
+	op := p.next()
+	switch op {
+	case "call":
+		return p.parseCall(dest)
+	case "addr":
+		return &GetAddressInst{
+			Name: p.next(),
+			Dest: &VarValue{
+				V:    dest,
+				Type: Uint64,
+			},
+		}
+	case "sext", "zext", "trunc", "copy":
+	default:
+		_, unary := lookupOp(unaryOps, op)
+		_, binary := lookupOp(binaryOps, op)
+		if !unary && !binary {
+			p.errorf("unknown instruction: %s", op)
+		}
+	}
+
+	t := p.parseType()
+	operands := []Value{p.parseValue(t)}
+	for p.peek() == "," {
+		p.next()
+		operands = append(operands, p.parseValue(t))
+	}
+
+	destType := t
+	if p.peek() == "to" {
+		p.next()
+		destType = p.parseType()
+	}
+	d := &VarValue{
+		V:    dest,
+		Type: destType,
+	}
+
+	if tok, ok := lookupOp(unaryOps, op); ok {
+		p.expectOperands(op, operands, 1)
+		return &UnaryInst{
+			Op:   tok,
+			Src:  operands[0],
+			Dest: d,
+		}
+	}
+	if tok, ok := lookupOp(binaryOps, op); ok {
+		p.expectOperands(op, operands, 2)
+		return &BinaryInst{
+			Op:   tok,
+			V1:   operands[0],
+			V2:   operands[1],
+			Dest: d,
+		}
+	}
+
+	p.expectOperands(op, operands, 1)
+	switch op {
+	case "sext":
+		return &SignExtendInst{
+			Src:  operands[0],
+			Dest: d,
+		}
+	case "zext":
+		return &ZeroExtendInst{
+			Src:  operands[0],
+			Dest: d,
+		}
+	case "trunc":
+		return &TruncateInst{
+			Src:  operands[0],
+			Dest: d,
+		}
+	default:
+		return &CopyInst{
+			L: operands[0],
+			R: d,
+		}
+	}
+}
+
+func (p *textParser) parseCall(dest string) Inst {
+	d := &VarValue{
+		V:    dest,
+		Type: p.parseType(),
+	}
+
+	var name string
+	var fn Value
+	if p.peek() == "*" {
+		// Function pointers are 64-bit addresses.
+		p.next()
+		fn = p.parseValue(Uint64)
+	} else {
+		name = p.next()
+	}
+
+	args := p.parseArgs()
+	if fn != nil {
+		return &CallIndirectInst{
+			Func: fn,
+			Args: args,
+			Dest: d,
+		}
+	}
+	return &CallInst{
+		Name: name,
+		Args: args,
+		Dest: d,
+	}
+}
+
+func (p *textParser) parseArgs() []Value {
+	var args []Value
+	p.expect("(")
+	for p.peek() != ")" {
+		args = append(args, p.parseTypedValue())
+		if p.peek() != ")" {
+			p.expect(",")
+		}
+	}
+	p.expect(")")
+	return args
+}
+
+// Values.
+
+func (p *textParser) parseTypedValue() Value {
+	t := p.parseType()
+	return p.parseValue(t)
+}
+
+func (p *textParser) parseValue(t Type) Value {
+	w := p.next()
+	if w == "" {
+		p.errorf("expected value")
+	}
+	if w[0] == '-' || unicode.IsDigit(rune(w[0])) {
+		return &ConstValue{
+			V:    w,
+			Type: t,
+		}
+	}
+	return &VarValue{
+		V:    w,
+		Type: t,
+	}
+}
+
+func (p *textParser) parseType() Type {
+	w := p.next()
+	for t := Int8; t <= Uint64; t++ {
+		if t.String() == w {
+			return t
+		}
+	}
+	p.errorf("expected type, found '%s'", w)
+	return 0
+}
+
+func lookupOp(ops map[token.Token]string, name string) (token.Token, bool) {
+	for tok, s := range ops {
+		if s == name {
+			return tok, true
+		}
+	}
+	return token.ILLEGAL, false
+}
+
+// Words.
+
+// nextLine moves to the next non-empty line, returning false at the end of
+// the input.
+func (p *textParser) nextLine() bool {
+	p.line++
+	for p.line < len(p.lines) && len(p.lines[p.line]) == 0 {
+		p.line++
+	}
+	p.pos = 0
+	return p.line < len(p.lines)
+}
+
+func (p *textParser) peek() string {
+	words := p.lines[p.line]
+	if p.pos >= len(words) {
+		return ""
+	}
+	return words[p.pos]
+}
+
+func (p *textParser) next() string {
+	w := p.peek()
+	p.pos++
+	return w
+}
+
+func (p *textParser) expect(w string) {
+	if found := p.next(); found != w {
+		p.errorf("expected '%s', found '%s'", w, found)
+	}
+}
+
+func (p *textParser) expectEnd() {
+	if p.pos < len(p.lines[p.line]) {
+		p.errorf("unexpected '%s'", p.peek())
+	}
+}
+
+func (p *textParser) expectOperands(op string, operands []Value, n int) {
+	if len(operands) != n {
+		p.errorf("%s expects %d operands", op, n)
+	}
+}
+
+func (p *textParser) errorf(format string, a ...any) {
+	panic(textError{
+		msg: fmt.Sprintf("line %d: %s", p.line+1, fmt.Sprintf(format, a...)),
+	})
+}
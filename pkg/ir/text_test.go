@@ -0,0 +1,66 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTextErrors(t *testing.T) {
+	tests := []struct {
+		Name string
+		IR   string
+		Err  string
+	}{
+		{
+			// The operation is checked before its operands.
+			Name: "unknown operation",
+			IR: `global fn f(i32 a) {
+	x = frob i32 a, a
+	ret i32 x
+}
+`,
+			Err: "line 2: unknown instruction: frob",
+		},
+		{
+			Name: "unknown instruction",
+			IR: `global fn f() {
+	frob i32 0
+}
+`,
+			Err: "line 2: unknown instruction: frob",
+		},
+		{
+			Name: "operand count",
+			IR: `global fn f(i32 a) {
+	x = add i32 a
+	ret i32 x
+}
+`,
+			Err: "line 2: add expects 2 operands",
+		},
+		{
+			Name: "unknown type",
+			IR: `global fn f() {
+	ret i33 0
+}
+`,
+			Err: "line 2: expected type, found 'i33'",
+		},
+		{
+			Name: "unterminated function",
+			IR: `global fn f() {
+	ret i32 0
+`,
+			Err: "line 4: expected '}'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			_, err := ir.ParseText([]byte(tt.IR), false)
+			assert.EqualError(t, err, tt.Err)
+		})
+	}
+}
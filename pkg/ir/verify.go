@@ -0,0 +1,209 @@
+package ir
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Verify checks the file is well formed, and returns an error describing
+// each problem found. It's run between compiler stages and passes so a
+// broken transform fails where it happens, rather than miscompiling later.
+//
+// In each function:
+//   - Every label is defined once, and every jump is to a defined label
+//   - The last instruction is a return, jump or tail call
+//   - No instruction assigns to a constant
+//   - Every variable other than a parameter, static or declared local is
+//     defined on all paths before it's used
+//
+// Declared locals may be read before they're assigned, as C allows (the
+// value is indeterminate). Any other variable was introduced by the
+// compiler, and is only ever read by the code generated alongside its
+// definition, so one that may be undefined is always a bug in a pass.
+func Verify(file *File) error {
+	statics := StaticVars(file)
+	var errs []error
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*FuncDecl); ok {
+			errs = append(errs, verifyFunc(decl, statics)...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type verifier struct {
+	decl *FuncDecl
+	errs []error
+
+	// labels contains the index of the instruction defining each label.
+	labels map[string]int
+}
+
+func verifyFunc(decl *FuncDecl, statics map[string]bool) []error {
+	v := &verifier{
+		decl:   decl,
+		labels: make(map[string]int),
+	}
+
+	if len(decl.Insts) == 0 {
+		v.errorf("function has no instructions")
+		return v.errs
+	}
+	if last := decl.Insts[len(decl.Insts)-1]; !IsTerminator(last) {
+		v.instErrorf(last, "function doesn't end in a return, jump or tail call")
+	}
+
+	labelsOK := true
+	for i, inst := range decl.Insts {
+		if label, ok := inst.(*LabelInst); ok {
+			if _, ok := v.labels[label.Name]; ok {
+				v.errorf("label %s defined more than once", label.Name)
+				labelsOK = false
+			}
+			v.labels[label.Name] = i
+		}
+	}
+	for _, inst := range decl.Insts {
+		if label, ok := jumpLabel(inst); ok {
+			if _, ok := v.labels[label]; !ok {
+				v.instErrorf(inst, "jump to undefined label %s", label)
+				labelsOK = false
+			}
+		}
+		if d, ok := dest(inst); ok {
+			if _, ok := d.(*ConstValue); ok {
+				v.instErrorf(inst, "destination is a constant")
+			}
+		}
+	}
+
+	// The control flow is unknown if a jump has no single target.
+	if labelsOK {
+		v.verifyDefined(statics)
+	}
+	return v.errs
+}
+
+// verifyDefined checks every variable that isn't a parameter, static or
+// declared local is defined on all paths to each of its uses.
+//
+// This is a forward dataflow analysis over the instructions, where the
+// variables defined on entry to an instruction are those defined on all
+// paths to it.
+func (v *verifier) verifyDefined(statics map[string]bool) {
+	insts := v.decl.Insts
+
+	untracked := make(map[string]bool)
+	for _, param := range v.decl.Params {
+		untracked[param.V] = true
+	}
+	for _, local := range v.decl.Locals {
+		untracked[local.V] = true
+	}
+	tracked := func(name string) bool {
+		return !untracked[name] && !statics[name]
+	}
+
+	// Number the variables the function defines.
+	ids := make(map[string]int)
+	for _, inst := range insts {
+		if def := Def(inst); def != nil && tracked(def.V) {
+			if _, ok := ids[def.V]; !ok {
+				ids[def.V] = len(ids)
+			}
+		}
+	}
+
+	// in contains the variables defined on entry to each instruction,
+	// or nil if the instruction hasn't been reached yet.
+	in := make([][]bool, len(insts))
+	in[0] = make([]bool, len(ids))
+	work := []int{0}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		out := append([]bool(nil), in[i]...)
+		if def := Def(insts[i]); def != nil && tracked(def.V) {
+			out[ids[def.V]] = true
+		}
+		for _, succ := range v.succs(i) {
+			if in[succ] == nil {
+				in[succ] = append([]bool(nil), out...)
+				work = append(work, succ)
+				continue
+			}
+			changed := false
+			for id, defined := range in[succ] {
+				if defined && !out[id] {
+					in[succ][id] = false
+					changed = true
+				}
+			}
+			if changed {
+				work = append(work, succ)
+			}
+		}
+	}
+
+	for i, inst := range insts {
+		// Unreachable code is never run, so may use anything.
+		if in[i] == nil {
+			continue
+		}
+		for _, use := range Uses(inst) {
+			use, ok := (*use).(*VarValue)
+			if !ok || !tracked(use.V) {
+				continue
+			}
+			id, ok := ids[use.V]
+			if !ok {
+				v.instErrorf(inst, "%s is never defined", use.V)
+			} else if !in[i][id] {
+				v.instErrorf(inst, "%s may be used before it's defined", use.V)
+			}
+		}
+	}
+}
+
+// succs returns the indices of the instructions that may run after the
+// instruction at index i.
+func (v *verifier) succs(i int) []int {
+	var succs []int
+	switch inst := v.decl.Insts[i].(type) {
+	case *RetInst, *TailCallInst:
+		return nil
+	case *JumpInst:
+		return []int{v.labels[inst.Label]}
+	case *JumpIfZeroInst:
+		succs = append(succs, v.labels[inst.Label])
+	case *JumpIfNotZeroInst:
+		succs = append(succs, v.labels[inst.Label])
+	}
+	if i+1 < len(v.decl.Insts) {
+		succs = append(succs, i+1)
+	}
+	return succs
+}
+
+func (v *verifier) errorf(format string, a ...any) {
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", v.decl.Name, fmt.Sprintf(format, a...)))
+}
+
+func (v *verifier) instErrorf(inst Inst, format string, a ...any) {
+	v.errorf("%q: %s", formatInst(inst), fmt.Sprintf(format, a...))
+}
+
+// jumpLabel returns the label the instruction jumps to, if it's a jump.
+func jumpLabel(inst Inst) (string, bool) {
+	switch inst := inst.(type) {
+	case *JumpInst:
+		return inst.Label, true
+	case *JumpIfZeroInst:
+		return inst.Label, true
+	case *JumpIfNotZeroInst:
+		return inst.Label, true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,193 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/andydunstall/minc/pkg/ir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		Name string
+		IR   string
+		Err  string
+	}{
+		{
+			Name: "valid",
+			IR: `global var i32 count = 0
+
+global fn f(i32 n) {
+	jz i32 n, else.1
+	tmp.1 = add i32 n, count
+	jmp end.2
+else.1:
+	tmp.1 = copy i32 0
+end.2:
+	ret i32 tmp.1
+}
+`,
+		},
+		{
+			// Declared locals may be read uninitialized.
+			Name: "local maybe undefined",
+			IR: `global fn f(i32 n) {
+	local i32 x.1
+	jz i32 n, end.1
+	x.1 = copy i32 2
+end.1:
+	ret i32 x.1
+}
+`,
+		},
+		{
+			Name: "loop",
+			IR: `global fn f(i32 n) {
+	tmp.1 = copy i32 0
+loop.1:
+	jz i32 n, end.2
+	tmp.1 = add i32 tmp.1, n
+	n = sub i32 n, 1
+	jmp loop.1
+end.2:
+	ret i32 tmp.1
+}
+`,
+		},
+		{
+			Name: "unreachable use",
+			IR: `global fn f() {
+	ret i32 0
+	ret i32 tmp.1
+}
+`,
+		},
+		{
+			Name: "undefined label",
+			IR: `global fn f() {
+	jmp end.1
+}
+`,
+			Err: `f: "jmp end.1": jump to undefined label end.1`,
+		},
+		{
+			Name: "duplicate label",
+			IR: `global fn f() {
+end.1:
+end.1:
+	ret i32 0
+}
+`,
+			Err: `f: label end.1 defined more than once`,
+		},
+		{
+			Name: "no terminator",
+			IR: `global fn f() {
+	x = copy i32 1
+}
+`,
+			Err: `f: "x = copy i32 1": function doesn't end in a return, jump or tail call`,
+		},
+		{
+			Name: "empty",
+			IR: `global fn f() {
+}
+`,
+			Err: `f: function has no instructions`,
+		},
+		{
+			Name: "never defined",
+			IR: `global fn f() {
+	ret i32 tmp.1
+}
+`,
+			Err: `f: "ret i32 tmp.1": tmp.1 is never defined`,
+		},
+		{
+			Name: "maybe undefined",
+			IR: `global fn f(i32 n) {
+	jz i32 n, end.1
+	tmp.1 = copy i32 2
+end.1:
+	ret i32 tmp.1
+}
+`,
+			Err: `f: "ret i32 tmp.1": tmp.1 may be used before it's defined`,
+		},
+		{
+			// Variables are checked whatever their name, unless declared.
+			Name: "undeclared variable maybe undefined",
+			IR: `global fn f(i32 n) {
+	jz i32 n, end.1
+	x.1 = copy i32 2
+end.1:
+	ret i32 x.1
+}
+`,
+			Err: `f: "ret i32 x.1": x.1 may be used before it's defined`,
+		},
+		{
+			Name: "defined after use in loop",
+			IR: `global fn f(i32 n) {
+loop.1:
+	jz i32 n, end.2
+	tmp.2 = add i32 tmp.1, 1
+	tmp.1 = copy i32 n
+	jmp loop.1
+end.2:
+	ret i32 0
+}
+`,
+			Err: `f: "tmp.2 = add i32 tmp.1, 1": tmp.1 may be used before it's defined`,
+		},
+		{
+			Name: "multiple errors",
+			IR: `global fn f() {
+	jmp end.1
+}
+
+global fn g() {
+	ret i32 tmp.1
+}
+`,
+			Err: `f: "jmp end.1": jump to undefined label end.1
+g: "ret i32 tmp.1": tmp.1 is never defined`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			file, err := ir.ParseText([]byte(tt.IR), false)
+			require.NoError(t, err)
+
+			err = ir.Verify(file)
+			if tt.Err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.Err)
+			}
+		})
+	}
+}
+
+func TestVerifyConstantDestination(t *testing.T) {
+	// The text form can't express a constant destination.
+	file := &ir.File{
+		Decls: []ir.Decl{
+			&ir.FuncDecl{
+				Name: "f",
+				Insts: []ir.Inst{
+					&ir.CopyInst{
+						L: ir.NewConst(2, ir.Int32),
+						R: ir.NewConst(1, ir.Int32),
+					},
+					&ir.RetInst{
+						Value: ir.NewConst(0, ir.Int32),
+					},
+				},
+			},
+		},
+	}
+	assert.EqualError(t, ir.Verify(file), `f: "1 = copy i32 2": destination is a constant`)
+}
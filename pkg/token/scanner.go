@@ -53,7 +53,7 @@ func (s *Scanner) Scan() (tok Token, lit string) {
 				tok = LAND
 				s.next()
 			} else {
-				tok = ILLEGAL
+				tok = AND
 			}
 		case '|':
 			if s.ch == '|' {
@@ -104,6 +104,8 @@ func (s *Scanner) Scan() (tok Token, lit string) {
 			tok = COMMA
 		case '~':
 			tok = TILDE
+		case '@':
+			tok = AT
 		case eof:
 			tok = EOF
 		default:
@@ -131,7 +133,8 @@ func (s *Scanner) scanIdentifier() string {
 
 func (s *Scanner) scanNumber() string {
 	for i, b := range s.src[s.offset:] {
-		if '0' <= b && b <= '9' {
+		// Include any suffix (such as 10ul) in the literal.
+		if '0' <= b && b <= '9' || lower(b) == 'u' || lower(b) == 'l' {
 			continue
 		}
 
@@ -10,8 +10,9 @@ const (
 
 	// Identifiers and basic type literals
 	literal_beg
-	IDENT // main
-	INT   // 12345
+	IDENT    // main
+	TYPENAME // int
+	INT      // 12345
 	literal_end
 
 	// Operators and delimiters
@@ -22,6 +23,7 @@ const (
 	QUO // /
 	REM // %
 
+	AND  // &
 	LAND // &&
 	LOR  // ||
 
@@ -55,11 +57,19 @@ const (
 	LOOP
 	CONTINUE
 	BREAK
+
+	ENUM
+	TYPEDEF
+	SIZEOF
+
+	STATIC
+	EXTERN
 	keyword_end
 
 	// Additional tokens
 	additional_beg
 	TILDE // ~
+	AT    // @
 	additional_end
 )
 
@@ -67,8 +77,9 @@ var tokens = [...]string{
 	ILLEGAL: "ILLEGAL",
 	EOF:     "EOF",
 
-	IDENT: "IDENT",
-	INT:   "INT",
+	IDENT:    "IDENT",
+	TYPENAME: "TYPENAME",
+	INT:      "INT",
 
 	ADD: "+",
 	SUB: "-",
@@ -76,6 +87,7 @@ var tokens = [...]string{
 	QUO: "/",
 	REM: "%",
 
+	AND:  "&",
 	LAND: "&&",
 	LOR:  "||",
 
@@ -107,7 +119,15 @@ var tokens = [...]string{
 	CONTINUE: "continue",
 	BREAK:    "break",
 
+	ENUM:    "enum",
+	TYPEDEF: "typedef",
+	SIZEOF:  "sizeof",
+
+	STATIC: "static",
+	EXTERN: "extern",
+
 	TILDE: "~",
+	AT:    "@",
 }
 
 func (tok Token) String() string {
@@ -0,0 +1,10 @@
+fn widen(char c, uint u) long {
+	return c + u;
+}
+
+fn main() {
+	let long big = 4294967296 * 3;
+	let uchar u = (uchar)(big + 200);
+	let char c = (char)u;
+	return (int)(widen(c, 60u) - big) + (u > 100);
+}
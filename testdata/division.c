@@ -0,0 +1,51 @@
+fn mix(long h, long v) long {
+	return h * 31 + v;
+}
+
+fn main() {
+	let long h = 0;
+	let int n = -1000;
+	loop (n <= 1000) {
+		h = mix(h, n / 3);
+		h = mix(h, n % 3);
+		h = mix(h, n / 7);
+		h = mix(h, n % 7);
+		h = mix(h, n / -5);
+		h = mix(h, n % -5);
+		h = mix(h, n / 8);
+		h = mix(h, n % 8);
+		h = mix(h, n / -16);
+		h = mix(h, n % -16);
+		h = mix(h, n * 4);
+		h = mix(h, 8 * n);
+
+		let uint u = (uint)n;
+		h = mix(h, u / 3u);
+		h = mix(h, u % 3u);
+		h = mix(h, u / 7u);
+		h = mix(h, u % 7u);
+		h = mix(h, u / 16u);
+		h = mix(h, u % 16u);
+		h = mix(h, u / 3000000000u);
+
+		let long l = (long)n * 1000003;
+		h = mix(h, l / 1000000007);
+		h = mix(h, l % 1000000007);
+		h = mix(h, l / 4294967296);
+		h = mix(h, l % 4294967296);
+
+		let ulong ul = (ulong)l;
+		h = mix(h, (long)(ul / 10));
+		h = mix(h, (long)(ul % 7));
+		h = mix(h, (long)(ul / 4294967296));
+		n = n + 1;
+	}
+
+	let long min = -9223372036854775807 - 1;
+	h = mix(h, min / 3);
+	h = mix(h, min % 3);
+	h = mix(h, min / 8);
+	h = mix(h, min % 8);
+	h = mix(h, min / min);
+	return (int)((ulong)h % 256);
+}
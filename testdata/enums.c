@@ -0,0 +1,15 @@
+enum Color { RED, GREEN = 5, BLUE };
+
+typedef enum Color color;
+typedef int myint;
+
+fn size(myint n) myint {
+	return n * sizeof(myint);
+}
+
+fn main() {
+	let color c = BLUE;
+	enum Mode { OFF = BLUE - GREEN, ON = sizeof c };
+	let myint myint = c + ON;
+	return size(myint) + sizeof(enum Color);
+}
@@ -0,0 +1,23 @@
+typedef fn(int) int unop;
+
+fn double(int n) {
+	return n * 2;
+}
+
+fn negate(int n) {
+	return -n;
+}
+
+fn apply(unop f, int n) {
+	return f(n);
+}
+
+fn main() {
+	let unop f = double;
+	let fn(int) int g = &negate;
+	let unop none = 0;
+	if (f == g || none != 0) {
+		return 1;
+	}
+	return apply(f, 20) + g(3) + (none == 0);
+}
@@ -0,0 +1,28 @@
+@inline fn square(int n) {
+	return n * n;
+}
+
+@noinline
+fn addOne(int n) {
+	return n + 1;
+}
+
+fn fact(int n) {
+	if (n < 2) {
+		return 1;
+	}
+	return n * fact(n - 1);
+}
+
+@inline static fn clamp(int n) int;
+
+static fn clamp(int n) int {
+	if (n > 10) {
+		return 10;
+	}
+	return n;
+}
+
+fn main() {
+	return square(3) + addOne(square(2)) + fact(4) + clamp(15);
+}
@@ -0,0 +1,24 @@
+static fn helper(int n) int;
+
+let int total;
+static let long scale = 3;
+let char small = -2;
+
+fn counter() {
+	static let int calls;
+	calls = calls + 1;
+	return calls;
+}
+
+extern fn helper(int n) int {
+	extern let int total;
+	total = total + n;
+	return total;
+}
+
+fn main() {
+	counter();
+	counter();
+	helper(4);
+	return (int)(counter() * scale) + helper(1) + small;
+}
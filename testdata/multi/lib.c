@@ -0,0 +1,10 @@
+let int count = 10;
+
+static fn bump(int n) {
+	count = count + n;
+	return count;
+}
+
+fn add(int n) {
+	return bump(n);
+}
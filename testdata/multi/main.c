@@ -0,0 +1,8 @@
+extern let int count;
+
+fn add(int n) int;
+
+fn main() {
+	add(5);
+	return add(count);
+}
@@ -0,0 +1,37 @@
+fn sum8(int a, int b, int c, int d, int e, int f, char g, long h) long {
+	return a + b * 2 + c * 3 + d * 4 + e * 5 + f * 6 + g * 7 + h * 8;
+}
+
+fn pressure(int n) int {
+	let a = n + 1;
+	let b = n + 2;
+	let c = n + 3;
+	let d = n + 4;
+	let e = n + 5;
+	let f = n + 6;
+	let g = n + 7;
+	let h = n + 8;
+	let j = n + 9;
+	let k = n + 10;
+	let l = n + 11;
+	let m = n + 12;
+	let o = n + 13;
+	let p = n + 14;
+	let total = 0;
+	let i = 0;
+	loop (i < n) {
+		total = total + a * i + b - c + d * e / (f + i) + g % (h - i) - j + k + l * m - o + p;
+		total = total + (int)sum8(a, b, c, d, e, f, (char)i, (long)total);
+		a = a + 1;
+		p = p - 1;
+		i = i + 1;
+	}
+	return total + a + b + c + d + e + f + g + h + j + k + l + m + o + p;
+}
+
+fn main() {
+	let char c = (char)-3;
+	let long r = sum8(1, 2, 3, 4, 5, 6, c, 10);
+	let int x = pressure(10);
+	return (int)((ulong)(r + x) % 256);
+}
@@ -0,0 +1,33 @@
+fn isOdd(int n) int;
+
+fn isEven(int n) int {
+	if (n == 0) {
+		return 1;
+	}
+	return isOdd(n - 1);
+}
+
+fn isOdd(int n) int {
+	if (n == 0) {
+		return 0;
+	}
+	return isEven(n - 1);
+}
+
+fn sum(int n, int acc) int {
+	if (n == 0) {
+		return acc;
+	}
+	return sum(n - 1, acc + n);
+}
+
+fn swap(int a, int b, int n) int {
+	if (n == 0) {
+		return a * 10 + b;
+	}
+	return swap(b, a, n - 1);
+}
+
+fn main() {
+	return sum(100, 0) % 256 + isEven(10) + swap(1, 2, 3);
+}
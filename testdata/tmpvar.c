@@ -0,0 +1,7 @@
+fn main() {
+	let tmp = 5;
+	let a = tmp + 1;
+	let b = a + 2;
+	let c = b + 3;
+	return tmp;
+}
@@ -0,0 +1,21 @@
+fn main() {
+	let s = 0;
+	let i = 0;
+	loop (i < 3) {
+		i = i + 1;
+		s = s + i;
+	}
+	let j = 0;
+	loop (j < 4) {
+		j = j + 1;
+		let k = 0;
+		loop (k < j) {
+			k = k + 1;
+		}
+		if (k > 2) {
+			break;
+		}
+		s = s + k;
+	}
+	return s;
+}
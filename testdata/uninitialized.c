@@ -0,0 +1,23 @@
+fn pick(int c) int {
+	let int x;
+	if (c) {
+		x = 2;
+	}
+	return x;
+}
+
+fn sum(int n) int {
+	let int total;
+	let i = 0;
+	loop (i < n) {
+		total = total + i;
+		i = i + 1;
+	}
+	return total;
+}
+
+fn main() {
+	let int y;
+	y = 3;
+	return pick(1) + y;
+}
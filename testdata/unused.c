@@ -0,0 +1,30 @@
+@warn_unused_result
+fn compute(int n) int {
+	return n * 2;
+}
+
+static fn helper(int n) int {
+	return helper(n - 1);
+}
+
+static fn used() int {
+	return 1;
+}
+
+fn callback(int a, int b) int {
+	return a;
+}
+
+fn main() {
+	let int x = 3;
+	let int y;
+	let int z;
+	let long s = sizeof z;
+	y = 4;
+	x + 1;
+	compute(x);
+	x = compute(2);
+	(long) compute(1);
+	used();
+	return x;
+}